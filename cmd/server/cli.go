@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/database"
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+// cli is what the commands share: a context cancelled on interrupt, the
+// config and whether to print JSON.
+type cli struct {
+	ctx  context.Context
+	cfg  Config
+	json bool
+}
+
+// parse parses a command's flags, adding -json, and returns the arguments
+// left after them. ok is false if the flags were invalid.
+func (c *cli) parse(flags *flag.FlagSet, args []string) (rest []string, ok bool) {
+	flags.BoolVar(&c.json, "json", false, "print results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return nil, false
+	}
+	return flags.Args(), true
+}
+
+func (c *cli) connect() (*pgxpool.Pool, error) {
+	db, err := database.Connect(c.cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// print writes v to stdout as JSON with -json, and otherwise calls text to
+// write it in a table.
+func (c *cli) print(v any, text func(out *tabwriter.Writer)) {
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	text(out)
+	out.Flush()
+}
+
+// fail reports err and returns the exit code for it.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+const migrateUsage = `usage: moltpress migrate <command>
+
+commands:
+  up [-dry-run]                 apply pending migrations
+  down [-steps 1] [-dry-run]    revert the most recently applied migrations
+  status                        list migrations and when they were applied
+`
+
+func runMigrateCommand(c *cli, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	var dryRun *bool
+	var steps *int
+	switch args[0] {
+	case "up":
+		dryRun = flags.Bool("dry-run", false, "list the migrations that would be applied")
+	case "down":
+		dryRun = flags.Bool("dry-run", false, "list the migrations that would be reverted")
+		steps = flags.Int("steps", 1, "how many migrations to revert")
+	case "status":
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	if _, ok := c.parse(flags, args[1:]); !ok {
+		return 2
+	}
+	if steps != nil && *steps < 1 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	if args[0] == "status" {
+		statuses, err := database.Status(c.ctx, db)
+		if err != nil {
+			return fail(fmt.Errorf("failed to get migration status: %w", err))
+		}
+		c.print(statuses, func(out *tabwriter.Writer) {
+			for _, s := range statuses {
+				appliedAt := ""
+				if s.AppliedAt != nil {
+					appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+				}
+				fmt.Fprintf(out, "%s\t%s\t%s\n", s.Name, s.State, appliedAt)
+			}
+		})
+		return 0
+	}
+
+	var names []string
+	verb := "applied"
+	if args[0] == "up" {
+		names, err = database.Migrate(c.ctx, db, *dryRun)
+	} else {
+		verb = "reverted"
+		names, err = database.Rollback(c.ctx, db, *steps, *dryRun)
+	}
+	if *dryRun {
+		verb = "would be " + verb
+	}
+
+	// Print what was done even if a later migration failed
+	c.print(map[string]any{"direction": args[0], "dry_run": *dryRun, "migrations": names}, func(out *tabwriter.Writer) {
+		for _, name := range names {
+			fmt.Fprintf(out, "%s\t%s\n", verb, name)
+		}
+		fmt.Fprintf(out, "%d migrations %s\n", len(names), verb)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("migration failed: %w", err))
+	}
+	return 0
+}
+
+func runRecountCommand(c *cli, args []string) int {
+	if _, ok := c.parse(flag.NewFlagSet("recount", flag.ContinueOnError), args); !ok {
+		return 2
+	}
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	result, err := posts.NewRepository(db).Recount(c.ctx)
+	if err != nil {
+		return fail(fmt.Errorf("failed to recount: %w", err))
+	}
+	c.print(result, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "corrected %d posts and %d tags\n", result.Posts, result.Tags)
+	})
+	return 0
+}
+
+func runRescoreCommand(c *cli, args []string) int {
+	flags := flag.NewFlagSet("rescore-sentiment", flag.ContinueOnError)
+	batch := flags.Int("batch", 500, "posts to rescore per transaction")
+	if _, ok := c.parse(flags, args); !ok {
+		return 2
+	}
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	scanned, changed, err := posts.NewRepository(db).RescoreSentiment(c.ctx, *batch)
+	c.print(map[string]int{"scanned": scanned, "changed": changed}, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "rescored %d posts, %d changed\n", scanned, changed)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to rescore sentiment: %w", err))
+	}
+	return 0
+}
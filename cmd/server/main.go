@@ -4,18 +4,13 @@ import (
 	"context"
 	"embed"
 	"fmt"
-	"io/fs"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/redis/go-redis/v9"
-	"github.com/watzon/moltpress/internal/api"
-	"github.com/watzon/moltpress/internal/database"
-	"github.com/watzon/moltpress/internal/ratelimit"
+	"github.com/watzon/moltpress/internal/reconcile"
 	"github.com/watzon/moltpress/internal/storage"
 )
 
@@ -25,117 +20,57 @@ var staticFiles embed.FS
 //go:embed skill.md
 var skillFile []byte
 
-func main() {
-	// Setup structured logging
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
-	// Load config from environment
-	cfg := loadConfig()
-
-	// Connect to database
-	db, err := database.Connect(cfg.DatabaseURL)
-	if err != nil {
-		slog.Error("failed to connect to database", "error", err)
-		os.Exit(1)
-	}
-	defer db.Close()
-
-	// Run migrations
-	if err := database.Migrate(db); err != nil {
-		slog.Error("failed to run migrations", "error", err)
-		os.Exit(1)
-	}
-
-	// Extract static files
-	staticFS, err := fs.Sub(staticFiles, "static")
-	if err != nil {
-		slog.Error("failed to load static files", "error", err)
-		os.Exit(1)
-	}
+const usage = `usage: moltpress [command]
+
+commands:
+  serve [-migrate=false]                           run the server (the default)
+  migrate up|down|status                           apply, revert or list database migrations
+  user <command>                                   create, verify, moderate or delete accounts
+  key rotate <username>                            replace a user's API keys
+  recount                                          recompute post and tag counters
+  rescore-sentiment                                rerun sentiment analysis on every post
+  storage ls|check|gc                              inspect and clean up stored files
+
+Commands other than serve take -json to print their results as JSON.
+Configuration comes from the same environment variables as the server.
+`
+
+// commands get the arguments after their name and return the exit code.
+var commands = map[string]func(c *cli, args []string) int{
+	"migrate":           runMigrateCommand,
+	"user":              runUserCommand,
+	"key":               runKeyCommand,
+	"recount":           runRecountCommand,
+	"rescore-sentiment": runRescoreCommand,
+	"storage":           runStorageCommand,
+}
 
-	// Initialize storage
-	var store storage.Storage
-	if cfg.StorageType == "s3" {
-		store, err = storage.NewS3Storage(storage.S3Config{
-			Endpoint:        cfg.S3Endpoint,
-			Region:          cfg.S3Region,
-			Bucket:          cfg.S3Bucket,
-			AccessKeyID:     cfg.S3AccessKey,
-			SecretAccessKey: cfg.S3SecretKey,
-			PublicURL:       cfg.S3PublicURL,
-		})
-		if err != nil {
-			slog.Error("failed to initialize S3 storage", "error", err)
-			os.Exit(1)
-		}
-		slog.Info("using S3 storage", "bucket", cfg.S3Bucket, "endpoint", cfg.S3Endpoint)
-	} else {
-		store, err = storage.NewLocalStorage(cfg.StorageLocalPath, cfg.BaseURL)
-		if err != nil {
-			slog.Error("failed to initialize local storage", "error", err)
-			os.Exit(1)
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || args[0] == "serve" {
+		if len(args) > 0 {
+			args = args[1:]
 		}
-		slog.Info("using local storage", "path", cfg.StorageLocalPath)
-	}
-
-	redisOpts, err := redis.ParseURL(cfg.RedisURL)
-	if err != nil {
-		slog.Error("failed to parse Redis URL", "error", err)
-		os.Exit(1)
+		os.Exit(runServe(args))
 	}
-	redisClient := redis.NewClient(redisOpts)
-	defer redisClient.Close()
-
-	pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	if err := redisClient.Ping(pingCtx).Err(); err != nil {
-		slog.Error("failed to connect to Redis", "error", err)
-		os.Exit(1)
-	}
-	pingCancel()
-	slog.Info("connected to Redis", "url", cfg.RedisURL)
-
-	rateLimiter := ratelimit.NewLimiter(redisClient)
 
-	// Create router
-	router := api.NewRouter(db, staticFS, skillFile, cfg.BaseURL, store, rateLimiter)
-
-	// Create server
-	server := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-
-	// Start server in goroutine
-	go func() {
-		slog.Info("starting server", "port", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("server error", "error", err)
-			os.Exit(1)
+	run, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			fmt.Print(usage)
+			os.Exit(0)
 		}
-	}()
-
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	slog.Info("shutting down server...")
-
-	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	if err := server.Shutdown(ctx); err != nil {
-		slog.Error("server forced to shutdown", "error", err)
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	slog.Info("server stopped")
+	// Keep stdout for the command's output
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(&cli{ctx: ctx, cfg: loadConfig()}, args[1:])
+	stop()
+	os.Exit(code)
 }
 
 type Config struct {
@@ -152,6 +87,15 @@ type Config struct {
 	S3AccessKey      string
 	S3SecretKey      string
 	S3PublicURL      string
+
+	// S3RedirectUploads redirects /uploads/ to S3PublicURL rather than
+	// proxying files from the bucket
+	S3RedirectUploads bool
+
+	// StorageGCDelete deletes orphaned files older than StorageGCGrace
+	// instead of only reporting them
+	StorageGCDelete bool
+	StorageGCGrace  time.Duration
 }
 
 func loadConfig() Config {
@@ -185,18 +129,52 @@ func loadConfig() Config {
 		storageLocalPath = "./uploads"
 	}
 
+	storageGCGrace := reconcile.DefaultGrace
+	if grace, err := time.ParseDuration(os.Getenv("STORAGE_GC_GRACE")); err == nil && grace > 0 {
+		storageGCGrace = grace
+	}
+
 	return Config{
-		Port:             port,
-		DatabaseURL:      dbURL,
-		RedisURL:         redisURL,
-		BaseURL:          baseURL,
-		StorageType:      storageType,
-		StorageLocalPath: storageLocalPath,
-		S3Endpoint:       os.Getenv("S3_ENDPOINT"),
-		S3Region:         os.Getenv("S3_REGION"),
-		S3Bucket:         os.Getenv("S3_BUCKET"),
-		S3AccessKey:      os.Getenv("S3_ACCESS_KEY"),
-		S3SecretKey:      os.Getenv("S3_SECRET_KEY"),
-		S3PublicURL:      os.Getenv("S3_PUBLIC_URL"),
+		Port:              port,
+		DatabaseURL:       dbURL,
+		RedisURL:          redisURL,
+		BaseURL:           baseURL,
+		StorageType:       storageType,
+		StorageLocalPath:  storageLocalPath,
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3Region:          os.Getenv("S3_REGION"),
+		S3Bucket:          os.Getenv("S3_BUCKET"),
+		S3AccessKey:       os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:       os.Getenv("S3_SECRET_KEY"),
+		S3PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		S3RedirectUploads: os.Getenv("S3_REDIRECT_UPLOADS") == "true",
+		StorageGCDelete:   os.Getenv("STORAGE_GC_DELETE") == "true",
+		StorageGCGrace:    storageGCGrace,
+	}
+}
+
+// newStorage opens the storage backend cfg selects.
+func newStorage(cfg Config) (storage.Storage, error) {
+	if cfg.StorageType == "s3" {
+		store, err := storage.NewS3Storage(storage.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKey,
+			SecretAccessKey: cfg.S3SecretKey,
+			PublicURL:       cfg.S3PublicURL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("using S3 storage", "bucket", cfg.S3Bucket, "endpoint", cfg.S3Endpoint)
+		return store, nil
+	}
+
+	store, err := storage.NewLocalStorage(cfg.StorageLocalPath, cfg.BaseURL)
+	if err != nil {
+		return nil, err
 	}
+	slog.Info("using local storage", "path", cfg.StorageLocalPath)
+	return store, nil
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/watzon/moltpress/internal/api"
+	"github.com/watzon/moltpress/internal/database"
+	"github.com/watzon/moltpress/internal/posts"
+	"github.com/watzon/moltpress/internal/ratelimit"
+	"github.com/watzon/moltpress/internal/reconcile"
+	"github.com/watzon/moltpress/internal/sessions"
+	"github.com/watzon/moltpress/internal/stream"
+	"github.com/watzon/moltpress/internal/uploads"
+	"github.com/watzon/moltpress/internal/webhooks"
+)
+
+// runServe runs the server until it is interrupted and returns the exit
+// code.
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	migrate := flags.Bool("migrate", true, "apply pending database migrations before starting")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Setup structured logging
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+	slog.SetDefault(logger)
+
+	// Load config from environment
+	cfg := loadConfig()
+
+	// Connect to database
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		slog.Error("failed to connect to database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	// Run migrations, or with -migrate=false only check the database
+	// isn't ahead of this binary
+	pending, err := database.Migrate(context.Background(), db, !*migrate)
+	if err != nil {
+		slog.Error("failed to run migrations", "error", err)
+		return 1
+	}
+	if !*migrate && len(pending) > 0 {
+		slog.Warn("database has pending migrations", "pending", pending)
+	}
+
+	// Extract static files
+	staticFS, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		slog.Error("failed to load static files", "error", err)
+		return 1
+	}
+
+	// Initialize storage
+	store, err := newStorage(cfg)
+	if err != nil {
+		slog.Error("failed to initialize storage", "error", err, "type", cfg.StorageType)
+		return 1
+	}
+
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		slog.Error("failed to parse Redis URL", "error", err)
+		return 1
+	}
+	redisClient := redis.NewClient(redisOpts)
+	defer redisClient.Close()
+
+	pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err = redisClient.Ping(pingCtx).Err()
+	pingCancel()
+	if err != nil {
+		slog.Error("failed to connect to Redis", "error", err)
+		return 1
+	}
+	slog.Info("connected to Redis", "url", cfg.RedisURL)
+
+	rateLimiter := ratelimit.NewLimiter(redisClient)
+
+	broker := stream.NewBroker(redisClient)
+
+	// Create router
+	opts := []api.Option{api.WithBroker(broker)}
+	if cfg.StorageType == "s3" && cfg.S3PublicURL != "" && cfg.S3RedirectUploads {
+		opts = append(opts, api.WithUploadRedirect())
+	}
+	router := api.NewRouter(db, staticFS, skillFile, cfg.BaseURL, store, rateLimiter, opts...)
+
+	// Background jobs run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	var background sync.WaitGroup
+
+	background.Go(func() { broker.Run(bgCtx) })
+
+	background.Go(func() { sessions.NewRepository(db).Sweep(bgCtx, time.Hour) })
+	background.Go(func() { webhooks.NewDispatcher(db).Run(bgCtx) })
+	background.Go(func() { uploads.NewRepository(db).Sweep(bgCtx, store, 15*time.Minute) })
+	background.Go(func() {
+		reconcile.NewReconciler(db, store).Run(bgCtx, 24*time.Hour, cfg.StorageGCGrace, cfg.StorageGCDelete)
+	})
+
+	// Publishes scheduled and queued posts
+	scheduler := posts.NewScheduler(posts.NewRepository(db).WithPublisher(broker), rateLimiter)
+	background.Go(func() { scheduler.Run(bgCtx) })
+
+	// Create server
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      router,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	// Start server in goroutine
+	go func() {
+		slog.Info("starting server", "port", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for interrupt signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	slog.Info("shutting down server...")
+	stopBackground()
+
+	// Graceful shutdown with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("server forced to shutdown", "error", err)
+	}
+
+	// Let background jobs finish what they are doing, such as a post being
+	// published, before the database and Redis connections close
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("background jobs did not stop in time")
+	}
+
+	slog.Info("server stopped")
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/watzon/moltpress/internal/reconcile"
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+const storageUsage = `usage: moltpress storage <command>
+
+commands:
+  ls [prefix]                     list stored files
+  check [-delete] [-grace 24h]    find orphaned files and dangling references
+  gc [-grace 24h]                 check, then delete orphaned files older than -grace
+`
+
+func runStorageCommand(c *cli, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, storageUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("storage "+args[0], flag.ContinueOnError)
+	var remove *bool
+	var grace *time.Duration
+	switch args[0] {
+	case "ls":
+	case "check", "gc":
+		remove = flags.Bool("delete", args[0] == "gc", "delete orphaned files older than -grace")
+		grace = flags.Duration("grace", c.cfg.StorageGCGrace, "how old an orphaned file must be to delete it")
+	default:
+		fmt.Fprint(os.Stderr, storageUsage)
+		return 2
+	}
+	rest, ok := c.parse(flags, args[1:])
+	if !ok {
+		return 2
+	}
+
+	store, err := newStorage(c.cfg)
+	if err != nil {
+		return fail(fmt.Errorf("failed to initialize storage: %w", err))
+	}
+
+	if args[0] == "ls" {
+		prefixes := reconcile.Prefixes
+		if len(rest) > 0 {
+			prefixes = rest
+		}
+
+		files := []storage.FileInfo{}
+		for _, prefix := range prefixes {
+			listed, err := store.List(c.ctx, prefix)
+			if err != nil {
+				return fail(fmt.Errorf("failed to list storage: %w", err))
+			}
+			files = append(files, listed...)
+		}
+		c.print(files, func(out *tabwriter.Writer) {
+			for _, f := range files {
+				fmt.Fprintf(out, "%s\t%d\t%s\n", f.Key, f.Size, f.LastModified.UTC().Format(time.RFC3339))
+			}
+		})
+		return 0
+	}
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	reconciler := reconcile.NewReconciler(db, store)
+	report, err := reconciler.Check(c.ctx)
+	if err != nil {
+		return fail(fmt.Errorf("failed to check storage: %w", err))
+	}
+
+	var deleted int
+	if *remove {
+		deleted, err = reconciler.DeleteOrphans(c.ctx, report, *grace)
+	}
+
+	result := struct {
+		*reconcile.Report
+		Deleted int `json:"deleted"`
+	}{report, deleted}
+	c.print(result, func(out *tabwriter.Writer) {
+		for _, f := range report.Orphans {
+			fmt.Fprintf(out, "orphan\t%s\t%d\t%s\n", f.Key, f.Size, f.LastModified.UTC().Format(time.RFC3339))
+		}
+		for _, ref := range report.Dangling {
+			fmt.Fprintf(out, "dangling\t%s\t%s\t%s\n", ref.Key, ref.Source, ref.ID)
+		}
+		fmt.Fprintf(out, "%d files, %d references, %d orphans, %d dangling\n",
+			report.Files, report.References, len(report.Orphans), len(report.Dangling))
+		if *remove {
+			fmt.Fprintf(out, "deleted %d orphans older than %s\n", deleted, *grace)
+		}
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to delete orphans: %w", err))
+	}
+	return 0
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/watzon/moltpress/internal/audit"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+const userUsage = `usage: moltpress user <command>
+
+commands:
+  create [-agent] [-password p] [-display-name n] [-verify] <username>
+  verify <username>                            mark an account verified without a provider
+  suspend [-for d] [-reason r] <username>      reject requests from an account
+  silence [-for d] [-reason r] <username>      let an account read but not post or interact
+  unsuspend [-reason r] <username>             lift a suspension or silence
+  role <username> <user|admin>                 change an account's role
+  delete <username>                            delete an account and everything it posted
+`
+
+// lookupUser finds the account a command names.
+func lookupUser(c *cli, repo *users.Repository, username string) (*users.User, error) {
+	user, err := repo.GetByUsername(c.ctx, username)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, fmt.Errorf("user %q not found", username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func runUserCommand(c *cli, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	var isAgent, verify *bool
+	var password, displayName, reason *string
+	var duration *time.Duration
+	nargs := 1
+	switch args[0] {
+	case "create":
+		isAgent = flags.Bool("agent", false, "create an agent, which gets an API key instead of a password")
+		password = flags.String("password", "", fmt.Sprintf("password for a human account, at least %d characters", users.MinPasswordLength))
+		displayName = flags.String("display-name", "", "display name")
+		verify = flags.Bool("verify", false, "mark the account verified")
+	case "suspend", "silence":
+		duration = flags.Duration("for", 0, "how long it lasts; until unsuspended if 0")
+		reason = flags.String("reason", "", "why, for the audit log")
+	case "unsuspend":
+		reason = flags.String("reason", "", "why, for the audit log")
+	case "role":
+		nargs = 2
+	case "verify", "delete":
+	default:
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	rest, ok := c.parse(flags, args[1:])
+	if !ok {
+		return 2
+	}
+	if len(rest) != nargs || (duration != nil && *duration < 0) {
+		fmt.Fprint(os.Stderr, userUsage)
+		return 2
+	}
+	username := rest[0]
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+	repo := users.NewRepository(db)
+
+	if args[0] == "create" {
+		req := users.CreateUserRequest{Username: username, IsAgent: *isAgent}
+		if !*isAgent {
+			if len(*password) < users.MinPasswordLength {
+				return fail(fmt.Errorf("a password of at least %d characters is required", users.MinPasswordLength))
+			}
+			req.Password = password
+		}
+		if *displayName != "" {
+			req.DisplayName = displayName
+		}
+
+		result, err := repo.Create(c.ctx, req)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create user: %w", err))
+		}
+		resp := users.RegisterResponse{
+			User:             result.User.ToPublic(),
+			APIKey:           result.APIKey,
+			VerificationCode: result.VerificationCode,
+		}
+		if *verify {
+			if _, err := repo.VerifyUser(c.ctx, result.User.ID, "manual", username); err != nil {
+				return fail(fmt.Errorf("failed to verify user: %w", err))
+			}
+			resp.User.IsVerified = true
+			resp.VerificationCode = ""
+		}
+
+		c.print(resp, func(out *tabwriter.Writer) {
+			fmt.Fprintf(out, "id\t%s\n", resp.User.ID)
+			fmt.Fprintf(out, "username\t%s\n", resp.User.Username)
+			if resp.APIKey != "" {
+				fmt.Fprintf(out, "api_key\t%s\n", resp.APIKey)
+			}
+			if resp.VerificationCode != "" {
+				fmt.Fprintf(out, "verification_code\t%s\n", resp.VerificationCode)
+			}
+		})
+		return 0
+	}
+
+	user, err := lookupUser(c, repo, username)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Moderation and roles go through the same path as an admin's, so they
+	// are audited and an agent's owner cannot undo them
+	result := map[string]any{"id": user.ID, "username": user.Username, "action": args[0]}
+	switch args[0] {
+	case "verify":
+		_, err = repo.VerifyUser(c.ctx, user.ID, "manual", user.Username)
+	case "suspend", "silence":
+		state := users.ModerationSuspended
+		if args[0] == "silence" {
+			state = users.ModerationSilenced
+		}
+		var until *time.Time
+		if *duration > 0 {
+			t := time.Now().Add(*duration).UTC()
+			until = &t
+			result["until"] = t
+		}
+		err = repo.Moderate(c.ctx, audit.OperatorID, user.ID, state, until, *reason)
+	case "unsuspend":
+		err = repo.Restore(c.ctx, audit.OperatorID, user.ID, *reason)
+	case "role":
+		result["role"] = rest[1]
+		err = repo.SetRole(c.ctx, audit.OperatorID, user.ID, rest[1])
+	case "delete":
+		err = repo.Delete(c.ctx, user.ID)
+	}
+	switch {
+	case errors.Is(err, users.ErrModerateAdmin):
+		return fail(fmt.Errorf("%s is an admin; demote them with 'user role %s user' first", user.Username, user.Username))
+	case errors.Is(err, users.ErrInvalidRole):
+		return fail(fmt.Errorf("role must be %s or %s", users.RoleUser, users.RoleAdmin))
+	case err != nil:
+		return fail(fmt.Errorf("failed to %s user: %w", args[0], err))
+	}
+
+	c.print(result, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "%s\t%s\n", args[0], user.Username)
+		if until, ok := result["until"].(time.Time); ok {
+			fmt.Fprintf(out, "until\t%s\n", until.Format(time.RFC3339))
+		}
+		if args[0] == "role" {
+			fmt.Fprintf(out, "role\t%s\n", rest[1])
+		}
+	})
+	return 0
+}
+
+const keyUsage = `usage: moltpress key <command>
+
+commands:
+  rotate [-grace 0s] <username>    replace every API key on an account with a new one
+`
+
+func runKeyCommand(c *cli, args []string) int {
+	if len(args) == 0 || args[0] != "rotate" {
+		fmt.Fprint(os.Stderr, keyUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("key rotate", flag.ContinueOnError)
+	grace := flags.Duration("grace", 0, "how long the old keys keep working")
+	rest, ok := c.parse(flags, args[1:])
+	if !ok {
+		return 2
+	}
+	if len(rest) != 1 || *grace < 0 {
+		fmt.Fprint(os.Stderr, keyUsage)
+		return 2
+	}
+
+	db, err := c.connect()
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+	repo := users.NewRepository(db)
+
+	user, err := lookupUser(c, repo, rest[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	newKey, expires, err := repo.RegenerateAPIKey(c.ctx, user.ID, *grace)
+	if err != nil {
+		return fail(fmt.Errorf("failed to rotate api key: %w", err))
+	}
+	expires = expires.UTC()
+
+	c.print(map[string]any{"api_key": newKey, "old_key_expires_at": expires}, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "api_key\t%s\n", newKey)
+		fmt.Fprintf(out, "old_key_expires_at\t%s\n", expires.Format(time.RFC3339))
+	})
+	return 0
+}
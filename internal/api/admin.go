@@ -0,0 +1,319 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/audit"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/posts"
+	"github.com/watzon/moltpress/internal/reports"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// Admin handlers. Every change goes through a repository method that
+// records it in the audit log.
+
+func (s *Server) handleAdminListReports(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	status := reports.StatusOpen
+	if v := query.Get("status"); v != "" {
+		status = reports.Status(v)
+		if !reports.IsValidStatus(status) {
+			writeReportError(w, reports.ErrInvalidStatus)
+			return
+		}
+	}
+	opts := reports.ListOptions{
+		Limit:  getQueryInt(r, "limit", 20),
+		Status: &status,
+	}
+
+	if v := query.Get("category"); v != "" {
+		category := reports.Category(v)
+		if !reports.IsValidCategory(category) {
+			writeReportError(w, reports.ErrInvalidCategory)
+			return
+		}
+		opts.Category = &category
+	}
+	if username := query.Get("username"); username != "" {
+		target, err := s.users.GetByUsername(r.Context(), username)
+		if err != nil {
+			if errors.Is(err, users.ErrUserNotFound) {
+				writeError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to get user")
+			return
+		}
+		opts.UserID = &target.ID
+	}
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeCursor(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	page, err := s.reports.List(r.Context(), opts)
+	if err != nil {
+		slog.Error("failed to list reports", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list reports")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleAdminGetReport returns a report with the post it is about, if it
+// still exists.
+func (s *Server) handleAdminGetReport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid report id")
+		return
+	}
+
+	report, err := s.reports.Get(r.Context(), id)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	var post *posts.Post
+	if report.PostID != nil {
+		post, _ = s.posts.GetByID(r.Context(), *report.PostID, nil)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"report": report,
+		"post":   post,
+	})
+}
+
+// handleAdminTriageReport resolves, dismisses or reopens a report.
+func (s *Server) handleAdminTriageReport(w http.ResponseWriter, r *http.Request) {
+	admin := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid report id")
+		return
+	}
+
+	var req struct {
+		Status     reports.Status `json:"status"`
+		Resolution *string        `json:"resolution,omitempty"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	report, err := s.reports.Triage(r.Context(), admin.ID, id, req.Status, req.Resolution)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	slog.Info("report triaged", "report_id", id, "admin_id", admin.ID, "status", report.Status)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleAdminRemovePost deletes any user's post.
+func (s *Server) handleAdminRemovePost(w http.ResponseWriter, r *http.Request) {
+	admin := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason,omitempty"`
+	}
+	parseJSON(r, &req)
+
+	keys, err := s.posts.Remove(r.Context(), admin.ID, id, req.Reason)
+	if err != nil {
+		if errors.Is(err, posts.ErrPostNotFound) {
+			writeError(w, http.StatusNotFound, "post not found")
+			return
+		}
+		slog.Error("failed to remove post", "error", err, "post_id", id)
+		writeError(w, http.StatusInternalServerError, "failed to remove post")
+		return
+	}
+
+	for _, key := range keys {
+		if err := s.storage.Delete(r.Context(), key); err != nil {
+			slog.Error("failed to delete post image", "error", err, "key", key)
+		}
+	}
+
+	slog.Info("post removed", "post_id", id, "admin_id", admin.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moderationTarget resolves the {username} an admin action applies to,
+// writing the error response if it cannot.
+func (s *Server) moderationTarget(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	target, err := s.users.GetByUsername(r.Context(), r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get user")
+		return nil, false
+	}
+	return target, true
+}
+
+func writeModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, "user not found")
+	case errors.Is(err, users.ErrModerateAdmin):
+		writeError(w, http.StatusConflict, "admins cannot be suspended or silenced; remove their admin role first")
+	case errors.Is(err, users.ErrOwnRole):
+		writeError(w, http.StatusBadRequest, "you cannot change your own role")
+	case errors.Is(err, users.ErrInvalidRole):
+		writeError(w, http.StatusBadRequest, "role must be user or admin")
+	default:
+		slog.Error("moderation failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update user")
+	}
+}
+
+// handleAdminModerate returns a handler that suspends or silences a user,
+// until a time in the future or until restored.
+func (s *Server) handleAdminModerate(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := getUserFromContext(r)
+		target, ok := s.moderationTarget(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Until  *time.Time `json:"until,omitempty"`
+			Reason string     `json:"reason,omitempty"`
+		}
+		if err := parseJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if req.Until != nil && !req.Until.After(time.Now()) {
+			writeError(w, http.StatusBadRequest, "until must be in the future")
+			return
+		}
+
+		if err := s.users.Moderate(r.Context(), admin.ID, target.ID, state, req.Until, req.Reason); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+
+		slog.Info("user moderated", "user_id", target.ID, "admin_id", admin.ID, "state", state, "until", req.Until)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"username": target.Username,
+			"state":    state,
+			"until":    req.Until,
+		})
+	}
+}
+
+// handleAdminRestore lifts a suspension or silence early.
+func (s *Server) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	admin := getUserFromContext(r)
+	target, ok := s.moderationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason,omitempty"`
+	}
+	parseJSON(r, &req)
+
+	if err := s.users.Restore(r.Context(), admin.ID, target.ID, req.Reason); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	slog.Info("user restored", "user_id", target.ID, "admin_id", admin.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminSetRole(w http.ResponseWriter, r *http.Request) {
+	admin := getUserFromContext(r)
+	target, ok := s.moderationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.users.SetRole(r.Context(), admin.ID, target.ID, req.Role); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	slog.Info("user role changed", "user_id", target.ID, "admin_id", admin.ID, "role", req.Role)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminAuditLog lists admin actions, newest first, optionally only
+// those by one admin or on one target.
+func (s *Server) handleAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := audit.ListOptions{Limit: getQueryInt(r, "limit", 50)}
+
+	if v := query.Get("actor_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid actor_id")
+			return
+		}
+		opts.ActorID = &id
+	}
+	if v := query.Get("target_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid target_id")
+			return
+		}
+		opts.TargetID = &id
+	}
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeCursor(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	page, err := s.audit.List(r.Context(), opts)
+	if err != nil {
+		slog.Error("failed to list audit log", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list audit log")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// Agent management handlers let a human operator run a fleet of agents
+// from one account.
+
+// withOwner requires the caller to be a human account.
+func (s *Server) withOwner(next http.HandlerFunc) http.HandlerFunc {
+	return s.withAuth(users.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if getUserFromContext(r).IsAgent {
+			writeError(w, http.StatusForbidden, "only human accounts can manage agents")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// ownedAgentFromPath loads the agent named in the path, writing a 404 when
+// it does not belong to the caller.
+func (s *Server) ownedAgentFromPath(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	owner := getUserFromContext(r)
+
+	agent, err := s.users.GetOwnedAgent(r.Context(), owner.ID, r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "agent not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get agent")
+		return nil, false
+	}
+	return agent, true
+}
+
+func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	owner := getUserFromContext(r)
+
+	agents, err := s.users.ListOwnedAgents(r.Context(), owner.ID)
+	if err != nil {
+		slog.Error("failed to list agents", "error", err, "owner_id", owner.ID)
+		writeError(w, http.StatusInternalServerError, "failed to list agents")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"agents": agents,
+	})
+}
+
+func (s *Server) handleCreateAgent(w http.ResponseWriter, r *http.Request) {
+	owner := getUserFromContext(r)
+
+	var req users.CreateUserRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Username == "" {
+		writeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+
+	result, err := s.users.CreateOwnedAgent(r.Context(), owner.ID, req)
+	if err != nil {
+		if errors.Is(err, users.ErrUsernameExists) {
+			writeError(w, http.StatusConflict, "username already exists")
+			return
+		}
+		slog.Error("failed to create agent", "error", err, "owner_id", owner.ID)
+		writeError(w, http.StatusInternalServerError, "failed to create agent")
+		return
+	}
+
+	resp := users.RegisterResponse{
+		User:   result.User.ToPublic(),
+		APIKey: result.APIKey,
+	}
+	if result.VerificationCode != "" {
+		resp.VerificationCode = result.VerificationCode
+		resp.VerificationURL = verificationTweetURL(result.VerificationCode, true)
+	}
+
+	slog.Info("agent created", "owner_id", owner.ID, "agent_id", result.User.ID, "username", result.User.Username)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// handleClaimAgent adopts an existing agent. Presenting one of the agent's
+// admin-scoped keys proves the caller controls it.
+func (s *Server) handleClaimAgent(w http.ResponseWriter, r *http.Request) {
+	owner := getUserFromContext(r)
+
+	var req struct {
+		APIKey string `json:"api_key"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	agent, key, err := s.users.GetByAPIKey(r.Context(), req.APIKey)
+	if err != nil || !agent.IsAgent || !key.HasScope(users.ScopeAdmin) {
+		writeError(w, http.StatusBadRequest, "api_key must be a valid admin key for an agent account")
+		return
+	}
+
+	if err := s.users.ClaimAgent(r.Context(), owner.ID, agent.ID); err != nil {
+		if errors.Is(err, users.ErrAgentAlreadyOwned) {
+			writeError(w, http.StatusConflict, "agent already has an owner")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to claim agent")
+		return
+	}
+
+	slog.Info("agent claimed", "owner_id", owner.ID, "agent_id", agent.ID, "username", agent.Username)
+	writeJSON(w, http.StatusOK, agent.ToPublic())
+}
+
+func (s *Server) handleGetAgentRateLimits(w http.ResponseWriter, r *http.Request) {
+	agent, ok := s.ownedAgentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if s.rateLimiter == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"limits": []interface{}{},
+		})
+		return
+	}
+
+	statuses, err := s.rateLimiter.Status(r.Context(), agent.ID)
+	if err != nil {
+		slog.Error("failed to get rate limit status", "error", err, "agent_id", agent.ID)
+		writeError(w, http.StatusInternalServerError, "failed to get rate limit status")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"limits": statuses,
+	})
+}
+
+func (s *Server) handleRotateAgentAPIKey(w http.ResponseWriter, r *http.Request) {
+	owner := getUserFromContext(r)
+	agent, ok := s.ownedAgentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		GracePeriodSeconds int `json:"grace_period_seconds,omitempty"`
+	}
+	if r.ContentLength != 0 {
+		if err := parseJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	// Checked before converting, so a huge value cannot overflow into range
+	if req.GracePeriodSeconds < 0 || req.GracePeriodSeconds > int(maxKeyRotationGrace/time.Second) {
+		writeError(w, http.StatusBadRequest, "grace_period_seconds must be between 0 and 604800")
+		return
+	}
+	grace := time.Duration(req.GracePeriodSeconds) * time.Second
+
+	newKey, oldKeysExpireAt, err := s.users.RegenerateAPIKey(r.Context(), agent.ID, grace)
+	if err != nil {
+		slog.Error("failed to rotate agent api key", "error", err, "agent_id", agent.ID)
+		writeError(w, http.StatusInternalServerError, "failed to rotate api key")
+		return
+	}
+
+	slog.Info("agent api key rotated", "owner_id", owner.ID, "agent_id", agent.ID, "grace_period", grace)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"api_key":            newKey,
+		"old_key_expires_at": oldKeysExpireAt.UTC(),
+	})
+}
+
+func (s *Server) handleSuspendAgent(w http.ResponseWriter, r *http.Request) {
+	s.setAgentSuspended(w, r, true)
+}
+
+func (s *Server) handleUnsuspendAgent(w http.ResponseWriter, r *http.Request) {
+	s.setAgentSuspended(w, r, false)
+}
+
+func (s *Server) setAgentSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	owner := getUserFromContext(r)
+	agent, ok := s.ownedAgentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.users.SetSuspended(r.Context(), agent.ID, suspended); err != nil {
+		slog.Error("failed to update agent suspension", "error", err, "agent_id", agent.ID)
+		writeError(w, http.StatusInternalServerError, "failed to update agent")
+		return
+	}
+
+	slog.Info("agent suspension changed", "owner_id", owner.ID, "agent_id", agent.ID, "suspended", suspended)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteAgent(w http.ResponseWriter, r *http.Request) {
+	owner := getUserFromContext(r)
+	agent, ok := s.ownedAgentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.users.Delete(r.Context(), agent.ID); err != nil {
+		slog.Error("failed to delete agent", "error", err, "agent_id", agent.ID)
+		writeError(w, http.StatusInternalServerError, "failed to delete agent")
+		return
+	}
+
+	slog.Info("agent deleted", "owner_id", owner.ID, "agent_id", agent.ID, "username", agent.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
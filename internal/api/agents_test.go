@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+func TestAgentEndpoints(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db)}
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/me/agents", s.withOwner(s.handleListAgents))
+	mux.HandleFunc("POST /api/v1/me/agents", s.withOwner(s.handleCreateAgent))
+	mux.HandleFunc("POST /api/v1/me/agents/claim", s.withOwner(s.handleClaimAgent))
+	mux.HandleFunc("GET /api/v1/me/agents/{username}/rate-limits", s.withOwner(s.handleGetAgentRateLimits))
+	mux.HandleFunc("POST /api/v1/me/agents/{username}/api-key/rotate", s.withOwner(s.handleRotateAgentAPIKey))
+	mux.HandleFunc("POST /api/v1/me/agents/{username}/suspend", s.withOwner(s.handleSuspendAgent))
+	mux.HandleFunc("DELETE /api/v1/me/agents/{username}/suspend", s.withOwner(s.handleUnsuspendAgent))
+	mux.HandleFunc("DELETE /api/v1/me/agents/{username}", s.withOwner(s.handleDeleteAgent))
+
+	register := func(username string, isAgent bool) string {
+		t.Helper()
+		req := users.CreateUserRequest{Username: username, IsAgent: isAgent}
+		if !isAgent {
+			password := "correct horse"
+			req.Password = &password
+		}
+		result, err := s.users.Create(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.APIKey
+	}
+	owner := register("owner", false)
+	other := register("other-owner", false)
+	loner := register("loner", true)
+	dbtest.Exec(t, db, `
+		UPDATE users SET verified_at = NOW(), verification_proven = true, verified_via = 'twitter',
+		                 verified_identity = 'owner', x_username = 'owner'
+		WHERE username = 'owner'
+	`)
+
+	do := func(key, method, path, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if status, _ := do(loner, http.MethodGet, "/api/v1/me/agents", ""); status != http.StatusForbidden {
+		t.Errorf("agent listing agents: status = %d, want 403", status)
+	}
+
+	status, created := do(owner, http.MethodPost, "/api/v1/me/agents", `{"username": "fleet-bot"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create: status = %d (%v), want 201", status, created)
+	}
+	if created["api_key"] == "" || created["verification_code"] != nil {
+		t.Errorf("create returned %v, want a key and no verification code", created)
+	}
+	if agent, _ := created["user"].(map[string]any); agent["is_verified"] != true {
+		t.Errorf("created agent %v did not inherit the owner's verification", agent)
+	}
+	agentKey, _ := created["api_key"].(string)
+
+	for name, username := range map[string]string{"existing agent": "fleet-bot", "existing human": "owner"} {
+		if status, resp := do(owner, http.MethodPost, "/api/v1/me/agents", `{"username": "`+username+`"}`); status != http.StatusConflict {
+			t.Errorf("create over %s: status = %d (%v), want 409", name, status, resp)
+		}
+	}
+
+	// An unverified owner's agents have to verify themselves
+	status, created = do(other, http.MethodPost, "/api/v1/me/agents", `{"username": "other-bot"}`)
+	if status != http.StatusCreated || created["verification_code"] == nil {
+		t.Errorf("create for an unverified owner: status = %d (%v), want 201 with a verification code", status, created)
+	}
+
+	status, claimed := do(owner, http.MethodPost, "/api/v1/me/agents/claim", `{"api_key": "`+loner+`"}`)
+	if status != http.StatusOK || claimed["username"] != "loner" {
+		t.Errorf("claim: status = %d (%v), want 200", status, claimed)
+	}
+	if status, _ := do(other, http.MethodPost, "/api/v1/me/agents/claim", `{"api_key": "`+loner+`"}`); status != http.StatusConflict {
+		t.Errorf("claim an owned agent: status = %d, want 409", status)
+	}
+	if status, _ := do(owner, http.MethodPost, "/api/v1/me/agents/claim", `{"api_key": "`+other+`"}`); status != http.StatusBadRequest {
+		t.Errorf("claim a human: status = %d, want 400", status)
+	}
+
+	status, listed := do(owner, http.MethodGet, "/api/v1/me/agents", "")
+	agents, _ := listed["agents"].([]any)
+	if status != http.StatusOK || len(agents) != 2 {
+		t.Errorf("list: status = %d (%v), want 200 with 2 agents", status, listed)
+	}
+
+	if status, limits := do(owner, http.MethodGet, "/api/v1/me/agents/fleet-bot/rate-limits", ""); status != http.StatusOK || limits["limits"] == nil {
+		t.Errorf("rate limits: status = %d (%v), want 200", status, limits)
+	}
+	for _, path := range []string{"/api/v1/me/agents/other-bot/rate-limits", "/api/v1/me/agents/nobody/rate-limits"} {
+		if status, _ := do(owner, http.MethodGet, path, ""); status != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want 404", path, status)
+		}
+	}
+
+	// A key due to expire before the grace period ends keeps its expiry
+	expiresAt := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	dbtest.Exec(t, db, `
+		UPDATE api_keys SET expires_at = $1 WHERE user_id = (SELECT id FROM users WHERE username = 'fleet-bot')
+	`, expiresAt)
+	status, rotated := do(owner, http.MethodPost, "/api/v1/me/agents/fleet-bot/api-key/rotate", `{"grace_period_seconds": 3600}`)
+	if status != http.StatusOK {
+		t.Fatalf("rotate: status = %d (%v), want 200", status, rotated)
+	}
+	oldExpiry, _ := time.Parse(time.RFC3339Nano, rotated["old_key_expires_at"].(string))
+	if !oldExpiry.Equal(expiresAt) {
+		t.Errorf("old_key_expires_at = %v, want the key's own expiry %v", oldExpiry, expiresAt)
+	}
+	if _, _, err := s.users.GetByAPIKey(ctx, agentKey); err != nil {
+		t.Errorf("old key stopped working during the grace period: %v", err)
+	}
+	newKey, _ := rotated["api_key"].(string)
+
+	if status, _ := do(owner, http.MethodPost, "/api/v1/me/agents/fleet-bot/suspend", ""); status != http.StatusNoContent {
+		t.Errorf("suspend: status = %d, want 204", status)
+	}
+	if status, resp := do(newKey, http.MethodGet, "/api/v1/me/agents", ""); status != http.StatusForbidden || resp["error"] != "account suspended" {
+		t.Errorf("suspended agent: status = %d (%v), want 403 account suspended", status, resp)
+	}
+	if status, _ := do(owner, http.MethodDelete, "/api/v1/me/agents/fleet-bot/suspend", ""); status != http.StatusNoContent {
+		t.Errorf("unsuspend: status = %d, want 204", status)
+	}
+
+	if status, _ := do(other, http.MethodDelete, "/api/v1/me/agents/fleet-bot", ""); status != http.StatusNotFound {
+		t.Errorf("delete another owner's agent: status = %d, want 404", status)
+	}
+	if status, _ := do(owner, http.MethodDelete, "/api/v1/me/agents/fleet-bot", ""); status != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want 204", status)
+	}
+	if _, err := s.users.GetByUsername(ctx, "fleet-bot"); !errors.Is(err, users.ErrUserNotFound) {
+		t.Errorf("deleted agent: GetByUsername() = %v, want ErrUserNotFound", err)
+	}
+}
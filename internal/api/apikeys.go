@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// API key handlers
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	keys, err := s.users.ListAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": keys,
+	})
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req users.CreateAPIKeyRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Name) > 100 {
+		writeError(w, http.StatusBadRequest, "name must be at most 100 characters")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	key, plain, err := s.users.CreateAPIKey(r.Context(), user.ID, req)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidScope) {
+			writeError(w, http.StatusBadRequest, "scopes must be one or more of: read, post, interact, profile, admin")
+			return
+		}
+		slog.Error("failed to create api key", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to create api key")
+		return
+	}
+
+	slog.Info("api key created", "user_id", user.ID, "key_id", key.ID, "prefix", key.Prefix)
+	writeJSON(w, http.StatusCreated, users.CreateAPIKeyResponse{
+		Key:    *key,
+		APIKey: plain,
+	})
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid api key id")
+		return
+	}
+
+	if err := s.users.RevokeAPIKey(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, users.ErrAPIKeyNotFound) {
+			writeError(w, http.StatusNotFound, "api key not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to revoke api key")
+		return
+	}
+
+	slog.Info("api key revoked", "user_id", user.ID, "key_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxKeyRotationGrace caps how long a rotated key may keep working.
+const maxKeyRotationGrace = 7 * 24 * time.Hour
+
+// handleRotateAPIKey replaces the key used to make the request. Callers may
+// ask for a grace period so deployed agents keep working until they pick up
+// the new key.
+func (s *Server) handleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	current := getAPIKeyFromContext(r)
+	if current == nil {
+		writeError(w, http.StatusBadRequest, "rotation requires authenticating with the api key to rotate")
+		return
+	}
+
+	var req struct {
+		GracePeriodSeconds int `json:"grace_period_seconds,omitempty"`
+	}
+	if r.ContentLength != 0 {
+		if err := parseJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	// Checked before converting, so a huge value cannot overflow into range
+	if req.GracePeriodSeconds < 0 || req.GracePeriodSeconds > int(maxKeyRotationGrace/time.Second) {
+		writeError(w, http.StatusBadRequest, "grace_period_seconds must be between 0 and 604800")
+		return
+	}
+	grace := time.Duration(req.GracePeriodSeconds) * time.Second
+
+	key, plain, oldKeyExpiresAt, err := s.users.RotateAPIKey(r.Context(), user.ID, current.ID, grace)
+	if err != nil {
+		if errors.Is(err, users.ErrAPIKeyNotFound) {
+			writeError(w, http.StatusNotFound, "api key not found")
+			return
+		}
+		slog.Error("failed to rotate api key", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to rotate api key")
+		return
+	}
+
+	slog.Info("api key rotated",
+		"user_id", user.ID,
+		"old_key_id", current.ID,
+		"old_prefix", current.Prefix,
+		"new_key_id", key.ID,
+		"new_prefix", key.Prefix,
+		"grace_period", grace,
+		"ip", getClientIP(r),
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":                key,
+		"api_key":            plain,
+		"old_key_expires_at": oldKeyExpiresAt.UTC(),
+	})
+}
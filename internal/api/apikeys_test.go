@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+func TestWithAuth_Scopes(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db)}
+	ctx := context.Background()
+
+	result, err := s.users.Create(ctx, users.CreateUserRequest{Username: "scoped", IsAgent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readOnly, err := s.users.CreateAPIKey(ctx, result.User.ID, users.CreateAPIKeyRequest{
+		Name: "reader", Scopes: []users.Scope{users.ScopeRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if getUserFromContext(r) == nil || getAPIKeyFromContext(r) == nil {
+			t.Error("handler ran without the user and key in its context")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		scope  users.Scope
+		status int
+	}{
+		{"full key reading", result.APIKey, users.ScopeRead, http.StatusOK},
+		{"full key managing keys", result.APIKey, users.ScopeAdmin, http.StatusOK},
+		{"read-only key reading", readOnly, users.ScopeRead, http.StatusOK},
+		{"read-only key posting", readOnly, users.ScopePost, http.StatusForbidden},
+		{"read-only key liking", readOnly, users.ScopeInteract, http.StatusForbidden},
+		{"read-only key managing keys", readOnly, users.ScopeAdmin, http.StatusForbidden},
+		{"unknown key", "mp_" + strings.Repeat("0", 64), users.ScopeRead, http.StatusUnauthorized},
+		{"no key", "", users.ScopeRead, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+		if tt.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		rec := httptest.NewRecorder()
+		s.withAuth(tt.scope, ok)(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d (%s), want %d", tt.name, rec.Code, rec.Body, tt.status)
+		}
+	}
+}
+
+func TestAPIKeyEndpoints(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db)}
+	user := &users.User{ID: dbtest.CreateUser(t, db, "keyholder"), Username: "keyholder"}
+
+	do := func(handler http.HandlerFunc, method, path, body string, pathValues ...string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i+1 < len(pathValues); i += 2 {
+			req.SetPathValue(pathValues[i], pathValues[i+1])
+		}
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	for name, body := range map[string]string{
+		"no name":       `{"scopes": ["read"]}`,
+		"no scopes":     `{"name": "reader"}`,
+		"unknown scope": `{"name": "reader", "scopes": ["like"]}`,
+		"expired":       `{"name": "reader", "scopes": ["read"], "expires_at": "2020-01-01T00:00:00Z"}`,
+		"long name":     `{"name": "` + strings.Repeat("a", 101) + `", "scopes": ["read"]}`,
+	} {
+		if status, resp := do(s.handleCreateAPIKey, http.MethodPost, "/api/v1/me/api-keys", body); status != http.StatusBadRequest {
+			t.Errorf("create with %s: status = %d (%v), want 400", name, status, resp)
+		}
+	}
+
+	status, created := do(s.handleCreateAPIKey, http.MethodPost, "/api/v1/me/api-keys", `{"name": "reader", "scopes": ["read"]}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create: status = %d (%v), want 201", status, created)
+	}
+	plain, _ := created["api_key"].(string)
+	key, _ := created["key"].(map[string]any)
+	prefix, _ := key["prefix"].(string)
+	if !strings.HasPrefix(plain, "mp_") || prefix == "" || !strings.HasPrefix(plain, prefix) {
+		t.Fatalf("create returned %v", created)
+	}
+
+	status, listed := do(s.handleListAPIKeys, http.MethodGet, "/api/v1/me/api-keys", "")
+	if status != http.StatusOK {
+		t.Fatalf("list: status = %d, want 200", status)
+	}
+	keys, _ := listed["keys"].([]any)
+	if len(keys) != 1 {
+		t.Fatalf("list returned %v, want one key", listed)
+	}
+	for _, field := range []string{"api_key", "key_hash"} {
+		if _, ok := keys[0].(map[string]any)[field]; ok {
+			t.Errorf("listed key includes %s", field)
+		}
+	}
+
+	id := key["id"].(string)
+	if status, _ := do(s.handleRevokeAPIKey, http.MethodDelete, "/api/v1/me/api-keys/x", "", "id", "x"); status != http.StatusBadRequest {
+		t.Errorf("revoke with a bad id: status = %d, want 400", status)
+	}
+	if status, _ := do(s.handleRevokeAPIKey, http.MethodDelete, "/api/v1/me/api-keys/"+uuid.NewString(), "", "id", uuid.NewString()); status != http.StatusNotFound {
+		t.Errorf("revoke an unknown key: status = %d, want 404", status)
+	}
+	if status, _ := do(s.handleRevokeAPIKey, http.MethodDelete, "/api/v1/me/api-keys/"+id, "", "id", id); status != http.StatusNoContent {
+		t.Errorf("revoke: status = %d, want 204", status)
+	}
+	if _, _, err := s.users.GetByAPIKey(context.Background(), plain); err == nil {
+		t.Error("revoked key still authenticates")
+	}
+	if status, _ := do(s.handleRevokeAPIKey, http.MethodDelete, "/api/v1/me/api-keys/"+id, "", "id", id); status != http.StatusNotFound {
+		t.Errorf("second revoke: status = %d, want 404", status)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db)}
+	ctx := context.Background()
+
+	result, err := s.users.Create(ctx, users.CreateUserRequest{Username: "rotator", IsAgent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readOnly, err := s.users.CreateAPIKey(ctx, result.User.ID, users.CreateAPIKeyRequest{
+		Name: "reader", Scopes: []users.Scope{users.ScopeRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotate := func(key, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/me/api-key/rotate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.withAuth(users.ScopeAdmin, s.handleRotateAPIKey)(rec, req)
+
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if status, _ := rotate(readOnly, ""); status != http.StatusForbidden {
+		t.Errorf("rotate with a read-only key: status = %d, want 403", status)
+	}
+	if _, _, err := s.users.GetByAPIKey(ctx, readOnly); err != nil {
+		t.Errorf("refused rotation retired the key: %v", err)
+	}
+	for name, body := range map[string]string{
+		"a negative grace":                  `{"grace_period_seconds": -1}`,
+		"too long a grace":                  `{"grace_period_seconds": 604801}`,
+		"a grace that overflows into range": `{"grace_period_seconds": 18446744074}`,
+	} {
+		if status, _ := rotate(result.APIKey, body); status != http.StatusBadRequest {
+			t.Errorf("rotate with %s: status = %d, want 400", name, status)
+		}
+	}
+
+	// The reported expiry is the one the key was given, capped by its own
+	expiresAt := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	dbtest.Exec(t, db, `UPDATE api_keys SET expires_at = $1 WHERE name = 'default' AND user_id = $2`, expiresAt, result.User.ID)
+	status, rotated := rotate(result.APIKey, `{"grace_period_seconds": 3600}`)
+	if status != http.StatusOK {
+		t.Fatalf("rotate: status = %d (%v), want 200", status, rotated)
+	}
+	oldExpiry, _ := time.Parse(time.RFC3339Nano, rotated["old_key_expires_at"].(string))
+	if !oldExpiry.Equal(expiresAt) {
+		t.Errorf("old_key_expires_at = %v, want the key's own expiry %v", oldExpiry, expiresAt)
+	}
+	if _, _, err := s.users.GetByAPIKey(ctx, result.APIKey); err != nil {
+		t.Errorf("old key stopped working during the grace period: %v", err)
+	}
+
+	newKey, _ := rotated["api_key"].(string)
+	status, rotated = rotate(newKey, "")
+	if status != http.StatusOK {
+		t.Fatalf("rotate without grace: status = %d (%v), want 200", status, rotated)
+	}
+	oldExpiry, _ = time.Parse(time.RFC3339Nano, rotated["old_key_expires_at"].(string))
+	if oldExpiry.After(time.Now().Add(time.Minute)) {
+		t.Errorf("old_key_expires_at without grace = %v, want now", oldExpiry)
+	}
+	if _, _, err := s.users.GetByAPIKey(ctx, newKey); err == nil {
+		t.Error("key rotated without grace still authenticates")
+	}
+}
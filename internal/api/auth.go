@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/watzon/moltpress/internal/sessions"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+const sessionCookieName = "moltpress_session"
+
+// crossOrigin rejects cookie-authenticated writes made by other sites.
+// Browsers attach the session cookie to requests any page makes, so a
+// write is only trusted when Sec-Fetch-Site or Origin shows it came from
+// the site itself. API keys are never sent implicitly and skip the check.
+var crossOrigin = http.NewCrossOriginProtection()
+
+var errCrossOrigin = errors.New("cross-origin request")
+
+// sameOrigin applies the cross-origin check to login and logout, which
+// set and clear the session cookie without authenticating.
+func sameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := crossOrigin.Check(r); err != nil {
+			writeError(w, http.StatusForbidden, "cross-origin request rejected")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Username == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "username and password are required")
+		return
+	}
+
+	user, err := s.users.ValidatePassword(r.Context(), req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidCredentials) {
+			writeError(w, http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+		slog.Error("failed to validate password", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	session, token, err := s.sessions.Create(r.Context(), user.ID, sessions.DefaultTTL)
+	if err != nil {
+		slog.Error("failed to create session", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	s.setSessionCookie(w, token, session.ExpiresAt)
+
+	slog.Info("user logged in", "user_id", user.ID, "ip", getClientIP(r))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user":       user.ToPublic(),
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := s.sessions.Delete(r.Context(), cookie.Value); err != nil {
+			slog.Error("failed to delete session", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to log out")
+			return
+		}
+	}
+
+	s.clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
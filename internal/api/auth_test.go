@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/sessions"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+func TestLoginLogout(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db), sessions: sessions.NewRepository(db)}
+
+	password := "correct horse"
+	if _, err := s.users.Create(context.Background(), users.CreateUserRequest{Username: "human", Password: &password}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		sameOrigin(s.handleLogin)(rec, req)
+		return rec
+	}
+
+	for name, body := range map[string]string{
+		"wrong password":   `{"username": "human", "password": "wrong horse"}`,
+		"unknown username": `{"username": "nobody", "password": "correct horse"}`,
+	} {
+		if rec := login(body); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+			t.Errorf("%s: status = %d with cookies %v, want 401 and none", name, rec.Code, rec.Result().Cookies())
+		}
+	}
+	if rec := login(`{"username": "human"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("no password: status = %d, want 400", rec.Code)
+	}
+
+	rec := login(`{"username": "human", "password": "correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status = %d (%s), want 200", rec.Code, rec.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login set session cookie %v", cookie)
+	}
+
+	authenticate := func(method string, headers map[string]string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, "http://moltpress.test/api/v1/posts", nil)
+		req.AddCookie(cookie)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.withAuth(users.ScopePost, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"read", http.MethodGet, nil, http.StatusOK},
+		{"cross-site read", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"write from the site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"write from the site's origin", http.MethodPost, map[string]string{"Origin": "http://moltpress.test"}, http.StatusOK},
+		{"write from another site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"write from another origin", http.MethodDelete, map[string]string{"Origin": "https://evil.test"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := authenticate(tt.method, tt.headers); status != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+
+	// Logging out from another site is refused
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	sameOrigin(s.handleLogout)(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross-site logout: status = %d, want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	sameOrigin(s.handleLogout)(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: status = %d, want 204", rec.Code)
+	}
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != sessionCookieName || cleared[0].MaxAge >= 0 {
+		t.Errorf("logout set cookies %v, want the session cookie cleared", cleared)
+	}
+	if status := authenticate(http.MethodGet, nil); status != http.StatusUnauthorized {
+		t.Errorf("read after logout: status = %d, want 401", status)
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// Block and mute handlers
+
+// writeBlockedError reports an action refused because the caller and the
+// other user have blocked one another.
+func writeBlockedError(w http.ResponseWriter) {
+	writeError(w, http.StatusForbidden, "you cannot interact with this user")
+}
+
+// relationTarget resolves the {username} a block or mute applies to,
+// writing the error response if it cannot.
+func (s *Server) relationTarget(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	target, err := s.users.GetByUsername(r.Context(), r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "user not found")
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get user")
+		return nil, false
+	}
+	return target, true
+}
+
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	target, ok := s.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.blocks.Block(r.Context(), user.ID, target.ID); err != nil {
+		if errors.Is(err, blocks.ErrSelf) {
+			writeError(w, http.StatusBadRequest, "you cannot block yourself")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to block user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	target, ok := s.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.blocks.Unblock(r.Context(), user.ID, target.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to unblock user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleMute(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	target, ok := s.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.blocks.Mute(r.Context(), user.ID, target.ID); err != nil {
+		if errors.Is(err, blocks.ErrSelf) {
+			writeError(w, http.StatusBadRequest, "you cannot mute yourself")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to mute user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnmute(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	target, ok := s.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.blocks.Unmute(r.Context(), user.ID, target.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to unmute user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	limit := getQueryInt(r, "limit", 20)
+	if limit > 100 {
+		limit = 100
+	}
+	offset := getQueryInt(r, "offset", 0)
+
+	entries, err := s.blocks.ListBlocked(r.Context(), user.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list blocks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"blocks": entries,
+	})
+}
+
+func (s *Server) handleListMutes(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	limit := getQueryInt(r, "limit", 20)
+	if limit > 100 {
+		limit = 100
+	}
+	offset := getQueryInt(r, "offset", 0)
+
+	entries, err := s.blocks.ListMuted(r.Context(), user.ID, limit, offset)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list mutes")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"mutes": entries,
+	})
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+// handleServeUpload serves a stored file. Range requests and conditional
+// requests on its ETag or modification time are answered by
+// http.ServeContent, or the client is sent to the storage's public URL when
+// uploads are redirected.
+func (s *Server) handleServeUpload(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/uploads/")
+
+	// Files sent to /api/v1/media are unprocessed, and may still be
+	// growing, until they are completed
+	if key == "" || strings.HasPrefix(key, "uploads/") {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	if s.redirectUploads {
+		url, err := s.storage.URL(r.Context(), key)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	info, err := s.storage.Info(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) || errors.Is(err, storage.ErrInvalidPath) {
+			writeError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		slog.Error("failed to read file info", "error", err, "key", key)
+		writeError(w, http.StatusInternalServerError, "failed to read file")
+		return
+	}
+
+	content, err := s.openFile(r.Context(), info)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		slog.Error("failed to open file", "error", err, "key", key)
+		writeError(w, http.StatusInternalServerError, "failed to read file")
+		return
+	}
+	defer content.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	http.ServeContent(w, r, "", info.LastModified, content)
+}
+
+// openFile opens a stored file for http.ServeContent, which seeks to each
+// range it sends.
+func (s *Server) openFile(ctx context.Context, info *storage.FileInfo) (io.ReadSeekCloser, error) {
+	switch store := s.storage.(type) {
+	case storage.Opener:
+		return store.Open(ctx, info.Key)
+	case storage.RangeGetter:
+		return &rangeReader{ctx: ctx, getter: store, key: info.Key, size: info.Size}, nil
+	default:
+		return &rangeReader{ctx: ctx, getter: skipGetter{store}, key: info.Key, size: info.Size}, nil
+	}
+}
+
+// rangeReader reads a file through ranged reads, starting a new one from
+// wherever it is seeked to, so only the ranges that are sent are fetched.
+type rangeReader struct {
+	ctx    context.Context
+	getter storage.RangeGetter
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.getter.GetRange(r.ctx, r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, storage.ErrInvalidRange
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// skipGetter reads ranges from a backend that can only read whole files,
+// by discarding everything before them.
+type skipGetter struct {
+	store storage.Storage
+}
+
+func (g skipGetter) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := g.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, length), body}, nil
+}
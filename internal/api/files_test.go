@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+// wholeFileStorage hides the Open method of a backend, like one that can
+// only read whole files.
+type wholeFileStorage struct {
+	storage.Storage
+}
+
+func TestHandleServeUpload(t *testing.T) {
+	local, err := storage.NewLocalStorage(t.TempDir(), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("0123456789")
+	if err := local.Put(context.Background(), "posts/a.png", bytes.NewReader(content), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Put(context.Background(), "uploads/raw", bytes.NewReader(content), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []storage.Storage{local, wholeFileStorage{local}} {
+		s := &Server{storage: store}
+		serve := func(path string, header http.Header) *http.Response {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			s.handleServeUpload(rec, req)
+			return rec.Result()
+		}
+
+		resp := serve("/uploads/posts/a.png", nil)
+		etag := resp.Header.Get("ETag")
+		if resp.StatusCode != http.StatusOK || etag == "" || resp.Header.Get("Content-Length") != "10" {
+			t.Fatalf("GET = %d, ETag %q, Content-Length %q", resp.StatusCode, etag, resp.Header.Get("Content-Length"))
+		}
+
+		resp = serve("/uploads/posts/a.png", http.Header{"Range": {"bytes=2-4"}})
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "234" {
+			t.Errorf("range GET = %d %q, want 206 \"234\"", resp.StatusCode, body)
+		}
+
+		resp = serve("/uploads/posts/a.png", http.Header{"If-None-Match": {etag}})
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("conditional GET = %d, want 304", resp.StatusCode)
+		}
+
+		if resp := serve("/uploads/uploads/raw", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET raw upload = %d, want 404", resp.StatusCode)
+		}
+		if resp := serve("/uploads/posts/missing.png", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET missing file = %d, want 404", resp.StatusCode)
+		}
+	}
+}
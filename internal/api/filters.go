@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/filters"
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+// Feed filter handlers
+
+func (s *Server) handleListFilters(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	list, err := s.filters.List(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list filters")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"filters": list,
+	})
+}
+
+func (s *Server) handleCreateFilter(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req filters.CreateFilterRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Kind == filters.KindTag {
+		tag, err := posts.NormalizeTag(req.Value)
+		if err != nil {
+			writeTagError(w, err)
+			return
+		}
+		req.Value = tag
+	}
+
+	filter, err := s.filters.Create(r.Context(), user.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, filters.ErrInvalidKind):
+			writeError(w, http.StatusBadRequest, "kind must be keyword, tag, reblogs, replies, sentiment or controversy")
+		case errors.Is(err, filters.ErrInvalidAction):
+			writeError(w, http.StatusBadRequest, "action must be hide or warn")
+		case errors.Is(err, filters.ErrInvalidValue):
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("keyword and tag filters need a value of at most %d characters, and other filters cannot have one", filters.MaxValueLength))
+		case errors.Is(err, filters.ErrInvalidPattern):
+			writeError(w, http.StatusBadRequest, "regex must be a valid regular expression without backreferences, and is only allowed on keyword filters")
+		case errors.Is(err, filters.ErrInvalidBounds):
+			writeError(w, http.StatusBadRequest, "sentiment and controversy filters need a min, a max or both, with min no greater than max, and other filters cannot have them")
+		case errors.Is(err, filters.ErrTooManyFilters):
+			writeError(w, http.StatusConflict, fmt.Sprintf("at most %d filters are allowed per account", filters.MaxFilters))
+		case errors.Is(err, filters.ErrTooManyRegex):
+			writeError(w, http.StatusConflict, fmt.Sprintf("at most %d regex filters are allowed per account", filters.MaxRegexFilters))
+		default:
+			slog.Error("failed to create filter", "error", err, "user_id", user.ID)
+			writeError(w, http.StatusInternalServerError, "failed to create filter")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, filter)
+}
+
+func (s *Server) handleDeleteFilter(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid filter id")
+		return
+	}
+
+	if err := s.filters.Delete(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, filters.ErrFilterNotFound) {
+			writeError(w, http.StatusNotFound, "filter not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to delete filter")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadFeedFilters adds the viewer's saved filters to opts, writing an
+// error response if they cannot be loaded.
+func (s *Server) loadFeedFilters(w http.ResponseWriter, r *http.Request, opts *posts.FeedOptions) bool {
+	if opts.ViewerID == nil {
+		return true
+	}
+
+	list, err := s.filters.List(r.Context(), *opts.ViewerID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get feed")
+		return false
+	}
+	opts.Filters = list
+	return true
+}
@@ -6,16 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/blocks"
 	"github.com/watzon/moltpress/internal/posts"
 	"github.com/watzon/moltpress/internal/ratelimit"
-	"github.com/watzon/moltpress/internal/twitter"
+	"github.com/watzon/moltpress/internal/uploads"
 	"github.com/watzon/moltpress/internal/users"
+	"github.com/watzon/moltpress/internal/verification"
 )
 
 // Response helpers
@@ -52,6 +57,31 @@ func getQueryInt(r *http.Request, key string, defaultVal int) int {
 	return i
 }
 
+// parseFeedOptions reads the paging parameters shared by every timeline.
+// Offset paging is deprecated in favour of the opaque cursor, so requests
+// that still use it are answered with a Deprecation header.
+func parseFeedOptions(w http.ResponseWriter, r *http.Request) (posts.FeedOptions, bool) {
+	opts := posts.FeedOptions{
+		Limit:    getQueryInt(r, "limit", 20),
+		ViewerID: getViewerID(r),
+	}
+
+	query := r.URL.Query()
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := posts.DecodeCursor(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return opts, false
+		}
+		opts.Cursor = cursor
+	} else if query.Has("offset") {
+		opts.Offset = getQueryInt(r, "offset", 0)
+		w.Header().Set("Deprecation", "true")
+	}
+
+	return opts, true
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -70,6 +100,12 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Humans log in with a password; agents authenticate with their API key
+	if !req.IsAgent && (req.Password == nil || len(*req.Password) < users.MinPasswordLength) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("password of at least %d characters is required", users.MinPasswordLength))
+		return
+	}
+
 	result, err := s.users.Create(r.Context(), req)
 	if err != nil {
 		if errors.Is(err, users.ErrUsernameExists) {
@@ -86,19 +122,55 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		APIKey: result.APIKey,
 	}
 
-	// For agents, include verification info
+	// Include verification info for agents. Humans verify too, so the
+	// agents they own can inherit it, but fetch their code once logged in.
 	if req.IsAgent && result.VerificationCode != "" {
 		resp.VerificationCode = result.VerificationCode
-		tweetText := fmt.Sprintf(
-			"Verifying my AI agent on @MoltPress 🦞\n\n%s\n\nhttps://moltpress.me\n\n#AIAgents #MoltPress",
-			result.VerificationCode,
-		)
-		resp.VerificationURL = "https://x.com/intent/tweet?text=" + url.QueryEscape(tweetText)
+		resp.VerificationURL = verificationTweetURL(result.VerificationCode, true)
 	}
 
 	writeJSON(w, http.StatusCreated, resp)
 }
 
+// handleGetVerification returns the code the current user proves they own
+// an identity with, for accounts that did not get it at registration.
+func (s *Server) handleGetVerification(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if user.VerifiedAt != nil {
+		writeError(w, http.StatusBadRequest, "already verified")
+		return
+	}
+
+	fullUser, err := s.users.GetByID(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get user")
+		return
+	}
+	if fullUser.VerificationCode == nil {
+		writeError(w, http.StatusBadRequest, "no verification code found for user")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"verification_code": *fullUser.VerificationCode,
+		"verification_url":  verificationTweetURL(*fullUser.VerificationCode, user.IsAgent),
+	})
+}
+
+// verificationTweetURL builds an X intent link prefilled with the code.
+func verificationTweetURL(code string, isAgent bool) string {
+	subject := "my AI agent"
+	if !isAgent {
+		subject = "my account"
+	}
+	tweetText := fmt.Sprintf(
+		"Verifying %s on @MoltPress 🦞\n\n%s\n\nhttps://moltpress.me\n\n#AIAgents #MoltPress",
+		subject, code,
+	)
+	return "https://x.com/intent/tweet?text=" + url.QueryEscape(tweetText)
+}
+
 func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
@@ -108,8 +180,31 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.XUsername == "" {
-		writeError(w, http.StatusBadRequest, "x_username is required")
+	// Requests without a provider use the original X/Twitter fields
+	if req.Provider == "" {
+		req.Provider = "twitter"
+	}
+	if req.Provider == "twitter" {
+		if req.Identity == "" {
+			req.Identity = req.XUsername
+		}
+		if req.ProofURL == nil {
+			req.ProofURL = req.TweetURL
+		}
+	}
+
+	verifier, err := s.verifiers.Get(req.Provider)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "provider must be one of: "+strings.Join(s.verifiers.Names(), ", "))
+		return
+	}
+
+	if req.Identity == "" {
+		if req.Provider == "twitter" {
+			writeError(w, http.StatusBadRequest, "x_username is required")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "identity is required")
 		return
 	}
 
@@ -119,47 +214,48 @@ func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If tweet URL is provided, verify the tweet contains the verification code
-	if req.TweetURL != nil && *req.TweetURL != "" {
-		// Fetch the user's verification code
-		fullUser, err := s.users.GetByID(r.Context(), user.ID)
-		if err != nil || fullUser.VerificationCode == nil {
-			writeError(w, http.StatusBadRequest, "no verification code found for user")
-			return
-		}
-
-		// Fetch the tweet
-		tweet, err := twitter.FetchTweet(*req.TweetURL)
-		if err != nil {
-			if errors.Is(err, twitter.ErrTweetNotFound) {
-				writeError(w, http.StatusBadRequest, "tweet not found or inaccessible")
-				return
-			}
-			if errors.Is(err, twitter.ErrInvalidURL) {
-				writeError(w, http.StatusBadRequest, "invalid tweet URL")
-				return
-			}
-			slog.Error("failed to fetch tweet", "error", err)
-			writeError(w, http.StatusBadRequest, "failed to fetch tweet")
-			return
-		}
+	// Every provider checks a proof; there is no unproven verification
+	proofURL := ""
+	if req.ProofURL != nil {
+		proofURL = *req.ProofURL
+	}
+	if req.Provider == "twitter" && proofURL == "" {
+		writeError(w, http.StatusBadRequest, "tweet_url is required")
+		return
+	}
 
-		// Verify the tweet contains the verification code
-		if !strings.Contains(strings.ToLower(tweet.Text), strings.ToLower(*fullUser.VerificationCode)) {
-			writeError(w, http.StatusBadRequest, "verification code not found in tweet")
-			return
-		}
+	// Fetch the user's verification code
+	fullUser, err := s.users.GetByID(r.Context(), user.ID)
+	if err != nil || fullUser.VerificationCode == nil {
+		writeError(w, http.StatusBadRequest, "no verification code found for user")
+		return
+	}
 
-		// Verify the tweet author matches the provided X username
-		if !strings.EqualFold(tweet.AuthorUsername, req.XUsername) {
-			writeError(w, http.StatusBadRequest, "tweet author does not match provided x_username")
-			return
+	identity, err := verifier.Verify(r.Context(), verification.Claim{
+		Identity: req.Identity,
+		ProofURL: proofURL,
+		Code:     *fullUser.VerificationCode,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, verification.ErrInvalidClaim):
+			writeError(w, http.StatusBadRequest, "invalid identity or proof_url for provider "+req.Provider)
+		case errors.Is(err, verification.ErrProofNotFound):
+			writeError(w, http.StatusBadRequest, "proof not found or inaccessible")
+		case errors.Is(err, verification.ErrCodeNotFound):
+			writeError(w, http.StatusBadRequest, "verification code not found in proof")
+		case errors.Is(err, verification.ErrIdentityMismatch):
+			writeError(w, http.StatusBadRequest, "proof author does not match provided identity")
+		default:
+			slog.Error("failed to fetch verification proof", "error", err, "provider", req.Provider)
+			writeError(w, http.StatusBadRequest, "failed to fetch proof")
 		}
-
-		slog.Info("tweet verification successful", "user_id", user.ID, "tweet_id", tweet.ID)
+		return
 	}
 
-	verifiedUser, err := s.users.VerifyUser(r.Context(), user.ID, req.XUsername)
+	slog.Info("verification successful", "user_id", user.ID, "provider", req.Provider, "identity", identity)
+
+	verifiedUser, err := s.users.VerifyUser(r.Context(), user.ID, req.Provider, identity)
 	if err != nil {
 		slog.Error("failed to verify user", "error", err)
 		writeError(w, http.StatusInternalServerError, "failed to verify user")
@@ -232,32 +328,14 @@ func (s *Server) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
-	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid multipart form or file too large")
-		return
-	}
-
-	file, header, err := r.FormFile("avatar")
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "missing avatar file")
-		return
-	}
-	defer file.Close()
-
-	fileContentType := header.Header.Get("Content-Type")
-	if !allowedContentTypes[fileContentType] {
-		writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
-		return
-	}
-
-	ext, ok := extensionForContentType(fileContentType)
+	img, ok := s.readProfileImage(w, r, "avatar", "avatars")
 	if !ok {
-		writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
 		return
 	}
 
-	oldAvatarKey, _, err := s.users.GetProfileImageKeys(r.Context(), user.ID)
+	oldAvatarKeys, _, err := s.users.GetProfileImageKeys(r.Context(), user.ID)
 	if err != nil {
+		s.discardProfileImage(r.Context(), img)
 		if errors.Is(err, users.ErrUserNotFound) {
 			writeError(w, http.StatusNotFound, "user not found")
 			return
@@ -266,33 +344,14 @@ func (s *Server) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := fmt.Sprintf("avatars/%s%s", user.ID.String(), ext)
-	if err := s.storage.Put(r.Context(), key, file, fileContentType); err != nil {
-		slog.Error("failed to upload avatar", "error", err)
-		writeError(w, http.StatusInternalServerError, "failed to upload avatar")
-		return
-	}
-
-	imageURL, err := s.storage.URL(r.Context(), key)
+	updated, err := s.users.UpdateAvatar(r.Context(), user.ID, *img)
 	if err != nil {
-		slog.Error("failed to get avatar URL", "error", err)
-		_ = s.storage.Delete(r.Context(), key)
-		writeError(w, http.StatusInternalServerError, "failed to process avatar")
-		return
-	}
-
-	updated, err := s.users.UpdateAvatar(r.Context(), user.ID, imageURL, key)
-	if err != nil {
-		_ = s.storage.Delete(r.Context(), key)
+		s.discardProfileImage(r.Context(), img)
 		writeError(w, http.StatusInternalServerError, "failed to update user")
 		return
 	}
 
-	if oldAvatarKey != nil && *oldAvatarKey != "" && *oldAvatarKey != key {
-		if err := s.storage.Delete(r.Context(), *oldAvatarKey); err != nil {
-			slog.Error("failed to delete old avatar", "error", err, "key", *oldAvatarKey)
-		}
-	}
+	s.deleteImages(r.Context(), oldAvatarKeys)
 
 	writeJSON(w, http.StatusOK, updated.ToPublic())
 }
@@ -300,32 +359,14 @@ func (s *Server) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUploadHeader(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
-	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid multipart form or file too large")
-		return
-	}
-
-	file, header, err := r.FormFile("header")
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "missing header file")
-		return
-	}
-	defer file.Close()
-
-	fileContentType := header.Header.Get("Content-Type")
-	if !allowedContentTypes[fileContentType] {
-		writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
-		return
-	}
-
-	ext, ok := extensionForContentType(fileContentType)
+	img, ok := s.readProfileImage(w, r, "header", "headers")
 	if !ok {
-		writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
 		return
 	}
 
-	_, oldHeaderKey, err := s.users.GetProfileImageKeys(r.Context(), user.ID)
+	_, oldHeaderKeys, err := s.users.GetProfileImageKeys(r.Context(), user.ID)
 	if err != nil {
+		s.discardProfileImage(r.Context(), img)
 		if errors.Is(err, users.ErrUserNotFound) {
 			writeError(w, http.StatusNotFound, "user not found")
 			return
@@ -334,33 +375,14 @@ func (s *Server) handleUploadHeader(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := fmt.Sprintf("headers/%s%s", user.ID.String(), ext)
-	if err := s.storage.Put(r.Context(), key, file, fileContentType); err != nil {
-		slog.Error("failed to upload header", "error", err)
-		writeError(w, http.StatusInternalServerError, "failed to upload header")
-		return
-	}
-
-	imageURL, err := s.storage.URL(r.Context(), key)
+	updated, err := s.users.UpdateHeader(r.Context(), user.ID, *img)
 	if err != nil {
-		slog.Error("failed to get header URL", "error", err)
-		_ = s.storage.Delete(r.Context(), key)
-		writeError(w, http.StatusInternalServerError, "failed to process header")
-		return
-	}
-
-	updated, err := s.users.UpdateHeader(r.Context(), user.ID, imageURL, key)
-	if err != nil {
-		_ = s.storage.Delete(r.Context(), key)
+		s.discardProfileImage(r.Context(), img)
 		writeError(w, http.StatusInternalServerError, "failed to update user")
 		return
 	}
 
-	if oldHeaderKey != nil && *oldHeaderKey != "" && *oldHeaderKey != key {
-		if err := s.storage.Delete(r.Context(), *oldHeaderKey); err != nil {
-			slog.Error("failed to delete old header", "error", err, "key", *oldHeaderKey)
-		}
-	}
+	s.deleteImages(r.Context(), oldHeaderKeys)
 
 	writeJSON(w, http.StatusOK, updated.ToPublic())
 }
@@ -389,9 +411,14 @@ func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
 	var req posts.CreatePostRequest
+	var images []*multipart.FileHeader
+	var alts []string
 
 	contentType := r.Header.Get("Content-Type")
 	if strings.HasPrefix(contentType, "multipart/form-data") {
+		// Files past maxUploadSize are spooled to disk, so bound the body
+		// by what MaxMediaPerPost images can need
+		r.Body = http.MaxBytesReader(w, r.Body, posts.MaxMediaPerPost*maxUploadSize+maxFormOverhead)
 		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
 			writeError(w, http.StatusBadRequest, "invalid multipart form or file too large")
 			return
@@ -419,59 +446,72 @@ func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 				req.Tags[i] = strings.TrimSpace(req.Tags[i])
 			}
 		}
-
-		file, header, err := r.FormFile("image")
-		if err == nil {
-			defer file.Close()
-
-			fileContentType := header.Header.Get("Content-Type")
-			if !allowedContentTypes[fileContentType] {
-				writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
-				return
-			}
-
-			ext := ""
-			switch fileContentType {
-			case "image/jpeg":
-				ext = ".jpg"
-			case "image/png":
-				ext = ".png"
-			case "image/gif":
-				ext = ".gif"
-			case "image/webp":
-				ext = ".webp"
-			}
-
-			key := fmt.Sprintf("posts/%s%s", uuid.New().String(), ext)
-			if err := s.storage.Put(r.Context(), key, file, fileContentType); err != nil {
-				slog.Error("failed to upload image", "error", err)
-				writeError(w, http.StatusInternalServerError, "failed to upload image")
-				return
-			}
-
-			imageURL, err := s.storage.URL(r.Context(), key)
+		req.State = r.FormValue("state")
+		if publishAt := r.FormValue("publish_at"); publishAt != "" {
+			t, err := time.Parse(time.RFC3339, publishAt)
 			if err != nil {
-				slog.Error("failed to get image URL", "error", err)
-				writeError(w, http.StatusInternalServerError, "failed to process image")
+				writeError(w, http.StatusBadRequest, "publish_at must be an RFC 3339 timestamp")
 				return
 			}
+			req.PublishAt = &t
+		}
 
-			req.ImageURL = &imageURL
-			req.ImageKey = &key
+		// Images and their descriptions are matched up in order. They are
+		// processed once the post has passed every other check.
+		images = r.MultipartForm.File["image"]
+		alts = r.MultipartForm.Value["image_alt"]
+		if err := validatePostImages(images, alts); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 	} else {
 		if err := parseJSON(r, &req); err != nil {
 			writeError(w, http.StatusBadRequest, "invalid request body")
 			return
 		}
+		if err := s.attachUploads(r.Context(), user.ID, &req); err != nil {
+			switch {
+			case errors.Is(err, posts.ErrTooManyMedia):
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("a post may have at most %d images", posts.MaxMediaPerPost))
+			case errors.Is(err, errAltTooLong):
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("alt_text must be at most %d characters", maxImageAltLength))
+			case errors.Is(err, uploads.ErrUploadNotFound), errors.Is(err, uploads.ErrNotReady):
+				writeUploadError(w, err)
+			default:
+				writeError(w, http.StatusInternalServerError, "failed to get media")
+			}
+			return
+		}
 	}
 
-	if req.Content == nil && req.ImageURL == nil && req.ReblogOfID == nil {
+	if req.Content == nil && req.ImageURL == nil && len(req.Media) == 0 && len(images) == 0 && req.ReblogOfID == nil {
 		writeError(w, http.StatusBadRequest, "post must have content, image, or be a reblog")
 		return
 	}
 
-	if req.ReplyToID != nil {
+	tags, err := posts.ResolveTags(req.Tags, req.Content, req.ReblogComment)
+	if err != nil {
+		writeTagError(w, err)
+		return
+	}
+	req.Tags = tags
+
+	if req.ImageAlt != nil && utf8.RuneCountInString(*req.ImageAlt) > maxImageAltLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("image_alt must be at most %d characters", maxImageAltLength))
+		return
+	}
+
+	state, err := posts.ResolveState(req.State, req.PublishAt, time.Now())
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	req.State = state
+
+	switch {
+	case state != posts.StatePublished:
+		// Rate limits apply when the post is published
+	case req.ReplyToID != nil:
 		result, err := s.rateLimiter.AllowReply(r.Context(), user.ID, *req.ReplyToID)
 		if err != nil {
 			slog.Error("rate limit check failed", "error", err)
@@ -482,7 +522,7 @@ func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 			writeRateLimitError(w, result)
 			return
 		}
-	} else {
+	default:
 		result, err := s.rateLimiter.AllowCreatePost(r.Context(), user.ID)
 		if err != nil {
 			slog.Error("rate limit check failed", "error", err)
@@ -495,12 +535,40 @@ func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	for i, header := range images {
+		alt := ""
+		if i < len(alts) {
+			alt = alts[i]
+		}
+		media, err := s.uploadPostImage(r.Context(), header, alt)
+		if err != nil {
+			s.discardMedia(r.Context(), req.Media)
+			if isImageError(err) {
+				writeImageError(w, err)
+				return
+			}
+			slog.Error("failed to upload image", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to upload image")
+			return
+		}
+		req.Media = append(req.Media, *media)
+	}
+
 	post, err := s.posts.Create(r.Context(), user.ID, req)
 	if err != nil {
-		if req.ImageKey != nil {
-			s.storage.Delete(r.Context(), *req.ImageKey)
+		s.discardMedia(r.Context(), req.Media)
+		switch {
+		case errors.Is(err, posts.ErrPostNotFound):
+			writeError(w, http.StatusNotFound, "post not found")
+		case errors.Is(err, blocks.ErrBlocked):
+			writeBlockedError(w)
+		case errors.Is(err, posts.ErrTooManyPending):
+			writeScheduleError(w, err)
+		case errors.Is(err, posts.ErrTooManyMedia):
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("a post may have at most %d images", posts.MaxMediaPerPost))
+		default:
+			writeError(w, http.StatusInternalServerError, "failed to create post")
 		}
-		writeError(w, http.StatusInternalServerError, "failed to create post")
 		return
 	}
 
@@ -512,6 +580,108 @@ func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, post)
 }
 
+// maxImageAltLength bounds image descriptions.
+const maxImageAltLength = 1500
+
+// handleUpdatePost edits the caller's post. The previous version is kept
+// and listed by handleListPostRevisions.
+func (s *Server) handleUpdatePost(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return
+	}
+
+	var req posts.UpdatePostRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Content == nil && req.ReblogComment == nil && req.ImageAlt == nil && req.Media == nil &&
+		req.Tags == nil && req.State == nil && req.PublishAt == nil {
+		writeError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+	if req.State != nil && *req.State == posts.StatePublished {
+		writeError(w, http.StatusBadRequest, "use POST /api/v1/posts/{id}/publish to publish a post")
+		return
+	}
+	if req.ImageAlt != nil && utf8.RuneCountInString(*req.ImageAlt) > maxImageAltLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("image_alt must be at most %d characters", maxImageAltLength))
+		return
+	}
+	if req.Media != nil {
+		for _, m := range *req.Media {
+			if m.AltText != nil && utf8.RuneCountInString(*m.AltText) > maxImageAltLength {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("alt_text must be at most %d characters", maxImageAltLength))
+				return
+			}
+		}
+	}
+
+	post, err := s.posts.Update(r.Context(), id, user.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, posts.ErrPostNotFound):
+			writeError(w, http.StatusNotFound, "post not found")
+		case errors.Is(err, posts.ErrEmptyPost):
+			writeError(w, http.StatusBadRequest, "post must have content, image, or be a reblog")
+		case errors.Is(err, posts.ErrInvalidTag), errors.Is(err, posts.ErrTooManyTags):
+			writeTagError(w, err)
+		case errors.Is(err, posts.ErrInvalidMedia):
+			writeError(w, http.StatusBadRequest, "media must list each of the post's images once")
+		case errors.Is(err, posts.ErrInvalidState), errors.Is(err, posts.ErrInvalidPublishAt),
+			errors.Is(err, posts.ErrAlreadyPublished):
+			writeScheduleError(w, err)
+		default:
+			slog.Error("failed to update post", "error", err, "post_id", id)
+			writeError(w, http.StatusInternalServerError, "failed to update post")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+func (s *Server) handleListPostRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return
+	}
+
+	// Hidden from the viewer, as the post itself is, if they are blocked
+	if _, err := s.posts.GetByID(r.Context(), id, getViewerID(r)); err != nil {
+		if errors.Is(err, posts.ErrPostNotFound) {
+			writeError(w, http.StatusNotFound, "post not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get post")
+		return
+	}
+
+	revisions, err := s.posts.ListRevisions(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list revisions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"revisions": revisions,
+	})
+}
+
+// writeTagError reports a tag that failed posts.ResolveTags.
+func writeTagError(w http.ResponseWriter, err error) {
+	if errors.Is(err, posts.ErrTooManyTags) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("a post may have at most %d tags", posts.MaxTagsPerPost))
+		return
+	}
+	writeError(w, http.StatusBadRequest, fmt.Sprintf("tags must be at most %d letters, digits or underscores and include a letter", posts.MaxTagLength))
+}
+
 func (s *Server) handleGetPost(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := uuid.Parse(idStr)
@@ -543,7 +713,7 @@ func (s *Server) handleDeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	imageKey, err := s.posts.Delete(r.Context(), id, user.ID)
+	keys, err := s.posts.Delete(r.Context(), id, user.ID)
 	if err != nil {
 		if errors.Is(err, posts.ErrPostNotFound) {
 			writeError(w, http.StatusNotFound, "post not found")
@@ -553,9 +723,9 @@ func (s *Server) handleDeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if imageKey != nil {
-		if err := s.storage.Delete(r.Context(), *imageKey); err != nil {
-			slog.Error("failed to delete post image", "error", err, "key", *imageKey)
+	for _, key := range keys {
+		if err := s.storage.Delete(r.Context(), key); err != nil {
+			slog.Error("failed to delete post image", "error", err, "key", key)
 		}
 	}
 
@@ -585,6 +755,14 @@ func (s *Server) handleLikePost(w http.ResponseWriter, r *http.Request) {
 
 	err = s.posts.Like(r.Context(), user.ID, id)
 	if err != nil {
+		if errors.Is(err, posts.ErrPostNotFound) {
+			writeError(w, http.StatusNotFound, "post not found")
+			return
+		}
+		if errors.Is(err, blocks.ErrBlocked) {
+			writeBlockedError(w)
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to like post")
 		return
 	}
@@ -644,6 +822,18 @@ func (s *Server) handleReblogPost(w http.ResponseWriter, r *http.Request) {
 		Tags:          req.Tags,
 	})
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidTag) || errors.Is(err, posts.ErrTooManyTags) {
+			writeTagError(w, err)
+			return
+		}
+		if errors.Is(err, posts.ErrPostNotFound) {
+			writeError(w, http.StatusNotFound, "post not found")
+			return
+		}
+		if errors.Is(err, blocks.ErrBlocked) {
+			writeBlockedError(w)
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to reblog post")
 		return
 	}
@@ -664,14 +854,17 @@ func (s *Server) handleGetReplies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	opts := posts.FeedOptions{
-		Limit:    getQueryInt(r, "limit", 20),
-		Offset:   getQueryInt(r, "offset", 0),
-		ViewerID: getViewerID(r),
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
 	}
 
 	timeline, err := s.posts.GetReplies(r.Context(), id, opts)
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to get replies")
 		return
 	}
@@ -688,15 +881,25 @@ func (s *Server) handlePublicFeed(w http.ResponseWriter, r *http.Request) {
 		sort = "controversial"
 	}
 
-	opts := posts.FeedOptions{
-		Limit:    getQueryInt(r, "limit", 20),
-		Offset:   getQueryInt(r, "offset", 0),
-		ViewerID: getViewerID(r),
-		Sort:     sort,
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
+	}
+	opts.Sort = sort
+	if !s.loadFeedFilters(w, r, &opts) {
+		return
 	}
 
 	timeline, err := s.posts.GetPublicFeed(r.Context(), opts)
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if errors.Is(err, posts.ErrFeedTimeout) {
+			writeError(w, http.StatusServiceUnavailable, "feed took too long; simplify your pattern filters")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to get feed")
 		return
 	}
@@ -707,13 +910,21 @@ func (s *Server) handlePublicFeed(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleHomeFeed(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromContext(r)
 
-	opts := posts.FeedOptions{
-		Limit:  getQueryInt(r, "limit", 20),
-		Offset: getQueryInt(r, "offset", 0),
+	opts, ok := parseFeedOptions(w, r)
+	if !ok || !s.loadFeedFilters(w, r, &opts) {
+		return
 	}
 
 	timeline, err := s.posts.GetHomeFeed(r.Context(), user.ID, opts)
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if errors.Is(err, posts.ErrFeedTimeout) {
+			writeError(w, http.StatusServiceUnavailable, "feed took too long; simplify your pattern filters")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to get feed")
 		return
 	}
@@ -721,17 +932,42 @@ func (s *Server) handleHomeFeed(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, timeline)
 }
 
+// handleMentionsFeed lists posts that mention the current user.
+func (s *Server) handleMentionsFeed(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
+	}
+
+	timeline, err := s.posts.GetMentions(r.Context(), user.ID, opts)
+	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get mentions")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, timeline)
+}
+
 func (s *Server) handleTagFeed(w http.ResponseWriter, r *http.Request) {
 	tag := r.PathValue("tag")
 
-	opts := posts.FeedOptions{
-		Limit:    getQueryInt(r, "limit", 20),
-		Offset:   getQueryInt(r, "offset", 0),
-		ViewerID: getViewerID(r),
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
 	}
 
 	timeline, err := s.posts.GetTagFeed(r.Context(), tag, opts)
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to get feed")
 		return
 	}
@@ -754,7 +990,21 @@ func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fullUser, err := s.users.GetWithStats(r.Context(), user.ID, getViewerID(r))
+	// Users who have blocked each other cannot see each other's profiles
+	viewerID := getViewerID(r)
+	if viewerID != nil && *viewerID != user.ID {
+		blocked, err := s.blocks.IsBlocked(r.Context(), *viewerID, user.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to get user")
+			return
+		}
+		if blocked {
+			writeError(w, http.StatusNotFound, "user not found")
+			return
+		}
+	}
+
+	fullUser, err := s.users.GetWithStats(r.Context(), user.ID, viewerID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to get user stats")
 		return
@@ -776,14 +1026,17 @@ func (s *Server) handleGetUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	opts := posts.FeedOptions{
-		Limit:    getQueryInt(r, "limit", 20),
-		Offset:   getQueryInt(r, "offset", 0),
-		ViewerID: getViewerID(r),
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
 	}
 
 	timeline, err := s.posts.GetUserPosts(r.Context(), user.ID, opts)
 	if err != nil {
+		if errors.Is(err, posts.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to get posts")
 		return
 	}
@@ -872,6 +1125,10 @@ func (s *Server) handleFollow(w http.ResponseWriter, r *http.Request) {
 
 	err = s.follows.Follow(r.Context(), currentUser.ID, targetUser.ID)
 	if err != nil {
+		if errors.Is(err, blocks.ErrBlocked) {
+			writeBlockedError(w)
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to follow user")
 		return
 	}
@@ -977,7 +1234,7 @@ func (s *Server) handleTrendingAgents(w http.ResponseWriter, r *http.Request) {
 				COALESCE(SUM(CASE WHEN p.created_at > NOW() - INTERVAL '7 days' THEN p.reply_count ELSE 0 END), 0) as recent_replies
 			FROM users u
 			LEFT JOIN follows f ON u.id = f.following_id
-			LEFT JOIN posts p ON u.id = p.user_id
+			LEFT JOIN posts p ON u.id = p.user_id AND p.state = 'published'
 			WHERE u.is_agent = true
 			GROUP BY u.id
 			HAVING COUNT(p.id) > 0
@@ -1030,7 +1287,7 @@ func (s *Server) handleGetAgents(w http.ResponseWriter, r *http.Request) {
 			COUNT(DISTINCT p.id) as post_count
 		FROM users u
 		LEFT JOIN follows f ON u.id = f.following_id
-		LEFT JOIN posts p ON u.id = p.user_id
+		LEFT JOIN posts p ON u.id = p.user_id AND p.state = 'published'
 		WHERE u.is_agent = true
 		GROUP BY u.id
 		ORDER BY follower_count DESC, u.created_at DESC
@@ -1065,62 +1322,3 @@ func (s *Server) handleSkillDownload(w http.ResponseWriter, r *http.Request) {
 	content := bytes.ReplaceAll(s.skillFile, []byte("{{BASE_URL}}"), []byte(s.baseURL))
 	w.Write(content)
 }
-
-const maxUploadSize = 10 << 20
-
-var allowedContentTypes = map[string]bool{
-	"image/jpeg": true,
-	"image/png":  true,
-	"image/gif":  true,
-	"image/webp": true,
-}
-
-func extensionForContentType(contentType string) (string, bool) {
-	switch contentType {
-	case "image/jpeg":
-		return ".jpg", true
-	case "image/png":
-		return ".png", true
-	case "image/gif":
-		return ".gif", true
-	case "image/webp":
-		return ".webp", true
-	default:
-		return "", false
-	}
-}
-
-func (s *Server) handleServeUpload(w http.ResponseWriter, r *http.Request) {
-	key := strings.TrimPrefix(r.URL.Path, "/uploads/")
-	if key == "" {
-		writeError(w, http.StatusNotFound, "file not found")
-		return
-	}
-
-	reader, err := s.storage.Get(r.Context(), key)
-	if err != nil {
-		writeError(w, http.StatusNotFound, "file not found")
-		return
-	}
-	defer reader.Close()
-
-	info, err := s.storage.Info(r.Context(), key)
-	if err == nil && info.ContentType != "" {
-		w.Header().Set("Content-Type", info.ContentType)
-	}
-
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-
-	buf := make([]byte, 32*1024)
-	for {
-		n, readErr := reader.Read(buf)
-		if n > 0 {
-			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
-				return
-			}
-		}
-		if readErr != nil {
-			return
-		}
-	}
-}
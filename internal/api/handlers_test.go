@@ -1,8 +1,17 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/users"
+	"github.com/watzon/moltpress/internal/verification"
 )
 
 func TestHandleRegister_Agent(t *testing.T) {
@@ -84,3 +93,53 @@ func TestHandleRegister_MissingUsername(t *testing.T) {
 		t.Errorf("expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
 	}
 }
+
+func TestHandleVerify_TwitterRequiresProof(t *testing.T) {
+	s := &Server{verifiers: verification.DefaultRegistry(nil)}
+	user := &users.User{ID: uuid.New(), Username: "agent", IsAgent: true}
+
+	for _, body := range []string{
+		`{"x_username": "someone"}`,
+		`{"provider": "twitter", "identity": "someone"}`,
+		`{"x_username": "someone", "tweet_url": ""}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/verify", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		rec := httptest.NewRecorder()
+		s.handleVerify(rec, req)
+
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "tweet_url is required") {
+			t.Errorf("%s: got %d %s, want 400 tweet_url is required", body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleGetVerification(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{users: users.NewRepository(db)}
+
+	password := "correct horse"
+	result, err := s.users.Create(context.Background(), users.CreateUserRequest{Username: "human", Password: &password})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(user *users.User) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/me/verification", nil)
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		rec := httptest.NewRecorder()
+		s.handleGetVerification(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	status, body := get(result.User)
+	if status != http.StatusOK || !strings.Contains(body, result.VerificationCode) || !strings.Contains(body, "verification_url") {
+		t.Errorf("got %d %s, want 200 with the code and url", status, body)
+	}
+
+	now := time.Now()
+	result.User.VerifiedAt = &now
+	if status, _ := get(result.User); status != http.StatusBadRequest {
+		t.Errorf("verified user: status = %d, want 400", status)
+	}
+}
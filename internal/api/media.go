@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/imaging"
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+const maxUploadSize = imaging.MaxFileSize
+
+// maxFormOverhead allows for the boundaries, headers and other fields
+// around the file in a multipart upload.
+const maxFormOverhead = 64 << 10
+
+// parseImageForm parses a multipart body carrying a single image, refusing
+// to read more of it than such a body can hold. It writes the error
+// response itself and returns false on failure.
+func parseImageForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+maxFormOverhead)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid multipart form or file too large")
+		return false
+	}
+	return true
+}
+
+// validatePostImages checks the number and size of a post's uploads and
+// their descriptions before any of them are processed.
+func validatePostImages(images []*multipart.FileHeader, alts []string) error {
+	if len(images) > posts.MaxMediaPerPost {
+		return fmt.Errorf("a post may have at most %d images", posts.MaxMediaPerPost)
+	}
+	for _, header := range images {
+		if header.Size > maxUploadSize {
+			return fmt.Errorf("images must be at most %d MB", maxUploadSize>>20)
+		}
+	}
+	for _, alt := range alts {
+		if utf8.RuneCountInString(alt) > maxImageAltLength {
+			return fmt.Errorf("image_alt must be at most %d characters", maxImageAltLength)
+		}
+	}
+	return nil
+}
+
+// isImageError reports whether err is an upload that imaging.Process
+// rejected, as opposed to a failure on our side.
+func isImageError(err error) bool {
+	return errors.Is(err, imaging.ErrUnsupportedType) || errors.Is(err, imaging.ErrInvalidImage) ||
+		errors.Is(err, imaging.ErrTooLarge) || errors.Is(err, imaging.ErrFileTooLarge)
+}
+
+// writeImageError reports an upload that imaging.Process rejected.
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedType):
+		writeError(w, http.StatusBadRequest, "invalid image type (allowed: jpeg, png, gif, webp)")
+	case errors.Is(err, imaging.ErrFileTooLarge):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("images must be at most %d MB", imaging.MaxFileSize>>20))
+	case errors.Is(err, imaging.ErrTooLarge):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("images must be at most %d pixels on each side and %d megapixels",
+			imaging.MaxDimension, imaging.MaxPixels/1_000_000))
+	default:
+		writeError(w, http.StatusBadRequest, "image could not be decoded")
+	}
+}
+
+// storeImage saves each variant of img under a new name in dir and
+// returns them. Variants that share a size share a stored file.
+func (s *Server) storeImage(ctx context.Context, dir string, img *imaging.Image) (imaging.Variants, error) {
+	base := fmt.Sprintf("%s/%s", dir, uuid.New().String())
+	variants := imaging.Variants{}
+
+	var prev imaging.Variant
+	for i, encoded := range img.Variants {
+		if i > 0 && encoded.Width == prev.Width && encoded.Height == prev.Height {
+			variants[encoded.Name] = prev
+			continue
+		}
+
+		key := base + img.Ext
+		if i > 0 {
+			key = fmt.Sprintf("%s_%s%s", base, encoded.Name, img.Ext)
+		}
+		if err := s.storage.Put(ctx, key, bytes.NewReader(encoded.Data), img.ContentType); err != nil {
+			s.deleteImages(ctx, variants.Keys())
+			return nil, err
+		}
+		url, err := s.storage.URL(ctx, key)
+		if err != nil {
+			s.deleteImages(ctx, append(variants.Keys(), key))
+			return nil, err
+		}
+
+		prev = imaging.Variant{URL: url, Key: key, Width: encoded.Width, Height: encoded.Height}
+		variants[encoded.Name] = prev
+	}
+	return variants, nil
+}
+
+// deleteImages deletes stored images, logging rather than returning
+// failures since they only leave orphaned files behind.
+func (s *Server) deleteImages(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			slog.Error("failed to delete image", "error", err, "key", key)
+		}
+	}
+}
+
+// uploadPostImage processes and stores one upload for a post.
+func (s *Server) uploadPostImage(ctx context.Context, header *multipart.FileHeader, alt string) (*posts.Media, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := imaging.Process(file)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := s.storeImage(ctx, "posts", img)
+	if err != nil {
+		return nil, err
+	}
+
+	full := variants[img.Full().Name]
+	media := &posts.Media{
+		URL:         full.URL,
+		Key:         &full.Key,
+		ContentType: img.ContentType,
+		Size:        int64(len(img.Full().Data)),
+		Width:       &full.Width,
+		Height:      &full.Height,
+		Variants:    variants,
+	}
+	if alt != "" {
+		media.AltText = &alt
+	}
+	return media, nil
+}
+
+// errAltTooLong is returned by attachUploads for an overlong alt_text.
+var errAltTooLong = errors.New("alt text too long")
+
+// attachUploads adds the uploads listed in req.Attachments to req.Media,
+// after checking that they are the caller's and complete. The files belong
+// to the uploads, so they are not deleted if the post is not created.
+func (s *Server) attachUploads(ctx context.Context, userID uuid.UUID, req *posts.CreatePostRequest) error {
+	if len(req.Attachments) == 0 {
+		return nil
+	}
+	if len(req.Attachments) > posts.MaxMediaPerPost {
+		return posts.ErrTooManyMedia
+	}
+
+	ids := make([]uuid.UUID, len(req.Attachments))
+	for i, a := range req.Attachments {
+		if a.AltText != nil && utf8.RuneCountInString(*a.AltText) > maxImageAltLength {
+			return errAltTooLong
+		}
+		ids[i] = a.MediaID
+	}
+
+	found, err := s.uploads.GetReady(ctx, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, u := range found {
+		media := posts.Media{
+			UploadID:    &found[i].ID,
+			URL:         *u.URL,
+			Key:         u.Key,
+			ContentType: u.ContentType,
+			Size:        u.Size,
+			Width:       u.Width,
+			Height:      u.Height,
+			Variants:    u.Variants,
+		}
+		if alt := req.Attachments[i].AltText; alt != nil && *alt != "" {
+			media.AltText = alt
+		}
+		req.Media = append(req.Media, media)
+	}
+	return nil
+}
+
+// discardMedia deletes uploads for a post that was not created.
+func (s *Server) discardMedia(ctx context.Context, media []posts.Media) {
+	for _, m := range media {
+		s.deleteImages(ctx, m.Keys())
+	}
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -83,59 +85,140 @@ func getClientIP(r *http.Request) string {
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey   contextKey = "user"
+	apiKeyContextKey contextKey = "api_key"
+)
+
+// writeAuthError maps an authentication failure to its response.
+func writeAuthError(w http.ResponseWriter, err error, scope users.Scope) {
+	if errors.Is(err, users.ErrInsufficientScope) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("api key is missing the %q scope", scope))
+		return
+	}
+	if errors.Is(err, errCrossOrigin) {
+		writeError(w, http.StatusForbidden, "cross-origin request rejected")
+		return
+	}
+	if errors.Is(err, users.ErrAccountSuspended) {
+		writeError(w, http.StatusForbidden, "account suspended")
+		return
+	}
+	writeError(w, http.StatusUnauthorized, "unauthorized")
+}
+
+func withUser(r *http.Request, user *users.User, key *users.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	if key != nil {
+		ctx = context.WithValue(ctx, apiKeyContextKey, key)
+	}
+	return r.WithContext(ctx)
+}
 
-func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+func (s *Server) withAuth(scope users.Scope, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := s.authenticateRequest(r)
+		user, key, err := s.authenticateRequest(r, scope)
 		if err != nil {
-			writeError(w, http.StatusUnauthorized, "unauthorized")
+			writeAuthError(w, err, scope)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next(w, r.WithContext(ctx))
+		next(w, withUser(r, user, key))
 	}
 }
 
 // withVerified requires both authentication AND verification.
 // Use this for all routes that should only be accessible to verified agents.
-func (s *Server) withVerified(next http.HandlerFunc) http.HandlerFunc {
+func (s *Server) withVerified(scope users.Scope, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := s.authenticateRequest(r)
+		user, key, err := s.authenticateRequest(r, scope)
 		if err != nil {
-			writeError(w, http.StatusUnauthorized, "unauthorized")
+			writeAuthError(w, err, scope)
 			return
 		}
 
 		if user.VerifiedAt == nil {
-			writeError(w, http.StatusForbidden, "verification required: please verify your agent via X/Twitter before using this endpoint")
+			msg := "verification required: verify your account with POST /api/v1/verify before using this endpoint"
+			if s.verifiers != nil {
+				msg += " (providers: " + strings.Join(s.verifiers.Names(), ", ") + ")"
+			}
+			writeError(w, http.StatusForbidden, msg)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next(w, r.WithContext(ctx))
+		// Silenced accounts can still read
+		if scope != users.ScopeRead && user.Moderation(time.Now()) == users.ModerationSilenced {
+			writeError(w, http.StatusForbidden, "account silenced")
+			return
+		}
+
+		next(w, withUser(r, user, key))
 	}
 }
 
+// withAdmin requires an account with the admin role. API keys also need
+// the admin scope.
+func (s *Server) withAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return s.withAuth(users.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if !getUserFromContext(r).IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin role required")
+			return
+		}
+		next(w, r)
+	})
+}
+
 func (s *Server) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, _ := s.authenticateRequest(r)
+		user, key, _ := s.authenticateRequest(r, users.ScopeRead)
 		if user != nil {
-			ctx := context.WithValue(r.Context(), userContextKey, user)
-			r = r.WithContext(ctx)
+			r = withUser(r, user, key)
 		}
 		next(w, r)
 	}
 }
 
-func (s *Server) authenticateRequest(r *http.Request) (*users.User, error) {
+// authenticateRequest resolves the caller from a Bearer API key or a web
+// session cookie and checks that the credential was granted scope for the
+// route being served.
+func (s *Server) authenticateRequest(r *http.Request, scope users.Scope) (*users.User, *users.APIKey, error) {
 	auth := r.Header.Get("Authorization")
 	if strings.HasPrefix(auth, "Bearer ") {
 		apiKey := strings.TrimPrefix(auth, "Bearer ")
-		return s.users.GetByAPIKey(r.Context(), apiKey)
+		user, key, err := s.users.GetByAPIKey(r.Context(), apiKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user.IsSuspended() {
+			return nil, nil, users.ErrAccountSuspended
+		}
+		if !key.HasScope(scope) {
+			return nil, nil, users.ErrInsufficientScope
+		}
+		return user, key, nil
 	}
-	return nil, users.ErrUserNotFound
+
+	// Web sessions belong to a human logged in with a password and carry
+	// every scope.
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := crossOrigin.Check(r); err != nil {
+			return nil, nil, errCrossOrigin
+		}
+		session, err := s.sessions.Get(r.Context(), cookie.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		user, err := s.users.GetByID(r.Context(), session.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user.IsSuspended() {
+			return nil, nil, users.ErrAccountSuspended
+		}
+		return user, nil, nil
+	}
+
+	return nil, nil, users.ErrUserNotFound
 }
 
 func getUserFromContext(r *http.Request) *users.User {
@@ -143,6 +226,12 @@ func getUserFromContext(r *http.Request) *users.User {
 	return user
 }
 
+// getAPIKeyFromContext returns the key used to authenticate the request.
+func getAPIKeyFromContext(r *http.Request) *users.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*users.APIKey)
+	return key
+}
+
 func getViewerID(r *http.Request) *uuid.UUID {
 	user := getUserFromContext(r)
 	if user != nil {
@@ -155,7 +244,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Range, Authorization")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == "OPTIONS" {
@@ -199,3 +288,9 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// streaming handlers need to flush and to clear the write deadline.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
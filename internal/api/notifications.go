@@ -0,0 +1,156 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/notifications"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+// Notification handlers
+
+// notificationView adds the post a notification is about, so clients can
+// show a reply without fetching it separately.
+type notificationView struct {
+	notifications.Notification
+	Post *posts.Post `json:"post,omitempty"`
+}
+
+// notificationPostID is the post shown with a notification: the reply,
+// reblog or mention when there is one, otherwise the post acted on.
+func notificationPostID(n notifications.Notification) *uuid.UUID {
+	if n.SourcePostID != nil {
+		return n.SourcePostID
+	}
+	return n.PostID
+}
+
+func (s *Server) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	query := r.URL.Query()
+
+	opts := notifications.ListOptions{
+		Limit:      getQueryInt(r, "limit", 20),
+		UnreadOnly: query.Get("unread") == "true",
+	}
+
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeCursor(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	if types := query.Get("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			nt := notifications.Type(strings.TrimSpace(t))
+			if !notifications.IsValidType(nt) {
+				writeError(w, http.StatusBadRequest, "types must be a comma-separated list of: like, reblog, reply, follow, mention")
+				return
+			}
+			opts.Types = append(opts.Types, nt)
+		}
+	}
+
+	page, err := s.notifications.List(r.Context(), user.ID, opts)
+	if err != nil {
+		slog.Error("failed to list notifications", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to list notifications")
+		return
+	}
+
+	views := make([]notificationView, len(page.Notifications))
+	var postIDs []uuid.UUID
+	for i, n := range page.Notifications {
+		views[i].Notification = n
+		if postID := notificationPostID(n); postID != nil {
+			postIDs = append(postIDs, *postID)
+		}
+	}
+
+	found, err := s.posts.GetByIDs(r.Context(), postIDs, &user.ID)
+	if err != nil {
+		slog.Error("failed to load notification posts", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to list notifications")
+		return
+	}
+	for i, n := range page.Notifications {
+		if postID := notificationPostID(n); postID != nil {
+			views[i].Post = found[*postID]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": views,
+		"next_cursor":   page.NextCursor,
+		"has_more":      page.HasMore,
+	})
+}
+
+func (s *Server) handleUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	counts, err := s.notifications.UnreadCounts(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to count notifications")
+		return
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total":   total,
+		"by_type": counts,
+	})
+}
+
+// handleMarkNotificationsRead marks specific notifications read, or all of
+// them (optionally only those created before a timestamp).
+func (s *Server) handleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req struct {
+		IDs    []uuid.UUID `json:"ids,omitempty"`
+		All    bool        `json:"all,omitempty"`
+		Before *time.Time  `json:"before,omitempty"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var marked int64
+	var err error
+	switch {
+	case req.All:
+		marked, err = s.notifications.MarkAllRead(r.Context(), user.ID, req.Before)
+	case len(req.IDs) > 0:
+		if len(req.IDs) > 100 {
+			writeError(w, http.StatusBadRequest, "at most 100 ids may be marked at once")
+			return
+		}
+		marked, err = s.notifications.MarkRead(r.Context(), user.ID, req.IDs)
+	default:
+		writeError(w, http.StatusBadRequest, "ids or all is required")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to mark notifications read", "error", err, "user_id", user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to mark notifications read")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"marked": marked,
+	})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/notifications"
+	"github.com/watzon/moltpress/internal/posts"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+func TestHandleListNotifications(t *testing.T) {
+	db := dbtest.New(t)
+	s := &Server{posts: posts.NewRepository(db), notifications: notifications.NewRepository(db)}
+	ctx := context.Background()
+
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+
+	content := "hello #world"
+	post, err := s.posts.Create(ctx, alice, posts.CreatePostRequest{Content: &content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := "hi back"
+	replied, err := s.posts.Create(ctx, bob, posts.CreatePostRequest{Content: &reply, ReplyToID: &post.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.posts.Like(ctx, bob, post.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notifications.Notify(ctx, db, notifications.Event{Type: notifications.TypeFollow, UserID: alice, ActorID: bob}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/notifications", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, &users.User{ID: alice}))
+	rec := httptest.NewRecorder()
+	s.handleListNotifications(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want 200", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Notifications []struct {
+			Type notifications.Type `json:"type"`
+			Post *posts.Post        `json:"post"`
+		} `json:"notifications"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[notifications.Type]string{
+		notifications.TypeReply:  replied.ID.String(),
+		notifications.TypeLike:   post.ID.String(),
+		notifications.TypeFollow: "",
+	}
+	if len(resp.Notifications) != len(want) {
+		t.Fatalf("listed %d notifications, want %d", len(resp.Notifications), len(want))
+	}
+	for _, n := range resp.Notifications {
+		got := ""
+		if n.Post != nil {
+			got = n.Post.ID.String()
+		}
+		if got != want[n.Type] {
+			t.Errorf("%s notification has post %q, want %q", n.Type, got, want[n.Type])
+			continue
+		}
+		if n.Type == notifications.TypeLike && (n.Post.User == nil || n.Post.User.Username != "alice" || len(n.Post.Tags) != 1) {
+			t.Errorf("like notification post = %+v, want it loaded with its author and tags", n.Post)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/reports"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// Report handlers
+
+func writeReportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, reports.ErrInvalidCategory):
+		categories := make([]string, len(reports.AllCategories))
+		for i, c := range reports.AllCategories {
+			categories[i] = string(c)
+		}
+		writeError(w, http.StatusBadRequest, "category must be one of: "+strings.Join(categories, ", "))
+	case errors.Is(err, reports.ErrInvalidStatus):
+		writeError(w, http.StatusBadRequest, "status must be open, resolved or dismissed")
+	case errors.Is(err, reports.ErrCommentTooLong):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("comment must be at most %d characters", reports.MaxCommentLength))
+	case errors.Is(err, reports.ErrReportSelf):
+		writeError(w, http.StatusBadRequest, "you cannot report yourself")
+	case errors.Is(err, reports.ErrAlreadyReported):
+		writeError(w, http.StatusConflict, "there is already an open report for this")
+	case errors.Is(err, reports.ErrTargetNotFound):
+		writeError(w, http.StatusNotFound, "post or user not found")
+	case errors.Is(err, reports.ErrReportNotFound):
+		writeError(w, http.StatusNotFound, "report not found")
+	default:
+		slog.Error("report failed", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to save report")
+	}
+}
+
+// handleCreateReport reports a post, or a user by username, to the admins.
+func (s *Server) handleCreateReport(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req struct {
+		PostID   *uuid.UUID       `json:"post_id,omitempty"`
+		Username *string          `json:"username,omitempty"`
+		Category reports.Category `json:"category"`
+		Comment  *string          `json:"comment,omitempty"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if (req.PostID == nil) == (req.Username == nil) {
+		writeError(w, http.StatusBadRequest, "report either a post_id or a username")
+		return
+	}
+
+	var userID *uuid.UUID
+	if req.Username != nil {
+		target, err := s.users.GetByUsername(r.Context(), strings.TrimPrefix(*req.Username, "@"))
+		if err != nil {
+			if errors.Is(err, users.ErrUserNotFound) {
+				writeError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to get user")
+			return
+		}
+		userID = &target.ID
+	}
+
+	report, err := s.reports.Create(r.Context(), user.ID, req.PostID, userID, req.Category, req.Comment)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	slog.Info("report filed", "report_id", report.ID, "reporter_id", user.ID, "category", report.Category)
+	writeJSON(w, http.StatusCreated, report)
+}
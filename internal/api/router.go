@@ -6,11 +6,22 @@ import (
 	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/audit"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/filters"
 	"github.com/watzon/moltpress/internal/follows"
+	"github.com/watzon/moltpress/internal/notifications"
 	"github.com/watzon/moltpress/internal/posts"
 	"github.com/watzon/moltpress/internal/ratelimit"
+	"github.com/watzon/moltpress/internal/reports"
+	"github.com/watzon/moltpress/internal/sessions"
 	"github.com/watzon/moltpress/internal/storage"
+	"github.com/watzon/moltpress/internal/stream"
+	"github.com/watzon/moltpress/internal/twitter"
+	"github.com/watzon/moltpress/internal/uploads"
 	"github.com/watzon/moltpress/internal/users"
+	"github.com/watzon/moltpress/internal/verification"
+	"github.com/watzon/moltpress/internal/webhooks"
 )
 
 // spaHandler serves static files and falls back to index.html for SPA routing
@@ -50,30 +61,102 @@ func spaHandler(staticFS fs.FS) http.Handler {
 }
 
 type Server struct {
-	db          *pgxpool.Pool
-	users       *users.Repository
-	posts       *posts.Repository
-	follows     *follows.Repository
-	storage     storage.Storage
-	staticFS    fs.FS
-	skillFile   []byte
-	baseURL     string
-	authLimiter *RateLimiter
-	rateLimiter *ratelimit.Limiter
+	db            *pgxpool.Pool
+	users         *users.Repository
+	posts         *posts.Repository
+	follows       *follows.Repository
+	blocks        *blocks.Repository
+	filters       *filters.Repository
+	reports       *reports.Repository
+	audit         *audit.Repository
+	notifications *notifications.Repository
+	webhooks      *webhooks.Repository
+	sessions      *sessions.Repository
+	uploads       *uploads.Repository
+	storage       storage.Storage
+	staticFS      fs.FS
+	skillFile     []byte
+	baseURL       string
+	authLimiter   *RateLimiter
+	rateLimiter   *ratelimit.Limiter
+	twitter       *twitter.Client
+	verifiers     *verification.Registry
+	broker        *stream.Broker
+
+	// secureCookies marks session cookies Secure when served over HTTPS
+	secureCookies bool
+	// redirectUploads sends requests for /uploads/ to the storage's public
+	// URL instead of proxying them
+	redirectUploads bool
+}
+
+// Option customizes a Server built by NewRouter.
+type Option func(*Server)
+
+// WithTwitterClient sets the client used to check verification tweets.
+func WithTwitterClient(client *twitter.Client) Option {
+	return func(s *Server) {
+		s.twitter = client
+	}
+}
+
+// WithBroker enables the streaming API and makes the repositories publish
+// to it.
+func WithBroker(broker *stream.Broker) Option {
+	return func(s *Server) {
+		s.broker = broker
+	}
+}
+
+// WithUploadRedirect redirects requests for /uploads/ to the public URLs
+// of the files, for storage that serves them itself.
+func WithUploadRedirect() Option {
+	return func(s *Server) {
+		s.redirectUploads = true
+	}
+}
+
+// WithVerifiers replaces the default verification providers, e.g. to point
+// them at local fakes in tests.
+func WithVerifiers(registry *verification.Registry) Option {
+	return func(s *Server) {
+		s.verifiers = registry
+	}
 }
 
-func NewRouter(db *pgxpool.Pool, staticFS fs.FS, skillFile []byte, baseURL string, store storage.Storage, rateLimiter *ratelimit.Limiter) http.Handler {
+func NewRouter(db *pgxpool.Pool, staticFS fs.FS, skillFile []byte, baseURL string, store storage.Storage, rateLimiter *ratelimit.Limiter, opts ...Option) http.Handler {
 	s := &Server{
-		db:          db,
-		users:       users.NewRepository(db),
-		posts:       posts.NewRepository(db),
-		follows:     follows.NewRepository(db),
-		storage:     store,
-		staticFS:    staticFS,
-		skillFile:   skillFile,
-		baseURL:     baseURL,
-		authLimiter: NewRateLimiter(0.5, 5),
-		rateLimiter: rateLimiter,
+		db:            db,
+		users:         users.NewRepository(db),
+		posts:         posts.NewRepository(db),
+		follows:       follows.NewRepository(db),
+		blocks:        blocks.NewRepository(db),
+		filters:       filters.NewRepository(db),
+		reports:       reports.NewRepository(db),
+		audit:         audit.NewRepository(db),
+		notifications: notifications.NewRepository(db),
+		webhooks:      webhooks.NewRepository(db),
+		sessions:      sessions.NewRepository(db),
+		uploads:       uploads.NewRepository(db),
+		storage:       store,
+		staticFS:      staticFS,
+		skillFile:     skillFile,
+		baseURL:       baseURL,
+		authLimiter:   NewRateLimiter(0.5, 5),
+		rateLimiter:   rateLimiter,
+		twitter:       twitter.NewClient(),
+
+		secureCookies: strings.HasPrefix(baseURL, "https://"),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.verifiers == nil {
+		s.verifiers = verification.DefaultRegistry(s.twitter)
+	}
+	if s.broker != nil {
+		s.posts.WithPublisher(s.broker)
+		s.follows.WithPublisher(s.broker)
 	}
 
 	mux := http.NewServeMux()
@@ -83,35 +166,108 @@ func NewRouter(db *pgxpool.Pool, staticFS fs.FS, skillFile []byte, baseURL strin
 
 	// API v1 routes
 	mux.HandleFunc("POST /api/v1/register", s.authLimiter.Middleware(s.handleRegister))
-	mux.HandleFunc("POST /api/v1/verify", s.authLimiter.Middleware(s.withAuth(s.handleVerify)))
+	mux.HandleFunc("POST /api/v1/auth/login", s.authLimiter.Middleware(sameOrigin(s.handleLogin)))
+	mux.HandleFunc("POST /api/v1/auth/logout", sameOrigin(s.handleLogout))
+	mux.HandleFunc("GET /api/v1/me/verification", s.withAuth(users.ScopeProfile, s.handleGetVerification))
+	mux.HandleFunc("POST /api/v1/verify", s.authLimiter.Middleware(s.withAuth(users.ScopeProfile, s.handleVerify)))
 	mux.HandleFunc("GET /api/v1/verify/{code}", s.handleCheckVerification)
-	mux.HandleFunc("GET /api/v1/me", s.withAuth(s.handleGetMe))
-	mux.HandleFunc("PATCH /api/v1/me", s.withVerified(s.handleUpdateMe))
-	mux.HandleFunc("POST /api/v1/me/avatar", s.withVerified(s.handleUploadAvatar))
-	mux.HandleFunc("POST /api/v1/me/header", s.withVerified(s.handleUploadHeader))
-	mux.HandleFunc("DELETE /api/v1/me", s.withAuth(s.handleDeleteMe))
+	mux.HandleFunc("GET /api/v1/me", s.withAuth(users.ScopeRead, s.handleGetMe))
+	mux.HandleFunc("PATCH /api/v1/me", s.withVerified(users.ScopeProfile, s.handleUpdateMe))
+	mux.HandleFunc("POST /api/v1/me/avatar", s.withVerified(users.ScopeProfile, s.handleUploadAvatar))
+	mux.HandleFunc("POST /api/v1/me/header", s.withVerified(users.ScopeProfile, s.handleUploadHeader))
+	mux.HandleFunc("DELETE /api/v1/me", s.withAuth(users.ScopeAdmin, s.handleDeleteMe))
+	mux.HandleFunc("GET /api/v1/me/mentions", s.withAuth(users.ScopeRead, s.handleMentionsFeed))
+	mux.HandleFunc("GET /api/v1/me/posts", s.withAuth(users.ScopeRead, s.handleListPendingPosts))
+	mux.HandleFunc("GET /api/v1/me/queue", s.withAuth(users.ScopeRead, s.handleGetQueue))
+	mux.HandleFunc("PUT /api/v1/me/queue", s.withVerified(users.ScopePost, s.handleUpdateQueue))
+	mux.HandleFunc("GET /api/v1/me/blocks", s.withAuth(users.ScopeRead, s.handleListBlocks))
+	mux.HandleFunc("GET /api/v1/me/mutes", s.withAuth(users.ScopeRead, s.handleListMutes))
+	mux.HandleFunc("GET /api/v1/me/filters", s.withAuth(users.ScopeRead, s.handleListFilters))
+	mux.HandleFunc("POST /api/v1/me/filters", s.withAuth(users.ScopeProfile, s.handleCreateFilter))
+	mux.HandleFunc("DELETE /api/v1/me/filters/{id}", s.withAuth(users.ScopeProfile, s.handleDeleteFilter))
+
+	// API keys
+	mux.HandleFunc("GET /api/v1/me/api-keys", s.withAuth(users.ScopeAdmin, s.handleListAPIKeys))
+	mux.HandleFunc("POST /api/v1/me/api-keys", s.withAuth(users.ScopeAdmin, s.handleCreateAPIKey))
+	mux.HandleFunc("DELETE /api/v1/me/api-keys/{id}", s.withAuth(users.ScopeAdmin, s.handleRevokeAPIKey))
+	mux.HandleFunc("POST /api/v1/me/api-key/rotate", s.authLimiter.Middleware(s.withAuth(users.ScopeAdmin, s.handleRotateAPIKey)))
+
+	// Managed agents
+	mux.HandleFunc("GET /api/v1/me/agents", s.withOwner(s.handleListAgents))
+	mux.HandleFunc("POST /api/v1/me/agents", s.withOwner(s.handleCreateAgent))
+	mux.HandleFunc("POST /api/v1/me/agents/claim", s.authLimiter.Middleware(s.withOwner(s.handleClaimAgent)))
+	mux.HandleFunc("GET /api/v1/me/agents/{username}/rate-limits", s.withOwner(s.handleGetAgentRateLimits))
+	mux.HandleFunc("POST /api/v1/me/agents/{username}/api-key/rotate", s.withOwner(s.handleRotateAgentAPIKey))
+	mux.HandleFunc("POST /api/v1/me/agents/{username}/suspend", s.withOwner(s.handleSuspendAgent))
+	mux.HandleFunc("DELETE /api/v1/me/agents/{username}/suspend", s.withOwner(s.handleUnsuspendAgent))
+	mux.HandleFunc("DELETE /api/v1/me/agents/{username}", s.withOwner(s.handleDeleteAgent))
+
+	// Webhooks
+	mux.HandleFunc("GET /api/v1/me/webhooks", s.withAuth(users.ScopeRead, s.handleListWebhooks))
+	mux.HandleFunc("POST /api/v1/me/webhooks", s.withAuth(users.ScopeAdmin, s.handleCreateWebhook))
+	mux.HandleFunc("DELETE /api/v1/me/webhooks/{id}", s.withAuth(users.ScopeAdmin, s.handleDeleteWebhook))
+	mux.HandleFunc("GET /api/v1/me/webhooks/{id}/deliveries", s.withAuth(users.ScopeRead, s.handleListWebhookDeliveries))
+	mux.HandleFunc("POST /api/v1/me/webhooks/{id}/deliveries/{delivery_id}/redeliver", s.withAuth(users.ScopeAdmin, s.handleRedeliverWebhook))
+
+	// Notifications
+	mux.HandleFunc("GET /api/v1/notifications", s.withAuth(users.ScopeRead, s.handleListNotifications))
+	mux.HandleFunc("GET /api/v1/notifications/unread-count", s.withAuth(users.ScopeRead, s.handleUnreadNotificationCount))
+	mux.HandleFunc("POST /api/v1/notifications/read", s.withAuth(users.ScopeRead, s.handleMarkNotificationsRead))
 
 	// Posts
-	mux.HandleFunc("POST /api/v1/posts", s.withVerified(s.handleCreatePost))
-	mux.HandleFunc("GET /api/v1/posts/{id}", s.handleGetPost)
-	mux.HandleFunc("DELETE /api/v1/posts/{id}", s.withVerified(s.handleDeletePost))
-	mux.HandleFunc("POST /api/v1/posts/{id}/like", s.withVerified(s.handleLikePost))
-	mux.HandleFunc("DELETE /api/v1/posts/{id}/like", s.withVerified(s.handleUnlikePost))
-	mux.HandleFunc("POST /api/v1/posts/{id}/reblog", s.withVerified(s.handleReblogPost))
-	mux.HandleFunc("GET /api/v1/posts/{id}/replies", s.handleGetReplies)
+	mux.HandleFunc("POST /api/v1/posts", s.withVerified(users.ScopePost, s.handleCreatePost))
+	mux.HandleFunc("GET /api/v1/posts/{id}", s.optionalAuth(s.handleGetPost))
+	mux.HandleFunc("PATCH /api/v1/posts/{id}", s.withVerified(users.ScopePost, s.handleUpdatePost))
+	mux.HandleFunc("DELETE /api/v1/posts/{id}", s.withVerified(users.ScopePost, s.handleDeletePost))
+	mux.HandleFunc("GET /api/v1/posts/{id}/revisions", s.optionalAuth(s.handleListPostRevisions))
+	mux.HandleFunc("POST /api/v1/posts/{id}/publish", s.withVerified(users.ScopePost, s.handlePublishPost))
+	mux.HandleFunc("POST /api/v1/posts/{id}/like", s.withVerified(users.ScopeInteract, s.handleLikePost))
+	mux.HandleFunc("DELETE /api/v1/posts/{id}/like", s.withVerified(users.ScopeInteract, s.handleUnlikePost))
+	mux.HandleFunc("POST /api/v1/posts/{id}/reblog", s.withVerified(users.ScopePost, s.handleReblogPost))
+	mux.HandleFunc("GET /api/v1/posts/{id}/replies", s.optionalAuth(s.handleGetReplies))
+
+	// Media
+	mux.HandleFunc("POST /api/v1/media", s.withVerified(users.ScopePost, s.handleCreateMedia))
+	mux.HandleFunc("GET /api/v1/media/{id}", s.withAuth(users.ScopeRead, s.handleGetMedia))
+	mux.HandleFunc("PUT /api/v1/media/{id}/content", s.withVerified(users.ScopePost, s.handleUploadMediaChunk))
+	mux.HandleFunc("POST /api/v1/media/{id}/complete", s.withVerified(users.ScopePost, s.handleCompleteMedia))
 
 	// Feeds
-	mux.HandleFunc("GET /api/v1/feed", s.handlePublicFeed)
-	mux.HandleFunc("GET /api/v1/feed/home", s.withVerified(s.handleHomeFeed))
-	mux.HandleFunc("GET /api/v1/feed/tag/{tag}", s.handleTagFeed)
+	mux.HandleFunc("GET /api/v1/feed", s.optionalAuth(s.handlePublicFeed))
+	mux.HandleFunc("GET /api/v1/feed/home", s.withVerified(users.ScopeRead, s.handleHomeFeed))
+	mux.HandleFunc("GET /api/v1/feed/tag/{tag}", s.optionalAuth(s.handleTagFeed))
 
 	// Users
-	mux.HandleFunc("GET /api/v1/users/{username}", s.handleGetUser)
-	mux.HandleFunc("GET /api/v1/users/{username}/posts", s.handleGetUserPosts)
+	mux.HandleFunc("GET /api/v1/users/{username}", s.optionalAuth(s.handleGetUser))
+	mux.HandleFunc("GET /api/v1/users/{username}/posts", s.optionalAuth(s.handleGetUserPosts))
 	mux.HandleFunc("GET /api/v1/users/{username}/followers", s.handleGetFollowers)
 	mux.HandleFunc("GET /api/v1/users/{username}/following", s.handleGetFollowing)
-	mux.HandleFunc("POST /api/v1/users/{username}/follow", s.withVerified(s.handleFollow))
-	mux.HandleFunc("DELETE /api/v1/users/{username}/follow", s.withVerified(s.handleUnfollow))
+	mux.HandleFunc("POST /api/v1/users/{username}/follow", s.withVerified(users.ScopeInteract, s.handleFollow))
+	mux.HandleFunc("DELETE /api/v1/users/{username}/follow", s.withVerified(users.ScopeInteract, s.handleUnfollow))
+	mux.HandleFunc("POST /api/v1/users/{username}/block", s.withAuth(users.ScopeInteract, s.handleBlock))
+	mux.HandleFunc("DELETE /api/v1/users/{username}/block", s.withAuth(users.ScopeInteract, s.handleUnblock))
+	mux.HandleFunc("POST /api/v1/users/{username}/mute", s.withAuth(users.ScopeInteract, s.handleMute))
+	mux.HandleFunc("DELETE /api/v1/users/{username}/mute", s.withAuth(users.ScopeInteract, s.handleUnmute))
+
+	// Reports
+	mux.HandleFunc("POST /api/v1/reports", s.withAuth(users.ScopeInteract, s.handleCreateReport))
+
+	// Admin
+	mux.HandleFunc("GET /api/v1/admin/reports", s.withAdmin(s.handleAdminListReports))
+	mux.HandleFunc("GET /api/v1/admin/reports/{id}", s.withAdmin(s.handleAdminGetReport))
+	mux.HandleFunc("PATCH /api/v1/admin/reports/{id}", s.withAdmin(s.handleAdminTriageReport))
+	mux.HandleFunc("DELETE /api/v1/admin/posts/{id}", s.withAdmin(s.handleAdminRemovePost))
+	mux.HandleFunc("POST /api/v1/admin/users/{username}/suspend", s.withAdmin(s.handleAdminModerate(users.ModerationSuspended)))
+	mux.HandleFunc("POST /api/v1/admin/users/{username}/silence", s.withAdmin(s.handleAdminModerate(users.ModerationSilenced)))
+	mux.HandleFunc("POST /api/v1/admin/users/{username}/restore", s.withAdmin(s.handleAdminRestore))
+	mux.HandleFunc("PUT /api/v1/admin/users/{username}/role", s.withAdmin(s.handleAdminSetRole))
+	mux.HandleFunc("GET /api/v1/admin/audit", s.withAdmin(s.handleAdminAuditLog))
+
+	// Streaming
+	mux.HandleFunc("GET /api/v1/stream", s.optionalAuth(s.handleStream))
+
+	// Search
+	mux.HandleFunc("GET /api/v1/search", s.optionalAuth(s.handleSearch))
 
 	// Trending
 	mux.HandleFunc("GET /api/v1/trending/tags", s.handleTrendingTags)
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+// writeScheduleError reports a post state, publish_at or queue setting
+// that failed validation.
+func writeScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, posts.ErrInvalidState):
+		writeError(w, http.StatusBadRequest, "state must be draft, scheduled, queued or published")
+	case errors.Is(err, posts.ErrInvalidPublishAt):
+		writeError(w, http.StatusBadRequest, "scheduled posts need a publish_at in the next year, and other posts cannot have one")
+	case errors.Is(err, posts.ErrAlreadyPublished):
+		writeError(w, http.StatusConflict, "post is already published")
+	case errors.Is(err, posts.ErrTooManyPending):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("you may have at most %d drafts, scheduled and queued posts", posts.MaxPendingPosts))
+	case errors.Is(err, posts.ErrInvalidQueueTime):
+		writeError(w, http.StatusBadRequest, "queue times must be HH:MM")
+	case errors.Is(err, posts.ErrTooManyQueueTimes):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("a queue may have at most %d times", posts.MaxQueueTimes))
+	case errors.Is(err, posts.ErrInvalidTimezone):
+		writeError(w, http.StatusBadRequest, "timezone must be an IANA time zone name")
+	default:
+		writeError(w, http.StatusBadRequest, "invalid schedule")
+	}
+}
+
+// handleListPendingPosts lists the caller's drafts, scheduled or queued
+// posts, chosen by the state query parameter.
+func (s *Server) handleListPendingPosts(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = posts.StateDraft
+	}
+
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
+	}
+
+	timeline, err := s.posts.ListPending(r.Context(), user.ID, state, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, posts.ErrInvalidState):
+			writeError(w, http.StatusBadRequest, "state must be draft, scheduled or queued")
+		case errors.Is(err, posts.ErrInvalidCursor):
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+		default:
+			writeError(w, http.StatusInternalServerError, "failed to list posts")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, timeline)
+}
+
+// handlePublishPost publishes one of the caller's unpublished posts now.
+func (s *Server) handlePublishPost(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid post id")
+		return
+	}
+
+	post, err := s.posts.GetByID(r.Context(), id, &user.ID)
+	if err != nil || post.UserID != user.ID {
+		if err == nil || errors.Is(err, posts.ErrPostNotFound) {
+			writeError(w, http.StatusNotFound, "post not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get post")
+		return
+	}
+	if post.State == posts.StatePublished {
+		writeScheduleError(w, posts.ErrAlreadyPublished)
+		return
+	}
+
+	if post.ReplyToID != nil {
+		result, err := s.rateLimiter.AllowReply(r.Context(), user.ID, *post.ReplyToID)
+		if err != nil {
+			slog.Error("rate limit check failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "rate limit check failed")
+			return
+		}
+		if !result.Allowed {
+			writeRateLimitError(w, result)
+			return
+		}
+	} else {
+		result, err := s.rateLimiter.AllowCreatePost(r.Context(), user.ID)
+		if err != nil {
+			slog.Error("rate limit check failed", "error", err)
+			writeError(w, http.StatusInternalServerError, "rate limit check failed")
+			return
+		}
+		if !result.Allowed {
+			writeRateLimitError(w, result)
+			return
+		}
+	}
+
+	post, err = s.posts.Publish(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, posts.ErrPostNotFound):
+			writeError(w, http.StatusNotFound, "post not found")
+		case errors.Is(err, posts.ErrAlreadyPublished):
+			writeScheduleError(w, err)
+		case errors.Is(err, blocks.ErrBlocked):
+			writeBlockedError(w)
+		default:
+			slog.Error("failed to publish post", "error", err, "post_id", id)
+			writeError(w, http.StatusInternalServerError, "failed to publish post")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+func (s *Server) handleGetQueue(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	queue, err := s.posts.GetQueue(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get queue")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, queue)
+}
+
+// handleUpdateQueue replaces the caller's queue times, time zone and
+// paused flag.
+func (s *Server) handleUpdateQueue(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req posts.UpdateQueueRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	queue, err := s.posts.UpdateQueue(r.Context(), user.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, posts.ErrInvalidQueueTime), errors.Is(err, posts.ErrTooManyQueueTimes),
+			errors.Is(err, posts.ErrInvalidTimezone):
+			writeScheduleError(w, err)
+		default:
+			slog.Error("failed to update queue", "error", err, "user_id", user.ID)
+			writeError(w, http.StatusInternalServerError, "failed to update queue")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, queue)
+}
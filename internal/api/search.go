@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/watzon/moltpress/internal/posts"
+)
+
+// Search handlers
+
+// handleSearch searches posts, users and tags at once. Pass type to limit
+// the response to one of them; paging with cursor only applies to posts.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if len(q) > 256 {
+		writeError(w, http.StatusBadRequest, "q must be at most 256 characters")
+		return
+	}
+
+	searchType := query.Get("type")
+	switch searchType {
+	case "", "posts", "users", "tags":
+	default:
+		writeError(w, http.StatusBadRequest, "type must be one of: posts, users, tags")
+		return
+	}
+
+	var filters posts.SearchFilters
+	if author := strings.TrimPrefix(query.Get("author"), "@"); author != "" {
+		filters.Author = &author
+	}
+	if tag := strings.TrimPrefix(query.Get("tag"), "#"); tag != "" {
+		filters.Tag = &tag
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"since", &filters.Since}, {"until", &filters.Until}} {
+		if v := query.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, param.name+" must be an RFC 3339 timestamp")
+				return
+			}
+			*param.dest = &t
+		}
+	}
+	if sentiment := query.Get("sentiment"); sentiment != "" {
+		switch sentiment {
+		case "positive", "negative", "neutral":
+			filters.Sentiment = &sentiment
+		default:
+			writeError(w, http.StatusBadRequest, "sentiment must be one of: positive, negative, neutral")
+			return
+		}
+	}
+
+	opts, ok := parseFeedOptions(w, r)
+	if !ok {
+		return
+	}
+	if query.Get("sort") == "recent" {
+		opts.Sort = "recent"
+	}
+
+	resp := map[string]interface{}{}
+
+	if searchType == "" || searchType == "posts" {
+		timeline, err := s.posts.SearchPosts(r.Context(), q, filters, opts)
+		if err != nil {
+			if errors.Is(err, posts.ErrInvalidCursor) {
+				writeError(w, http.StatusBadRequest, "invalid cursor")
+				return
+			}
+			slog.Error("failed to search posts", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to search posts")
+			return
+		}
+		resp["posts"] = timeline
+	}
+
+	// Users and tags are short lists of best matches, not paged
+	sideLimit := 5
+	if searchType != "" {
+		sideLimit = min(max(opts.Limit, 1), 50)
+	}
+
+	if searchType == "" || searchType == "users" {
+		results, err := s.users.Search(r.Context(), q, sideLimit, getViewerID(r))
+		if err != nil {
+			slog.Error("failed to search users", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to search users")
+			return
+		}
+		resp["users"] = results
+	}
+
+	if searchType == "" || searchType == "tags" {
+		tags, err := s.posts.SearchTags(r.Context(), q, sideLimit)
+		if err != nil {
+			slog.Error("failed to search tags", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to search tags")
+			return
+		}
+		resp["tags"] = tags
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/posts"
+	"github.com/watzon/moltpress/internal/stream"
+)
+
+// Streaming handlers
+
+const (
+	// streamHeartbeat keeps idle connections, and proxies in front of them,
+	// from timing out.
+	streamHeartbeat = 25 * time.Second
+	// streamWriteTimeout drops clients that stop reading.
+	streamWriteTimeout = 10 * time.Second
+	// streamFollowRefresh is how often the home channel picks up follows,
+	// and every channel picks up blocks, mutes and moderation, made since
+	// the connection opened.
+	streamFollowRefresh = time.Minute
+	// streamReplayLimit caps how many missed events are replayed on resume.
+	streamReplayLimit = 500
+)
+
+// streamFrame is what clients receive for each event.
+type streamFrame struct {
+	ID       string           `json:"id"`
+	Type     stream.EventType `json:"type"`
+	Channels []string         `json:"channels"`
+	Data     json.RawMessage  `json:"data"`
+}
+
+// streamSink writes frames to one connected client.
+type streamSink interface {
+	send(ctx context.Context, frame *streamFrame) error
+	heartbeat(ctx context.Context) error
+}
+
+// handleStream serves live events over Server-Sent Events, or over a
+// WebSocket when the client asks to upgrade.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if s.broker == nil {
+		writeError(w, http.StatusServiceUnavailable, "streaming is not available")
+		return
+	}
+
+	user := getUserFromContext(r)
+	query := r.URL.Query()
+
+	names := query.Get("channels")
+	if names == "" {
+		names = stream.ChannelPublic
+		if user != nil {
+			names = stream.ChannelHome + "," + stream.ChannelNotifications
+		}
+	}
+	channels, err := stream.ParseChannels(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("channels must be a comma-separated list of: home, public, notifications, tag:<name> (at most %d tags)", stream.MaxTagChannels))
+		return
+	}
+	for i, tag := range channels.Tags {
+		if normalized, err := posts.NormalizeTag(tag); err == nil {
+			channels.Tags[i] = normalized
+		}
+	}
+	if channels.RequiresAuth() && user == nil {
+		writeError(w, http.StatusUnauthorized, "authentication required for home and notifications channels")
+		return
+	}
+
+	// EventSource sends Last-Event-ID when it reconnects; WebSocket clients
+	// and first connections pass it as a query parameter instead.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("last_event_id")
+	}
+	if lastID != "" && !stream.ValidEventID(lastID) {
+		writeError(w, http.StatusBadRequest, "invalid last event id")
+		return
+	}
+
+	// Streams outlive the server's write timeout, so clear it and rely on
+	// per-write deadlines instead. On a WebSocket this also covers the
+	// hijacked connection.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	_ = rc.SetReadDeadline(time.Time{})
+
+	// Subscribe before replaying so nothing published in between is lost.
+	sub := s.broker.Subscribe()
+	defer sub.Close()
+
+	ctx := r.Context()
+	var sink streamSink
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return // Accept has already written the response
+		}
+		defer conn.CloseNow()
+
+		// The client has nothing to say; this handles its close and pings.
+		ctx = conn.CloseRead(ctx)
+		sink = &wsSink{conn: conn}
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		sink = &sseSink{w: w, rc: rc}
+		if err := sink.heartbeat(ctx); err != nil {
+			return
+		}
+	}
+
+	var viewerID *uuid.UUID
+	if user != nil {
+		viewerID = &user.ID
+	}
+	s.runStream(ctx, sink, sub, channels, viewerID, lastID)
+}
+
+// runStream replays events missed since lastID and then relays live events
+// until the client goes away or falls too far behind.
+func (s *Server) runStream(ctx context.Context, sink streamSink, sub *stream.Subscription, channels stream.Channels, viewerID *uuid.UUID, lastID string) {
+	// Suspended and silenced users, and users blocked either way, are
+	// hidden from every channel, and muted users from home
+	following := map[uuid.UUID]bool{}
+	moderated := map[uuid.UUID]bool{}
+	blocked, muted := map[uuid.UUID]bool{}, map[uuid.UUID]bool{}
+	loadFollowing := func() {
+		if ids, err := s.users.ModeratedIDs(ctx); err == nil {
+			moderated = ids
+		}
+		if viewerID == nil {
+			return
+		}
+		if b, m, err := s.blocks.HiddenIDs(ctx, *viewerID); err == nil {
+			blocked, muted = b, m
+		}
+		if !channels.Home {
+			return
+		}
+		ids, err := s.follows.FollowingIDs(ctx, *viewerID)
+		if err != nil {
+			return // Keep the previous set
+		}
+		following = make(map[uuid.UUID]bool, len(ids))
+		for _, id := range ids {
+			following[id] = true
+		}
+	}
+	follows := func(id uuid.UUID) bool { return following[id] && !muted[id] }
+	loadFollowing()
+
+	// Live events are not strictly ordered across instances, so only those
+	// at or before the end of the replay are assumed to be duplicates.
+	var replayedThrough string
+	deliver := func(e *stream.Event) error {
+		if replayedThrough != "" && !stream.After(e.ID, replayedThrough) {
+			return nil
+		}
+		if blocked[e.ActorID] || (moderated[e.ActorID] && (viewerID == nil || e.ActorID != *viewerID)) {
+			return nil
+		}
+		matched := channels.Match(e, viewerID, follows)
+		if len(matched) == 0 {
+			return nil
+		}
+		return sink.send(ctx, &streamFrame{ID: e.ID, Type: e.Type, Channels: matched, Data: e.Data})
+	}
+
+	if lastID != "" {
+		missed, err := s.broker.Since(ctx, lastID, streamReplayLimit)
+		if err != nil {
+			slog.Error("failed to replay stream", "error", err)
+			return
+		}
+		for i := range missed {
+			if err := deliver(&missed[i]); err != nil {
+				return
+			}
+		}
+		replayedThrough = lastID
+		if n := len(missed); n > 0 {
+			replayedThrough = missed[n-1].ID
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	refresh := time.NewTicker(streamFollowRefresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return // Too slow; the client should reconnect and resume
+			}
+			if err := deliver(e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := sink.heartbeat(ctx); err != nil {
+				return
+			}
+		case <-refresh.C:
+			loadFollowing()
+		}
+	}
+}
+
+type sseSink struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseSink) send(_ context.Context, frame *streamFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", frame.ID, frame.Type, data))
+}
+
+func (s *sseSink) heartbeat(context.Context) error {
+	return s.write(": ping\n\n")
+}
+
+func (s *sseSink) write(chunk string) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := s.w.Write([]byte(chunk)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+type wsSink struct {
+	conn *websocket.Conn
+}
+
+func (s *wsSink) send(ctx context.Context, frame *streamFrame) error {
+	ctx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, s.conn, frame)
+}
+
+func (s *wsSink) heartbeat(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+	defer cancel()
+	err := s.conn.Ping(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.conn.Close(websocket.StatusPolicyViolation, "ping timeout")
+	}
+	return err
+}
@@ -51,13 +51,14 @@ func (m *mockUserRepo) GetByUsername(ctx context.Context, username string) (*use
 	return nil, users.ErrUserNotFound
 }
 
-func (m *mockUserRepo) GetByAPIKey(ctx context.Context, apiKey string) (*users.User, error) {
+func (m *mockUserRepo) GetByAPIKey(ctx context.Context, apiKey string) (*users.User, *users.APIKey, error) {
 	for _, user := range m.users {
 		if user.APIKey != nil && *user.APIKey == apiKey {
-			return user, nil
+			key := &users.APIKey{UserID: user.ID, Name: "default", Prefix: apiKey[:len("mp_")+8], Scopes: users.AllScopes}
+			return user, key, nil
 		}
 	}
-	return nil, users.ErrUserNotFound
+	return nil, nil, users.ErrUserNotFound
 }
 
 type testServer struct {
@@ -97,7 +98,7 @@ func setupTestServer(t *testing.T) *testServer {
 
 		if req.IsAgent && result.VerificationCode != "" {
 			resp.VerificationCode = result.VerificationCode
-			resp.VerificationURL = "https://x.com/intent/tweet?text=Verifying%20" + result.VerificationCode
+			resp.VerificationURL = verificationTweetURL(result.VerificationCode, true)
 		}
 
 		writeJSON(w, http.StatusCreated, resp)
@@ -0,0 +1,438 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/imaging"
+	"github.com/watzon/moltpress/internal/storage"
+	"github.com/watzon/moltpress/internal/uploads"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// uploadChunkSize bounds each chunk of an upload sent to us rather than to
+// a presigned URL, so a slow client finishes each request in time.
+const uploadChunkSize = 4 << 20
+
+var directUploadTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// writeUploadError reports an upload that cannot be used as asked.
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		writeError(w, http.StatusBadRequest, "media not found")
+	case errors.Is(err, uploads.ErrNotReady):
+		writeError(w, http.StatusBadRequest, "media must be completed before it is attached")
+	case errors.Is(err, uploads.ErrAlreadyComplete):
+		writeError(w, http.StatusConflict, "upload is already complete")
+	case errors.Is(err, uploads.ErrInvalidSize):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("size must be between 1 byte and %d MB", uploads.MaxSize>>20))
+	case errors.Is(err, uploads.ErrTooManyUploads):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("you may have at most %d uploads that are not attached to anything", uploads.MaxUnattached))
+	default:
+		writeError(w, http.StatusBadRequest, "invalid media")
+	}
+}
+
+// uploadImage describes an image stored by storeImage for the uploads
+// repository.
+func uploadImage(img *imaging.Image, variants imaging.Variants) uploads.Image {
+	full := variants[img.Full().Name]
+	return uploads.Image{
+		URL:         full.URL,
+		Key:         full.Key,
+		ContentType: img.ContentType,
+		Size:        int64(len(img.Full().Data)),
+		Width:       full.Width,
+		Height:      full.Height,
+		Variants:    variants,
+	}
+}
+
+// uploadTarget tells the client where to send the file of u: straight to
+// storage when it can presign URLs, and to us in chunks otherwise.
+func (s *Server) uploadTarget(ctx context.Context, u *uploads.Upload) (*uploads.Target, error) {
+	switch store := s.storage.(type) {
+	case storage.Presigner:
+		url, headers, err := store.PresignPut(ctx, u.RawKey, u.ContentType, u.Size, time.Until(u.ExpiresAt))
+		if err != nil {
+			return nil, err
+		}
+		return &uploads.Target{Method: http.MethodPut, URL: url, Headers: headers}, nil
+	case storage.Appender:
+		return &uploads.Target{
+			Method:    http.MethodPut,
+			URL:       fmt.Sprintf("%s/api/v1/media/%s/content", s.baseURL, u.ID),
+			ChunkSize: uploadChunkSize,
+		}, nil
+	default:
+		return nil, errors.New("storage does not support direct uploads")
+	}
+}
+
+// handleCreateMedia uploads an image to attach to posts and profiles
+// later. A multipart file is processed right away. A JSON body with the
+// file's size and content_type starts a direct upload instead, which the
+// client finishes by sending the file to the returned target and calling
+// handleCompleteMedia.
+func (s *Server) handleCreateMedia(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if !parseImageForm(w, r) {
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "missing file")
+			return
+		}
+		defer file.Close()
+
+		img, err := imaging.Process(file)
+		if err != nil {
+			if isImageError(err) {
+				writeImageError(w, err)
+				return
+			}
+			slog.Error("failed to process media", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to process media")
+			return
+		}
+
+		variants, err := s.storeImage(r.Context(), "media", img)
+		if err != nil {
+			slog.Error("failed to upload media", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to upload media")
+			return
+		}
+
+		upload, err := s.uploads.CreateReady(r.Context(), user.ID, uploadImage(img, variants))
+		if err != nil {
+			s.deleteImages(r.Context(), variants.Keys())
+			if errors.Is(err, uploads.ErrTooManyUploads) {
+				writeUploadError(w, err)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to upload media")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, upload)
+		return
+	}
+
+	var req struct {
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+	}
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !directUploadTypes[req.ContentType] {
+		writeError(w, http.StatusBadRequest, "content_type must be image/jpeg, image/png, image/gif or image/webp")
+		return
+	}
+
+	upload, err := s.uploads.Create(r.Context(), user.ID, req.Size, req.ContentType)
+	if err != nil {
+		if errors.Is(err, uploads.ErrInvalidSize) || errors.Is(err, uploads.ErrTooManyUploads) {
+			writeUploadError(w, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to start upload")
+		return
+	}
+
+	upload.Target, err = s.uploadTarget(r.Context(), upload)
+	if err != nil {
+		slog.Error("failed to start upload", "error", err)
+		_ = s.uploads.Expire(r.Context(), upload.ID, user.ID)
+		writeError(w, http.StatusInternalServerError, "failed to start upload")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, upload)
+}
+
+func (s *Server) handleGetMedia(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+
+	upload, err := s.uploads.Get(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			writeError(w, http.StatusNotFound, "media not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get media")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upload)
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header.
+func parseContentRange(header string) (start, end, total int64, ok bool) {
+	n, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &total)
+	if err != nil || n != 3 || start < 0 || end < start || total <= end {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+// handleUploadMediaChunk receives one chunk of a direct upload when storage
+// cannot presign URLs. Chunks are sent in order, each with a Content-Range
+// header; a file that fits in one chunk may omit it. A chunk that does not
+// start where the upload left off is rejected with the offset to resume
+// from.
+func (s *Server) handleUploadMediaChunk(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	appender, ok := s.storage.(storage.Appender)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "send the file to the upload url returned by POST /api/v1/media")
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+
+	upload, err := s.uploads.Get(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			writeError(w, http.StatusNotFound, "media not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get media")
+		return
+	}
+	if upload.State != uploads.StatePending {
+		writeUploadError(w, uploads.ErrAlreadyComplete)
+		return
+	}
+
+	start, end, total := int64(0), upload.Size-1, upload.Size
+	if header := r.Header.Get("Content-Range"); header != "" {
+		if start, end, total, ok = parseContentRange(header); !ok {
+			writeError(w, http.StatusBadRequest, "Content-Range must be bytes start-end/total")
+			return
+		}
+	}
+	if total != upload.Size {
+		writeError(w, http.StatusBadRequest, "Content-Range total must be the size the upload was created with")
+		return
+	}
+	if end-start+1 > uploadChunkSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("chunks must be at most %d bytes", uploadChunkSize))
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, end-start+1)
+	offset, err := appender.Append(r.Context(), upload.RawKey, start, body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, storage.ErrOffsetMismatch):
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":  "chunk does not start where the upload left off",
+				"offset": offset,
+			})
+		case errors.As(err, &tooLarge):
+			writeError(w, http.StatusBadRequest, "chunk is larger than its Content-Range")
+		default:
+			slog.Error("failed to store upload chunk", "error", err, "media_id", id)
+			writeError(w, http.StatusInternalServerError, "failed to store chunk")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"offset": offset,
+		"size":   upload.Size,
+	})
+}
+
+// handleCompleteMedia processes the file of a direct upload once it has
+// all been sent. Completing a completed upload returns it unchanged.
+func (s *Server) handleCompleteMedia(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+
+	upload, err := s.uploads.Get(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			writeError(w, http.StatusNotFound, "media not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get media")
+		return
+	}
+	if upload.State == uploads.StateReady {
+		writeJSON(w, http.StatusOK, upload)
+		return
+	}
+
+	info, err := s.storage.Info(r.Context(), upload.RawKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			writeError(w, http.StatusConflict, "upload the file before completing the upload")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to process media")
+		return
+	}
+	if info.Size != upload.Size {
+		writeError(w, http.StatusConflict, fmt.Sprintf("received %d of %d bytes", info.Size, upload.Size))
+		return
+	}
+
+	file, err := s.storage.Get(r.Context(), upload.RawKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to process media")
+		return
+	}
+	img, err := imaging.Process(io.LimitReader(file, upload.Size))
+	file.Close()
+	if err != nil {
+		if isImageError(err) {
+			// The upload cannot be fixed, so let the sweep have it
+			_ = s.uploads.Expire(r.Context(), id, user.ID)
+			writeImageError(w, err)
+			return
+		}
+		slog.Error("failed to process media", "error", err, "media_id", id)
+		writeError(w, http.StatusInternalServerError, "failed to process media")
+		return
+	}
+
+	variants, err := s.storeImage(r.Context(), "media", img)
+	if err != nil {
+		slog.Error("failed to store media", "error", err, "media_id", id)
+		writeError(w, http.StatusInternalServerError, "failed to process media")
+		return
+	}
+
+	completed, err := s.uploads.Complete(r.Context(), id, user.ID, uploadImage(img, variants))
+	if err != nil {
+		s.deleteImages(r.Context(), variants.Keys())
+		if errors.Is(err, uploads.ErrAlreadyComplete) {
+			// Another request completed it first
+			if completed, err = s.uploads.Get(r.Context(), id, user.ID); err == nil {
+				writeJSON(w, http.StatusOK, completed)
+				return
+			}
+		}
+		writeError(w, http.StatusInternalServerError, "failed to process media")
+		return
+	}
+
+	if err := s.storage.Delete(r.Context(), upload.RawKey); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+		slog.Error("failed to delete upload file", "error", err, "key", upload.RawKey)
+	}
+
+	writeJSON(w, http.StatusOK, completed)
+}
+
+// readProfileImage reads a new avatar or header: a file in the multipart
+// field, which is processed and stored under dir, or a media_id from
+// POST /api/v1/media in a JSON body. It writes the error response itself
+// and returns false on failure.
+func (s *Server) readProfileImage(w http.ResponseWriter, r *http.Request, field, dir string) (*users.ProfileImage, bool) {
+	user := getUserFromContext(r)
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var req struct {
+			MediaID uuid.UUID `json:"media_id"`
+		}
+		if err := parseJSON(r, &req); err != nil || req.MediaID == uuid.Nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("send a %s file or a media_id", field))
+			return nil, false
+		}
+
+		found, err := s.uploads.GetReady(r.Context(), user.ID, []uuid.UUID{req.MediaID})
+		if err != nil {
+			if errors.Is(err, uploads.ErrUploadNotFound) || errors.Is(err, uploads.ErrNotReady) {
+				writeUploadError(w, err)
+				return nil, false
+			}
+			writeError(w, http.StatusInternalServerError, "failed to get media")
+			return nil, false
+		}
+
+		upload := found[0]
+		return &users.ProfileImage{
+			URL:      *upload.URL,
+			Key:      *upload.Key,
+			Variants: upload.Variants,
+			UploadID: &upload.ID,
+		}, true
+	}
+
+	if !parseImageForm(w, r) {
+		return nil, false
+	}
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("missing %s file", field))
+		return nil, false
+	}
+	defer file.Close()
+
+	img, err := imaging.Process(file)
+	if err != nil {
+		if isImageError(err) {
+			writeImageError(w, err)
+			return nil, false
+		}
+		slog.Error("failed to process "+field, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to process "+field)
+		return nil, false
+	}
+
+	variants, err := s.storeImage(r.Context(), dir, img)
+	if err != nil {
+		slog.Error("failed to upload "+field, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to upload "+field)
+		return nil, false
+	}
+
+	full := variants[img.Full().Name]
+	return &users.ProfileImage{URL: full.URL, Key: full.Key, Variants: variants}, true
+}
+
+// discardProfileImage deletes a profile image read by readProfileImage
+// that was not saved. Uploads are left to the upload sweep.
+func (s *Server) discardProfileImage(ctx context.Context, img *users.ProfileImage) {
+	if img.UploadID == nil {
+		s.deleteImages(ctx, img.Variants.Keys())
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/storage"
+	"github.com/watzon/moltpress/internal/uploads"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header            string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 0-3/10", 0, 3, 10, true},
+		{"bytes 4-9/10", 4, 9, 10, true},
+		{"bytes 9-9/10", 9, 9, 10, true},
+		{"bytes 4-3/10", 0, 0, 0, false},
+		{"bytes 0-10/10", 0, 0, 0, false},
+		{"bytes -1-3/10", 0, 0, 0, false},
+		{"bytes 0-3/*", 0, 0, 0, false},
+		{"items 0-3/10", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, total, ok := parseContentRange(tt.header)
+		if ok != tt.ok || start != tt.start || end != tt.end || total != tt.total {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, %v", tt.header, start, end, total, ok)
+		}
+	}
+}
+
+func TestHandleUploadMediaChunk(t *testing.T) {
+	db := dbtest.New(t)
+	local, err := storage.NewLocalStorage(t.TempDir(), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{uploads: uploads.NewRepository(db), storage: local, baseURL: "http://localhost"}
+	user := &users.User{ID: dbtest.CreateUser(t, db, "uploader"), Username: "uploader"}
+
+	upload, err := s.uploads.Create(context.Background(), user.ID, 10, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(contentRange, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/media/"+upload.ID.String()+"/content", strings.NewReader(body))
+		req.SetPathValue("id", upload.ID.String())
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		rec := httptest.NewRecorder()
+		s.handleUploadMediaChunk(rec, req)
+
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	steps := []struct {
+		name         string
+		contentRange string
+		body         string
+		status       int
+		offset       float64 // Checked when not zero
+	}{
+		{"first chunk", "bytes 0-3/10", "0123", http.StatusOK, 4},
+		{"first chunk again", "bytes 0-3/10", "0123", http.StatusConflict, 4},
+		{"skipping ahead", "bytes 6-9/10", "6789", http.StatusConflict, 4},
+		{"wrong total", "bytes 4-9/11", "456789", http.StatusBadRequest, 0},
+		{"rest", "bytes 4-9/10", "456789", http.StatusOK, 10},
+	}
+	for _, step := range steps {
+		status, resp := send(step.contentRange, step.body)
+		if status != step.status {
+			t.Fatalf("%s: status = %d (%v), want %d", step.name, status, resp, step.status)
+		}
+		if step.offset != 0 && resp["offset"] != step.offset {
+			t.Errorf("%s: offset = %v, want %v", step.name, resp["offset"], step.offset)
+		}
+	}
+
+	file, err := local.Get(context.Background(), upload.RawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(file)
+	if string(data) != "0123456789" {
+		t.Errorf("stored file = %q, want 0123456789", data)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/webhooks"
+)
+
+// Webhook handlers
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	hooks, err := s.webhooks.List(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": hooks,
+	})
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var req webhooks.CreateWebhookRequest
+	if err := parseJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	hook, err := s.webhooks.Create(r.Context(), user.ID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, webhooks.ErrInvalidURL):
+			writeError(w, http.StatusBadRequest, "url must be an absolute http or https url")
+		case errors.Is(err, webhooks.ErrInvalidEvent):
+			writeError(w, http.StatusBadRequest, "events must be one or more of: reply, mention, follow, like, reblog, followed_post")
+		case errors.Is(err, webhooks.ErrTooManyWebhooks):
+			writeError(w, http.StatusConflict, fmt.Sprintf("at most %d webhooks are allowed per account", webhooks.MaxWebhooksPerUser))
+		default:
+			slog.Error("failed to create webhook", "error", err, "user_id", user.ID)
+			writeError(w, http.StatusInternalServerError, "failed to create webhook")
+		}
+		return
+	}
+
+	slog.Info("webhook created", "user_id", user.ID, "webhook_id", hook.ID)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"webhook": hook,
+		"secret":  hook.Secret,
+	})
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid webhook id")
+		return
+	}
+
+	if err := s.webhooks.Delete(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, webhooks.ErrWebhookNotFound) {
+			writeError(w, http.StatusNotFound, "webhook not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+
+	slog.Info("webhook deleted", "user_id", user.ID, "webhook_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnWebhook loads the webhook named in the path, writing an error
+// response and returning nil if it is missing or belongs to someone else.
+func (s *Server) getOwnWebhook(w http.ResponseWriter, r *http.Request) *webhooks.Webhook {
+	user := getUserFromContext(r)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid webhook id")
+		return nil
+	}
+
+	hook, err := s.webhooks.Get(r.Context(), user.ID, id)
+	if err != nil {
+		if errors.Is(err, webhooks.ErrWebhookNotFound) {
+			writeError(w, http.StatusNotFound, "webhook not found")
+			return nil
+		}
+		writeError(w, http.StatusInternalServerError, "failed to get webhook")
+		return nil
+	}
+	return hook
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook := s.getOwnWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	query := r.URL.Query()
+
+	opts := webhooks.DeliveryOptions{
+		Limit: getQueryInt(r, "limit", 20),
+	}
+
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeCursor(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	if status := query.Get("status"); status != "" {
+		ds := webhooks.DeliveryStatus(status)
+		if ds != webhooks.StatusPending && ds != webhooks.StatusSucceeded && ds != webhooks.StatusFailed {
+			writeError(w, http.StatusBadRequest, "status must be one of: pending, succeeded, failed")
+			return
+		}
+		opts.Status = &ds
+	}
+
+	page, err := s.webhooks.ListDeliveries(r.Context(), hook.ID, opts)
+	if err != nil {
+		slog.Error("failed to list webhook deliveries", "error", err, "webhook_id", hook.ID)
+		writeError(w, http.StatusInternalServerError, "failed to list deliveries")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := s.getOwnWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("delivery_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid delivery id")
+		return
+	}
+
+	delivery, err := s.webhooks.Redeliver(r.Context(), hook.ID, deliveryID)
+	if err != nil {
+		if errors.Is(err, webhooks.ErrDeliveryNotFound) {
+			writeError(w, http.StatusNotFound, "delivery not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to redeliver")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, delivery)
+}
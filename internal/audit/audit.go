@@ -0,0 +1,145 @@
+// Package audit records what admins do. The log is append-only: entries
+// are written in the same transaction as the action they describe, and the
+// database refuses to change or remove them.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/pagination"
+)
+
+type Action string
+
+const (
+	ActionRemovePost   Action = "post.remove"
+	ActionSuspendUser  Action = "user.suspend"
+	ActionSilenceUser  Action = "user.silence"
+	ActionRestoreUser  Action = "user.restore"
+	ActionSetRole      Action = "user.role"
+	ActionTriageReport Action = "report.triage"
+)
+
+// OperatorID is the actor recorded for actions taken with the moltpress
+// command, by whoever runs the server, rather than by an admin account.
+var OperatorID = uuid.Nil
+
+// Target types
+const (
+	TargetPost   = "post"
+	TargetUser   = "user"
+	TargetReport = "report"
+)
+
+type Entry struct {
+	ID         uuid.UUID      `json:"id"`
+	ActorID    uuid.UUID      `json:"actor_id"`
+	Action     Action         `json:"action"`
+	TargetType string         `json:"target_type"`
+	TargetID   uuid.UUID      `json:"target_id"`
+	Details    map[string]any `json:"details,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Execer is satisfied by both the pool and a transaction.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Record appends e to the log. Pass the transaction making the change so
+// the entry is only written if it commits.
+func Record(ctx context.Context, q Execer, e Entry) error {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO audit_log (actor_id, action, target_type, target_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, e.ActorID, e.Action, e.TargetType, e.TargetID, details)
+	return err
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+type ListOptions struct {
+	Limit    int
+	Cursor   *pagination.Cursor
+	ActorID  *uuid.UUID
+	TargetID *uuid.UUID
+}
+
+type Page struct {
+	Entries    []Entry `json:"entries"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	HasMore    bool    `json:"has_more"`
+}
+
+// List returns log entries, newest first.
+func (r *Repository) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+
+	var cursorTime *time.Time
+	var cursorID *uuid.UUID
+	if opts.Cursor != nil {
+		cursorTime = &opts.Cursor.CreatedAt
+		cursorID = &opts.Cursor.ID
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, actor_id, action, target_type, target_id, details, created_at
+		FROM audit_log
+		WHERE ($1::timestamptz IS NULL OR (created_at, id) < ($1, $2))
+		  AND ($3::uuid IS NULL OR actor_id = $3)
+		  AND ($4::uuid IS NULL OR target_id = $4)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`, cursorTime, cursorID, opts.ActorID, opts.TargetID, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page{Entries: []Entry{}}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Entries) > opts.Limit {
+		page.Entries = page.Entries[:opts.Limit]
+		page.HasMore = true
+	}
+	if n := len(page.Entries); n > 0 {
+		last := page.Entries[n-1]
+		page.NextCursor = pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
+}
@@ -0,0 +1,229 @@
+// Package blocks lets users block and mute each other. A block removes
+// follows between the two users, stops either one following, replying to,
+// reblogging or liking the other, and hides each one's content from the
+// other. A mute only hides the muted user's content from the muter's home
+// feed, replies and notifications.
+package blocks
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+var (
+	ErrBlocked = errors.New("one of the users has blocked the other")
+	ErrSelf    = errors.New("cannot block or mute yourself")
+)
+
+// Blocked returns an SQL condition that holds when either of the users a
+// and b, given as SQL expressions, has blocked the other.
+func Blocked(a, b string) string {
+	return `EXISTS(SELECT 1 FROM blocks WHERE (blocker_id = ` + a + ` AND blocked_id = ` + b + `)
+		OR (blocker_id = ` + b + ` AND blocked_id = ` + a + `))`
+}
+
+// Muted returns an SQL condition that holds when muter has muted muted,
+// both given as SQL expressions.
+func Muted(muter, muted string) string {
+	return `EXISTS(SELECT 1 FROM mutes WHERE muter_id = ` + muter + ` AND muted_id = ` + muted + `)`
+}
+
+// Between reports whether either of a and b has blocked the other. Pass
+// the transaction about to act on the answer: it holds the pair's lock
+// until it ends, so a block between them waits for it rather than landing
+// after the check and before whatever tx does next.
+func Between(ctx context.Context, tx pgx.Tx, a, b uuid.UUID) (bool, error) {
+	if err := lockPair(ctx, tx, a, b); err != nil {
+		return false, err
+	}
+
+	var blocked bool
+	err := tx.QueryRow(ctx, `SELECT `+Blocked("$1::uuid", "$2::uuid"), a, b).Scan(&blocked)
+	return blocked, err
+}
+
+// lockPair takes a lock on a and b, in either order, held until tx ends.
+// It must be its own statement, so those after it see what the holder
+// before it committed.
+func lockPair(ctx context.Context, tx pgx.Tx, a, b uuid.UUID) error {
+	first, second := a.String(), b.String()
+	if second < first {
+		first, second = second, first
+	}
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "blocks:"+first+":"+second)
+	return err
+}
+
+// Entry is a user someone has blocked or muted.
+type Entry struct {
+	User      users.UserPublic `json:"user"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Block blocks blockedID for blockerID and removes any follows between
+// them.
+func (r *Repository) Block(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	if blockerID == blockedID {
+		return ErrSelf
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockPair(ctx, tx, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM follows
+		WHERE (follower_id = $1 AND following_id = $2) OR (follower_id = $2 AND following_id = $1)
+	`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2
+	`, blockerID, blockedID)
+	return err
+}
+
+func (r *Repository) Mute(ctx context.Context, muterID, mutedID uuid.UUID) error {
+	if muterID == mutedID {
+		return ErrSelf
+	}
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO mutes (muter_id, muted_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, muterID, mutedID)
+	return err
+}
+
+func (r *Repository) Unmute(ctx context.Context, muterID, mutedID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM mutes WHERE muter_id = $1 AND muted_id = $2
+	`, muterID, mutedID)
+	return err
+}
+
+// ListBlocked returns the users userID has blocked, most recent first.
+func (r *Repository) ListBlocked(ctx context.Context, userID uuid.UUID, limit, offset int) ([]Entry, error) {
+	return r.list(ctx, `
+		SELECT u.id, u.username, u.display_name, u.avatar_url, u.is_agent, u.created_at, b.created_at
+		FROM blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+}
+
+// ListMuted returns the users userID has muted, most recent first.
+func (r *Repository) ListMuted(ctx context.Context, userID uuid.UUID, limit, offset int) ([]Entry, error) {
+	return r.list(ctx, `
+		SELECT u.id, u.username, u.display_name, u.avatar_url, u.is_agent, u.created_at, m.created_at
+		FROM mutes m
+		JOIN users u ON u.id = m.muted_id
+		WHERE m.muter_id = $1
+		ORDER BY m.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+}
+
+func (r *Repository) list(ctx context.Context, query string, userID uuid.UUID, limit, offset int) ([]Entry, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+
+	rows, err := r.db.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		err := rows.Scan(
+			&e.User.ID, &e.User.Username, &e.User.DisplayName, &e.User.AvatarURL, &e.User.IsAgent,
+			&e.User.CreatedAt, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// IsBlocked reports whether either of a and b has blocked the other. Use
+// Between instead when acting on the answer in a transaction.
+func (r *Repository) IsBlocked(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	var blocked bool
+	err := r.db.QueryRow(ctx, `SELECT `+Blocked("$1::uuid", "$2::uuid"), a, b).Scan(&blocked)
+	return blocked, err
+}
+
+// HiddenIDs returns the users whose content is hidden from userID: those
+// blocked either way, and those userID has muted.
+func (r *Repository) HiddenIDs(ctx context.Context, userID uuid.UUID) (blocked, muted map[uuid.UUID]bool, err error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT blocked_id, true FROM blocks WHERE blocker_id = $1
+		UNION
+		SELECT blocker_id, true FROM blocks WHERE blocked_id = $1
+		UNION
+		SELECT muted_id, false FROM mutes WHERE muter_id = $1
+	`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	blocked = map[uuid.UUID]bool{}
+	muted = map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		var isBlock bool
+		if err := rows.Scan(&id, &isBlock); err != nil {
+			return nil, nil, err
+		}
+		if isBlock {
+			blocked[id] = true
+		} else {
+			muted[id] = true
+		}
+	}
+
+	return blocked, muted, rows.Err()
+}
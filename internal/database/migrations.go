@@ -2,191 +2,410 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func Migrate(db *pgxpool.Pool) error {
-	ctx := context.Background()
+// Migrations are NAME.up.sql files, applied in name order, each with an
+// optional NAME.down.sql that reverts it. Once a migration has been
+// released its files must not change; add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
 
-	// Create migrations table
-	_, err := db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS migrations (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL UNIQUE,
-			applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		)
+// migrationLockID identifies the advisory lock held while migrating, so
+// replicas starting at the same time take turns.
+const migrationLockID = 0x6d6f6c7470726573 // "moltpres"
+
+var (
+	ErrSchemaAhead      = errors.New("database has migrations this binary does not know about")
+	ErrMigrationChanged = errors.New("migration has changed since it was applied")
+	ErrIrreversible     = errors.New("migration has no down migration")
+)
+
+// Migration states reported by Status
+const (
+	StatePending = "pending"
+	StateApplied = "applied"
+	StateChanged = "changed" // Applied, but the file has changed since
+	StateUnknown = "unknown" // Applied by a newer binary
+)
+
+var migrationName = regexp.MustCompile(`^[0-9]+_[a-z0-9_]+$`)
+
+type migration struct {
+	name     string
+	up       string
+	down     string // Empty if the migration cannot be reverted
+	checksum string // Of up
+}
+
+// loadMigrations reads the migrations in fsys, in the order they apply.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*migration)
+	for _, file := range files {
+		name, isUp := strings.CutSuffix(file, ".up.sql")
+		if !isUp {
+			var isDown bool
+			if name, isDown = strings.CutSuffix(file, ".down.sql"); !isDown {
+				return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", file)
+			}
+		}
+		if !migrationName.MatchString(name) {
+			return nil, fmt.Errorf("migration %s must be named like 001_create_things", file)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byName[name]
+		if m == nil {
+			m = &migration{name: name}
+			byName[name] = m
+		}
+		if isUp {
+			sum := sha256.Sum256(data)
+			m.up = string(data)
+			m.checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byName))
+	for _, m := range byName {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %s has a down migration but no up migration", m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].name < migrations[j].name })
+	return migrations, nil
+}
+
+func embeddedMigrations() ([]migration, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(fsys)
+}
+
+// appliedMigration is a row of the migrations table.
+type appliedMigration struct {
+	name      string
+	checksum  *string // Nil for migrations applied before checksums were kept
+	appliedAt time.Time
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// readApplied returns the applied migrations, oldest first, or none if the
+// database has never been migrated.
+func readApplied(ctx context.Context, q querier) ([]appliedMigration, error) {
+	var exists bool
+	if err := q.QueryRow(ctx, `SELECT to_regclass('migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	// Through to_jsonb, so this also works before the checksum column is
+	// added
+	rows, err := q.Query(ctx, `
+		SELECT name, to_jsonb(m)->>'checksum', applied_at FROM migrations m ORDER BY id
 	`)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// checkApplied makes sure every applied migration is one of migrations,
+// unchanged since it was applied.
+func checkApplied(migrations []migration, applied []appliedMigration) error {
+	known := make(map[string]migration, len(migrations))
+	for _, m := range migrations {
+		known[m.name] = m
+	}
+
+	for _, a := range applied {
+		m, ok := known[a.name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrSchemaAhead, a.name)
+		}
+		if a.checksum != nil && *a.checksum != m.checksum {
+			return fmt.Errorf("%w: %s", ErrMigrationChanged, a.name)
+		}
 	}
+	return nil
+}
 
-	migrations := []struct {
-		name string
-		sql  string
-	}{
-		{
-			name: "001_initial_schema",
-			sql: `
-				-- Users table (agents and humans)
-				CREATE TABLE IF NOT EXISTS users (
-					id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-					username VARCHAR(50) NOT NULL UNIQUE,
-					display_name VARCHAR(100),
-					bio TEXT,
-					avatar_url TEXT,
-					header_url TEXT,
-					api_key VARCHAR(128) UNIQUE,
-					password_hash VARCHAR(255),
-					is_agent BOOLEAN DEFAULT false,
-					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
-				CREATE INDEX IF NOT EXISTS idx_users_api_key ON users(api_key);
-
-				-- Posts table
-				CREATE TABLE IF NOT EXISTS posts (
-					id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-					content TEXT,
-					image_url TEXT,
-					reblog_of_id UUID REFERENCES posts(id) ON DELETE SET NULL,
-					reblog_comment TEXT,
-					like_count INTEGER DEFAULT 0,
-					reblog_count INTEGER DEFAULT 0,
-					reply_count INTEGER DEFAULT 0,
-					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts(user_id);
-				CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts(created_at DESC);
-				CREATE INDEX IF NOT EXISTS idx_posts_reblog_of ON posts(reblog_of_id);
-
-				-- Follows table
-				CREATE TABLE IF NOT EXISTS follows (
-					follower_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-					following_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-					PRIMARY KEY (follower_id, following_id)
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_follows_follower ON follows(follower_id);
-				CREATE INDEX IF NOT EXISTS idx_follows_following ON follows(following_id);
-
-				-- Likes table
-				CREATE TABLE IF NOT EXISTS likes (
-					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-					post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
-					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-					PRIMARY KEY (user_id, post_id)
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_likes_post ON likes(post_id);
-
-				-- Tags table
-				CREATE TABLE IF NOT EXISTS tags (
-					id SERIAL PRIMARY KEY,
-					name VARCHAR(100) NOT NULL UNIQUE,
-					post_count INTEGER DEFAULT 0
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_tags_name ON tags(name);
-
-				-- Post tags junction
-				CREATE TABLE IF NOT EXISTS post_tags (
-					post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
-					tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
-					PRIMARY KEY (post_id, tag_id)
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_post_tags_tag ON post_tags(tag_id);
-
-				-- Sessions table for web auth
-				CREATE TABLE IF NOT EXISTS sessions (
-					id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-					user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-					token VARCHAR(64) NOT NULL UNIQUE,
-					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
-					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_sessions_token ON sessions(token);
-				CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
-			`,
-		},
-		{
-			name: "002_add_reply_to",
-			sql: `
-				ALTER TABLE posts ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES posts(id) ON DELETE SET NULL;
-				CREATE INDEX IF NOT EXISTS idx_posts_reply_to ON posts(reply_to_id);
-			`,
-		},
-		{
-			name: "003_add_verification",
-			sql: `
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_code VARCHAR(32);
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS x_username VARCHAR(50);
-			CREATE INDEX IF NOT EXISTS idx_users_verification_code ON users(verification_code);
-		`,
-		},
-		{
-			name: "004_add_theme_settings",
-			sql: `
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS theme_settings JSONB DEFAULT NULL;
-		`,
-		},
-		{
-			name: "005_add_sentiment_and_trending_hotness",
-			sql: `
-			ALTER TABLE tags ADD COLUMN IF NOT EXISTS hot_score DOUBLE PRECISION DEFAULT 0;
-			ALTER TABLE tags ADD COLUMN IF NOT EXISTS hot_updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
-			CREATE INDEX IF NOT EXISTS idx_tags_hot_score ON tags(hot_score DESC);
-
-			ALTER TABLE posts ADD COLUMN IF NOT EXISTS sentiment_score DOUBLE PRECISION DEFAULT 0;
-			ALTER TABLE posts ADD COLUMN IF NOT EXISTS sentiment_label VARCHAR(16) DEFAULT 'neutral';
-			ALTER TABLE posts ADD COLUMN IF NOT EXISTS controversy_score DOUBLE PRECISION DEFAULT 0;
-			CREATE INDEX IF NOT EXISTS idx_posts_controversy_score ON posts(controversy_score DESC);
-		`,
-		},
-		{
-			name: "006_add_image_key",
-			sql: `
-			ALTER TABLE posts ADD COLUMN IF NOT EXISTS image_key TEXT;
-		`,
-		},
-		{
-			name: "007_add_profile_image_keys",
-			sql: `
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key TEXT;
-			ALTER TABLE users ADD COLUMN IF NOT EXISTS header_key TEXT;
-		`,
-		},
+// pending returns the migrations that have not been applied, in order.
+func pending(migrations []migration, applied []appliedMigration) []migration {
+	done := make(map[string]bool, len(applied))
+	for _, a := range applied {
+		done[a.name] = true
 	}
 
+	var todo []migration
 	for _, m := range migrations {
-		var exists bool
-		err := db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM migrations WHERE name = $1)", m.name).Scan(&exists)
+		if !done[m.name] {
+			todo = append(todo, m)
+		}
+	}
+	return todo
+}
+
+// withLock runs fn on a connection holding the migration lock, waiting for
+// any other process migrating to finish first.
+func withLock(ctx context.Context, db *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(migrationLockID)); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(migrationLockID))
+
+	return fn(conn)
+}
+
+// Migrate applies the migrations the database doesn't have yet, in order,
+// and returns their names. Each runs in its own transaction, so one that
+// fails leaves nothing behind and stops the rest. With dryRun it only
+// returns what it would apply.
+//
+// It refuses to run if the database has migrations this binary doesn't
+// know about, or if an applied migration has changed since.
+func Migrate(ctx context.Context, db *pgxpool.Pool, dryRun bool) ([]string, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		applied, err := readApplied(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkApplied(migrations, applied); err != nil {
+			return nil, err
+		}
+		return names(pending(migrations, applied)), nil
+	}
+
+	var done []string
+	err = withLock(ctx, db, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			CREATE TABLE IF NOT EXISTS migrations (
+				id SERIAL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL UNIQUE,
+				applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+			);
+			ALTER TABLE migrations ADD COLUMN IF NOT EXISTS checksum VARCHAR(64);
+		`)
 		if err != nil {
 			return err
 		}
 
-		if !exists {
+		applied, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := checkApplied(migrations, applied); err != nil {
+			return err
+		}
+
+		// Migrations applied before checksums were kept are taken as they
+		// are now
+		checksums := make([]string, len(migrations))
+		for i, m := range migrations {
+			checksums[i] = m.checksum
+		}
+		_, err = conn.Exec(ctx, `
+			UPDATE migrations m SET checksum = c.checksum
+			FROM unnest($1::text[], $2::text[]) AS c(name, checksum)
+			WHERE m.name = c.name AND m.checksum IS NULL
+		`, names(migrations), checksums)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range pending(migrations, applied) {
 			slog.Info("applying migration", "name", m.name)
-			_, err := db.Exec(ctx, m.sql)
-			if err != nil {
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `INSERT INTO migrations (name, checksum) VALUES ($1, $2)`, m.name, m.checksum)
 				return err
-			}
-			_, err = db.Exec(ctx, "INSERT INTO migrations (name) VALUES ($1)", m.name)
+			})
 			if err != nil {
+				return fmt.Errorf("migration %s: %w", m.name, err)
+			}
+			done = append(done, m.name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Rollback reverts the last steps migrations applied, newest first, each
+// in its own transaction, and returns their names. Nothing is reverted
+// unless every one of them has a down migration. With dryRun it only
+// returns what it would revert.
+func Rollback(ctx context.Context, db *pgxpool.Pool, steps int, dryRun bool) ([]string, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]migration, len(migrations))
+	for _, m := range migrations {
+		known[m.name] = m
+	}
+
+	// plan picks the migrations to revert
+	plan := func(applied []appliedMigration) ([]migration, error) {
+		if err := checkApplied(migrations, applied); err != nil {
+			return nil, err
+		}
+		var todo []migration
+		for i := len(applied) - 1; i >= 0 && len(todo) < steps; i-- {
+			m := known[applied[i].name]
+			if m.down == "" {
+				return nil, fmt.Errorf("%w: %s", ErrIrreversible, m.name)
+			}
+			todo = append(todo, m)
+		}
+		return todo, nil
+	}
+
+	if dryRun {
+		applied, err := readApplied(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		todo, err := plan(applied)
+		return names(todo), err
+	}
+
+	var done []string
+	err = withLock(ctx, db, func(conn *pgxpool.Conn) error {
+		applied, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		todo, err := plan(applied)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range todo {
+			slog.Info("reverting migration", "name", m.name)
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM migrations WHERE name = $1`, m.name)
 				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s: %w", m.name, err)
+			}
+			done = append(done, m.name)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrationStatus is a migration and whether it has been applied.
+type MigrationStatus struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Status lists the migrations this binary has in the order they apply,
+// then any applied ones it doesn't know about, without changing the
+// database.
+func Status(ctx context.Context, db *pgxpool.Pool) ([]MigrationStatus, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]appliedMigration, len(rows))
+	for _, a := range rows {
+		applied[a.name] = a
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Name: m.name, State: StatePending}
+		if a, ok := applied[m.name]; ok {
+			status.State = StateApplied
+			status.AppliedAt = &a.appliedAt
+			if a.checksum != nil && *a.checksum != m.checksum {
+				status.State = StateChanged
 			}
+			delete(applied, m.name)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, a := range rows {
+		if _, ok := applied[a.name]; ok {
+			statuses = append(statuses, MigrationStatus{Name: a.name, State: StateUnknown, AppliedAt: &a.appliedAt})
 		}
 	}
+	return statuses, nil
+}
 
-	return nil
+func names(migrations []migration) []string {
+	names := make([]string, len(migrations))
+	for i, m := range migrations {
+		names[i] = m.name
+	}
+	return names
 }
@@ -0,0 +1,2 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES posts(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_posts_reply_to ON posts(reply_to_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_verification_code;
+ALTER TABLE users DROP COLUMN IF EXISTS x_username;
+ALTER TABLE users DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE users DROP COLUMN IF EXISTS verification_code;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_code VARCHAR(32);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS x_username VARCHAR(50);
+CREATE INDEX IF NOT EXISTS idx_users_verification_code ON users(verification_code);
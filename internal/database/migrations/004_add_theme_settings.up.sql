@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS theme_settings JSONB DEFAULT NULL;
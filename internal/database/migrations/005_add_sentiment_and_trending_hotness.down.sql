@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_posts_controversy_score;
+ALTER TABLE posts DROP COLUMN IF EXISTS controversy_score;
+ALTER TABLE posts DROP COLUMN IF EXISTS sentiment_label;
+ALTER TABLE posts DROP COLUMN IF EXISTS sentiment_score;
+
+DROP INDEX IF EXISTS idx_tags_hot_score;
+ALTER TABLE tags DROP COLUMN IF EXISTS hot_updated_at;
+ALTER TABLE tags DROP COLUMN IF EXISTS hot_score;
@@ -0,0 +1,8 @@
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS hot_score DOUBLE PRECISION DEFAULT 0;
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS hot_updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_tags_hot_score ON tags(hot_score DESC);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS sentiment_score DOUBLE PRECISION DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS sentiment_label VARCHAR(16) DEFAULT 'neutral';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS controversy_score DOUBLE PRECISION DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_posts_controversy_score ON posts(controversy_score DESC);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys(prefix);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+-- Move existing plaintext keys into hashed rows with full scopes
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+SELECT id, 'default', LEFT(api_key, 11), encode(sha256(api_key::bytea), 'hex'),
+       ARRAY['read', 'post', 'interact', 'profile', 'admin']
+FROM users
+WHERE api_key IS NOT NULL
+ON CONFLICT (key_hash) DO NOTHING;
+
+ALTER TABLE users DROP COLUMN IF EXISTS api_key;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS verified_identity;
+ALTER TABLE users DROP COLUMN IF EXISTS verified_via;
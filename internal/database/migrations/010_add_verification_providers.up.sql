@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_via VARCHAR(32);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_identity VARCHAR(255);
+
+UPDATE users SET verified_via = 'twitter', verified_identity = x_username
+WHERE verified_at IS NOT NULL AND x_username IS NOT NULL AND verified_via IS NULL;
@@ -0,0 +1,17 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', COALESCE(content, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(reblog_comment, '')), 'B')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS idx_posts_search ON posts USING GIN(search_vector);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', COALESCE(username, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(display_name, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(bio, '')), 'C')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS idx_users_search ON users USING GIN(search_vector);
+CREATE INDEX IF NOT EXISTS idx_users_username_prefix ON users(LOWER(username) text_pattern_ops);
+
+CREATE INDEX IF NOT EXISTS idx_tags_name_prefix ON tags(LOWER(name) text_pattern_ops);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(16) NOT NULL,
+    post_id UUID REFERENCES posts(id) ON DELETE CASCADE,
+    source_post_id UUID REFERENCES posts(id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
+
+-- One like/follow notification per actor, however often they toggle
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_dedup
+    ON notifications(user_id, actor_id, type, post_id) NULLS NOT DISTINCT
+    WHERE type IN ('like', 'follow');
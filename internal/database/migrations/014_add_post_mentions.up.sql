@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS post_mentions (
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    PRIMARY KEY (post_id, start_offset)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_mentions_user ON post_mentions(user_id, post_id);
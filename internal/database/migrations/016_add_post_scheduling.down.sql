@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS post_queues;
+
+-- Posts that were never published have nowhere to go without a state
+DELETE FROM posts WHERE state <> 'published';
+DROP INDEX IF EXISTS idx_posts_pending;
+DROP INDEX IF EXISTS idx_posts_scheduled;
+ALTER TABLE posts DROP COLUMN IF EXISTS publish_at;
+ALTER TABLE posts DROP COLUMN IF EXISTS state;
@@ -0,0 +1,17 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS state VARCHAR(16) NOT NULL DEFAULT 'published';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_posts_scheduled ON posts(publish_at) WHERE state = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_posts_pending ON posts(user_id, state, created_at) WHERE state <> 'published';
+
+-- Queue settings; slot times are "HH:MM" in the queue's time zone
+CREATE TABLE IF NOT EXISTS post_queues (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    times TEXT[] NOT NULL DEFAULT '{}',
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    paused BOOLEAN NOT NULL DEFAULT false,
+    next_slot_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_queues_next_slot ON post_queues(next_slot_at) WHERE next_slot_at IS NOT NULL;
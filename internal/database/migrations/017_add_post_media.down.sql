@@ -0,0 +1,2 @@
+-- Posts keep their first image in image_url and image_key
+DROP TABLE IF EXISTS post_media;
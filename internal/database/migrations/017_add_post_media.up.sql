@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS post_media (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    url TEXT NOT NULL,
+    storage_key TEXT,
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    width INTEGER,
+    height INTEGER,
+    alt_text TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- Deferred so attachments can be reordered in one statement
+    UNIQUE (post_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- Existing single images become each post's first attachment
+INSERT INTO post_media (post_id, position, url, storage_key, content_type, alt_text, created_at)
+SELECT id, 0, image_url, image_key,
+    CASE
+        WHEN image_key ILIKE '%.jpg' OR image_key ILIKE '%.jpeg' THEN 'image/jpeg'
+        WHEN image_key ILIKE '%.png' THEN 'image/png'
+        WHEN image_key ILIKE '%.gif' THEN 'image/gif'
+        WHEN image_key ILIKE '%.webp' THEN 'image/webp'
+        ELSE ''
+    END,
+    image_alt, created_at
+FROM posts
+WHERE image_url IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM post_media m WHERE m.post_id = posts.id);
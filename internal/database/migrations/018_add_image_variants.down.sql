@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS header_variants;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_variants;
+ALTER TABLE post_media DROP COLUMN IF EXISTS variants;
@@ -0,0 +1,5 @@
+-- Resized copies of uploads, by size name. Images uploaded
+-- before processing have none and are served as they are.
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS variants JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_variants JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS header_variants JSONB;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_users_header_upload;
+DROP INDEX IF EXISTS idx_users_avatar_upload;
+ALTER TABLE users DROP COLUMN IF EXISTS header_upload_id;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_upload_id;
+
+DROP INDEX IF EXISTS idx_post_media_upload;
+ALTER TABLE post_media DROP COLUMN IF EXISTS upload_id;
+
+DROP TABLE IF EXISTS uploads;
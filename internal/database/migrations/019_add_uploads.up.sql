@@ -0,0 +1,30 @@
+-- Images uploaded through /api/v1/media, which posts and
+-- profiles then reference. Uploads nothing references are
+-- deleted once they expire.
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',
+    raw_key TEXT NOT NULL,
+    content_type VARCHAR(64) NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL,
+    url TEXT,
+    storage_key TEXT,
+    width INTEGER,
+    height INTEGER,
+    variants JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_user ON uploads(user_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads(expires_at);
+
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS upload_id UUID REFERENCES uploads(id);
+CREATE INDEX IF NOT EXISTS idx_post_media_upload ON post_media(upload_id) WHERE upload_id IS NOT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_upload_id UUID REFERENCES uploads(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS header_upload_id UUID REFERENCES uploads(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_users_avatar_upload ON users(avatar_upload_id) WHERE avatar_upload_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_header_upload ON users(header_upload_id) WHERE header_upload_id IS NOT NULL;
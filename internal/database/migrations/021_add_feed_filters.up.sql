@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS feed_filters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    value TEXT NOT NULL DEFAULT '',
+    regex BOOLEAN NOT NULL DEFAULT false,
+    min_score DOUBLE PRECISION,
+    max_score DOUBLE PRECISION,
+    action VARCHAR(8) NOT NULL DEFAULT 'hide',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_feed_filters_user ON feed_filters(user_id, created_at);
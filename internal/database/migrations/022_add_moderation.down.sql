@@ -0,0 +1,10 @@
+-- Dropping the table is the only way to remove audit entries
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+
+DROP TABLE IF EXISTS reports;
+
+ALTER TABLE users DROP COLUMN IF EXISTS moderation_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS moderation_until;
+ALTER TABLE users DROP COLUMN IF EXISTS moderation_state;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
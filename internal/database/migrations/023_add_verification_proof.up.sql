@@ -0,0 +1,9 @@
+-- Whether the account's verification was checked against a proof. Only
+-- those accounts pass their verification on to the agents they create.
+-- Providers other than twitter always checked one; twitter verifications
+-- could skip it, and which did was not recorded.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_proven BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users SET verification_proven = true
+WHERE verified_at IS NOT NULL AND owner_id IS NULL
+  AND verified_via IS NOT NULL AND verified_via <> 'twitter';
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_add_things.up.sql":      {Data: []byte("ALTER TABLE things ADD COLUMN b TEXT;")},
+		"001_create_things.up.sql":   {Data: []byte("CREATE TABLE things (a TEXT);")},
+		"001_create_things.down.sql": {Data: []byte("DROP TABLE things;")},
+	}
+
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	if got := names(migrations); len(got) != 2 || got[0] != "001_create_things" || got[1] != "002_add_things" {
+		t.Fatalf("names = %v, want [001_create_things 002_add_things]", got)
+	}
+	if migrations[0].down != "DROP TABLE things;" {
+		t.Errorf("down = %q", migrations[0].down)
+	}
+	if migrations[1].down != "" {
+		t.Errorf("002 should have no down migration, got %q", migrations[1].down)
+	}
+	if migrations[0].checksum == "" || migrations[0].checksum == migrations[1].checksum {
+		t.Errorf("checksums = %q, %q", migrations[0].checksum, migrations[1].checksum)
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"down without up": {"001_things.down.sql": {Data: []byte("DROP TABLE things;")}},
+		"wrong suffix":    {"001_things.sql": {Data: []byte("SELECT 1;")}},
+		"bad name":        {"Things.up.sql": {Data: []byte("SELECT 1;")}},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadMigrations(fsys); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatalf("embeddedMigrations() error = %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].name != "001_initial_schema" {
+		t.Fatalf("first migration = %v", names(migrations))
+	}
+}
+
+func TestCheckApplied(t *testing.T) {
+	migrations := []migration{
+		{name: "001_a", checksum: "aaa"},
+		{name: "002_b", checksum: "bbb"},
+	}
+	sum := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		applied []appliedMigration
+		want    error
+	}{
+		{"none", nil, nil},
+		{"matching", []appliedMigration{{name: "001_a", checksum: sum("aaa")}}, nil},
+		{"no checksum yet", []appliedMigration{{name: "001_a"}}, nil},
+		{"changed", []appliedMigration{{name: "001_a", checksum: sum("xxx")}}, ErrMigrationChanged},
+		{"ahead", []appliedMigration{{name: "001_a"}, {name: "003_c"}}, ErrSchemaAhead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkApplied(migrations, tt.applied)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("checkApplied() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	todo := pending(migrations, []appliedMigration{{name: "001_a"}})
+	if len(todo) != 1 || todo[0].name != "002_b" {
+		t.Errorf("pending() = %v, want [002_b]", names(todo))
+	}
+}
@@ -0,0 +1,326 @@
+// Package filters stores the rules an account uses to trim its feeds:
+// muted words, phrases and patterns, muted tags, reblogs, replies, and
+// bounds on sentiment and controversy scores. Each filter either hides the
+// posts it matches or lets them through flagged with the reason, so agents
+// can skip them without reading them.
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Kind string
+
+const (
+	KindKeyword     Kind = "keyword"     // Value is a word or phrase, or a pattern when Regex is set
+	KindTag         Kind = "tag"         // Value is a normalized tag
+	KindReblogs     Kind = "reblogs"     // Every reblog
+	KindReplies     Kind = "replies"     // Every reply
+	KindSentiment   Kind = "sentiment"   // Posts whose sentiment_score is below Min or above Max
+	KindControversy Kind = "controversy" // Posts whose controversy_score is below Min or above Max
+)
+
+type Action string
+
+const (
+	ActionHide Action = "hide" // Leave matching posts out of the feed
+	ActionWarn Action = "warn" // Return matching posts flagged as filtered
+)
+
+const (
+	// MaxFilters caps how many filters one account can save.
+	MaxFilters = 100
+	// MaxRegexFilters caps how many of those filters can be patterns,
+	// which cost far more to run against a feed than words or tags.
+	MaxRegexFilters = 10
+	// MaxValueLength caps keywords, patterns and tags, in characters.
+	MaxValueLength = 200
+)
+
+var (
+	ErrInvalidKind    = errors.New("invalid filter kind")
+	ErrInvalidAction  = errors.New("invalid filter action")
+	ErrInvalidValue   = errors.New("invalid filter value")
+	ErrInvalidPattern = errors.New("invalid filter pattern")
+	ErrInvalidBounds  = errors.New("invalid filter bounds")
+	ErrTooManyFilters = errors.New("too many filters")
+	ErrTooManyRegex   = errors.New("too many pattern filters")
+	ErrFilterNotFound = errors.New("filter not found")
+)
+
+type Filter struct {
+	ID        uuid.UUID `json:"id"`
+	Kind      Kind      `json:"kind"`
+	Value     string    `json:"value,omitempty"`
+	Regex     bool      `json:"regex,omitempty"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+	Action    Action    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateFilterRequest struct {
+	Kind   Kind     `json:"kind"`
+	Value  string   `json:"value,omitempty"`
+	Regex  bool     `json:"regex,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Action Action   `json:"action,omitempty"` // Defaults to hide
+}
+
+// Validate checks that req describes a usable filter, defaulting its
+// action. Tags must already be normalized. Patterns are only checked for
+// backreferences here; Create compiles them, since they are run by the
+// database.
+func (req *CreateFilterRequest) Validate() error {
+	if req.Action == "" {
+		req.Action = ActionHide
+	}
+	if req.Action != ActionHide && req.Action != ActionWarn {
+		return ErrInvalidAction
+	}
+
+	switch req.Kind {
+	case KindKeyword, KindTag:
+		req.Value = strings.TrimSpace(req.Value)
+		if req.Value == "" || utf8.RuneCountInString(req.Value) > MaxValueLength {
+			return ErrInvalidValue
+		}
+		if req.Regex && (req.Kind != KindKeyword || hasBackreference(req.Value)) {
+			return ErrInvalidPattern
+		}
+		if req.Min != nil || req.Max != nil {
+			return ErrInvalidBounds
+		}
+	case KindReblogs, KindReplies:
+		if req.Value != "" || req.Regex {
+			return ErrInvalidValue
+		}
+		if req.Min != nil || req.Max != nil {
+			return ErrInvalidBounds
+		}
+	case KindSentiment, KindControversy:
+		if req.Value != "" || req.Regex {
+			return ErrInvalidValue
+		}
+		if req.Min == nil && req.Max == nil {
+			return ErrInvalidBounds
+		}
+		if req.Min != nil && req.Max != nil && *req.Min > *req.Max {
+			return ErrInvalidBounds
+		}
+	default:
+		return ErrInvalidKind
+	}
+	return nil
+}
+
+// hasBackreference reports whether pattern refers back to a group, as in
+// `(a+)\1`. Backreferences make PostgreSQL match by backtracking, which a
+// crafted pattern can keep busy for as long as it likes.
+func hasBackreference(pattern string) bool {
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] != '\\' {
+			continue
+		}
+		if next := pattern[i+1]; next >= '1' && next <= '9' {
+			return true
+		}
+		i++ // Skip the escaped character, so `\\1` is a backslash and a 1
+	}
+	return false
+}
+
+// wordPattern returns a case-insensitive pattern matching phrase as whole
+// words.
+func wordPattern(phrase string) string {
+	var sb strings.Builder
+	sb.WriteString(`(^|[^[:alnum:]_])`)
+	for _, r := range phrase {
+		if strings.ContainsRune(`\^$.|?*+()[]{}`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString(`($|[^[:alnum:]_])`)
+	return sb.String()
+}
+
+// postText is the text keyword filters match: a post, its reblog comment
+// and the post it reblogs.
+const postText = `concat_ws(E'\n', p.content, p.reblog_comment, (SELECT content FROM posts WHERE id = p.reblog_of_id))`
+
+// Condition returns an SQL condition, over a post aliased p, that holds
+// for the posts f matches. bind adds a query argument and returns its
+// placeholder. The condition may be NULL for posts without a score.
+func (f Filter) Condition(bind func(any) string) string {
+	switch f.Kind {
+	case KindKeyword:
+		pattern := f.Value
+		if !f.Regex {
+			pattern = wordPattern(f.Value)
+		}
+		return postText + ` ~* ` + bind(pattern)
+	case KindTag:
+		return `EXISTS(SELECT 1 FROM post_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.post_id IN (p.id, p.reblog_of_id) AND t.name = ` + bind(f.Value) + `)`
+	case KindReblogs:
+		return `p.reblog_of_id IS NOT NULL`
+	case KindReplies:
+		return `p.reply_to_id IS NOT NULL`
+	case KindSentiment, KindControversy:
+		column := "p.sentiment_score"
+		if f.Kind == KindControversy {
+			column = "p.controversy_score"
+		}
+		var bounds []string
+		if f.Min != nil {
+			bounds = append(bounds, column+` < `+bind(*f.Min)+`::float8`)
+		}
+		if f.Max != nil {
+			bounds = append(bounds, column+` > `+bind(*f.Max)+`::float8`)
+		}
+		return `(` + strings.Join(bounds, " OR ") + `)`
+	}
+	return "false"
+}
+
+// Reason describes f for the posts it flags, e.g. `keyword "crypto"`.
+func (f Filter) Reason() string {
+	switch f.Kind {
+	case KindKeyword:
+		if f.Regex {
+			return "pattern /" + f.Value + "/"
+		}
+		return fmt.Sprintf("keyword %q", f.Value)
+	case KindTag:
+		return "tag #" + f.Value
+	case KindReblogs:
+		return "reblog"
+	case KindReplies:
+		return "reply"
+	case KindSentiment, KindControversy:
+		switch {
+		case f.Min != nil && f.Max != nil:
+			return fmt.Sprintf("%s outside %s to %s", f.Kind, formatScore(*f.Min), formatScore(*f.Max))
+		case f.Min != nil:
+			return fmt.Sprintf("%s below %s", f.Kind, formatScore(*f.Min))
+		case f.Max != nil:
+			return fmt.Sprintf("%s above %s", f.Kind, formatScore(*f.Max))
+		}
+	}
+	return string(f.Kind)
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// List returns userID's filters, oldest first.
+func (r *Repository) List(ctx context.Context, userID uuid.UUID) ([]Filter, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, kind, value, regex, min_score, max_score, action, created_at
+		FROM feed_filters
+		WHERE user_id = $1
+		ORDER BY created_at ASC, id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := []Filter{}
+	for rows.Next() {
+		var f Filter
+		if err := rows.Scan(&f.ID, &f.Kind, &f.Value, &f.Regex, &f.Min, &f.Max, &f.Action, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, rows.Err()
+}
+
+// Create saves a filter for userID. Patterns are checked by compiling them
+// in the database, whose regular expression syntax is the one they run in.
+func (r *Repository) Create(ctx context.Context, userID uuid.UUID, req CreateFilterRequest) (*Filter, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if req.Regex {
+		var matched bool
+		err := r.db.QueryRow(ctx, `SELECT '' ~* $1`, req.Value).Scan(&matched)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "2201B" { // invalid_regular_expression
+				return nil, ErrInvalidPattern
+			}
+			return nil, err
+		}
+	}
+
+	var count, regexCount int
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE regex) FROM feed_filters WHERE user_id = $1
+	`, userID).Scan(&count, &regexCount)
+	if err != nil {
+		return nil, err
+	}
+	if count >= MaxFilters {
+		return nil, ErrTooManyFilters
+	}
+	if req.Regex && regexCount >= MaxRegexFilters {
+		return nil, ErrTooManyRegex
+	}
+
+	f := &Filter{
+		Kind:   req.Kind,
+		Value:  req.Value,
+		Regex:  req.Regex,
+		Min:    req.Min,
+		Max:    req.Max,
+		Action: req.Action,
+	}
+	err = r.db.QueryRow(ctx, `
+		INSERT INTO feed_filters (user_id, kind, value, regex, min_score, max_score, action)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, userID, f.Kind, f.Value, f.Regex, f.Min, f.Max, f.Action).Scan(&f.ID, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (r *Repository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		DELETE FROM feed_filters WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrFilterNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+func score(v float64) *float64 { return &v }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  CreateFilterRequest
+		want error
+	}{
+		{"keyword", CreateFilterRequest{Kind: KindKeyword, Value: "crypto"}, nil},
+		{"pattern", CreateFilterRequest{Kind: KindKeyword, Value: "^gm", Regex: true, Action: ActionWarn}, nil},
+		{"tag", CreateFilterRequest{Kind: KindTag, Value: "nsfw"}, nil},
+		{"reblogs", CreateFilterRequest{Kind: KindReblogs}, nil},
+		{"sentiment floor", CreateFilterRequest{Kind: KindSentiment, Min: score(-0.5)}, nil},
+		{"controversy range", CreateFilterRequest{Kind: KindControversy, Min: score(0), Max: score(0.8)}, nil},
+		{"unknown kind", CreateFilterRequest{Kind: "vibes"}, ErrInvalidKind},
+		{"unknown action", CreateFilterRequest{Kind: KindReplies, Action: "delete"}, ErrInvalidAction},
+		{"empty keyword", CreateFilterRequest{Kind: KindKeyword, Value: "  "}, ErrInvalidValue},
+		{"long keyword", CreateFilterRequest{Kind: KindKeyword, Value: strings.Repeat("a", MaxValueLength+1)}, ErrInvalidValue},
+		{"regex tag", CreateFilterRequest{Kind: KindTag, Value: "a", Regex: true}, ErrInvalidPattern},
+		{"backreference", CreateFilterRequest{Kind: KindKeyword, Value: `(a+)+\1`, Regex: true}, ErrInvalidPattern},
+		{"escaped backslash", CreateFilterRequest{Kind: KindKeyword, Value: `C:\\1`, Regex: true}, nil},
+		{"backslash digit without regex", CreateFilterRequest{Kind: KindKeyword, Value: `\1`}, nil},
+		{"replies with value", CreateFilterRequest{Kind: KindReplies, Value: "x"}, ErrInvalidValue},
+		{"keyword with bounds", CreateFilterRequest{Kind: KindKeyword, Value: "x", Max: score(1)}, ErrInvalidBounds},
+		{"sentiment without bounds", CreateFilterRequest{Kind: KindSentiment}, ErrInvalidBounds},
+		{"inverted bounds", CreateFilterRequest{Kind: KindSentiment, Min: score(0.5), Max: score(-0.5)}, ErrInvalidBounds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			if err := req.Validate(); err != tt.want {
+				t.Fatalf("Validate() = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && tt.req.Action == "" && req.Action != ActionHide {
+				t.Errorf("Action = %q, want default %q", req.Action, ActionHide)
+			}
+		})
+	}
+}
+
+func TestWordPattern(t *testing.T) {
+	// The pattern is run by PostgreSQL, but this subset of its syntax
+	// behaves the same in Go
+	tests := []struct {
+		phrase string
+		text   string
+		want   bool
+	}{
+		{"cat", "my cat sat", true},
+		{"cat", "Cat!", true},
+		{"cat", "category", false},
+		{"cat", "bobcat", false},
+		{"c++", "learning c++ today", true},
+		{"a.b", "axb", false},
+		{"a.b", "see a.b", true},
+		{"two words", "say two words now", true},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile("(?i)" + wordPattern(tt.phrase))
+		if got := re.MatchString(tt.text); got != tt.want {
+			t.Errorf("wordPattern(%q) matching %q = %v, want %v", tt.phrase, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCondition_BindsArguments(t *testing.T) {
+	var args []any
+	bind := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	f := Filter{Kind: KindSentiment, Min: score(-0.5), Max: score(0.5)}
+	got := f.Condition(bind)
+	if got != "(p.sentiment_score < $1::float8 OR p.sentiment_score > $2::float8)" {
+		t.Errorf("Condition() = %q", got)
+	}
+	if len(args) != 2 || args[0] != -0.5 || args[1] != 0.5 {
+		t.Errorf("args = %v", args)
+	}
+
+	if got := f.Reason(); got != "sentiment outside -0.5 to 0.5" {
+		t.Errorf("Reason() = %q", got)
+	}
+}
+
+func TestCreate_Limits(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "filterer")
+
+	for i := range MaxRegexFilters {
+		req := CreateFilterRequest{Kind: KindKeyword, Value: "^gm" + strconv.Itoa(i), Regex: true}
+		if _, err := repo.Create(ctx, userID, req); err != nil {
+			t.Fatalf("Create() pattern %d error = %v", i, err)
+		}
+	}
+	req := CreateFilterRequest{Kind: KindKeyword, Value: "^gn", Regex: true}
+	if _, err := repo.Create(ctx, userID, req); !errors.Is(err, ErrTooManyRegex) {
+		t.Errorf("Create() past the pattern limit = %v, want ErrTooManyRegex", err)
+	}
+
+	// Words still fit under the overall limit
+	if _, err := repo.Create(ctx, userID, CreateFilterRequest{Kind: KindKeyword, Value: "gn"}); err != nil {
+		t.Errorf("Create() keyword error = %v", err)
+	}
+
+	req = CreateFilterRequest{Kind: KindKeyword, Value: "(unclosed", Regex: true}
+	if _, err := repo.Create(ctx, dbtest.CreateUser(t, db, "other"), req); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Create() invalid pattern = %v, want ErrInvalidPattern", err)
+	}
+}
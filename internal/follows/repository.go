@@ -5,27 +5,91 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/notifications"
+	"github.com/watzon/moltpress/internal/stream"
 	"github.com/watzon/moltpress/internal/users"
 )
 
 type Repository struct {
-	db *pgxpool.Pool
+	db     *pgxpool.Pool
+	events stream.Publisher
 }
 
 func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// WithPublisher streams follow notifications to connected clients.
+func (r *Repository) WithPublisher(p stream.Publisher) *Repository {
+	r.events = p
+	return r
+}
+
+// Follow makes followerID follow followingID. It returns blocks.ErrBlocked
+// if either has blocked the other.
 func (r *Repository) Follow(ctx context.Context, followerID, followingID uuid.UUID) error {
 	if followerID == followingID {
 		return nil // Can't follow yourself
 	}
 
-	_, err := r.db.Exec(ctx, `
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	blocked, err := blocks.Between(ctx, tx, followerID, followingID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return blocks.ErrBlocked
+	}
+
+	result, err := tx.Exec(ctx, `
 		INSERT INTO follows (follower_id, following_id) VALUES ($1, $2)
 		ON CONFLICT DO NOTHING
 	`, followerID, followingID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	var live *stream.Event
+	if result.RowsAffected() > 0 {
+		live, err = notifications.Notify(ctx, tx, notifications.Event{
+			Type: notifications.TypeFollow, UserID: followingID, ActorID: followerID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	stream.PublishAll(ctx, r.events, live)
+	return nil
+}
+
+// FollowingIDs returns the IDs of everyone userID follows.
+func (r *Repository) FollowingIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(ctx, `SELECT following_id FROM follows WHERE follower_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 func (r *Repository) Unfollow(ctx context.Context, followerID, followingID uuid.UUID) error {
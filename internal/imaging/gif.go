@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// processAnimated encodes an animated GIF in each of Sizes, keeping every
+// frame, its delay and the loop count. Re-encoding drops comments and
+// application extensions other than looping.
+func processAnimated(g *gif.GIF) (*Image, error) {
+	out := &Image{ContentType: "image/gif", Ext: ".gif"}
+
+	var frames []*image.RGBA
+	var prev Encoded
+	for _, size := range Sizes {
+		w, h := fit(g.Config.Width, g.Config.Height, size.MaxEdge)
+		if prev.Data != nil && w == prev.Width && h == prev.Height {
+			out.Variants = append(out.Variants, Encoded{Name: size.Name, Width: w, Height: h, Data: prev.Data})
+			continue
+		}
+
+		var encoded *gif.GIF
+		if w == g.Config.Width && h == g.Config.Height {
+			encoded = &gif.GIF{
+				Image:           g.Image,
+				Delay:           g.Delay,
+				LoopCount:       g.LoopCount,
+				Disposal:        g.Disposal,
+				Config:          g.Config,
+				BackgroundIndex: g.BackgroundIndex,
+			}
+		} else {
+			if frames == nil {
+				frames = compositeFrames(g)
+			}
+			encoded = scaleAnimation(g, frames, w, h)
+		}
+
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, encoded); err != nil {
+			return nil, err
+		}
+
+		prev = Encoded{Name: size.Name, Width: w, Height: h, Data: buf.Bytes()}
+		out.Variants = append(out.Variants, prev)
+	}
+	return out, nil
+}
+
+// compositeFrames renders each frame of g as it appears on screen, applying
+// the disposal of the frames before it.
+func compositeFrames(g *gif.GIF) []*image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]*image.RGBA, len(g.Image))
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames[i] = image.NewRGBA(canvas.Bounds())
+		copy(frames[i].Pix, canvas.Pix)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// scaleAnimation builds a w by h GIF from the composited frames of g. Each
+// frame is a whole picture, mapped onto the palette of the frame it came
+// from, and is cleared before the next one is drawn.
+func scaleAnimation(g *gif.GIF, frames []*image.RGBA, w, h int) *gif.GIF {
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, len(frames)),
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+		Disposal:  make([]byte, len(frames)),
+		Config:    image.Config{Width: w, Height: h},
+	}
+
+	for i, frame := range frames {
+		scaled := scale(frame, w, h)
+		paletted := image.NewPaletted(scaled.Bounds(), withTransparent(g.Image[i].Palette))
+		draw.Draw(paletted, paletted.Bounds(), scaled, image.Point{}, draw.Src)
+		out.Image[i] = paletted
+		out.Disposal[i] = gif.DisposalBackground
+	}
+	return out
+}
+
+// withTransparent returns p with a transparent color if it has room and
+// lacks one, since a composited frame may show through to the background.
+func withTransparent(p color.Palette) color.Palette {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p
+		}
+	}
+	if len(p) >= 256 {
+		return p
+	}
+	return append(append(color.Palette{}, p...), color.Transparent)
+}
+
+// checkAnimation walks the blocks of a GIF without decoding any pixels and
+// fails with ErrTooLarge as soon as its frames, each composited onto the
+// width by height canvas, add up to more than maxAnimationPixels. Frames
+// reaching outside the canvas are invalid. gif.DecodeAll allocates every
+// frame as it goes, so this has to run before it.
+func checkAnimation(data []byte, width, height int) error {
+	// Header and logical screen descriptor
+	const headerSize = 13
+	if len(data) < headerSize {
+		return ErrInvalidImage
+	}
+	pos := headerSize + colorTableSize(data[10])
+
+	// skipSubBlocks moves past a run of data sub-blocks and its terminator
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return pos <= len(data)
+			}
+		}
+		return false
+	}
+
+	area := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: a label, then sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return ErrInvalidImage
+			}
+		case 0x2C: // Image descriptor
+			if pos+10 > len(data) {
+				return ErrInvalidImage
+			}
+			left := int(binary.LittleEndian.Uint16(data[pos+1:]))
+			top := int(binary.LittleEndian.Uint16(data[pos+3:]))
+			w := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			h := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			if left+w > width || top+h > height {
+				return ErrInvalidImage
+			}
+			area += width * height
+			if area > maxAnimationPixels {
+				return ErrTooLarge
+			}
+			// Local color table, then the LZW code size, then the pixels
+			pos += 10 + colorTableSize(data[pos+9]) + 1
+			if !skipSubBlocks() {
+				return ErrInvalidImage
+			}
+		case 0x3B: // Trailer
+			return nil
+		default:
+			return ErrInvalidImage
+		}
+	}
+	return ErrInvalidImage
+}
+
+// colorTableSize returns the size in bytes of the color table described by
+// the packed field of a screen or image descriptor.
+func colorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << ((packed & 0x07) + 1)
+}
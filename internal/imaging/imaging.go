@@ -0,0 +1,203 @@
+// Package imaging verifies uploaded images and re-encodes them into the
+// sizes we serve. Re-encoding drops EXIF and other metadata, so uploads
+// never leak camera details or locations.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image")
+	ErrTooLarge        = errors.New("image is too large")
+	ErrFileTooLarge    = errors.New("image file is too large")
+)
+
+const (
+	// MaxFileSize bounds the size of an uploaded file.
+	MaxFileSize = 10 << 20
+	// MaxDimension bounds the width and height of an upload.
+	MaxDimension = 12000
+	// MaxPixels bounds the area of an upload, so a small file cannot
+	// decode into a huge bitmap.
+	MaxPixels = 50_000_000
+	// maxAnimationPixels bounds the area of all of an animated GIF's
+	// frames together.
+	maxAnimationPixels = 200_000_000
+
+	jpegQuality = 85
+)
+
+// Size is a variant we produce, named and bounded by its longest edge.
+type Size struct {
+	Name    string
+	MaxEdge int
+}
+
+// Sizes are the variants of every image, largest first. Images are never
+// scaled up, so a small image's variants may share a size.
+var Sizes = []Size{
+	{Name: "full", MaxEdge: 2560},
+	{Name: "medium", MaxEdge: 1280},
+	{Name: "thumb", MaxEdge: 320},
+}
+
+// Image is a verified upload, re-encoded in each of Sizes.
+type Image struct {
+	ContentType string
+	Ext         string
+	Variants    []Encoded // In the order of Sizes
+}
+
+// Encoded is one variant of an image, ready to store.
+type Encoded struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Full returns the largest variant.
+func (img *Image) Full() Encoded {
+	return img.Variants[0]
+}
+
+// Process verifies that r holds a JPEG, PNG, GIF or WebP image by its
+// content rather than its claimed type, and re-encodes it in each of
+// Sizes. JPEGs are turned upright according to their EXIF orientation.
+// Animated GIFs stay animated GIFs; other GIFs become PNGs, and WebPs
+// become JPEGs or, if they have transparency, PNGs. Reading stops once r
+// has given more than MaxFileSize bytes.
+func Process(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	format := http.DetectContentType(data)
+	switch format {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if format == "image/gif" {
+		if err := checkAnimation(data, cfg.Width, cfg.Height); err != nil {
+			return nil, err
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		if len(g.Image) > 1 {
+			return processAnimated(g)
+		}
+		return processStatic(g.Image[0], "image/png")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	switch format {
+	case "image/jpeg":
+		if o := jpegOrientation(data); o > 1 {
+			// Scale down first so turning the image upright is cheap
+			w, h := fit(cfg.Width, cfg.Height, Sizes[0].MaxEdge)
+			if w != cfg.Width || h != cfg.Height {
+				img = scale(img, w, h)
+			}
+			img = orient(img, o)
+		}
+		return processStatic(img, format)
+	case "image/webp":
+		if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+			return processStatic(img, "image/jpeg")
+		}
+		return processStatic(img, "image/png")
+	default:
+		return processStatic(img, format)
+	}
+}
+
+// processStatic encodes img in each of Sizes as contentType, which is
+// image/jpeg or image/png. Each variant is scaled from the one before it.
+func processStatic(img image.Image, contentType string) (*Image, error) {
+	out := &Image{ContentType: contentType, Ext: ".png"}
+	if contentType == "image/jpeg" {
+		out.Ext = ".jpg"
+	}
+
+	src := img
+	var prev Encoded
+	for _, size := range Sizes {
+		w, h := fit(src.Bounds().Dx(), src.Bounds().Dy(), size.MaxEdge)
+		if prev.Data != nil && w == prev.Width && h == prev.Height {
+			out.Variants = append(out.Variants, Encoded{Name: size.Name, Width: w, Height: h, Data: prev.Data})
+			continue
+		}
+
+		if w != src.Bounds().Dx() || h != src.Bounds().Dy() {
+			src = scale(src, w, h)
+		}
+
+		var buf bytes.Buffer
+		var err error
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, src)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		prev = Encoded{Name: size.Name, Width: w, Height: h, Data: buf.Bytes()}
+		out.Variants = append(out.Variants, prev)
+	}
+	return out, nil
+}
+
+// fit returns the size of a w by h image scaled down, keeping its aspect
+// ratio, so neither edge exceeds maxEdge.
+func fit(w, h, maxEdge int) (int, int) {
+	if w <= maxEdge && h <= maxEdge {
+		return w, h
+	}
+	if w >= h {
+		return maxEdge, max(1, (h*maxEdge+w/2)/w)
+	}
+	return max(1, (w*maxEdge+h/2)/h), maxEdge
+}
+
+func scale(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+	return dst
+}
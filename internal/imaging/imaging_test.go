@@ -0,0 +1,257 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeJPEG encodes a w by h JPEG with an EXIF block giving orientation
+// and a GPS-looking marker string.
+func encodeJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0, 'G', 'P', 'S', 'L', 'E', 'A', 'K')
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func encodeGIF(t *testing.T, w, h, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{LoopCount: 0}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		frame.SetColorIndex(i%w, 0, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess_RejectsUnsupported(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("<html><body>not an image</body></html>"),
+		[]byte("%PDF-1.4"),
+	} {
+		if _, err := Process(bytes.NewReader(data)); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("Process(%q) error = %v, want ErrUnsupportedType", data, err)
+		}
+	}
+}
+
+func TestProcess_RejectsTruncated(t *testing.T) {
+	data := encodePNG(t, 10, 10)[:20]
+	if _, err := Process(bytes.NewReader(data)); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Process() error = %v, want ErrInvalidImage", err)
+	}
+}
+
+func TestProcess_Variants(t *testing.T) {
+	img, err := Process(bytes.NewReader(encodePNG(t, 3000, 1500)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/png" || img.Ext != ".png" {
+		t.Errorf("type = %s %s, want image/png .png", img.ContentType, img.Ext)
+	}
+
+	want := []struct {
+		name string
+		w, h int
+	}{
+		{"full", 2560, 1280},
+		{"medium", 1280, 640},
+		{"thumb", 320, 160},
+	}
+	if len(img.Variants) != len(want) {
+		t.Fatalf("got %d variants, want %d", len(img.Variants), len(want))
+	}
+	for i, v := range img.Variants {
+		if v.Name != want[i].name || v.Width != want[i].w || v.Height != want[i].h {
+			t.Errorf("variant %d = %s %dx%d, want %s %dx%d", i, v.Name, v.Width, v.Height, want[i].name, want[i].w, want[i].h)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatalf("variant %s: %v", v.Name, err)
+		}
+		if cfg.Width != v.Width || cfg.Height != v.Height {
+			t.Errorf("variant %s encodes %dx%d, want %dx%d", v.Name, cfg.Width, cfg.Height, v.Width, v.Height)
+		}
+	}
+}
+
+func TestProcess_SmallImageSharesVariants(t *testing.T) {
+	img, err := Process(bytes.NewReader(encodePNG(t, 100, 50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range img.Variants {
+		if v.Width != 100 || v.Height != 50 {
+			t.Errorf("variant %s = %dx%d, want 100x50", v.Name, v.Width, v.Height)
+		}
+		if &v.Data[0] != &img.Full().Data[0] {
+			t.Errorf("variant %s does not share the full variant's data", v.Name)
+		}
+	}
+}
+
+func TestProcess_JPEGStripsEXIFAndOrients(t *testing.T) {
+	img, err := Process(bytes.NewReader(encodeJPEG(t, 40, 20, 6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/jpeg" {
+		t.Errorf("ContentType = %s, want image/jpeg", img.ContentType)
+	}
+
+	full := img.Full()
+	if full.Width != 20 || full.Height != 40 {
+		t.Errorf("full = %dx%d, want 20x40 after rotating", full.Width, full.Height)
+	}
+	if bytes.Contains(full.Data, []byte("Exif")) || bytes.Contains(full.Data, []byte("GPSLEAK")) {
+		t.Error("full variant still contains EXIF data")
+	}
+}
+
+func TestProcess_AnimatedGIF(t *testing.T) {
+	img, err := Process(bytes.NewReader(encodeGIF(t, 800, 400, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/gif" {
+		t.Fatalf("ContentType = %s, want image/gif", img.ContentType)
+	}
+
+	for _, v := range img.Variants {
+		g, err := gif.DecodeAll(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatalf("variant %s: %v", v.Name, err)
+		}
+		if len(g.Image) != 3 {
+			t.Errorf("variant %s has %d frames, want 3", v.Name, len(g.Image))
+		}
+		if g.Config.Width != v.Width || g.Config.Height != v.Height {
+			t.Errorf("variant %s encodes %dx%d, want %dx%d", v.Name, g.Config.Width, g.Config.Height, v.Width, v.Height)
+		}
+	}
+	if thumb := img.Variants[2]; thumb.Width != 320 || thumb.Height != 160 {
+		t.Errorf("thumb = %dx%d, want 320x160", thumb.Width, thumb.Height)
+	}
+}
+
+func TestProcess_StaticGIFBecomesPNG(t *testing.T) {
+	img, err := Process(bytes.NewReader(encodeGIF(t, 10, 10, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/png" {
+		t.Errorf("ContentType = %s, want image/png", img.ContentType)
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for o := uint16(1); o <= 8; o++ {
+		if got := jpegOrientation(encodeJPEG(t, 4, 4, o)); got != int(o) {
+			t.Errorf("jpegOrientation() = %d, want %d", got, o)
+		}
+	}
+	if got := jpegOrientation([]byte{0xFF, 0xD8, 0xFF}); got != 1 {
+		t.Errorf("jpegOrientation(truncated) = %d, want 1", got)
+	}
+}
+
+func TestVariantsRoundTrip(t *testing.T) {
+	v := Variants{
+		"full":  {URL: "/uploads/a.jpg", Key: "a.jpg", Width: 100, Height: 50},
+		"thumb": {URL: "/uploads/a.jpg", Key: "a.jpg", Width: 100, Height: 50},
+	}
+	got := DecodeVariants(EncodeVariants(v))
+	if got["full"] != v["full"] || got["thumb"] != v["thumb"] {
+		t.Errorf("DecodeVariants() = %v, want %v", got, v)
+	}
+	if keys := got.Keys(); len(keys) != 1 || keys[0] != "a.jpg" {
+		t.Errorf("Keys() = %v, want [a.jpg]", keys)
+	}
+	if EncodeVariants(nil) != nil || DecodeVariants(nil) != nil {
+		t.Error("empty variants should encode and decode as nil")
+	}
+}
+
+func TestProcess_RejectsLargeFile(t *testing.T) {
+	data := append(encodePNG(t, 10, 10), make([]byte, MaxFileSize)...)
+	if _, err := Process(bytes.NewReader(data)); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("expected ErrFileTooLarge, got %v", err)
+	}
+}
+
+func TestProcess_RejectsLongAnimationBeforeDecoding(t *testing.T) {
+	// Tiny frames on a big canvas: a small file, but each frame would be
+	// composited at the canvas size
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Config: image.Config{Width: 10000, Height: 5000, ColorModel: palette}}
+	for i := 0; i < 5; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Process(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestCheckAnimation(t *testing.T) {
+	data := encodeGIF(t, 20, 10, 3)
+	if err := checkAnimation(data, 20, 10); err != nil {
+		t.Errorf("checkAnimation() = %v, want nil", err)
+	}
+	// Frames reaching outside a smaller canvas
+	if err := checkAnimation(data, 10, 10); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("oversized frame: expected ErrInvalidImage, got %v", err)
+	}
+	if err := checkAnimation(data[:len(data)-10], 20, 10); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("truncated: expected ErrInvalidImage, got %v", err)
+	}
+}
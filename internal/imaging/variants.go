@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// Variant is one stored size of an image.
+type Variant struct {
+	URL    string `json:"url"`
+	Key    string `json:"-"` // Storage key
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Variants are the stored sizes of an image by name, as in Sizes. Sizes
+// that came out the same share a Variant.
+type Variants map[string]Variant
+
+// storedVariant is a Variant as saved in the database, with its key.
+type storedVariant struct {
+	URL    string `json:"url"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Keys returns the storage keys of v without duplicates.
+func (v Variants) Keys() []string {
+	var keys []string
+	for _, variant := range v {
+		if variant.Key != "" && !slices.Contains(keys, variant.Key) {
+			keys = append(keys, variant.Key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// EncodeVariants marshals v for a JSONB column. Empty variants encode as
+// nil, which stores NULL.
+func EncodeVariants(v Variants) []byte {
+	if len(v) == 0 {
+		return nil
+	}
+	stored := make(map[string]storedVariant, len(v))
+	for name, variant := range v {
+		stored[name] = storedVariant(variant)
+	}
+	data, _ := json.Marshal(stored)
+	return data
+}
+
+// DecodeVariants unmarshals variants saved by EncodeVariants. NULL, or
+// anything unreadable, decodes as no variants.
+func DecodeVariants(data []byte) Variants {
+	if data == nil {
+		return nil
+	}
+	var stored map[string]storedVariant
+	if err := json.Unmarshal(data, &stored); err != nil || len(stored) == 0 {
+		return nil
+	}
+	v := make(Variants, len(stored))
+	for name, variant := range stored {
+		v[name] = Variant(variant)
+	}
+	return v
+}
@@ -0,0 +1,42 @@
+// Package netguard keeps requests the server makes on a user's behalf, to
+// URLs or hosts the user chose, from reaching internal services.
+package netguard
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenAddress = errors.New("destination is not a public address")
+
+// Dialer returns a dialer that refuses to connect to anything but a public
+// unicast address. The check runs on the resolved address of every
+// connection, so it also covers redirects and DNS names that point inward.
+func Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !IsPublicIP(ip) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// net.IP.IsPrivate does not cover.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() ||
+		ip.IsInterfaceLocalMulticast() || sharedAddressSpace.Contains(ip))
+}
@@ -0,0 +1,40 @@
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for input, want := range map[string]bool{
+		"203.0.113.7":     true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.10":    false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"::1":             false,
+		"fd00::1":         false,
+	} {
+		if got := IsPublicIP(net.ParseIP(input)); got != want {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestDialerRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not have reached a loopback server")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: Dialer(time.Second).DialContext}}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress, got %v", err)
+	}
+}
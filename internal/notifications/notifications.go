@@ -0,0 +1,258 @@
+// Package notifications records activity on a user's posts and account so
+// they do not have to poll their own content to find it.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/stream"
+	"github.com/watzon/moltpress/internal/users"
+	"github.com/watzon/moltpress/internal/webhooks"
+)
+
+type Type string
+
+const (
+	TypeLike    Type = "like"
+	TypeReblog  Type = "reblog"
+	TypeReply   Type = "reply"
+	TypeFollow  Type = "follow"
+	TypeMention Type = "mention"
+)
+
+var AllTypes = []Type{TypeLike, TypeReblog, TypeReply, TypeFollow, TypeMention}
+
+func IsValidType(t Type) bool {
+	for _, valid := range AllTypes {
+		if t == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type Notification struct {
+	ID           uuid.UUID        `json:"id"`
+	Type         Type             `json:"type"`
+	Actor        users.UserPublic `json:"actor"`
+	PostID       *uuid.UUID       `json:"post_id,omitempty"`        // The recipient's post that was acted on
+	SourcePostID *uuid.UUID       `json:"source_post_id,omitempty"` // The reply, reblog or mentioning post
+	ReadAt       *time.Time       `json:"read_at,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+// Event describes something that happened to UserID because of ActorID.
+type Event struct {
+	Type         Type
+	UserID       uuid.UUID
+	ActorID      uuid.UUID
+	PostID       *uuid.UUID
+	SourcePostID *uuid.UUID
+}
+
+// Notify records e and queues it for the recipient's webhooks. Pass the
+// transaction that made the change so both are only written if it commits.
+// Actions on your own content are ignored, and likes and follows are only
+// recorded once per actor so unlike/like toggling does not spam the
+// recipient. Nothing is recorded if either user has blocked the other or
+// the recipient has muted the actor.
+//
+// It returns the live event to publish once the transaction has committed,
+// or nil if nothing was recorded.
+func Notify(ctx context.Context, q webhooks.Querier, e Event) (*stream.Event, error) {
+	if e.UserID == e.ActorID {
+		return nil, nil
+	}
+
+	var id uuid.UUID
+	var createdAt time.Time
+	err := q.QueryRow(ctx, `
+		INSERT INTO notifications (user_id, actor_id, type, post_id, source_post_id)
+		SELECT $1::uuid, $2::uuid, $3::varchar, $4::uuid, $5::uuid
+		WHERE NOT `+blocks.Blocked("$1::uuid", "$2::uuid")+`
+		  AND NOT `+blocks.Muted("$1::uuid", "$2::uuid")+`
+		ON CONFLICT (user_id, actor_id, type, post_id) WHERE type IN ('like', 'follow') DO NOTHING
+		RETURNING id, created_at
+	`, e.UserID, e.ActorID, e.Type, e.PostID, e.SourcePostID).Scan(&id, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Already notified, or hidden from the recipient
+		}
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"notification_id": id,
+		"type":            e.Type,
+		"actor_id":        e.ActorID,
+		"post_id":         e.PostID,
+		"source_post_id":  e.SourcePostID,
+		"created_at":      createdAt,
+	}
+
+	if err := webhooks.Enqueue(ctx, q, e.UserID, webhooks.Event(e.Type), data); err != nil {
+		return nil, err
+	}
+
+	live, err := stream.NewEvent(stream.EventNotification, e.ActorID, data)
+	if err != nil {
+		return nil, err
+	}
+	live.RecipientID = &e.UserID
+	return &live, nil
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+type ListOptions struct {
+	Limit      int
+	Cursor     *pagination.Cursor
+	Types      []Type
+	UnreadOnly bool
+}
+
+type Page struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	HasMore       bool           `json:"has_more"`
+}
+
+// List returns userID's notifications, newest first. Notifications from
+// users they have since blocked, been blocked by or muted are left out.
+func (r *Repository) List(ctx context.Context, userID uuid.UUID, opts ListOptions) (*Page, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+
+	var cursorTime *time.Time
+	var cursorID *uuid.UUID
+	if opts.Cursor != nil {
+		cursorTime = &opts.Cursor.CreatedAt
+		cursorID = &opts.Cursor.ID
+	}
+
+	var types []string
+	for _, t := range opts.Types {
+		types = append(types, string(t))
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT n.id, n.type, n.post_id, n.source_post_id, n.read_at, n.created_at,
+		       u.id, u.username, u.display_name, u.avatar_url, u.is_agent, u.verified_at, u.created_at
+		FROM notifications n
+		JOIN users u ON n.actor_id = u.id
+		WHERE n.user_id = $1
+		  AND NOT `+blocks.Blocked("n.user_id", "n.actor_id")+`
+		  AND NOT `+blocks.Muted("n.user_id", "n.actor_id")+`
+		  AND ($2::timestamptz IS NULL OR (n.created_at, n.id) < ($2, $3))
+		  AND ($4::text[] IS NULL OR n.type = ANY($4))
+		  AND (NOT $5 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $6
+	`, userID, cursorTime, cursorID, types, opts.UnreadOnly, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page{Notifications: []Notification{}}
+	for rows.Next() {
+		var n Notification
+		var verifiedAt *time.Time
+		if err := rows.Scan(
+			&n.ID, &n.Type, &n.PostID, &n.SourcePostID, &n.ReadAt, &n.CreatedAt,
+			&n.Actor.ID, &n.Actor.Username, &n.Actor.DisplayName, &n.Actor.AvatarURL,
+			&n.Actor.IsAgent, &verifiedAt, &n.Actor.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		n.Actor.IsVerified = verifiedAt != nil
+		page.Notifications = append(page.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Notifications) > opts.Limit {
+		page.Notifications = page.Notifications[:opts.Limit]
+		page.HasMore = true
+	}
+	if n := len(page.Notifications); n > 0 {
+		last := page.Notifications[n-1]
+		page.NextCursor = pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
+}
+
+// UnreadCounts returns the number of unread notifications by type.
+func (r *Repository) UnreadCounts(ctx context.Context, userID uuid.UUID) (map[Type]int, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT n.type, COUNT(*) FROM notifications n
+		WHERE n.user_id = $1 AND n.read_at IS NULL
+		  AND NOT `+blocks.Blocked("n.user_id", "n.actor_id")+`
+		  AND NOT `+blocks.Muted("n.user_id", "n.actor_id")+`
+		GROUP BY n.type
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[Type]int, len(AllTypes))
+	for _, t := range AllTypes {
+		counts[t] = 0
+	}
+	for rows.Next() {
+		var t Type
+		var count int
+		if err := rows.Scan(&t, &count); err != nil {
+			return nil, err
+		}
+		counts[t] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// MarkRead marks the given notifications read and returns how many changed.
+func (r *Repository) MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	result, err := r.db.Exec(ctx, `
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND id = ANY($2) AND read_at IS NULL
+	`, userID, ids)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// MarkAllRead marks every notification created before the given time read,
+// or all of them when before is nil.
+func (r *Repository) MarkAllRead(ctx context.Context, userID uuid.UUID, before *time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, `
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL
+		  AND ($2::timestamptz IS NULL OR created_at <= $2)
+	`, userID, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
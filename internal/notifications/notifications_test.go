@@ -0,0 +1,181 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/pagination"
+)
+
+func TestNotify(t *testing.T) {
+	db := dbtest.New(t)
+	ctx := context.Background()
+
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+	carol := dbtest.CreateUser(t, db, "carol")
+	dave := dbtest.CreateUser(t, db, "dave")
+
+	if err := blocks.NewRepository(db).Block(ctx, alice, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocks.NewRepository(db).Mute(ctx, alice, carol); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		event    Event
+		recorded bool
+	}{
+		{"from someone blocked", Event{Type: TypeFollow, UserID: alice, ActorID: bob}, false},
+		{"to someone who blocked the actor", Event{Type: TypeFollow, UserID: bob, ActorID: alice}, false},
+		{"from someone muted", Event{Type: TypeFollow, UserID: alice, ActorID: carol}, false},
+		{"to someone who muted the actor", Event{Type: TypeFollow, UserID: carol, ActorID: alice}, true},
+		{"from yourself", Event{Type: TypeFollow, UserID: alice, ActorID: alice}, false},
+		{"from anyone else", Event{Type: TypeFollow, UserID: alice, ActorID: dave}, true},
+		{"the same follow again", Event{Type: TypeFollow, UserID: alice, ActorID: dave}, false},
+	}
+	for _, tt := range tests {
+		live, err := Notify(ctx, db, tt.event)
+		if err != nil {
+			t.Fatalf("%s: Notify() error = %v", tt.name, err)
+		}
+		if (live != nil) != tt.recorded {
+			t.Errorf("%s: recorded = %v, want %v", tt.name, live != nil, tt.recorded)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM notifications`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d notifications recorded, want 2", count)
+	}
+}
+
+func TestList(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+	carol := dbtest.CreateUser(t, db, "carol")
+	dave := dbtest.CreateUser(t, db, "dave")
+
+	var post, reply uuid.UUID
+	if err := db.QueryRow(ctx, `INSERT INTO posts (user_id, content) VALUES ($1, 'hello') RETURNING id`, alice).Scan(&post); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(ctx, `INSERT INTO posts (user_id, content, reply_to_id) VALUES ($1, 'hi', $2) RETURNING id`, bob, post).Scan(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []Event{
+		{Type: TypeFollow, UserID: alice, ActorID: bob},
+		{Type: TypeFollow, UserID: alice, ActorID: carol},
+		{Type: TypeFollow, UserID: alice, ActorID: dave},
+		{Type: TypeLike, UserID: alice, ActorID: carol, PostID: &post},
+		{Type: TypeReply, UserID: alice, ActorID: bob, PostID: &post, SourcePostID: &reply},
+	} {
+		if _, err := Notify(ctx, db, e); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+
+	// list pages through every notification matching opts
+	list := func(opts ListOptions) []Notification {
+		t.Helper()
+		var all []Notification
+		for {
+			page, err := repo.List(ctx, alice, opts)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(page.Notifications) > opts.Limit {
+				t.Fatalf("List() returned %d notifications, over the limit of %d", len(page.Notifications), opts.Limit)
+			}
+			all = append(all, page.Notifications...)
+			if !page.HasMore {
+				return all
+			}
+			opts.Cursor, err = pagination.DecodeCursor(page.NextCursor)
+			if err != nil {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+		}
+	}
+
+	all := list(ListOptions{Limit: 2})
+	seen := make(map[uuid.UUID]bool)
+	for i, n := range all {
+		if seen[n.ID] {
+			t.Errorf("notification %s listed twice", n.ID)
+		}
+		seen[n.ID] = true
+		if i > 0 && n.CreatedAt.After(all[i-1].CreatedAt) {
+			t.Errorf("notifications are not newest first")
+		}
+	}
+	if len(all) != 5 {
+		t.Fatalf("List() paged through %d notifications, want 5", len(all))
+	}
+
+	replies := list(ListOptions{Limit: 20, Types: []Type{TypeReply}})
+	if len(replies) != 1 || replies[0].Actor.ID != bob || *replies[0].SourcePostID != reply || *replies[0].PostID != post {
+		t.Errorf("List() of replies = %+v, want bob's reply to the post", replies)
+	}
+	if got := list(ListOptions{Limit: 20, Types: []Type{TypeLike, TypeReply}}); len(got) != 2 {
+		t.Errorf("List() of likes and replies returned %d, want 2", len(got))
+	}
+
+	counts, err := repo.UnreadCounts(ctx, alice)
+	if err != nil {
+		t.Fatalf("UnreadCounts() error = %v", err)
+	}
+	want := map[Type]int{TypeFollow: 3, TypeLike: 1, TypeReply: 1, TypeReblog: 0, TypeMention: 0}
+	for typ, count := range want {
+		if counts[typ] != count {
+			t.Errorf("UnreadCounts()[%s] = %d, want %d", typ, counts[typ], count)
+		}
+	}
+
+	ids := []uuid.UUID{all[0].ID, all[1].ID}
+	if marked, err := repo.MarkRead(ctx, bob, ids); err != nil || marked != 0 {
+		t.Errorf("MarkRead() of someone else's notifications = %d, %v; want 0", marked, err)
+	}
+	if marked, err := repo.MarkRead(ctx, alice, ids); err != nil || marked != 2 {
+		t.Errorf("MarkRead() = %d, %v; want 2", marked, err)
+	}
+	if marked, err := repo.MarkRead(ctx, alice, ids); err != nil || marked != 0 {
+		t.Errorf("second MarkRead() = %d, %v; want 0", marked, err)
+	}
+	if unread := list(ListOptions{Limit: 20, UnreadOnly: true}); len(unread) != 3 {
+		t.Errorf("List() of unread notifications returned %d, want 3", len(unread))
+	}
+
+	// Muting hides what the actor did before the mute too
+	if err := blocks.NewRepository(db).Mute(ctx, alice, dave); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range list(ListOptions{Limit: 20}) {
+		if n.Actor.ID == dave {
+			t.Errorf("List() includes %s from a muted user", n.Type)
+		}
+	}
+	if counts, err := repo.UnreadCounts(ctx, alice); err != nil || counts[TypeFollow] > 2 {
+		t.Errorf("UnreadCounts() after muting = %v, %v; want dave's follow left out", counts, err)
+	}
+
+	if _, err := repo.MarkAllRead(ctx, alice, nil); err != nil {
+		t.Fatalf("MarkAllRead() error = %v", err)
+	}
+	if unread := list(ListOptions{Limit: 20, UnreadOnly: true}); len(unread) != 0 {
+		t.Errorf("List() of unread notifications after MarkAllRead() returned %d, want none", len(unread))
+	}
+}
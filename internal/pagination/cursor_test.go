@@ -0,0 +1,40 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	score := 1.75
+	original := Cursor{
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC),
+		ID:        uuid.New(),
+		Score:     &score,
+	}
+
+	decoded, err := DecodeCursor(original.Encode())
+	if err != nil {
+		t.Fatalf("failed to decode cursor: %v", err)
+	}
+
+	if !decoded.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("expected created_at %v, got %v", original.CreatedAt, decoded.CreatedAt)
+	}
+	if decoded.ID != original.ID {
+		t.Errorf("expected id %v, got %v", original.ID, decoded.ID)
+	}
+	if decoded.Score == nil || *decoded.Score != score {
+		t.Errorf("expected score %v, got %v", score, decoded.Score)
+	}
+}
+
+func TestDecodeCursor_Invalid(t *testing.T) {
+	for _, input := range []string{"", "not-base64!", "e30", Cursor{ID: uuid.New()}.Encode()} {
+		if _, err := DecodeCursor(input); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q): expected ErrInvalidCursor, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/follows"
+	"github.com/watzon/moltpress/internal/ratelimit"
+)
+
+// createPost publishes content as userID, or reblogs reblogOf when set.
+func createPost(t *testing.T, repo *Repository, userID uuid.UUID, content string, reblogOf *uuid.UUID) *Post {
+	t.Helper()
+	post, err := repo.Create(context.Background(), userID, CreatePostRequest{Content: &content, ReblogOfID: reblogOf})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return post
+}
+
+// timelineIDs returns which of the named posts are in timeline.
+func timelineIDs(timeline *Timeline, named map[string]*Post) map[string]bool {
+	in := make(map[uuid.UUID]bool)
+	for _, p := range timeline.Posts {
+		in[p.ID] = true
+	}
+	got := make(map[string]bool)
+	for name, p := range named {
+		got[name] = in[p.ID]
+	}
+	return got
+}
+
+func block(t *testing.T, db *pgxpool.Pool, blockerID, blockedID uuid.UUID) {
+	t.Helper()
+	if err := blocks.NewRepository(db).Block(context.Background(), blockerID, blockedID); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+}
+
+func TestTimeline_BlockedAndMuted(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	viewer := dbtest.CreateUser(t, db, "viewer")
+	blocked := dbtest.CreateUser(t, db, "blocked")
+	blocker := dbtest.CreateUser(t, db, "blocker")
+	muted := dbtest.CreateUser(t, db, "muted")
+	friend := dbtest.CreateUser(t, db, "friend")
+	dbtest.Exec(t, db, `INSERT INTO follows (follower_id, following_id) VALUES ($1, $2), ($1, $3)`, viewer, muted, friend)
+
+	named := map[string]*Post{
+		"blocked": createPost(t, repo, blocked, "by the blocked user", nil),
+		"blocker": createPost(t, repo, blocker, "by the blocker", nil),
+		"muted":   createPost(t, repo, muted, "by the muted user", nil),
+		"friend":  createPost(t, repo, friend, "by a friend", nil),
+		"viewer":  createPost(t, repo, viewer, "by the viewer", nil),
+	}
+	named["reblog of blocked"] = createPost(t, repo, friend, "", &named["blocked"].ID)
+	named["reblog of muted"] = createPost(t, repo, friend, "", &named["muted"].ID)
+
+	block(t, db, viewer, blocked)
+	block(t, db, blocker, viewer)
+	if err := blocks.NewRepository(db).Mute(ctx, viewer, muted); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := repo.GetPublicFeed(ctx, FeedOptions{ViewerID: &viewer, Limit: 50})
+	if err != nil {
+		t.Fatalf("GetPublicFeed() error = %v", err)
+	}
+	want := map[string]bool{
+		"blocked": false, "blocker": false, "muted": true, "friend": true, "viewer": true,
+		"reblog of blocked": false, "reblog of muted": true,
+	}
+	for name, in := range timelineIDs(public, named) {
+		if in != want[name] {
+			t.Errorf("public feed: post %s shown = %v, want %v", name, in, want[name])
+		}
+	}
+
+	home, err := repo.GetHomeFeed(ctx, viewer, FeedOptions{Limit: 50})
+	if err != nil {
+		t.Fatalf("GetHomeFeed() error = %v", err)
+	}
+	want = map[string]bool{
+		"blocked": false, "blocker": false, "muted": false, "friend": true, "viewer": true,
+		"reblog of blocked": false, "reblog of muted": false,
+	}
+	for name, in := range timelineIDs(home, named) {
+		if in != want[name] {
+			t.Errorf("home feed: post %s shown = %v, want %v", name, in, want[name])
+		}
+	}
+
+	// Blocks only hide posts from the two users involved
+	anonymous, err := repo.GetPublicFeed(ctx, FeedOptions{Limit: 50})
+	if err != nil {
+		t.Fatalf("GetPublicFeed() error = %v", err)
+	}
+	for name, in := range timelineIDs(anonymous, named) {
+		if !in {
+			t.Errorf("anonymous public feed: post %s is hidden", name)
+		}
+	}
+
+	if _, err := repo.GetByID(ctx, named["blocked"].ID, &viewer); !errors.Is(err, ErrPostNotFound) {
+		t.Errorf("GetByID() of a blocked user's post = %v, want ErrPostNotFound", err)
+	}
+}
+
+func TestTimeline_BlockedTagAndUserFeeds(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	viewer := dbtest.CreateUser(t, db, "viewer")
+	blocked := dbtest.CreateUser(t, db, "blocked")
+	blocker := dbtest.CreateUser(t, db, "blocker")
+	friend := dbtest.CreateUser(t, db, "friend")
+
+	named := map[string]*Post{
+		"blocked": createPost(t, repo, blocked, "#news by the blocked user", nil),
+		"blocker": createPost(t, repo, blocker, "#news by the blocker", nil),
+		"friend":  createPost(t, repo, friend, "#news by a friend", nil),
+	}
+	block(t, db, viewer, blocked)
+	block(t, db, blocker, viewer)
+
+	for name, viewerID := range map[string]*uuid.UUID{"viewer": &viewer, "anonymous": nil} {
+		tagged, err := repo.GetTagFeed(ctx, "news", FeedOptions{ViewerID: viewerID, Limit: 50})
+		if err != nil {
+			t.Fatalf("GetTagFeed() error = %v", err)
+		}
+		for post, in := range timelineIDs(tagged, named) {
+			if want := viewerID == nil || post == "friend"; in != want {
+				t.Errorf("%s tag feed: post %s shown = %v, want %v", name, post, in, want)
+			}
+		}
+	}
+
+	for name, authorID := range map[string]uuid.UUID{"blocked": blocked, "blocker": blocker, "friend": friend} {
+		posts, err := repo.GetUserPosts(ctx, authorID, FeedOptions{ViewerID: &viewer, Limit: 50})
+		if err != nil {
+			t.Fatalf("GetUserPosts() error = %v", err)
+		}
+		if want := name == "friend"; timelineIDs(posts, named)[name] != want || (!want && len(posts.Posts) != 0) {
+			t.Errorf("%s's posts as seen by the viewer: %d posts, want them shown = %v", name, len(posts.Posts), want)
+		}
+
+		anonymous, err := repo.GetUserPosts(ctx, authorID, FeedOptions{Limit: 50})
+		if err != nil {
+			t.Fatalf("GetUserPosts() error = %v", err)
+		}
+		if !timelineIDs(anonymous, named)[name] {
+			t.Errorf("%s's posts are hidden from anonymous viewers", name)
+		}
+	}
+}
+
+func TestMentions_BlockedAndMuted(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	viewer := dbtest.CreateUser(t, db, "viewer")
+	blocked := dbtest.CreateUser(t, db, "blocked")
+	muted := dbtest.CreateUser(t, db, "muted")
+	friend := dbtest.CreateUser(t, db, "friend")
+
+	named := map[string]*Post{
+		"blocked": createPost(t, repo, blocked, "hello @viewer", nil),
+		"muted":   createPost(t, repo, muted, "hello @viewer", nil),
+		"friend":  createPost(t, repo, friend, "hello @viewer", nil),
+	}
+	block(t, db, viewer, blocked)
+	if err := blocks.NewRepository(db).Mute(ctx, viewer, muted); err != nil {
+		t.Fatal(err)
+	}
+
+	mentions, err := repo.GetMentions(ctx, viewer, FeedOptions{Limit: 50})
+	if err != nil {
+		t.Fatalf("GetMentions() error = %v", err)
+	}
+	for name, in := range timelineIDs(mentions, named) {
+		if want := name == "friend"; in != want {
+			t.Errorf("mentions: post %s shown = %v, want %v", name, in, want)
+		}
+	}
+}
+
+func TestBlocked_Interactions(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+	alicePost := createPost(t, repo, alice, "by alice", nil)
+	bobPost := createPost(t, repo, bob, "by bob", nil)
+	dbtest.Exec(t, db, `INSERT INTO follows (follower_id, following_id) VALUES ($1, $2), ($2, $1)`, alice, bob)
+
+	block(t, db, alice, bob)
+
+	var following int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM follows`).Scan(&following); err != nil {
+		t.Fatal(err)
+	}
+	if following != 0 {
+		t.Errorf("%d follows left after blocking, want 0", following)
+	}
+
+	reply := "a reply"
+	comment := "a reblog"
+	tests := []struct {
+		name string
+		act  func() error
+	}{
+		{"blocker likes", func() error { return repo.Like(ctx, alice, bobPost.ID) }},
+		{"blocked likes", func() error { return repo.Like(ctx, bob, alicePost.ID) }},
+		{"blocked replies", func() error {
+			_, err := repo.Create(ctx, bob, CreatePostRequest{Content: &reply, ReplyToID: &alicePost.ID})
+			return err
+		}},
+		{"blocked reblogs", func() error {
+			_, err := repo.Create(ctx, bob, CreatePostRequest{ReblogOfID: &alicePost.ID, ReblogComment: &comment})
+			return err
+		}},
+		{"blocker follows", func() error { return follows.NewRepository(db).Follow(ctx, alice, bob) }},
+		{"blocked follows", func() error { return follows.NewRepository(db).Follow(ctx, bob, alice) }},
+	}
+	for _, tt := range tests {
+		if err := tt.act(); !errors.Is(err, blocks.ErrBlocked) {
+			t.Errorf("%s: error = %v, want ErrBlocked", tt.name, err)
+		}
+	}
+
+	// Unblocking lets them interact again
+	if err := blocks.NewRepository(db).Unblock(ctx, alice, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Like(ctx, bob, alicePost.ID); err != nil {
+		t.Errorf("Like() after unblocking error = %v", err)
+	}
+	if err := follows.NewRepository(db).Follow(ctx, bob, alice); err != nil {
+		t.Errorf("Follow() after unblocking error = %v", err)
+	}
+}
+
+func TestScheduler_ReturnsBlockedToDrafts(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+	alicePost := createPost(t, repo, alice, "by alice", nil)
+
+	reply := "a scheduled reply"
+	publishAt := time.Now().Add(time.Hour)
+	scheduled, err := repo.Create(ctx, bob, CreatePostRequest{Content: &reply, ReplyToID: &alicePost.ID, PublishAt: &publishAt})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	dbtest.Exec(t, db, `UPDATE posts SET publish_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, scheduled.ID)
+
+	block(t, db, alice, bob)
+
+	// No limits, so the limiter never needs Redis
+	s := NewScheduler(repo, ratelimit.NewLimiter(nil).WithLimits(map[ratelimit.Action]ratelimit.Limit{}))
+	limited := []uuid.UUID{}
+	if _, err := s.publishScheduled(ctx, &limited); err != nil {
+		t.Fatalf("publishScheduled() error = %v", err)
+	}
+
+	var state string
+	var due *time.Time
+	if err := db.QueryRow(ctx, `SELECT state, publish_at FROM posts WHERE id = $1`, scheduled.ID).Scan(&state, &due); err != nil {
+		t.Fatal(err)
+	}
+	if state != StateDraft || due != nil {
+		t.Errorf("blocked reply is %s with publish_at %v, want a draft without one", state, due)
+	}
+
+	var replies int
+	if err := db.QueryRow(ctx, `SELECT reply_count FROM posts WHERE id = $1`, alicePost.ID).Scan(&replies); err != nil {
+		t.Fatal(err)
+	}
+	if replies != 0 {
+		t.Errorf("reply_count = %d, want 0", replies)
+	}
+}
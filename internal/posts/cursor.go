@@ -0,0 +1,13 @@
+package posts
+
+import "github.com/watzon/moltpress/internal/pagination"
+
+// Cursor marks a position in a timeline; see pagination.Cursor.
+type Cursor = pagination.Cursor
+
+var ErrInvalidCursor = pagination.ErrInvalidCursor
+
+// DecodeCursor parses a cursor previously produced by Cursor.Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	return pagination.DecodeCursor(s)
+}
@@ -0,0 +1,62 @@
+package posts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+func TestTimelineQuery_ScoredCursorRequiresScore(t *testing.T) {
+	for _, order := range []feedOrder{orderControversial, orderRank} {
+		q := newTimelineQuery(nil, order)
+		q.rank = "0::float8"
+		_, _, err := q.build(FeedOptions{Limit: 20, Cursor: &Cursor{CreatedAt: time.Now(), ID: uuid.New()}})
+		if err != ErrInvalidCursor {
+			t.Errorf("order %d: expected ErrInvalidCursor, got %v", order, err)
+		}
+	}
+}
+
+func TestTimelineQuery_ScheduleOrderRejectsCursor(t *testing.T) {
+	q := pendingQuery(uuid.New(), StateQueued, orderSchedule)
+	_, _, err := q.build(FeedOptions{Limit: 20, Cursor: &Cursor{CreatedAt: time.Now(), ID: uuid.New()}})
+	if err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestTimeline_CursorPaging(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+
+	for _, content := range []string{"one", "two", "three"} {
+		createPost(t, repo, alice, content, nil)
+	}
+
+	first, err := repo.GetUserPosts(ctx, alice, FeedOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetUserPosts() error = %v", err)
+	}
+	if len(first.Posts) != 2 || !first.HasMore || first.NextCursor == "" {
+		t.Fatalf("first page: %d posts, has_more = %v, next_cursor = %q; want 2 posts and a cursor",
+			len(first.Posts), first.HasMore, first.NextCursor)
+	}
+
+	cursor, err := DecodeCursor(first.NextCursor)
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	last, err := repo.GetUserPosts(ctx, alice, FeedOptions{Limit: 2, Cursor: cursor})
+	if err != nil {
+		t.Fatalf("GetUserPosts() error = %v", err)
+	}
+	if len(last.Posts) != 1 || last.HasMore || last.NextCursor != "" {
+		t.Errorf("last page: %d posts, has_more = %v, next_cursor = %q; want 1 post and no cursor",
+			len(last.Posts), last.HasMore, last.NextCursor)
+	}
+}
@@ -0,0 +1,337 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/watzon/moltpress/internal/stream"
+)
+
+// ErrEmptyPost is returned when an edit would leave a post with nothing in
+// it.
+var ErrEmptyPost = errors.New("post must have content, image, or be a reblog")
+
+// UpdatePostRequest changes the fields that are set and leaves the rest.
+// An empty string clears content, reblog_comment or image_alt. Media
+// reorders the post's attachments and changes their alt text, and
+// image_alt describes the first one. State and PublishAt move an
+// unpublished post between draft, scheduled and queued.
+type UpdatePostRequest struct {
+	Content       *string      `json:"content,omitempty"`
+	ReblogComment *string      `json:"reblog_comment,omitempty"`
+	ImageAlt      *string      `json:"image_alt,omitempty"`
+	Media         *[]MediaEdit `json:"media,omitempty"`
+	Tags          *[]string    `json:"tags,omitempty"`
+	State         *string      `json:"state,omitempty"`
+	PublishAt     *time.Time   `json:"publish_at,omitempty"`
+}
+
+// Revision is a post as it was before an edit.
+type Revision struct {
+	ID            uuid.UUID `json:"id"`
+	PostID        uuid.UUID `json:"post_id"`
+	Content       *string   `json:"content,omitempty"`
+	ReblogComment *string   `json:"reblog_comment,omitempty"`
+	ImageAlt      *string   `json:"image_alt,omitempty"`
+	Tags          []string  `json:"tags"`
+	CreatedAt     time.Time `json:"created_at"` // When it was replaced
+}
+
+// emptyToNil treats an empty string in an update as clearing the field.
+func emptyToNil(s *string) *string {
+	if s != nil && *s == "" {
+		return nil
+	}
+	return s
+}
+
+// sameString reports whether a and b are both nil or hold the same text.
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Update edits one of userID's posts. Edits to a published post keep the
+// previous version as a revision, unless they leave it as it was. Sentiment is re-scored and tag counts
+// follow the new tags.
+//
+// Tags are replaced when req.Tags is set. Otherwise the post keeps its tags
+// and gains any new inline hashtags; removing a hashtag from the text does
+// not untag the post.
+func (r *Repository) Update(ctx context.Context, id, userID uuid.UUID, req UpdatePostRequest) (*Post, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := lockPost(ctx, tx, "WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	published := current.State == StatePublished
+
+	state, publishAt := current.State, current.PublishAt
+	if req.State != nil || req.PublishAt != nil {
+		if published {
+			return nil, ErrAlreadyPublished
+		}
+		if req.State != nil {
+			state = *req.State
+		}
+		// Rescheduling without a new time keeps the old one
+		publishAt = req.PublishAt
+		if publishAt == nil && state == StateScheduled && current.State == StateScheduled {
+			publishAt = current.PublishAt
+		}
+		state, err = ResolveState(state, publishAt, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if state == StatePublished {
+			return nil, ErrInvalidState
+		}
+	}
+
+	currentTags, err := postTags(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *current
+	if req.Content != nil {
+		updated.Content = emptyToNil(req.Content)
+	}
+	if req.ReblogComment != nil {
+		updated.ReblogComment = emptyToNil(req.ReblogComment)
+	}
+	mediaChanged := false
+	if req.Media != nil {
+		media, changed, err := editMedia(ctx, tx, id, *req.Media)
+		if err != nil {
+			return nil, err
+		}
+		mediaChanged = changed
+		if len(media) > 0 {
+			updated.ImageURL, updated.ImageKey, updated.ImageAlt = &media[0].URL, media[0].Key, media[0].AltText
+		}
+	}
+	if req.ImageAlt != nil {
+		updated.ImageAlt = emptyToNil(req.ImageAlt)
+		_, err := tx.Exec(ctx, `
+			UPDATE post_media SET alt_text = $2 WHERE post_id = $1 AND position = 0
+		`, id, updated.ImageAlt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if updated.Content == nil && updated.ImageURL == nil && updated.ReblogOfID == nil {
+		return nil, ErrEmptyPost
+	}
+
+	var tags []string
+	if req.Tags != nil {
+		tags, err = ResolveTags(*req.Tags, updated.Content, updated.ReblogComment)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Existing tags are kept as they are, even ones that predate tag
+		// validation
+		inline, _ := ResolveTags(nil, updated.Content, updated.ReblogComment)
+		added, _ := diffTags(currentTags, inline)
+		tags = currentTags
+		for _, tag := range added {
+			if len(tags) >= MaxTagsPerPost {
+				break
+			}
+			tags = append(tags, tag)
+		}
+	}
+
+	sentimentScore, sentimentLabel := AnalyzeSentiment(updated.Content, updated.ReblogComment)
+	controversyScore := ComputeControversyScore(current.LikeCount, current.ReplyCount, sentimentScore)
+
+	added, removed := diffTags(currentTags, tags)
+
+	if !published {
+		_, err = tx.Exec(ctx, `
+			UPDATE posts SET
+				content = $2, reblog_comment = $3, image_url = $4, image_key = $5, image_alt = $6,
+				sentiment_score = $7, sentiment_label = $8, controversy_score = $9,
+				state = $10, publish_at = $11, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, id, updated.Content, updated.ReblogComment, updated.ImageURL, updated.ImageKey, updated.ImageAlt,
+			sentimentScore, sentimentLabel, controversyScore, state, publishAt)
+		if err != nil {
+			return nil, err
+		}
+
+		// Counts and mentions wait until the post is published
+		if err := unlinkTags(ctx, tx, id, removed, nil); err != nil {
+			return nil, err
+		}
+		if err := linkTags(ctx, tx, id, added); err != nil {
+			return nil, err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+		return r.GetByID(ctx, id, &userID)
+	}
+
+	// An edit that changes nothing is not recorded as one
+	if !mediaChanged && len(added) == 0 && len(removed) == 0 && sameString(updated.Content, current.Content) &&
+		sameString(updated.ReblogComment, current.ReblogComment) && sameString(updated.ImageAlt, current.ImageAlt) {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+		return r.GetByID(ctx, id, &userID)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO post_revisions (post_id, content, reblog_comment, image_alt, tags)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, current.Content, current.ReblogComment, current.ImageAlt, currentTags)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE posts SET
+			content = $2, reblog_comment = $3, image_url = $4, image_key = $5, image_alt = $6,
+			sentiment_score = $7, sentiment_label = $8, controversy_score = $9,
+			edited_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, updated.Content, updated.ReblogComment, updated.ImageURL, updated.ImageKey, updated.ImageAlt,
+		sentimentScore, sentimentLabel, controversyScore)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unlinkTags(ctx, tx, id, removed, &current.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := linkTags(ctx, tx, id, added); err != nil {
+		return nil, err
+	}
+	if err := countTags(ctx, tx, added); err != nil {
+		return nil, err
+	}
+
+	// Mention offsets refer to the old text, so re-link them. Users who
+	// were already mentioned are not notified again.
+	previous, err := mentionedUsers(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM post_mentions WHERE post_id = $1`, id); err != nil {
+		return nil, err
+	}
+	live, err := insertMentions(ctx, tx, &updated, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	stream.PublishAll(ctx, r.events, live...)
+
+	return r.GetByID(ctx, id, &userID)
+}
+
+// ListRevisions returns the earlier versions of a post, newest first.
+func (r *Repository) ListRevisions(ctx context.Context, postID uuid.UUID) ([]Revision, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, post_id, content, reblog_comment, image_alt, tags, created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.ID, &rev.PostID, &rev.Content, &rev.ReblogComment, &rev.ImageAlt, &rev.Tags, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		if rev.Tags == nil {
+			rev.Tags = []string{}
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func postTags(ctx context.Context, tx pgx.Tx, postID uuid.UUID) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT t.name FROM tags t
+		JOIN post_tags pt ON t.id = pt.tag_id
+		WHERE pt.post_id = $1
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func mentionedUsers(ctx context.Context, tx pgx.Tx, postID uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := tx.Query(ctx, `SELECT DISTINCT user_id FROM post_mentions WHERE post_id = $1`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentioned := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		mentioned[id] = true
+	}
+	return mentioned, rows.Err()
+}
+
+// diffTags returns the tags in next but not prev, and in prev but not next.
+func diffTags(prev, next []string) (added, removed []string) {
+	inPrev := make(map[string]bool, len(prev))
+	for _, t := range prev {
+		inPrev[t] = true
+	}
+	inNext := make(map[string]bool, len(next))
+	for _, t := range next {
+		inNext[t] = true
+		if !inPrev[t] {
+			added = append(added, t)
+		}
+	}
+	for _, t := range prev {
+		if !inNext[t] {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
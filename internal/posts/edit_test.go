@@ -0,0 +1,204 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+// tagCount returns a tag's post_count and hot_score, or zeros if it has
+// never been used.
+func tagCount(t *testing.T, db *pgxpool.Pool, name string) (int, float64) {
+	t.Helper()
+	var count int
+	var score float64
+	err := db.QueryRow(context.Background(), `
+		SELECT post_count, COALESCE(hot_score, 0) FROM tags WHERE name = $1
+	`, name).Scan(&count, &score)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatal(err)
+	}
+	return count, score
+}
+
+func TestUpdate_Revisions(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+	bob := dbtest.CreateUser(t, db, "bob")
+
+	post := createPost(t, repo, alice, "first draft of an idea #golang", nil)
+	if count, _ := tagCount(t, db, "golang"); count != 1 {
+		t.Fatalf("golang post_count = %d after publishing, want 1", count)
+	}
+
+	content := "a better idea"
+	if _, err := repo.Update(ctx, post.ID, bob, UpdatePostRequest{Content: &content}); !errors.Is(err, ErrPostNotFound) {
+		t.Errorf("Update() by another user = %v, want ErrPostNotFound", err)
+	}
+
+	updated, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Content: &content, Tags: &[]string{"rust"}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.EditedAt == nil || *updated.Content != content || !slices.Equal(updated.Tags, []string{"rust"}) {
+		t.Errorf("Update() = %+v, want the new content and tags marked as edited", updated)
+	}
+
+	// The removed tag gives back its count and score, the added one gains them
+	if count, score := tagCount(t, db, "golang"); count != 0 || score > 0.001 {
+		t.Errorf("removed tag: post_count = %d, hot_score = %v; want 0, 0", count, score)
+	}
+	if count, score := tagCount(t, db, "rust"); count != 1 || score < 0.9 {
+		t.Errorf("added tag: post_count = %d, hot_score = %v; want 1, ~1", count, score)
+	}
+
+	revisions, err := repo.ListRevisions(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("ListRevisions() returned %d revisions, want 1", len(revisions))
+	}
+	if rev := revisions[0]; *rev.Content != "first draft of an idea #golang" || !slices.Equal(rev.Tags, []string{"golang"}) {
+		t.Errorf("revision = %+v, want the post as it was before the edit", rev)
+	}
+
+	// Without Tags the post keeps its tags and picks up new hashtags
+	content = "the best idea #zig"
+	updated, err = repo.Update(ctx, post.ID, alice, UpdatePostRequest{Content: &content})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if tags := slices.Sorted(slices.Values(updated.Tags)); !slices.Equal(tags, []string{"rust", "zig"}) {
+		t.Errorf("Update() tags = %v, want [rust zig]", tags)
+	}
+	for _, tag := range []string{"rust", "zig"} {
+		if count, _ := tagCount(t, db, tag); count != 1 {
+			t.Errorf("%s post_count = %d, want 1", tag, count)
+		}
+	}
+
+	empty := ""
+	if _, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Content: &empty}); !errors.Is(err, ErrEmptyPost) {
+		t.Errorf("Update() clearing the content = %v, want ErrEmptyPost", err)
+	}
+	draft := StateDraft
+	if _, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{State: &draft}); !errors.Is(err, ErrAlreadyPublished) {
+		t.Errorf("Update() moving a published post to drafts = %v, want ErrAlreadyPublished", err)
+	}
+
+	revisions, err = repo.ListRevisions(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("ListRevisions() returned %d revisions, want 2; failed edits must not add any", len(revisions))
+	}
+	if rev := revisions[0]; *rev.Content != "a better idea" || !slices.Equal(rev.Tags, []string{"rust"}) {
+		t.Errorf("newest revision = %+v, want the second version", rev)
+	}
+}
+
+func TestUpdate_Draft(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+
+	content := "not ready yet #golang"
+	post, err := repo.Create(ctx, alice, CreatePostRequest{Content: &content, State: StateDraft})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	content = "still not ready"
+	updated, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Content: &content, Tags: &[]string{"rust"}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.EditedAt != nil || updated.State != StateDraft || !slices.Equal(updated.Tags, []string{"rust"}) {
+		t.Errorf("Update() of a draft = %+v, want an unedited draft tagged rust", updated)
+	}
+
+	revisions, err := repo.ListRevisions(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions() error = %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("editing a draft kept %d revisions, want none", len(revisions))
+	}
+	for _, tag := range []string{"golang", "rust"} {
+		if count, score := tagCount(t, db, tag); count != 0 || score != 0 {
+			t.Errorf("draft tag %s: post_count = %d, hot_score = %v; want 0, 0", tag, count, score)
+		}
+	}
+
+	if _, err := repo.Publish(ctx, post.ID, alice); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if count, _ := tagCount(t, db, "rust"); count != 1 {
+		t.Errorf("rust post_count = %d after publishing, want 1", count)
+	}
+	if count, _ := tagCount(t, db, "golang"); count != 0 {
+		t.Errorf("golang post_count = %d after publishing, want 0", count)
+	}
+}
+
+func TestUpdate_Unchanged(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+
+	content, alt := "look at this #cats", "a cat"
+	media := images(2)
+	media[0].AltText = &alt
+	post, err := repo.Create(ctx, alice, CreatePostRequest{Content: &content, Media: media, Tags: []string{"pets"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	a, b := post.Media[0].ID, post.Media[1].ID
+
+	revisions := func() int {
+		t.Helper()
+		revs, err := repo.ListRevisions(ctx, post.ID)
+		if err != nil {
+			t.Fatalf("ListRevisions() error = %v", err)
+		}
+		return len(revs)
+	}
+
+	for name, req := range map[string]UpdatePostRequest{
+		"the same content": {Content: &content},
+		"the same tags":    {Tags: &[]string{"pets", "cats"}},
+		"the same alt":     {ImageAlt: &alt},
+		"the same media":   {Media: &[]MediaEdit{{ID: a, AltText: &alt}, {ID: b}}},
+	} {
+		updated, err := repo.Update(ctx, post.ID, alice, req)
+		if err != nil {
+			t.Fatalf("Update() with %s error = %v", name, err)
+		}
+		if updated.EditedAt != nil {
+			t.Errorf("Update() with %s marked the post edited", name)
+		}
+		if n := revisions(); n != 0 {
+			t.Errorf("Update() with %s kept %d revisions, want none", name, n)
+		}
+	}
+
+	// Reordering the media is an edit
+	updated, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Media: &[]MediaEdit{{ID: b}, {ID: a}}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.EditedAt == nil || revisions() != 1 {
+		t.Errorf("reordering media: edited_at = %v with %d revisions, want it edited with 1", updated.EditedAt, revisions())
+	}
+}
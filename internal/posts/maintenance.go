@@ -0,0 +1,150 @@
+package posts
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// RecountResult is how many rows Recount corrected.
+type RecountResult struct {
+	Posts int64 `json:"posts"`
+	Tags  int64 `json:"tags"`
+}
+
+// Recount recomputes the like, reblog and reply counts of every post, and
+// the post count of every tag, from the rows they count, for when they have
+// drifted. Corrected posts get their controversy score recomputed too.
+func (r *Repository) Recount(ctx context.Context) (*RecountResult, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var result RecountResult
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE posts p SET
+			like_count = c.likes,
+			reblog_count = c.reblogs,
+			reply_count = c.replies,
+			controversy_score = (c.replies + 1) * (ABS(p.sentiment_score) + 0.25) / (c.likes + 1)
+		FROM (
+			SELECT q.id,
+				(SELECT COUNT(*) FROM likes l WHERE l.post_id = q.id) AS likes,
+				(SELECT COUNT(*) FROM posts rb WHERE rb.reblog_of_id = q.id AND rb.state = 'published') AS reblogs,
+				(SELECT COUNT(*) FROM posts rp WHERE rp.reply_to_id = q.id AND rp.state = 'published') AS replies
+			FROM posts q
+		) c
+		WHERE p.id = c.id
+		  AND (p.like_count, p.reblog_count, p.reply_count) IS DISTINCT FROM (c.likes::int, c.reblogs::int, c.replies::int)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	result.Posts = tag.RowsAffected()
+
+	// Only published posts are counted towards their tags
+	tag, err = tx.Exec(ctx, `
+		UPDATE tags t SET post_count = c.posts
+		FROM (
+			SELECT tg.id,
+				(SELECT COUNT(*) FROM post_tags pt JOIN posts p ON p.id = pt.post_id
+				 WHERE pt.tag_id = tg.id AND p.state = 'published') AS posts
+			FROM tags tg
+		) c
+		WHERE t.id = c.id AND t.post_count IS DISTINCT FROM c.posts::int
+	`)
+	if err != nil {
+		return nil, err
+	}
+	result.Tags = tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RescoreSentiment runs AnalyzeSentiment over every post again, batchSize
+// posts at a time, for when the analyzer has changed. Posts whose sentiment
+// changes get their controversy score recomputed too. It returns how many
+// posts it looked at and how many changed.
+func (r *Repository) RescoreSentiment(ctx context.Context, batchSize int) (scanned, changed int, err error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	type rescore struct {
+		id    uuid.UUID
+		score float64
+		label string
+	}
+
+	var after uuid.UUID
+	for {
+		rows, err := r.db.Query(ctx, `
+			SELECT id, content, reblog_comment, sentiment_score, sentiment_label
+			FROM posts
+			WHERE id > $1
+			ORDER BY id
+			LIMIT $2
+		`, after, batchSize)
+		if err != nil {
+			return scanned, changed, err
+		}
+
+		var updates []rescore
+		n := 0
+		for rows.Next() {
+			var id uuid.UUID
+			var content, reblogComment, label *string
+			var score *float64
+			if err := rows.Scan(&id, &content, &reblogComment, &score, &label); err != nil {
+				rows.Close()
+				return scanned, changed, err
+			}
+			n++
+			after = id
+
+			newScore, newLabel := AnalyzeSentiment(content, reblogComment)
+			if score == nil || *score != newScore || label == nil || *label != newLabel {
+				updates = append(updates, rescore{id: id, score: newScore, label: newLabel})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return scanned, changed, err
+		}
+		scanned += n
+
+		if len(updates) > 0 {
+			tx, err := r.db.Begin(ctx)
+			if err != nil {
+				return scanned, changed, err
+			}
+			for _, u := range updates {
+				_, err := tx.Exec(ctx, `
+					UPDATE posts SET
+						sentiment_score = $2,
+						sentiment_label = $3,
+						controversy_score = (reply_count + 1) * (ABS($2::float8) + 0.25) / (like_count + 1)
+					WHERE id = $1
+				`, u.id, u.score, u.label)
+				if err != nil {
+					tx.Rollback(ctx)
+					return scanned, changed, err
+				}
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return scanned, changed, err
+			}
+			changed += len(updates)
+		}
+
+		if n < batchSize {
+			return scanned, changed, nil
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/watzon/moltpress/internal/imaging"
+)
+
+var (
+	ErrTooManyMedia = errors.New("too many attachments")
+	ErrInvalidMedia = errors.New("invalid attachments")
+)
+
+// MaxMediaPerPost caps how many images one post can carry.
+const MaxMediaPerPost = 10
+
+// Media is an image attached to a post. Position orders a post's media
+// from 0; the first one is also exposed as the post's image_url and
+// image_alt for older clients. URL is the full size variant of images we
+// processed, and the original of older ones.
+type Media struct {
+	ID          uuid.UUID        `json:"id"`
+	URL         string           `json:"url"`
+	Key         *string          `json:"-"` // Storage key, if we host the file
+	ContentType string           `json:"content_type,omitempty"`
+	Size        int64            `json:"size,omitempty"`
+	Width       *int             `json:"width,omitempty"`
+	Height      *int             `json:"height,omitempty"`
+	AltText     *string          `json:"alt_text,omitempty"`
+	Position    int              `json:"position"`
+	Variants    imaging.Variants `json:"variants,omitempty"`
+	UploadID    *uuid.UUID       `json:"media_id,omitempty"` // Set when attached from /api/v1/media
+}
+
+// Attachment attaches an upload from /api/v1/media when creating a post.
+type Attachment struct {
+	MediaID uuid.UUID `json:"media_id"`
+	AltText *string   `json:"alt_text,omitempty"`
+}
+
+// Keys returns the storage keys of m and its variants. Files of an upload
+// are shared by everything it is attached to and are left to the upload
+// sweep, so there are none.
+func (m Media) Keys() []string {
+	if m.UploadID != nil {
+		return nil
+	}
+	keys := m.Variants.Keys()
+	if m.Key != nil && !slices.Contains(keys, *m.Key) {
+		keys = append(keys, *m.Key)
+	}
+	return keys
+}
+
+// MediaEdit updates one attachment when editing a post. Edits list every
+// attachment, in the order they should be shown.
+type MediaEdit struct {
+	ID      uuid.UUID `json:"id"`
+	AltText *string   `json:"alt_text,omitempty"` // Empty clears; omitted keeps
+}
+
+// insertMedia attaches media to a new post in the order given.
+func insertMedia(ctx context.Context, tx pgx.Tx, postID uuid.UUID, media []Media) ([]Media, error) {
+	if len(media) > MaxMediaPerPost {
+		return nil, ErrTooManyMedia
+	}
+
+	attached := make([]Media, 0, len(media))
+	for i, m := range media {
+		m.Position = i
+		err := tx.QueryRow(ctx, `
+			INSERT INTO post_media (
+				post_id, position, url, storage_key, content_type, size_bytes, width, height, alt_text, variants, upload_id
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id
+		`, postID, m.Position, m.URL, m.Key, m.ContentType, m.Size, m.Width, m.Height, m.AltText,
+			imaging.EncodeVariants(m.Variants), m.UploadID).Scan(&m.ID)
+		if err != nil {
+			return nil, err
+		}
+		attached = append(attached, m)
+	}
+	return attached, nil
+}
+
+// editMedia applies edits to a post's media, which must name each of its
+// attachments exactly once, and returns them in their new order and
+// whether the order or any alt text changed.
+func editMedia(ctx context.Context, tx pgx.Tx, postID uuid.UUID, edits []MediaEdit) ([]Media, bool, error) {
+	current, err := postMedia(ctx, tx, postID)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(edits) != len(current) {
+		return nil, false, ErrInvalidMedia
+	}
+
+	byID := make(map[uuid.UUID]Media, len(current))
+	for _, m := range current {
+		byID[m.ID] = m
+	}
+
+	media := make([]Media, 0, len(edits))
+	changed := false
+	for i, edit := range edits {
+		m, ok := byID[edit.ID]
+		if !ok {
+			return nil, false, ErrInvalidMedia
+		}
+		delete(byID, edit.ID)
+
+		if m.Position != i {
+			m.Position = i
+			changed = true
+		}
+		if edit.AltText != nil && !sameString(m.AltText, emptyToNil(edit.AltText)) {
+			m.AltText = emptyToNil(edit.AltText)
+			changed = true
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE post_media SET position = $2, alt_text = $3 WHERE id = $1
+		`, m.ID, m.Position, m.AltText)
+		if err != nil {
+			return nil, false, err
+		}
+		media = append(media, m)
+	}
+	return media, changed, nil
+}
+
+func postMedia(ctx context.Context, tx pgx.Tx, postID uuid.UUID) ([]Media, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, url, storage_key, content_type, size_bytes, width, height, alt_text, position, variants, upload_id
+		FROM post_media
+		WHERE post_id = $1
+		ORDER BY position
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []Media{}
+	for rows.Next() {
+		var m Media
+		var variants []byte
+		if err := rows.Scan(&m.ID, &m.URL, &m.Key, &m.ContentType, &m.Size, &m.Width, &m.Height, &m.AltText, &m.Position, &variants, &m.UploadID); err != nil {
+			return nil, err
+		}
+		m.Variants = imaging.DecodeVariants(variants)
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+// loadMedia fills in the media of the given posts.
+func (r *Repository) loadMedia(ctx context.Context, posts map[uuid.UUID]*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(posts))
+	for id := range posts {
+		ids = append(ids, id)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT post_id, id, url, content_type, size_bytes, width, height, alt_text, position, variants, upload_id
+		FROM post_media
+		WHERE post_id = ANY($1)
+		ORDER BY post_id, position
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID uuid.UUID
+		var m Media
+		var variants []byte
+		if err := rows.Scan(&postID, &m.ID, &m.URL, &m.ContentType, &m.Size, &m.Width, &m.Height, &m.AltText, &m.Position, &variants, &m.UploadID); err != nil {
+			return err
+		}
+		m.Variants = imaging.DecodeVariants(variants)
+		if p, ok := posts[postID]; ok {
+			p.Media = append(p.Media, m)
+		}
+	}
+	return rows.Err()
+}
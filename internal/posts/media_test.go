@@ -0,0 +1,129 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/imaging"
+)
+
+func TestMedia_Keys(t *testing.T) {
+	key := func(s string) *string { return &s }
+	variants := imaging.Variants{
+		"small":  {URL: "https://cdn.test/small.webp", Key: "posts/a/small.webp"},
+		"medium": {URL: "https://cdn.test/small.webp", Key: "posts/a/small.webp"},
+		"large":  {URL: "https://cdn.test/large.webp", Key: "posts/a/large.webp"},
+	}
+	uploadID := uuid.New()
+
+	tests := []struct {
+		name  string
+		media Media
+		want  []string
+	}{
+		{"external image", Media{URL: "https://example.com/cat.png"}, nil},
+		{"hosted original", Media{Key: key("posts/a/original.png")}, []string{"posts/a/original.png"}},
+		{"hosted with variants", Media{Key: key("posts/a/original.png"), Variants: variants},
+			[]string{"posts/a/large.webp", "posts/a/small.webp", "posts/a/original.png"}},
+		{"original that is also a variant", Media{Key: key("posts/a/large.webp"), Variants: variants},
+			[]string{"posts/a/large.webp", "posts/a/small.webp"}},
+		{"upload", Media{Key: key("media/a/original.png"), Variants: variants, UploadID: &uploadID}, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.media.Keys(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Keys() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// images returns n external images numbered from 0.
+func images(n int) []Media {
+	media := make([]Media, n)
+	for i := range media {
+		media[i] = Media{URL: fmt.Sprintf("https://example.com/%d.png", i)}
+	}
+	return media
+}
+
+func TestCreate_MediaLimit(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+
+	if _, err := repo.Create(ctx, alice, CreatePostRequest{Media: images(MaxMediaPerPost + 1)}); !errors.Is(err, ErrTooManyMedia) {
+		t.Errorf("Create() with %d images = %v, want ErrTooManyMedia", MaxMediaPerPost+1, err)
+	}
+
+	post, err := repo.Create(ctx, alice, CreatePostRequest{Media: images(MaxMediaPerPost)})
+	if err != nil {
+		t.Fatalf("Create() with %d images error = %v", MaxMediaPerPost, err)
+	}
+	if len(post.Media) != MaxMediaPerPost {
+		t.Fatalf("Create() attached %d images, want %d", len(post.Media), MaxMediaPerPost)
+	}
+	for i, m := range post.Media {
+		if m.Position != i || m.URL != fmt.Sprintf("https://example.com/%d.png", i) {
+			t.Errorf("media[%d] = %+v, want image %d", i, m, i)
+		}
+	}
+	if post.ImageURL == nil || *post.ImageURL != post.Media[0].URL {
+		t.Errorf("image_url = %v, want the first image", post.ImageURL)
+	}
+}
+
+func TestUpdate_Media(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	alice := dbtest.CreateUser(t, db, "alice")
+
+	post, err := repo.Create(ctx, alice, CreatePostRequest{Media: images(3)})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	a, b, c := post.Media[0].ID, post.Media[1].ID, post.Media[2].ID
+
+	for name, edits := range map[string][]MediaEdit{
+		"one missing":    {{ID: a}, {ID: b}},
+		"one twice":      {{ID: a}, {ID: b}, {ID: b}},
+		"an unknown one": {{ID: a}, {ID: b}, {ID: uuid.New()}},
+		"one extra":      {{ID: a}, {ID: b}, {ID: c}, {ID: uuid.New()}},
+	} {
+		if _, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Media: &edits}); !errors.Is(err, ErrInvalidMedia) {
+			t.Errorf("Update() listing %s = %v, want ErrInvalidMedia", name, err)
+		}
+	}
+
+	alt, cleared := "the third image", ""
+	updated, err := repo.Update(ctx, post.ID, alice, UpdatePostRequest{Media: &[]MediaEdit{
+		{ID: c, AltText: &alt}, {ID: a, AltText: &cleared}, {ID: b},
+	}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	var order []uuid.UUID
+	for i, m := range updated.Media {
+		if m.Position != i {
+			t.Errorf("media[%d] has position %d", i, m.Position)
+		}
+		order = append(order, m.ID)
+	}
+	if !slices.Equal(order, []uuid.UUID{c, a, b}) {
+		t.Errorf("Update() media order = %v, want [%s %s %s]", order, c, a, b)
+	}
+	if m := updated.Media[0]; m.AltText == nil || *m.AltText != alt {
+		t.Errorf("media[0] alt text = %v, want %q", m.AltText, alt)
+	}
+	if m := updated.Media[1]; m.AltText != nil {
+		t.Errorf("media[1] alt text = %q, want it cleared", *m.AltText)
+	}
+	if updated.ImageURL == nil || *updated.ImageURL != updated.Media[0].URL || updated.ImageAlt == nil || *updated.ImageAlt != alt {
+		t.Errorf("image_url = %v, image_alt = %v; want the new first image", updated.ImageURL, updated.ImageAlt)
+	}
+}
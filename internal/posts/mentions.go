@@ -0,0 +1,202 @@
+package posts
+
+import (
+	"context"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/watzon/moltpress/internal/notifications"
+	"github.com/watzon/moltpress/internal/stream"
+)
+
+// MaxMentionsPerPost caps how many users one post can link and notify.
+const MaxMentionsPerPost = 10
+
+// maxUsernameLength matches users.username VARCHAR(50).
+const maxUsernameLength = 50
+
+// Mention links an @username in a post to the user. Start and End are
+// offsets in Unicode code points (not bytes) into the post's content, or
+// into reblog_comment for a reblog without content. The range includes the
+// "@" and End is exclusive.
+type Mention struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Start    int       `json:"start"`
+	End      int       `json:"end"`
+}
+
+type mentionMatch struct {
+	username   string
+	start, end int
+}
+
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// extractMentions finds @username tokens in text. An "@" inside a word,
+// email address or URL path does not start a mention.
+func extractMentions(text string) []mentionMatch {
+	runes := []rune(text)
+	var matches []mentionMatch
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 {
+			prev := runes[i-1]
+			if isUsernameRune(prev) || prev == '@' || prev == '/' {
+				continue
+			}
+		}
+
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		// Sentence punctuation after a name is not part of it
+		for end > i+1 && runes[end-1] == '.' {
+			end--
+		}
+
+		if n := end - i - 1; n > 0 && n <= maxUsernameLength {
+			matches = append(matches, mentionMatch{username: string(runes[i+1 : end]), start: i, end: end})
+		}
+		i = end - 1
+	}
+
+	return matches
+}
+
+// mentionText is the text mentions are extracted from and their offsets
+// refer to.
+func mentionText(content, reblogComment *string) string {
+	if content != nil {
+		return *content
+	}
+	if reblogComment != nil {
+		return *reblogComment
+	}
+	return ""
+}
+
+// insertMentions links the mentions in a new post to the users that exist
+// and notifies them, except for those in skip who were already notified
+// about the post. It returns the live notification events to publish.
+func insertMentions(ctx context.Context, tx pgx.Tx, post *Post, skip map[uuid.UUID]bool) ([]*stream.Event, error) {
+	matches := extractMentions(mentionText(post.Content, post.ReblogComment))
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m.username)
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, username FROM users WHERE username = ANY($1)`, names)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make(map[string]uuid.UUID)
+	for rows.Next() {
+		var id uuid.UUID
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs[username] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var live []*stream.Event
+	notified := make(map[uuid.UUID]bool)
+	for _, m := range matches {
+		userID, ok := userIDs[m.username]
+		if !ok {
+			continue
+		}
+		if !notified[userID] && len(notified) >= MaxMentionsPerPost {
+			continue
+		}
+
+		_, err := tx.Exec(ctx, `
+			INSERT INTO post_mentions (post_id, user_id, start_offset, end_offset)
+			VALUES ($1, $2, $3, $4)
+		`, post.ID, userID, m.start, m.end)
+		if err != nil {
+			return nil, err
+		}
+		post.Mentions = append(post.Mentions, Mention{UserID: userID, Username: m.username, Start: m.start, End: m.end})
+
+		if notified[userID] {
+			continue
+		}
+		notified[userID] = true
+		if skip[userID] {
+			continue
+		}
+
+		n, err := notifications.Notify(ctx, tx, notifications.Event{
+			Type: notifications.TypeMention, UserID: userID, ActorID: post.UserID, PostID: &post.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		live = append(live, n)
+	}
+
+	return live, nil
+}
+
+// loadMentions fills in the mentions of the given posts.
+func (r *Repository) loadMentions(ctx context.Context, posts map[uuid.UUID]*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(posts))
+	for id := range posts {
+		ids = append(ids, id)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT pm.post_id, pm.user_id, u.username, pm.start_offset, pm.end_offset
+		FROM post_mentions pm
+		JOIN users u ON u.id = pm.user_id
+		WHERE pm.post_id = ANY($1)
+		ORDER BY pm.post_id, pm.start_offset
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID uuid.UUID
+		var m Mention
+		if err := rows.Scan(&postID, &m.UserID, &m.Username, &m.Start, &m.End); err != nil {
+			return err
+		}
+		if p, ok := posts[postID]; ok {
+			p.Mentions = append(p.Mentions, m)
+		}
+	}
+	return rows.Err()
+}
+
+// GetMentions returns posts that mention userID, newest first. Posts by
+// users they have blocked, been blocked by or muted are left out.
+func (r *Repository) GetMentions(ctx context.Context, userID uuid.UUID, opts FeedOptions) (*Timeline, error) {
+	q := newTimelineQuery(&userID, orderNewest)
+	q.filter("p.id IN (SELECT post_id FROM post_mentions WHERE user_id = " + q.bind(userID) + ")")
+	q.hideMuted()
+
+	return r.queryTimeline(ctx, q, opts, &userID)
+}
@@ -0,0 +1,39 @@
+package posts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []mentionMatch
+	}{
+		{"@claude-bot what do you think?", []mentionMatch{{"claude-bot", 0, 11}}},
+		{"cc @alice, @bob.", []mentionMatch{{"alice", 3, 9}, {"bob", 11, 15}}},
+		{"héllo @ünïcode_1 ok", []mentionMatch{{"ünïcode_1", 6, 16}}},
+		{"(@paren) and \"@quoted\"", []mentionMatch{{"paren", 1, 7}, {"quoted", 14, 21}}},
+		{"mail me at me@example.com", nil},
+		{"see https://mastodon.social/@bob", nil},
+		{"@@double and a lone @ sign", nil},
+		{"no mentions here", nil},
+	}
+
+	for _, tt := range tests {
+		got := extractMentions(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractMentions(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractMentions_TooLong(t *testing.T) {
+	name := make([]byte, maxUsernameLength+1)
+	for i := range name {
+		name[i] = 'a'
+	}
+	if got := extractMentions("@" + string(name)); got != nil {
+		t.Errorf("expected names longer than %d to be ignored, got %+v", maxUsernameLength, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/filters"
 	"github.com/watzon/moltpress/internal/users"
 )
 
@@ -13,6 +14,7 @@ type Post struct {
 	Content          *string    `json:"content,omitempty"`
 	ImageURL         *string    `json:"image_url,omitempty"`
 	ImageKey         *string    `json:"-"`
+	ImageAlt         *string    `json:"image_alt,omitempty"`
 	ReblogOfID       *uuid.UUID `json:"reblog_of_id,omitempty"`
 	ReblogComment    *string    `json:"reblog_comment,omitempty"`
 	ReplyToID        *uuid.UUID `json:"reply_to_id,omitempty"`
@@ -24,37 +26,57 @@ type Post struct {
 	ControversyScore float64    `json:"controversy_score"`
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
+	EditedAt         *time.Time `json:"edited_at,omitempty"`
+	State            string     `json:"state"`
+	PublishAt        *time.Time `json:"publish_at,omitempty"` // When a scheduled post goes out
 
 	// Joined fields
 	User        *users.UserPublic `json:"user,omitempty"`
 	ReblogOf    *Post             `json:"reblog_of,omitempty"`
 	ReplyTo     *Post             `json:"reply_to,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
+	Mentions    []Mention         `json:"mentions,omitempty"`
+	Media       []Media           `json:"media,omitempty"`
 	IsLiked     bool              `json:"is_liked,omitempty"`
 	IsReblogged bool              `json:"is_reblogged,omitempty"`
+	Rank        *float64          `json:"rank,omitempty"` // Search relevance
+
+	// Set when the viewer's warn filters match the post
+	Filtered      bool     `json:"filtered,omitempty"`
+	FilterReasons []string `json:"filter_reasons,omitempty"`
 }
 
 type CreatePostRequest struct {
-	Content       *string    `json:"content,omitempty"`
-	ImageURL      *string    `json:"image_url,omitempty"`
-	ImageKey      *string    `json:"-"`
-	ReblogOfID    *uuid.UUID `json:"reblog_of_id,omitempty"`
-	ReblogComment *string    `json:"reblog_comment,omitempty"`
-	ReplyToID     *uuid.UUID `json:"reply_to_id,omitempty"`
-	Tags          []string   `json:"tags,omitempty"`
+	Content       *string      `json:"content,omitempty"`
+	ImageURL      *string      `json:"image_url,omitempty"`
+	ImageKey      *string      `json:"-"`
+	ImageAlt      *string      `json:"image_alt,omitempty"`
+	Media         []Media      `json:"-"`               // Uploaded images; ImageURL is used when empty
+	Attachments   []Attachment `json:"media,omitempty"` // Uploads to add to Media
+	ReblogOfID    *uuid.UUID   `json:"reblog_of_id,omitempty"`
+	ReblogComment *string      `json:"reblog_comment,omitempty"`
+	ReplyToID     *uuid.UUID   `json:"reply_to_id,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+	State         string       `json:"state,omitempty"`      // Defaults to published
+	PublishAt     *time.Time   `json:"publish_at,omitempty"` // Required for scheduled posts
 }
 
 type FeedOptions struct {
 	Limit    int
-	Offset   int
+	Cursor   *Cursor    // Keyset position; takes precedence over Offset
+	Offset   int        // Deprecated: use Cursor
 	UserID   *uuid.UUID // For user-specific feeds
 	Tag      *string    // For tag feeds
 	ViewerID *uuid.UUID // For personalization (likes, etc)
 	Sort     string     // Optional sorting for feeds
+
+	// Filters are the viewer's saved filters, for the feeds that apply them
+	Filters []filters.Filter
 }
 
 type Timeline struct {
 	Posts      []Post `json:"posts"`
-	NextOffset int    `json:"next_offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	NextOffset int    `json:"next_offset,omitempty"` // Deprecated: use NextCursor
 	HasMore    bool   `json:"has_more"`
 }
@@ -0,0 +1,165 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidQueueTime  = errors.New("invalid queue time")
+	ErrTooManyQueueTimes = errors.New("too many queue times")
+	ErrInvalidTimezone   = errors.New("invalid time zone")
+)
+
+// MaxQueueTimes caps how many queued posts go out each day.
+const MaxQueueTimes = 50
+
+// Queue publishes a user's queued posts, oldest first, one at each of the
+// daily Times. Times are "HH:MM" in Timezone, an IANA zone name.
+type Queue struct {
+	Times      []string   `json:"times"`
+	Timezone   string     `json:"timezone"`
+	Paused     bool       `json:"paused"`
+	NextSlotAt *time.Time `json:"next_slot_at,omitempty"`
+	Queued     int        `json:"queued"` // Posts waiting in the queue
+}
+
+type UpdateQueueRequest struct {
+	Times    []string `json:"times"`
+	Timezone string   `json:"timezone,omitempty"` // Defaults to UTC
+	Paused   bool     `json:"paused,omitempty"`
+}
+
+// parseQueueTimes parses "HH:MM" times into sorted, distinct minutes after
+// midnight.
+func parseQueueTimes(times []string) ([]int, error) {
+	var slots []int
+	for _, s := range times {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return nil, ErrInvalidQueueTime
+		}
+		slot := t.Hour()*60 + t.Minute()
+		if !slices.Contains(slots, slot) {
+			slots = append(slots, slot)
+		}
+	}
+	if len(slots) > MaxQueueTimes {
+		return nil, ErrTooManyQueueTimes
+	}
+	slices.Sort(slots)
+	return slots, nil
+}
+
+func loadQueueLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, ErrInvalidTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, ErrInvalidTimezone
+	}
+	return loc, nil
+}
+
+// nextSlot returns the first of the daily slots, in minutes after midnight
+// in loc, that comes after t, or nil if there are no slots.
+func nextSlot(slots []int, loc *time.Location, t time.Time) *time.Time {
+	local := t.In(loc)
+
+	// Compare every candidate: around a DST change time.Date may shift a
+	// slot past a later one
+	var next *time.Time
+	for day := 0; day <= 1; day++ {
+		for _, slot := range slots {
+			candidate := time.Date(local.Year(), local.Month(), local.Day()+day, slot/60, slot%60, 0, 0, loc)
+			if candidate.After(t) && (next == nil || candidate.Before(*next)) {
+				next = &candidate
+			}
+		}
+	}
+	return next
+}
+
+// queueNextSlot is nextSlot for stored queue settings. Settings that no
+// longer parse stop the queue.
+func queueNextSlot(times []string, timezone string, t time.Time) *time.Time {
+	slots, err := parseQueueTimes(times)
+	if err != nil {
+		return nil
+	}
+	loc, err := loadQueueLocation(timezone)
+	if err != nil {
+		return nil
+	}
+	return nextSlot(slots, loc, t)
+}
+
+// GetQueue returns userID's queue. Users who never set one up have an
+// empty queue in UTC.
+func (r *Repository) GetQueue(ctx context.Context, userID uuid.UUID) (*Queue, error) {
+	queue := &Queue{Times: []string{}, Timezone: "UTC"}
+	err := r.db.QueryRow(ctx, `
+		SELECT times, timezone, paused, next_slot_at FROM post_queues WHERE user_id = $1
+	`, userID).Scan(&queue.Times, &queue.Timezone, &queue.Paused, &queue.NextSlotAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	err = r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM posts WHERE user_id = $1 AND state = 'queued'
+	`, userID).Scan(&queue.Queued)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+// UpdateQueue replaces userID's queue settings. The queue next publishes at
+// the first slot after now.
+func (r *Repository) UpdateQueue(ctx context.Context, userID uuid.UUID, req UpdateQueueRequest) (*Queue, error) {
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	slots, err := parseQueueTimes(req.Times)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := loadQueueLocation(req.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make([]string, len(slots))
+	for i, slot := range slots {
+		times[i] = fmt.Sprintf("%02d:%02d", slot/60, slot%60)
+	}
+
+	var next *time.Time
+	if !req.Paused {
+		next = nextSlot(slots, loc, time.Now())
+	}
+
+	_, err = r.db.Exec(ctx, `
+		INSERT INTO post_queues (user_id, times, timezone, paused, next_slot_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			times = EXCLUDED.times,
+			timezone = EXCLUDED.timezone,
+			paused = EXCLUDED.paused,
+			next_slot_at = EXCLUDED.next_slot_at,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, times, loc.String(), req.Paused, next)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetQueue(ctx, userID)
+}
@@ -0,0 +1,68 @@
+package posts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQueueTimes(t *testing.T) {
+	got, err := parseQueueTimes([]string{"18:30", "9:00", "09:00", "00:05"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int{5, 540, 1110}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, bad := range []string{"24:00", "12:60", "noon", "7:5", ""} {
+		if _, err := parseQueueTimes([]string{bad}); err != ErrInvalidQueueTime {
+			t.Errorf("parseQueueTimes(%q): expected ErrInvalidQueueTime, got %v", bad, err)
+		}
+	}
+
+	many := make([]string, 0, MaxQueueTimes+1)
+	for i := 0; i <= MaxQueueTimes; i++ {
+		many = append(many, time.Date(0, 1, 1, 0, i*10, 0, 0, time.UTC).Format("15:04"))
+	}
+	if _, err := parseQueueTimes(many); err != ErrTooManyQueueTimes {
+		t.Errorf("expected ErrTooManyQueueTimes, got %v", err)
+	}
+}
+
+func TestNextSlot(t *testing.T) {
+	slots := []int{9 * 60, 18 * 60}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		after time.Time
+		want  time.Time
+	}{
+		{now, time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC), time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)},
+		{time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := nextSlot(slots, time.UTC, tt.after)
+		if got == nil || !got.Equal(tt.want) {
+			t.Errorf("nextSlot after %v = %v, want %v", tt.after, got, tt.want)
+		}
+	}
+
+	if got := nextSlot(nil, time.UTC, now); got != nil {
+		t.Errorf("expected no slot without times, got %v", got)
+	}
+}
+
+func TestNextSlot_TimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	// 9:00 in New York is 13:00 UTC in summer
+	got := nextSlot([]int{9 * 60}, loc, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2025, 6, 1, 13, 0, 0, 0, time.UTC); got == nil || !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -3,128 +3,287 @@ package posts
 import (
 	"context"
 	"errors"
-	"strings"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/audit"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/notifications"
+	"github.com/watzon/moltpress/internal/stream"
 	"github.com/watzon/moltpress/internal/users"
+	"github.com/watzon/moltpress/internal/webhooks"
 )
 
 var (
 	ErrPostNotFound = errors.New("post not found")
+	ErrFeedTimeout  = errors.New("feed query timed out")
 )
 
+// feedTimeout bounds how long the database may spend on one page of a
+// feed. Feed filters put account-supplied patterns in the query, and this
+// keeps a pathological one from tying up a connection.
+const feedTimeout = 5 * time.Second
+
 type Repository struct {
-	db *pgxpool.Pool
+	db     *pgxpool.Pool
+	events stream.Publisher
 }
 
 func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// WithPublisher streams new posts, likes and the notifications they cause
+// to connected clients.
+func (r *Repository) WithPublisher(p stream.Publisher) *Repository {
+	r.events = p
+	return r
+}
+
+// Create adds a post. Posts created as drafts, scheduled or queued are
+// stored without touching counts, notifications or feeds; that happens
+// when they are published.
 func (r *Repository) Create(ctx context.Context, userID uuid.UUID, req CreatePostRequest) (*Post, error) {
+	tags, err := ResolveTags(req.Tags, req.Content, req.ReblogComment)
+	if err != nil {
+		return nil, err
+	}
+	state, err := ResolveState(req.State, req.PublishAt, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	media := req.Media
+	if len(media) == 0 && req.ImageURL != nil {
+		media = []Media{{URL: *req.ImageURL, Key: req.ImageKey, AltText: req.ImageAlt}}
+	}
+	if len(media) > MaxMediaPerPost {
+		return nil, ErrTooManyMedia
+	}
+	// The first attachment doubles as the post's image
+	if len(media) > 0 {
+		req.ImageURL, req.ImageKey, req.ImageAlt = &media[0].URL, media[0].Key, media[0].AltText
+	}
+
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
+	// Only published posts can be reblogged or replied to, and not by
+	// someone their author has blocked or who has blocked them
+	for _, target := range []*uuid.UUID{req.ReblogOfID, req.ReplyToID} {
+		if target == nil {
+			continue
+		}
+		var authorID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			SELECT user_id FROM posts WHERE id = $1 AND state = 'published'
+		`, target).Scan(&authorID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrPostNotFound
+			}
+			return nil, err
+		}
+		if err := checkBlocked(ctx, tx, userID, authorID); err != nil {
+			return nil, err
+		}
+	}
+
+	if state != StatePublished {
+		var pending int
+		err := tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM posts WHERE user_id = $1 AND state <> 'published'
+		`, userID).Scan(&pending)
+		if err != nil {
+			return nil, err
+		}
+		if pending >= MaxPendingPosts {
+			return nil, ErrTooManyPending
+		}
+	}
+
 	sentimentScore, sentimentLabel := AnalyzeSentiment(req.Content, req.ReblogComment)
 	controversyScore := ComputeControversyScore(0, 0, sentimentScore)
 
 	post := &Post{}
 	err = tx.QueryRow(ctx, `
 		INSERT INTO posts (
-			user_id, content, image_url, image_key, reblog_of_id, reblog_comment, reply_to_id,
-			sentiment_score, sentiment_label, controversy_score
+			user_id, content, image_url, image_key, image_alt, reblog_of_id, reblog_comment, reply_to_id,
+			sentiment_score, sentiment_label, controversy_score, state, publish_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		RETURNING id, user_id, content, image_url, image_key, reblog_of_id, reblog_comment, reply_to_id,
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, user_id, content, image_url, image_key, image_alt, reblog_of_id, reblog_comment, reply_to_id,
 				  like_count, reblog_count, reply_count, sentiment_score, sentiment_label,
-				  controversy_score, created_at, updated_at
-	`, userID, req.Content, req.ImageURL, req.ImageKey, req.ReblogOfID, req.ReblogComment, req.ReplyToID, sentimentScore, sentimentLabel, controversyScore).Scan(
-		&post.ID, &post.UserID, &post.Content, &post.ImageURL, &post.ImageKey, &post.ReblogOfID,
+				  controversy_score, created_at, updated_at, state, publish_at
+	`, userID, req.Content, req.ImageURL, req.ImageKey, req.ImageAlt, req.ReblogOfID, req.ReblogComment, req.ReplyToID, sentimentScore, sentimentLabel, controversyScore, state, req.PublishAt).Scan(
+		&post.ID, &post.UserID, &post.Content, &post.ImageURL, &post.ImageKey, &post.ImageAlt, &post.ReblogOfID,
 		&post.ReblogComment, &post.ReplyToID, &post.LikeCount, &post.ReblogCount,
 		&post.ReplyCount, &post.SentimentScore, &post.SentimentLabel, &post.ControversyScore,
-		&post.CreatedAt, &post.UpdatedAt,
+		&post.CreatedAt, &post.UpdatedAt, &post.State, &post.PublishAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	// Handle tags
-	if len(req.Tags) > 0 {
-		for _, tagName := range req.Tags {
-			tagName = strings.ToLower(strings.TrimSpace(tagName))
-			if tagName == "" {
-				continue
-			}
+	if len(media) > 0 {
+		post.Media, err = insertMedia(ctx, tx, post.ID, media)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			// Upsert tag
-			var tagID int
-			err = tx.QueryRow(ctx, `
-				INSERT INTO tags (name, post_count, hot_score, hot_updated_at) VALUES ($1, 1, 1, NOW())
-				ON CONFLICT (name) DO UPDATE SET
-					post_count = tags.post_count + 1,
-					hot_score = (
-						COALESCE(tags.hot_score, 0) * EXP(
-							-0.173286 * EXTRACT(EPOCH FROM (NOW() - COALESCE(tags.hot_updated_at, NOW()))) / 3600.0
-						)
-					) + 1,
-					hot_updated_at = NOW()
-				RETURNING id
-			`, tagName).Scan(&tagID)
-			if err != nil {
-				return nil, err
-			}
+	// Handle tags
+	if len(tags) > 0 {
+		if err := linkTags(ctx, tx, post.ID, tags); err != nil {
+			return nil, err
+		}
+		post.Tags = tags
+	}
 
-			// Link post to tag
-			_, err = tx.Exec(ctx, `
-				INSERT INTO post_tags (post_id, tag_id) VALUES ($1, $2)
-				ON CONFLICT DO NOTHING
-			`, post.ID, tagID)
-			if err != nil {
-				return nil, err
-			}
+	if state != StatePublished {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
 		}
-		post.Tags = req.Tags
+		return post, nil
+	}
+
+	live, err := publishEffects(ctx, tx, post)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	r.publishPost(ctx, post)
+	stream.PublishAll(ctx, r.events, live...)
+
+	return post, nil
+}
+
+// checkBlocked returns blocks.ErrBlocked if userID and authorID have
+// blocked each other.
+func checkBlocked(ctx context.Context, tx pgx.Tx, userID, authorID uuid.UUID) error {
+	blocked, err := blocks.Between(ctx, tx, userID, authorID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return blocks.ErrBlocked
+	}
+	return nil
+}
+
+// publishEffects does what publishing a post sets off: counting it towards
+// its tags and the post it reblogs or replies to, notifying the people
+// involved and queueing webhooks. It returns the live events to publish
+// once tx commits.
+func publishEffects(ctx context.Context, tx pgx.Tx, post *Post) ([]*stream.Event, error) {
+	var live []*stream.Event
+	notified := make(map[uuid.UUID]bool)
+
+	if err := countTags(ctx, tx, post.Tags); err != nil {
+		return nil, err
 	}
 
 	// Update reblog count if this is a reblog
-	if req.ReblogOfID != nil {
-		_, err = tx.Exec(ctx, `
-			UPDATE posts SET reblog_count = reblog_count + 1 WHERE id = $1
-		`, req.ReblogOfID)
+	if post.ReblogOfID != nil {
+		var authorID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			UPDATE posts SET reblog_count = reblog_count + 1 WHERE id = $1 RETURNING user_id
+		`, post.ReblogOfID).Scan(&authorID)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := notifications.Notify(ctx, tx, notifications.Event{
+			Type: notifications.TypeReblog, UserID: authorID, ActorID: post.UserID,
+			PostID: post.ReblogOfID, SourcePostID: &post.ID,
+		})
 		if err != nil {
 			return nil, err
 		}
+		live = append(live, n)
+		notified[authorID] = true
 	}
 
 	// Update reply count if this is a reply
-	if req.ReplyToID != nil {
-		_, err = tx.Exec(ctx, `
-			UPDATE posts SET reply_count = reply_count + 1 WHERE id = $1
-		`, req.ReplyToID)
+	if post.ReplyToID != nil {
+		var authorID uuid.UUID
+		err := tx.QueryRow(ctx, `
+			UPDATE posts SET reply_count = reply_count + 1 WHERE id = $1 RETURNING user_id
+		`, post.ReplyToID).Scan(&authorID)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := notifications.Notify(ctx, tx, notifications.Event{
+			Type: notifications.TypeReply, UserID: authorID, ActorID: post.UserID,
+			PostID: post.ReplyToID, SourcePostID: &post.ID,
+		})
 		if err != nil {
 			return nil, err
 		}
+		live = append(live, n)
+		notified[authorID] = true
 
 		_, err = tx.Exec(ctx, `
 			UPDATE posts
 			SET controversy_score = (reply_count + 1) * (ABS(sentiment_score) + 0.25) / (like_count + 1)
 			WHERE id = $1
-		`, req.ReplyToID)
+		`, post.ReplyToID)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if err := tx.Commit(ctx); err != nil {
+	// Link @mentions, without notifying anyone twice about the same post
+	mentioned, err := insertMentions(ctx, tx, post, notified)
+	if err != nil {
 		return nil, err
 	}
+	live = append(live, mentioned...)
 
-	return post, nil
+	if err := webhooks.EnqueueForFollowers(ctx, tx, post.UserID, webhooks.EventFollowedPost, post); err != nil {
+		return nil, err
+	}
+
+	return live, nil
+}
+
+// publishPost streams a newly created post, with its author, to clients
+// watching the feeds it appears in.
+func (r *Repository) publishPost(ctx context.Context, post *Post) {
+	if r.events == nil {
+		return
+	}
+
+	full, err := r.GetByID(ctx, post.ID, nil)
+	if err != nil {
+		return
+	}
+
+	eventType := stream.EventPost
+	if full.ReblogOfID != nil {
+		eventType = stream.EventReblog
+	}
+	e, err := stream.NewEvent(eventType, full.UserID, full)
+	if err != nil {
+		return
+	}
+	e.Public = full.ReplyToID == nil
+	e.Tags = full.Tags
+
+	stream.PublishAll(ctx, r.events, &e)
 }
 
 func (r *Repository) GetByID(ctx context.Context, id uuid.UUID, viewerID *uuid.UUID) (*Post, error) {
@@ -138,21 +297,23 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID, viewerID *uuid.U
 			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
 			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
 			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
+			p.image_alt, p.edited_at, p.state, p.publish_at,
 			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
 			CASE WHEN $2::uuid IS NOT NULL THEN
 				EXISTS(SELECT 1 FROM likes WHERE user_id = $2 AND post_id = p.id)
 			ELSE false END as is_liked,
 			CASE WHEN $2::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM posts WHERE user_id = $2 AND reblog_of_id = p.id)
+				EXISTS(SELECT 1 FROM posts WHERE user_id = $2 AND reblog_of_id = p.id AND state = 'published')
 			ELSE false END as is_reblogged
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
-		WHERE p.id = $1
+		WHERE p.id = $1 AND (p.state = 'published' OR p.user_id = $2)
+		  AND ($2::uuid IS NULL OR NOT `+blocks.Blocked("$2", "p.user_id")+`)
 	`, id, viewerID).Scan(
 		&post.ID, &post.UserID, &post.Content, &post.ImageURL, &post.ReblogOfID,
 		&post.ReblogComment, &post.ReplyToID, &post.LikeCount, &post.ReblogCount,
 		&post.ReplyCount, &post.SentimentScore, &post.SentimentLabel, &post.ControversyScore,
-		&post.CreatedAt, &post.UpdatedAt,
+		&post.CreatedAt, &post.UpdatedAt, &post.ImageAlt, &post.EditedAt, &post.State, &post.PublishAt,
 		&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.IsAgent,
 		&isLiked, &isReblogged,
 	)
@@ -186,6 +347,14 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID, viewerID *uuid.U
 		post.Tags = append(post.Tags, tag)
 	}
 
+	byID := map[uuid.UUID]*Post{post.ID: post}
+	if err := r.loadMentions(ctx, byID); err != nil {
+		return nil, err
+	}
+	if err := r.loadMedia(ctx, byID); err != nil {
+		return nil, err
+	}
+
 	// Get reblog source if this is a reblog
 	if post.ReblogOfID != nil {
 		reblogOf, err := r.GetByID(ctx, *post.ReblogOfID, viewerID)
@@ -197,180 +366,232 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID, viewerID *uuid.U
 	return post, nil
 }
 
-func (r *Repository) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*string, error) {
-	var imageKey *string
-	err := r.db.QueryRow(ctx, `
-		DELETE FROM posts WHERE id = $1 AND user_id = $2
-		RETURNING image_key
-	`, id, userID).Scan(&imageKey)
+// GetByIDs loads the posts in ids that GetByID would return, keyed by ID,
+// in a single query. Posts that are missing or hidden from the viewer are
+// left out.
+func (r *Repository) GetByIDs(ctx context.Context, ids []uuid.UUID, viewerID *uuid.UUID) (map[uuid.UUID]*Post, error) {
+	found := make(map[uuid.UUID]*Post, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	q := &timelineQuery{args: []any{viewerID}}
+	q.filter("p.id = ANY(" + q.bind(ids) + ")")
+	q.filter("(p.state = 'published' OR p.user_id = $1)")
+	q.filter("($1::uuid IS NULL OR NOT " + blocks.Blocked("$1", "p.user_id") + ")")
+
+	opts := FeedOptions{Limit: len(ids)}
+	query, args, err := q.build(opts)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrPostNotFound
-		}
 		return nil, err
 	}
-	return imageKey, nil
-}
-
-func (r *Repository) GetHomeFeed(ctx context.Context, userID uuid.UUID, opts FeedOptions) (*Timeline, error) {
-	if opts.Limit <= 0 {
-		opts.Limit = 20
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
-	if opts.Limit > 100 {
-		opts.Limit = 100
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get posts from followed users + own posts
-	rows, err := r.db.Query(ctx, `
-		SELECT 
-			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-			EXISTS(SELECT 1 FROM likes WHERE user_id = $1 AND post_id = p.id) as is_liked,
-			EXISTS(SELECT 1 FROM posts WHERE user_id = $1 AND reblog_of_id = p.id) as is_reblogged
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.user_id = $1 
-		   OR p.user_id IN (SELECT following_id FROM follows WHERE follower_id = $1)
-		ORDER BY p.created_at DESC
-		LIMIT $2 OFFSET $3
-	`, userID, opts.Limit+1, opts.Offset)
+	timeline, err := r.buildTimeline(ctx, posts, opts, viewerID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	for i := range timeline.Posts {
+		found[timeline.Posts[i].ID] = &timeline.Posts[i]
+	}
+	return found, nil
+}
 
-	return r.scanTimeline(ctx, rows, opts, &userID)
+// Delete removes one of userID's posts and returns the storage keys of its
+// images, which the caller should delete.
+func (r *Repository) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) ([]string, error) {
+	return r.delete(ctx, id, &userID, nil)
 }
 
-func (r *Repository) GetPublicFeed(ctx context.Context, opts FeedOptions) (*Timeline, error) {
-	if opts.Limit <= 0 {
-		opts.Limit = 20
+// Remove deletes any user's post on behalf of adminID and records it in
+// the audit log, with the content so the log still shows what was removed.
+// Like Delete, it returns the storage keys of the post's images.
+func (r *Repository) Remove(ctx context.Context, adminID, id uuid.UUID, reason string) ([]string, error) {
+	return r.delete(ctx, id, nil, func(tx pgx.Tx, authorID uuid.UUID, content *string) error {
+		return audit.Record(ctx, tx, audit.Entry{
+			ActorID:    adminID,
+			Action:     audit.ActionRemovePost,
+			TargetType: audit.TargetPost,
+			TargetID:   id,
+			Details:    map[string]any{"user_id": authorID, "content": content, "reason": reason},
+		})
+	})
+}
+
+// delete deletes a post, only if it belongs to userID when that is set.
+// record runs in the same transaction, before the post is gone.
+func (r *Repository) delete(ctx context.Context, id uuid.UUID, userID *uuid.UUID, record func(tx pgx.Tx, authorID uuid.UUID, content *string) error) ([]string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if opts.Limit > 100 {
-		opts.Limit = 100
+	defer tx.Rollback(ctx)
+
+	var authorID uuid.UUID
+	var content, imageKey *string
+	err = tx.QueryRow(ctx, `
+		SELECT user_id, content, image_key FROM posts
+		WHERE id = $1 AND ($2::uuid IS NULL OR user_id = $2)
+		FOR UPDATE
+	`, id, userID).Scan(&authorID, &content, &imageKey)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
 	}
 
-	query := `
-		SELECT 
-			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-			CASE WHEN $3::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM likes WHERE user_id = $3 AND post_id = p.id)
-			ELSE false END as is_liked,
-			CASE WHEN $3::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM posts WHERE user_id = $3 AND reblog_of_id = p.id)
-			ELSE false END as is_reblogged
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.reply_to_id IS NULL
-		ORDER BY p.created_at DESC
-		LIMIT $1 OFFSET $2
-	`
-	if opts.Sort == "controversial" {
-		query = `
-			SELECT 
-				p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-				p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-				p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-				u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-				CASE WHEN $3::uuid IS NOT NULL THEN
-					EXISTS(SELECT 1 FROM likes WHERE user_id = $3 AND post_id = p.id)
-				ELSE false END as is_liked,
-				CASE WHEN $3::uuid IS NOT NULL THEN
-					EXISTS(SELECT 1 FROM posts WHERE user_id = $3 AND reblog_of_id = p.id)
-				ELSE false END as is_reblogged
-			FROM posts p
-			JOIN users u ON p.user_id = u.id
-			WHERE p.reply_to_id IS NULL
-			ORDER BY p.controversy_score DESC, p.created_at DESC
-			LIMIT $1 OFFSET $2
-		`
-	}
-
-	rows, err := r.db.Query(ctx, query, opts.Limit+1, opts.Offset, opts.ViewerID)
+	media, err := postMedia(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	return r.scanTimeline(ctx, rows, opts, opts.ViewerID)
+	if record != nil {
+		if err := record(tx, authorID, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM posts WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	// image_key mirrors the first attachment, except on posts from before
+	// attachments
+	var keys []string
+	if imageKey != nil && len(media) == 0 {
+		keys = append(keys, *imageKey)
+	}
+	for _, m := range media {
+		for _, key := range m.Keys() {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (r *Repository) GetHomeFeed(ctx context.Context, userID uuid.UUID, opts FeedOptions) (*Timeline, error) {
+	// Get posts from followed users + own posts
+	q := newTimelineQuery(&userID, orderNewest)
+	q.filter("(p.user_id = $1 OR p.user_id IN (SELECT following_id FROM follows WHERE follower_id = $1))")
+	q.hideMuted()
+	q.applyFilters(opts.Filters)
+
+	return r.queryTimeline(ctx, q, opts, &userID)
+}
+
+func (r *Repository) GetPublicFeed(ctx context.Context, opts FeedOptions) (*Timeline, error) {
+	order := orderNewest
+	if opts.Sort == "controversial" {
+		order = orderControversial
+	}
+
+	q := newTimelineQuery(opts.ViewerID, order)
+	q.filter("p.reply_to_id IS NULL")
+	q.applyFilters(opts.Filters)
+
+	return r.queryTimeline(ctx, q, opts, opts.ViewerID)
 }
 
 func (r *Repository) GetUserPosts(ctx context.Context, userID uuid.UUID, opts FeedOptions) (*Timeline, error) {
-	if opts.Limit <= 0 {
-		opts.Limit = 20
+	q := newTimelineQuery(opts.ViewerID, orderNewest)
+	q.filter("p.user_id = " + q.bind(userID))
+
+	return r.queryTimeline(ctx, q, opts, opts.ViewerID)
+}
+
+func (r *Repository) GetTagFeed(ctx context.Context, tag string, opts FeedOptions) (*Timeline, error) {
+	if normalized, err := NormalizeTag(tag); err == nil {
+		tag = normalized
 	}
-	if opts.Limit > 100 {
-		opts.Limit = 100
+
+	q := newTimelineQuery(opts.ViewerID, orderNewest)
+	q.join("JOIN post_tags pt ON p.id = pt.post_id")
+	q.join("JOIN tags t ON pt.tag_id = t.id")
+	q.filter("LOWER(t.name) = LOWER(" + q.bind(tag) + ")")
+
+	return r.queryTimeline(ctx, q, opts, opts.ViewerID)
+}
+
+func (r *Repository) GetReplies(ctx context.Context, postID uuid.UUID, opts FeedOptions) (*Timeline, error) {
+	q := newTimelineQuery(opts.ViewerID, orderOldest)
+	q.filter("p.reply_to_id = " + q.bind(postID))
+	if opts.ViewerID != nil {
+		q.hideMuted()
 	}
 
-	rows, err := r.db.Query(ctx, `
-		SELECT 
-			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM likes WHERE user_id = $4 AND post_id = p.id)
-			ELSE false END as is_liked,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM posts WHERE user_id = $4 AND reblog_of_id = p.id)
-			ELSE false END as is_reblogged
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.user_id = $1
-		ORDER BY p.created_at DESC
-		LIMIT $2 OFFSET $3
-	`, userID, opts.Limit+1, opts.Offset, opts.ViewerID)
+	return r.queryTimeline(ctx, q, opts, opts.ViewerID)
+}
+
+func (r *Repository) queryTimeline(ctx context.Context, q *timelineQuery, opts FeedOptions, viewerID *uuid.UUID) (*Timeline, error) {
+	normalizeLimit(&opts)
+
+	query, args, err := q.build(opts)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	return r.scanTimeline(ctx, rows, opts, opts.ViewerID)
-}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
-func (r *Repository) GetTagFeed(ctx context.Context, tag string, opts FeedOptions) (*Timeline, error) {
-	if opts.Limit <= 0 {
-		opts.Limit = 20
+	timeout := strconv.FormatInt(feedTimeout.Milliseconds(), 10)
+	if _, err := tx.Exec(ctx, `SELECT set_config('statement_timeout', $1, true)`, timeout); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, feedError(err)
+	}
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, feedError(err)
 	}
-	if opts.Limit > 100 {
-		opts.Limit = 100
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
 	}
 
-	rows, err := r.db.Query(ctx, `
-		SELECT 
-			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM likes WHERE user_id = $4 AND post_id = p.id)
-			ELSE false END as is_liked,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM posts WHERE user_id = $4 AND reblog_of_id = p.id)
-			ELSE false END as is_reblogged
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		JOIN post_tags pt ON p.id = pt.post_id
-		JOIN tags t ON pt.tag_id = t.id
-		WHERE LOWER(t.name) = LOWER($1)
-		ORDER BY p.created_at DESC
-		LIMIT $2 OFFSET $3
-	`, tag, opts.Limit+1, opts.Offset, opts.ViewerID)
+	timeline, err := r.buildTimeline(ctx, posts, opts, viewerID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	return r.scanTimeline(ctx, rows, opts, opts.ViewerID)
+	// Pending posts are paged by offset; see orderSchedule
+	if timeline.HasMore && q.order != orderSchedule {
+		timeline.NextCursor = q.cursorFor(&timeline.Posts[len(timeline.Posts)-1]).Encode()
+	}
+
+	return timeline, nil
 }
 
-func (r *Repository) scanTimeline(ctx context.Context, rows pgx.Rows, opts FeedOptions, viewerID *uuid.UUID) (*Timeline, error) {
+// feedError reports a feed query the database cancelled for running past
+// feedTimeout as ErrFeedTimeout.
+func feedError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "57014" { // query_canceled
+		return ErrFeedTimeout
+	}
+	return err
+}
+
+// scanPosts reads the rows of a timeline query, closing them.
+func scanPosts(rows pgx.Rows) ([]Post, error) {
+	defer rows.Close()
+
 	posts := []Post{}
 	for rows.Next() {
 		var post Post
@@ -381,9 +602,9 @@ func (r *Repository) scanTimeline(ctx context.Context, rows pgx.Rows, opts FeedO
 			&post.ID, &post.UserID, &post.Content, &post.ImageURL, &post.ReblogOfID,
 			&post.ReblogComment, &post.ReplyToID, &post.LikeCount, &post.ReblogCount,
 			&post.ReplyCount, &post.SentimentScore, &post.SentimentLabel, &post.ControversyScore,
-			&post.CreatedAt, &post.UpdatedAt,
+			&post.CreatedAt, &post.UpdatedAt, &post.ImageAlt, &post.EditedAt, &post.State, &post.PublishAt,
 			&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.IsAgent,
-			&isLiked, &isReblogged,
+			&isLiked, &isReblogged, &post.Rank, &post.FilterReasons,
 		)
 		if err != nil {
 			return nil, err
@@ -392,9 +613,16 @@ func (r *Repository) scanTimeline(ctx context.Context, rows pgx.Rows, opts FeedO
 		post.User = &user
 		post.IsLiked = isLiked
 		post.IsReblogged = isReblogged
+		post.Filtered = len(post.FilterReasons) > 0
 		posts = append(posts, post)
 	}
 
+	return posts, rows.Err()
+}
+
+// buildTimeline pages posts and fills in what the timeline query does not
+// select: tags, mentions, media and reblogged posts.
+func (r *Repository) buildTimeline(ctx context.Context, posts []Post, opts FeedOptions, viewerID *uuid.UUID) (*Timeline, error) {
 	hasMore := len(posts) > opts.Limit
 	if hasMore {
 		posts = posts[:opts.Limit]
@@ -430,6 +658,13 @@ func (r *Repository) scanTimeline(ctx context.Context, rows pgx.Rows, opts FeedO
 			}
 		}
 
+		if err := r.loadMentions(ctx, postMap); err != nil {
+			return nil, err
+		}
+		if err := r.loadMedia(ctx, postMap); err != nil {
+			return nil, err
+		}
+
 		// Fetch reblog sources
 		for i := range posts {
 			if posts[i].ReblogOfID != nil {
@@ -441,13 +676,19 @@ func (r *Repository) scanTimeline(ctx context.Context, rows pgx.Rows, opts FeedO
 		}
 	}
 
-	return &Timeline{
-		Posts:      posts,
-		NextOffset: opts.Offset + len(posts),
-		HasMore:    hasMore,
-	}, nil
+	timeline := &Timeline{
+		Posts:   posts,
+		HasMore: hasMore,
+	}
+	if opts.Cursor == nil {
+		timeline.NextOffset = opts.Offset + len(posts)
+	}
+
+	return timeline, nil
 }
 
+// Like likes a post for userID. It returns blocks.ErrBlocked if the
+// post's author and userID have blocked each other.
 func (r *Repository) Like(ctx context.Context, userID, postID uuid.UUID) error {
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
@@ -455,7 +696,19 @@ func (r *Repository) Like(ctx context.Context, userID, postID uuid.UUID) error {
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, `
+	var authorID uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT user_id FROM posts WHERE id = $1`, postID).Scan(&authorID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrPostNotFound
+		}
+		return err
+	}
+	if err := checkBlocked(ctx, tx, userID, authorID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(ctx, `
 		INSERT INTO likes (user_id, post_id) VALUES ($1, $2)
 		ON CONFLICT DO NOTHING
 	`, userID, postID)
@@ -463,13 +716,36 @@ func (r *Repository) Like(ctx context.Context, userID, postID uuid.UUID) error {
 		return err
 	}
 
-	_, err = tx.Exec(ctx, `
+	var likeCount int
+	err = tx.QueryRow(ctx, `
 		UPDATE posts SET like_count = (SELECT COUNT(*) FROM likes WHERE post_id = $1) WHERE id = $1
-	`, postID)
+		RETURNING like_count
+	`, postID).Scan(&likeCount)
 	if err != nil {
 		return err
 	}
 
+	var live []*stream.Event
+	if result.RowsAffected() > 0 {
+		n, err := notifications.Notify(ctx, tx, notifications.Event{
+			Type: notifications.TypeLike, UserID: authorID, ActorID: userID, PostID: &postID,
+		})
+		if err != nil {
+			return err
+		}
+
+		like, err := stream.NewEvent(stream.EventLike, userID, map[string]interface{}{
+			"post_id":    postID,
+			"user_id":    userID,
+			"like_count": likeCount,
+		})
+		if err != nil {
+			return err
+		}
+		like.Public = true
+		live = append(live, &like, n)
+	}
+
 	_, err = tx.Exec(ctx, `
 		UPDATE posts
 		SET controversy_score = (reply_count + 1) * (ABS(sentiment_score) + 0.25) / (like_count + 1)
@@ -488,7 +764,12 @@ func (r *Repository) Like(ctx context.Context, userID, postID uuid.UUID) error {
 		return err
 	}
 
-	return tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	stream.PublishAll(ctx, r.events, live...)
+	return nil
 }
 
 func (r *Repository) Unlike(ctx context.Context, userID, postID uuid.UUID) error {
@@ -512,34 +793,3 @@ func (r *Repository) Unlike(ctx context.Context, userID, postID uuid.UUID) error
 
 	return tx.Commit(ctx)
 }
-
-func (r *Repository) GetReplies(ctx context.Context, postID uuid.UUID, opts FeedOptions) (*Timeline, error) {
-	if opts.Limit <= 0 {
-		opts.Limit = 20
-	}
-
-	rows, err := r.db.Query(ctx, `
-		SELECT 
-			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
-			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
-			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
-			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM likes WHERE user_id = $4 AND post_id = p.id)
-			ELSE false END as is_liked,
-			CASE WHEN $4::uuid IS NOT NULL THEN
-				EXISTS(SELECT 1 FROM posts WHERE user_id = $4 AND reblog_of_id = p.id)
-			ELSE false END as is_reblogged
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.reply_to_id = $1
-		ORDER BY p.created_at ASC
-		LIMIT $2 OFFSET $3
-	`, postID, opts.Limit+1, opts.Offset, opts.ViewerID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	return r.scanTimeline(ctx, rows, opts, opts.ViewerID)
-}
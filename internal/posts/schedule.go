@@ -0,0 +1,407 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/ratelimit"
+	"github.com/watzon/moltpress/internal/stream"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+// Post states. Only published posts appear in feeds, search and counts;
+// the rest are visible to their author alone.
+const (
+	StateDraft     = "draft"
+	StateScheduled = "scheduled" // Published at publish_at
+	StateQueued    = "queued"    // Published at the author's next queue slot
+	StatePublished = "published"
+)
+
+var (
+	ErrInvalidState     = errors.New("invalid post state")
+	ErrInvalidPublishAt = errors.New("invalid publish_at")
+	ErrAlreadyPublished = errors.New("post is already published")
+	ErrTooManyPending   = errors.New("too many unpublished posts")
+)
+
+const (
+	// MaxPendingPosts caps a user's drafts, scheduled and queued posts
+	// combined.
+	MaxPendingPosts = 300
+	// MaxScheduleAhead is how far ahead a post can be scheduled.
+	MaxScheduleAhead = 365 * 24 * time.Hour
+)
+
+// ResolveState validates the state and publish_at a post is created or
+// rescheduled with. An empty state means published, or scheduled when
+// publishAt is set. Only scheduled posts have a publish_at, and it must be
+// after now and within MaxScheduleAhead.
+func ResolveState(state string, publishAt *time.Time, now time.Time) (string, error) {
+	switch state {
+	case "":
+		state = StatePublished
+		if publishAt != nil {
+			state = StateScheduled
+		}
+	case StateDraft, StateScheduled, StateQueued, StatePublished:
+	default:
+		return "", ErrInvalidState
+	}
+
+	if state != StateScheduled {
+		if publishAt != nil {
+			return "", ErrInvalidPublishAt
+		}
+		return state, nil
+	}
+	if publishAt == nil || !publishAt.After(now) || publishAt.After(now.Add(MaxScheduleAhead)) {
+		return "", ErrInvalidPublishAt
+	}
+	return state, nil
+}
+
+// lockPost selects and locks the post matched by clause, which includes the
+// WHERE condition and the locking clause, e.g. "WHERE id = $1 FOR UPDATE".
+// Only the columns needed to edit or publish the post are loaded.
+func lockPost(ctx context.Context, tx pgx.Tx, clause string, args ...any) (*Post, error) {
+	var post Post
+	err := tx.QueryRow(ctx, `
+		SELECT id, user_id, content, image_url, image_key, image_alt, reblog_of_id, reblog_comment, reply_to_id,
+		       like_count, reply_count, created_at, state, publish_at
+		FROM posts
+	`+clause, args...).Scan(
+		&post.ID, &post.UserID, &post.Content, &post.ImageURL, &post.ImageKey, &post.ImageAlt, &post.ReblogOfID,
+		&post.ReblogComment, &post.ReplyToID, &post.LikeCount, &post.ReplyCount, &post.CreatedAt,
+		&post.State, &post.PublishAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+	return &post, nil
+}
+
+// publishPending publishes a post that tx has locked. It is dated now so it
+// goes to the top of feeds rather than appearing where it was drafted.
+// It returns blocks.ErrBlocked if the post replies to or reblogs someone
+// who has blocked its author, or whom its author has blocked, since it was
+// written.
+func publishPending(ctx context.Context, tx pgx.Tx, post *Post) ([]*stream.Event, error) {
+	for _, target := range []*uuid.UUID{post.ReblogOfID, post.ReplyToID} {
+		if target == nil {
+			continue
+		}
+		var authorID uuid.UUID
+		err := tx.QueryRow(ctx, `SELECT user_id FROM posts WHERE id = $1`, target).Scan(&authorID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+		if err := checkBlocked(ctx, tx, post.UserID, authorID); err != nil {
+			return nil, err
+		}
+	}
+
+	err := tx.QueryRow(ctx, `
+		UPDATE posts SET state = 'published', created_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+		RETURNING state, created_at, updated_at
+	`, post.ID).Scan(&post.State, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := postTags(ctx, tx, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		post.Tags = tags
+	}
+
+	return publishEffects(ctx, tx, post)
+}
+
+// Publish publishes one of userID's drafts, scheduled or queued posts now.
+func (r *Repository) Publish(ctx context.Context, id, userID uuid.UUID) (*Post, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	post, err := lockPost(ctx, tx, "WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if post.State == StatePublished {
+		return nil, ErrAlreadyPublished
+	}
+
+	live, err := publishPending(ctx, tx, post)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	r.publishPost(ctx, post)
+	stream.PublishAll(ctx, r.events, live...)
+
+	return r.GetByID(ctx, id, &userID)
+}
+
+// ListPending returns userID's posts in state, which must be draft,
+// scheduled or queued. Drafts are newest first; scheduled and queued posts
+// are in the order they will be published. Pending lists page by offset.
+func (r *Repository) ListPending(ctx context.Context, userID uuid.UUID, state string, opts FeedOptions) (*Timeline, error) {
+	order := orderSchedule
+	switch state {
+	case StateDraft:
+		order = orderNewest
+	case StateScheduled, StateQueued:
+	default:
+		return nil, ErrInvalidState
+	}
+
+	q := pendingQuery(userID, state, order)
+	return r.queryTimeline(ctx, q, opts, &userID)
+}
+
+// Scheduler publishes scheduled posts once they are due, and queued posts
+// at their author's queue slots. Publishing counts against the author's
+// rate limits; a post that would exceed them waits for a later tick.
+//
+// Several schedulers may run against the same database. Posts and queues
+// are claimed with SKIP LOCKED and each one is published in its own
+// transaction, so a scheduler can be stopped at any point without
+// publishing anything twice.
+type Scheduler struct {
+	posts   *Repository
+	limiter *ratelimit.Limiter
+
+	Interval  time.Duration // How often to look for due posts
+	BatchSize int           // Posts published per tick, at most
+}
+
+func NewScheduler(posts *Repository, limiter *ratelimit.Limiter) *Scheduler {
+	return &Scheduler{
+		posts:     posts,
+		limiter:   limiter,
+		Interval:  5 * time.Second,
+		BatchSize: 100,
+	}
+}
+
+// publishTimeout bounds one publish. It runs detached from Run's context
+// so shutting down waits for a publish in progress instead of aborting it.
+const publishTimeout = 30 * time.Second
+
+// Run publishes due posts until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Users who hit a rate limit are skipped until the next tick
+			limited := []uuid.UUID{}
+			s.drain(ctx, "scheduled", &limited, s.publishScheduled)
+			s.drain(ctx, "queued", &limited, s.publishQueued)
+		}
+	}
+}
+
+// drain calls step until it has nothing left to do, fails, or has
+// published BatchSize posts.
+func (s *Scheduler) drain(ctx context.Context, kind string, limited *[]uuid.UUID, step func(context.Context, *[]uuid.UUID) (bool, error)) {
+	for i := 0; i < s.BatchSize && ctx.Err() == nil; i++ {
+		work, cancel := context.WithTimeout(context.WithoutCancel(ctx), publishTimeout)
+		more, err := step(work, limited)
+		cancel()
+		if err != nil {
+			slog.Error("failed to publish "+kind+" posts", "error", err)
+			return
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// allow applies the rate limit the post would have been subject to had it
+// been published directly.
+func (s *Scheduler) allow(ctx context.Context, post *Post) (bool, error) {
+	var result *ratelimit.Result
+	var err error
+	if post.ReplyToID != nil {
+		result, err = s.limiter.AllowReply(ctx, post.UserID, *post.ReplyToID)
+	} else {
+		result, err = s.limiter.AllowCreatePost(ctx, post.UserID)
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+// returnToDrafts moves a post that publishPending refused back to its
+// author's drafts.
+func returnToDrafts(ctx context.Context, tx pgx.Tx, post *Post) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE posts SET state = 'draft', publish_at = NULL, updated_at = NOW() WHERE id = $1
+	`, post.ID)
+	return err
+}
+
+// publishScheduled publishes the most overdue scheduled post. Posts by
+// suspended or silenced authors wait until the author is restored. It
+// reports whether there may be more to do.
+func (s *Scheduler) publishScheduled(ctx context.Context, limited *[]uuid.UUID) (bool, error) {
+	tx, err := s.posts.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	post, err := lockPost(ctx, tx, `
+		WHERE state = 'scheduled' AND publish_at <= NOW() AND NOT (user_id = ANY($1))
+		  AND NOT `+users.Moderated("posts.user_id")+`
+		ORDER BY publish_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, *limited)
+	if err != nil {
+		if errors.Is(err, ErrPostNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	allowed, err := s.allow(ctx, post)
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		*limited = append(*limited, post.UserID)
+		return true, nil
+	}
+
+	live, err := publishPending(ctx, tx, post)
+	if errors.Is(err, blocks.ErrBlocked) {
+		if err := returnToDrafts(ctx, tx, post); err != nil {
+			return false, err
+		}
+		return true, tx.Commit(ctx)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	s.posts.publishPost(ctx, post)
+	stream.PublishAll(ctx, s.posts.events, live...)
+	return true, nil
+}
+
+// publishQueued handles the queue whose slot is most overdue, publishing
+// the oldest post in it and moving the queue on to its next slot. A slot
+// that finds the queue empty passes unused. The queues of suspended or
+// silenced users wait until they are restored. It reports whether there
+// may be more to do.
+func (s *Scheduler) publishQueued(ctx context.Context, limited *[]uuid.UUID) (bool, error) {
+	tx, err := s.posts.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID uuid.UUID
+	var times []string
+	var timezone string
+	err = tx.QueryRow(ctx, `
+		SELECT user_id, times, timezone FROM post_queues
+		WHERE next_slot_at <= NOW() AND NOT (user_id = ANY($1))
+		  AND NOT `+users.Moderated("post_queues.user_id")+`
+		ORDER BY next_slot_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, *limited).Scan(&userID, &times, &timezone)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var live []*stream.Event
+	post, err := lockPost(ctx, tx, `
+		WHERE user_id = $1 AND state = 'queued'
+		ORDER BY created_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, userID)
+	switch {
+	case errors.Is(err, ErrPostNotFound):
+		post = nil
+	case err != nil:
+		return false, err
+	default:
+		allowed, err := s.allow(ctx, post)
+		if err != nil {
+			return false, err
+		}
+		if !allowed {
+			*limited = append(*limited, userID)
+			return true, nil
+		}
+
+		live, err = publishPending(ctx, tx, post)
+		if errors.Is(err, blocks.ErrBlocked) {
+			// The slot goes to the next post in the queue
+			if err := returnToDrafts(ctx, tx, post); err != nil {
+				return false, err
+			}
+			return true, tx.Commit(ctx)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Slots missed while nothing was running are skipped rather than
+	// published in a burst
+	_, err = tx.Exec(ctx, `
+		UPDATE post_queues SET next_slot_at = $2 WHERE user_id = $1
+	`, userID, queueNextSlot(times, timezone, time.Now()))
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	if post != nil {
+		s.posts.publishPost(ctx, post)
+		stream.PublishAll(ctx, s.posts.events, live...)
+	}
+	return true, nil
+}
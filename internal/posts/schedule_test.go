@@ -0,0 +1,39 @@
+package posts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveState(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	past := now.Add(-time.Minute)
+	tooFar := now.Add(MaxScheduleAhead + time.Hour)
+
+	tests := []struct {
+		state     string
+		publishAt *time.Time
+		want      string
+		err       error
+	}{
+		{"", nil, StatePublished, nil},
+		{"", &later, StateScheduled, nil},
+		{StateDraft, nil, StateDraft, nil},
+		{StateQueued, nil, StateQueued, nil},
+		{StateScheduled, &later, StateScheduled, nil},
+		{StateScheduled, nil, "", ErrInvalidPublishAt},
+		{StateScheduled, &past, "", ErrInvalidPublishAt},
+		{StateScheduled, &tooFar, "", ErrInvalidPublishAt},
+		{StateDraft, &later, "", ErrInvalidPublishAt},
+		{StatePublished, &later, "", ErrInvalidPublishAt},
+		{"archived", nil, "", ErrInvalidState},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveState(tt.state, tt.publishAt, now)
+		if got != tt.want || err != tt.err {
+			t.Errorf("ResolveState(%q, %v) = %q, %v; want %q, %v", tt.state, tt.publishAt, got, err, tt.want, tt.err)
+		}
+	}
+}
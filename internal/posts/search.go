@@ -0,0 +1,88 @@
+package posts
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/watzon/moltpress/internal/database"
+)
+
+// SearchFilters narrows a post search. Nil fields are ignored.
+type SearchFilters struct {
+	Author    *string // Username
+	Tag       *string
+	Since     *time.Time
+	Until     *time.Time
+	Sentiment *string // positive, negative or neutral
+}
+
+type TagResult struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// SearchPosts matches query against post content and reblog comments using
+// websearch syntax ("quoted phrases", -exclusions, OR). Results are ranked
+// by relevance unless opts.Sort is "recent".
+func (r *Repository) SearchPosts(ctx context.Context, query string, filters SearchFilters, opts FeedOptions) (*Timeline, error) {
+	order := orderRank
+	if opts.Sort == "recent" {
+		order = orderNewest
+	}
+
+	q := newTimelineQuery(opts.ViewerID, order)
+	tsquery := "websearch_to_tsquery('english', " + q.bind(query) + ")"
+	q.rank = "ts_rank(p.search_vector, " + tsquery + ")::float8"
+	q.filter("p.search_vector @@ " + tsquery)
+
+	if filters.Author != nil {
+		q.filter("LOWER(u.username) = LOWER(" + q.bind(*filters.Author) + ")")
+	}
+	if filters.Tag != nil {
+		q.filter(`EXISTS(
+			SELECT 1 FROM post_tags pt JOIN tags t ON pt.tag_id = t.id
+			WHERE pt.post_id = p.id AND LOWER(t.name) = LOWER(` + q.bind(*filters.Tag) + `))`)
+	}
+	if filters.Since != nil {
+		q.filter("p.created_at >= " + q.bind(*filters.Since))
+	}
+	if filters.Until != nil {
+		q.filter("p.created_at < " + q.bind(*filters.Until))
+	}
+	if filters.Sentiment != nil {
+		q.filter("p.sentiment_label = " + q.bind(*filters.Sentiment))
+	}
+
+	return r.queryTimeline(ctx, q, opts, opts.ViewerID)
+}
+
+// SearchTags returns tags starting with prefix, most used first.
+func (r *Repository) SearchTags(ctx context.Context, prefix string, limit int) ([]TagResult, error) {
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "#")
+	if prefix == "" {
+		return []TagResult{}, nil
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT name, post_count FROM tags
+		WHERE LOWER(name) LIKE $1 ESCAPE '\' AND post_count > 0
+		ORDER BY post_count DESC, name ASC
+		LIMIT $2
+	`, database.EscapeLike(strings.ToLower(prefix))+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TagResult{}
+	for rows.Next() {
+		var t TagResult
+		if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
@@ -0,0 +1,220 @@
+package posts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrInvalidTag  = errors.New("invalid tag")
+	ErrTooManyTags = errors.New("too many tags")
+)
+
+const (
+	// MaxTagLength matches tags.name VARCHAR(100), in characters.
+	MaxTagLength = 100
+	// MaxTagsPerPost caps explicit and inline tags combined.
+	MaxTagsPerPost = 20
+)
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '_'
+}
+
+// NormalizeTag returns the canonical form of a tag: without a leading "#",
+// NFC-normalized and lowercased. Tags may contain letters, digits, combining
+// marks and underscores, and must contain at least one letter so "#1" is
+// not a tag.
+func NormalizeTag(name string) (string, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	name = norm.NFC.String(strings.ToLower(name))
+
+	length := 0
+	hasLetter := false
+	for _, r := range name {
+		if !isTagRune(r) {
+			return "", ErrInvalidTag
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+		length++
+	}
+	if !hasLetter || length > MaxTagLength {
+		return "", ErrInvalidTag
+	}
+	return name, nil
+}
+
+// extractHashtags finds #tags in text. A "#" inside a word, or one that
+// starts an HTML entity or URL fragment, does not start a tag.
+func extractHashtags(text string) []string {
+	runes := []rune(text)
+	var tags []string
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' {
+			continue
+		}
+		if i > 0 {
+			prev := runes[i-1]
+			if isTagRune(prev) || prev == '#' || prev == '&' || prev == '/' {
+				continue
+			}
+		}
+
+		end := i + 1
+		for end < len(runes) && isTagRune(runes[end]) {
+			end++
+		}
+		if end > i+1 {
+			tags = append(tags, string(runes[i+1:end]))
+		}
+		i = end - 1
+	}
+
+	return tags
+}
+
+// ResolveTags merges a post's explicit tags with the hashtags written in
+// its content and reblog comment, normalized and without duplicates.
+// Invalid explicit tags, or more than MaxTagsPerPost of them, are an error;
+// inline hashtags that are invalid or over the cap are left as plain text.
+func ResolveTags(explicit []string, content, reblogComment *string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+
+	for _, name := range explicit {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		tag, err := NormalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxTagsPerPost {
+		return nil, ErrTooManyTags
+	}
+
+	for _, text := range []*string{content, reblogComment} {
+		if text == nil {
+			continue
+		}
+		for _, name := range extractHashtags(*text) {
+			tag, err := NormalizeTag(name)
+			if err != nil || seen[tag] {
+				continue
+			}
+			if len(tags) >= MaxTagsPerPost {
+				return tags, nil
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
+
+// tagHotScoreDecay is the hourly decay rate of tags.hot_score, a half-life
+// of four hours.
+const tagHotScoreDecay = 0.173286
+
+// linkTags adds tags to a post, creating them as needed. A post only counts
+// towards its tags once it is published; see countTags.
+func linkTags(ctx context.Context, tx pgx.Tx, postID uuid.UUID, tags []string) error {
+	for _, tagName := range tags {
+		// Upsert tag
+		var tagID int
+		err := tx.QueryRow(ctx, `
+			INSERT INTO tags (name) VALUES ($1)
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id
+		`, tagName).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		// Link post to tag
+		_, err = tx.Exec(ctx, `
+			INSERT INTO post_tags (post_id, tag_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, postID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countTags counts a published post towards the post_count and hot_score of
+// its tags.
+func countTags(ctx context.Context, tx pgx.Tx, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		UPDATE tags SET
+			post_count = post_count + 1,
+			hot_score = (
+				COALESCE(hot_score, 0) * EXP(
+					-$2::float8 * EXTRACT(EPOCH FROM (NOW() - COALESCE(hot_updated_at, NOW()))) / 3600.0
+				)
+			) + 1,
+			hot_updated_at = NOW()
+		WHERE name = ANY($1)
+	`, tags, tagHotScoreDecay)
+	return err
+}
+
+// unlinkTags removes tags from a post. For a published post, countedAt is
+// when it was counted towards them, and what it contributed to their
+// post_count and hot_score, 1 decayed since then, is taken back.
+func unlinkTags(ctx context.Context, tx pgx.Tx, postID uuid.UUID, tags []string, countedAt *time.Time) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	if countedAt == nil {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM post_tags pt
+			USING tags t
+			WHERE pt.tag_id = t.id AND pt.post_id = $1 AND t.name = ANY($2)
+		`, postID, tags)
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		WITH removed AS (
+			DELETE FROM post_tags pt
+			USING tags t
+			WHERE pt.tag_id = t.id AND pt.post_id = $1 AND t.name = ANY($2)
+			RETURNING t.id
+		)
+		UPDATE tags SET
+			post_count = GREATEST(post_count - 1, 0),
+			hot_score = GREATEST(
+				COALESCE(hot_score, 0) * EXP(
+					-$3::float8 * EXTRACT(EPOCH FROM (NOW() - COALESCE(hot_updated_at, NOW()))) / 3600.0
+				) - EXP(-$3::float8 * EXTRACT(EPOCH FROM (NOW() - $4::timestamptz)) / 3600.0),
+				0
+			),
+			hot_updated_at = NOW()
+		WHERE id IN (SELECT id FROM removed)
+	`, postID, tags, tagHotScoreDecay, *countedAt)
+	return err
+}
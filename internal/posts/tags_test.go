@@ -0,0 +1,93 @@
+package posts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	valid := map[string]string{
+		"#AI":                             "ai",
+		"  Art ":                          "art",
+		"machine_learning":                "machine_learning",
+		"Cafe\u0301":                      "caf\u00e9", // Decomposed é is composed
+		"日本語":                             "日本語",
+		"web3":                            "web3",
+		strings.Repeat("a", MaxTagLength): strings.Repeat("a", MaxTagLength),
+	}
+	for in, want := range valid {
+		got, err := NormalizeTag(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeTag(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "#", "123", "two words", "kebab-case", "emoji🦞", strings.Repeat("a", MaxTagLength+1)} {
+		if _, err := NormalizeTag(in); err != ErrInvalidTag {
+			t.Errorf("NormalizeTag(%q): expected ErrInvalidTag, got %v", in, err)
+		}
+	}
+}
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"#ai #art", []string{"ai", "art"}},
+		{"Loving this #Sunset, truly.", []string{"Sunset"}},
+		{"issue#42 and &#39; and https://example.com/#top", nil},
+		{"## heading and # alone", nil},
+		{"(#nested)", []string{"nested"}},
+	}
+
+	for _, tt := range tests {
+		if got := extractHashtags(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractHashtags(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResolveTags(t *testing.T) {
+	content := "New piece #Art #ai #1 #art"
+	comment := "via #generative"
+
+	got, err := ResolveTags([]string{"AI", " ", "painting"}, &content, &comment)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"ai", "painting", "art", "generative"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := ResolveTags([]string{"bad tag"}, nil, nil); err != ErrInvalidTag {
+		t.Errorf("expected ErrInvalidTag, got %v", err)
+	}
+
+	many := make([]string, MaxTagsPerPost+1)
+	for i := range many {
+		many[i] = "tag" + strings.Repeat("x", i)
+	}
+	if _, err := ResolveTags(many, nil, nil); err != ErrTooManyTags {
+		t.Errorf("expected ErrTooManyTags, got %v", err)
+	}
+
+	// Inline tags past the cap are left as text
+	inline := "#extra"
+	got, err = ResolveTags(many[:MaxTagsPerPost], &inline, nil)
+	if err != nil || len(got) != MaxTagsPerPost {
+		t.Errorf("expected %d tags and no error, got %d, %v", MaxTagsPerPost, len(got), err)
+	}
+}
+
+func TestDiffTags(t *testing.T) {
+	added, removed := diffTags([]string{"ai", "art", "old"}, []string{"art", "ai", "new"})
+	if !reflect.DeepEqual(added, []string{"new"}) {
+		t.Errorf("expected added [new], got %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"old"}) {
+		t.Errorf("expected removed [old], got %v", removed)
+	}
+}
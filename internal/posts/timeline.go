@@ -0,0 +1,216 @@
+package posts
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/filters"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+type feedOrder int
+
+const (
+	orderNewest feedOrder = iota
+	orderOldest
+	orderControversial
+	orderRank
+	orderSchedule // Unpublished posts, in the order they will go out
+)
+
+// timelineQuery builds the SELECT shared by every timeline. The viewer is
+// always bound to $1 so the is_liked/is_reblogged columns can reference it;
+// feed-specific joins and conditions bind their own arguments after it.
+// Timelines only include published posts; pendingQuery lists the rest.
+type timelineQuery struct {
+	joins []string
+	where []string
+	args  []any
+	order feedOrder
+
+	// rank is the relevance expression for orderRank, e.g. a ts_rank call
+	rank string
+	// flags are expressions giving a filter's reason when it matches a post
+	// and NULL otherwise; see applyFilters
+	flags []string
+}
+
+func newTimelineQuery(viewerID *uuid.UUID, order feedOrder) *timelineQuery {
+	q := &timelineQuery{
+		args:  []any{viewerID},
+		order: order,
+	}
+	q.filter("p.state = 'published'")
+
+	// Posts by, or reblogging, suspended or silenced accounts are hidden
+	// from everyone but their author
+	q.filter("(p.user_id = $1 OR NOT " + users.Moderated("p.user_id") + ")")
+	q.filter("(p.reblog_of_id IS NULL OR NOT " +
+		users.Moderated("(SELECT user_id FROM posts WHERE id = p.reblog_of_id)") + ")")
+
+	// Posts by, or reblogging, someone the viewer has blocked or been
+	// blocked by are hidden from every timeline
+	if viewerID != nil {
+		q.filter("NOT " + blocks.Blocked("$1", "p.user_id"))
+		q.filter("(p.reblog_of_id IS NULL OR NOT " +
+			blocks.Blocked("$1", "(SELECT user_id FROM posts WHERE id = p.reblog_of_id)") + ")")
+	}
+	return q
+}
+
+// hideMuted hides posts by, or reblogging, someone the viewer has muted.
+// It is for timelines with a viewer that mutes apply to.
+func (q *timelineQuery) hideMuted() {
+	q.filter("NOT " + blocks.Muted("$1", "p.user_id"))
+	q.filter("(p.reblog_of_id IS NULL OR NOT " +
+		blocks.Muted("$1", "(SELECT user_id FROM posts WHERE id = p.reblog_of_id)") + ")")
+}
+
+// applyFilters hides the posts matched by the viewer's hide filters and
+// flags those matched by their warn filters with the filters' reasons.
+func (q *timelineQuery) applyFilters(fs []filters.Filter) {
+	for _, f := range fs {
+		condition := f.Condition(q.bind)
+		if f.Action == filters.ActionWarn {
+			q.flags = append(q.flags, "CASE WHEN "+condition+" THEN "+q.bind(f.Reason())+"::text END")
+			continue
+		}
+		// Posts without a score are not matched by score bounds
+		q.filter("NOT COALESCE(" + condition + ", false)")
+	}
+}
+
+// pendingQuery lists userID's own posts in an unpublished state.
+func pendingQuery(userID uuid.UUID, state string, order feedOrder) *timelineQuery {
+	q := &timelineQuery{
+		args:  []any{&userID},
+		order: order,
+	}
+	q.filter("p.user_id = $1")
+	q.filter("p.state = " + q.bind(state))
+	return q
+}
+
+// bind appends a query argument and returns its placeholder.
+func (q *timelineQuery) bind(v any) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *timelineQuery) join(clause string) {
+	q.joins = append(q.joins, clause)
+}
+
+func (q *timelineQuery) filter(condition string) {
+	q.where = append(q.where, condition)
+}
+
+// build renders the final SQL. A cursor takes precedence over an offset;
+// the offset path is kept only for clients that have not moved to cursors.
+func (q *timelineQuery) build(opts FeedOptions) (string, []any, error) {
+	if opts.Cursor != nil {
+		switch q.order {
+		case orderNewest:
+			q.filter("(p.created_at, p.id) < (" + q.bind(opts.Cursor.CreatedAt) + ", " + q.bind(opts.Cursor.ID) + ")")
+		case orderOldest:
+			q.filter("(p.created_at, p.id) > (" + q.bind(opts.Cursor.CreatedAt) + ", " + q.bind(opts.Cursor.ID) + ")")
+		case orderControversial:
+			if opts.Cursor.Score == nil {
+				return "", nil, ErrInvalidCursor
+			}
+			q.filter("(p.controversy_score, p.created_at, p.id) < (" +
+				q.bind(*opts.Cursor.Score) + ", " + q.bind(opts.Cursor.CreatedAt) + ", " + q.bind(opts.Cursor.ID) + ")")
+		case orderRank:
+			if opts.Cursor.Score == nil {
+				return "", nil, ErrInvalidCursor
+			}
+			q.filter("(" + q.rank + ", p.created_at, p.id) < (" +
+				q.bind(*opts.Cursor.Score) + "::float8, " + q.bind(opts.Cursor.CreatedAt) + ", " + q.bind(opts.Cursor.ID) + ")")
+		case orderSchedule:
+			return "", nil, ErrInvalidCursor
+		}
+	}
+
+	rank := "NULL::float8"
+	if q.order == orderRank {
+		rank = q.rank
+	}
+
+	reasons := "NULL::text[]"
+	if len(q.flags) > 0 {
+		reasons = "array_remove(ARRAY[" + strings.Join(q.flags, ", ") + "], NULL)"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`
+		SELECT
+			p.id, p.user_id, p.content, p.image_url, p.reblog_of_id, p.reblog_comment,
+			p.reply_to_id, p.like_count, p.reblog_count, p.reply_count,
+			p.sentiment_score, p.sentiment_label, p.controversy_score, p.created_at, p.updated_at,
+			p.image_alt, p.edited_at, p.state, p.publish_at,
+			u.id, u.username, u.display_name, u.avatar_url, u.is_agent,
+			CASE WHEN $1::uuid IS NOT NULL THEN
+				EXISTS(SELECT 1 FROM likes WHERE user_id = $1 AND post_id = p.id)
+			ELSE false END as is_liked,
+			CASE WHEN $1::uuid IS NOT NULL THEN
+				EXISTS(SELECT 1 FROM posts WHERE user_id = $1 AND reblog_of_id = p.id AND state = 'published')
+			ELSE false END as is_reblogged,
+			` + rank + ` as rank,
+			` + reasons + ` as filter_reasons
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+	`)
+	for _, j := range q.joins {
+		sb.WriteString("\t\t" + j + "\n")
+	}
+	if len(q.where) > 0 {
+		sb.WriteString("\t\tWHERE " + strings.Join(q.where, "\n\t\t  AND ") + "\n")
+	}
+
+	switch q.order {
+	case orderOldest:
+		sb.WriteString("\t\tORDER BY p.created_at ASC, p.id ASC\n")
+	case orderControversial:
+		sb.WriteString("\t\tORDER BY p.controversy_score DESC, p.created_at DESC, p.id DESC\n")
+	case orderRank:
+		sb.WriteString("\t\tORDER BY " + q.rank + " DESC, p.created_at DESC, p.id DESC\n")
+	case orderSchedule:
+		sb.WriteString("\t\tORDER BY p.publish_at ASC NULLS LAST, p.created_at ASC, p.id ASC\n")
+	default:
+		sb.WriteString("\t\tORDER BY p.created_at DESC, p.id DESC\n")
+	}
+
+	sb.WriteString("\t\tLIMIT " + q.bind(opts.Limit+1))
+	if opts.Cursor == nil && opts.Offset > 0 {
+		sb.WriteString(" OFFSET " + q.bind(opts.Offset))
+	}
+
+	return sb.String(), q.args, nil
+}
+
+// cursorFor returns the cursor that resumes a timeline after post.
+func (q *timelineQuery) cursorFor(post *Post) Cursor {
+	c := Cursor{CreatedAt: post.CreatedAt, ID: post.ID}
+	switch q.order {
+	case orderControversial:
+		score := post.ControversyScore
+		c.Score = &score
+	case orderRank:
+		if post.Rank != nil {
+			score := *post.Rank
+			c.Score = &score
+		}
+	}
+	return c
+}
+
+func normalizeLimit(opts *FeedOptions) {
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+}
@@ -131,3 +131,58 @@ func (l *Limiter) AllowLike(ctx context.Context, userID uuid.UUID) (*Result, err
 func (l *Limiter) AllowFollow(ctx context.Context, userID uuid.UUID) (*Result, error) {
 	return l.Allow(ctx, ActionFollow, userID, nil)
 }
+
+type ActionStatus struct {
+	Action        Action    `json:"action"`
+	Limit         int       `json:"limit"`
+	Remaining     int       `json:"remaining"`
+	WindowSeconds int       `json:"window_seconds"`
+	ResetAt       time.Time `json:"reset_at,omitempty"`
+}
+
+// Status reports the current state of each user-wide limit without
+// consuming any requests. Per-resource limits such as reply_same are not
+// included.
+func (l *Limiter) Status(ctx context.Context, userID uuid.UUID) ([]ActionStatus, error) {
+	actions := []Action{ActionCreatePost, ActionReblog, ActionReply, ActionLike, ActionFollow}
+
+	pipe := l.client.Pipeline()
+	getCmds := make([]*redis.StringCmd, len(actions))
+	ttlCmds := make([]*redis.DurationCmd, len(actions))
+	for i, action := range actions {
+		key := l.key(action, userID, nil)
+		getCmds[i] = pipe.Get(ctx, key)
+		ttlCmds[i] = pipe.TTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("redis pipeline exec: %w", err)
+	}
+
+	now := time.Now()
+	statuses := make([]ActionStatus, 0, len(actions))
+	for i, action := range actions {
+		limit, ok := l.limits[action]
+		if !ok {
+			continue
+		}
+
+		count, _ := getCmds[i].Int()
+		remaining := limit.MaxRequests - count
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		status := ActionStatus{
+			Action:        action,
+			Limit:         limit.MaxRequests,
+			Remaining:     remaining,
+			WindowSeconds: int(limit.Window.Seconds()),
+		}
+		if ttl := ttlCmds[i].Val(); count > 0 && ttl > 0 {
+			status.ResetAt = now.Add(ttl)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,235 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/imaging"
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+// Prefixes are the parts of storage that belong to MoltPress. Anything
+// else in a shared bucket is left alone.
+var Prefixes = []string{"avatars/", "headers/", "media/", "posts/", "uploads/"}
+
+// DefaultGrace is how old an orphaned file must be before it is deleted,
+// which leaves time for a request that stored it to save its reference.
+const DefaultGrace = 24 * time.Hour
+
+// Reference is a stored file that a row points at.
+type Reference struct {
+	Source string    `json:"source"` // Table and column, such as users.avatar
+	ID     uuid.UUID `json:"id"`
+	Key    string    `json:"key"`
+}
+
+// Report is the result of comparing storage with the database.
+type Report struct {
+	Files      int                `json:"files"`
+	References int                `json:"references"`
+	Orphans    []storage.FileInfo `json:"orphans"`  // Files nothing references
+	Dangling   []Reference        `json:"dangling"` // References to missing files
+}
+
+// Reconciler finds stored files the database has lost track of, such as
+// images of deleted users or of posts that failed to save, and database
+// rows whose files are gone.
+type Reconciler struct {
+	db    *pgxpool.Pool
+	store storage.Storage
+}
+
+func NewReconciler(db *pgxpool.Pool, store storage.Storage) *Reconciler {
+	return &Reconciler{db: db, store: store}
+}
+
+// references returns every file the database points at, and the keys of
+// upload files that may or may not exist yet.
+func (r *Reconciler) references(ctx context.Context) ([]Reference, map[string]bool, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT 'posts.image_key', id, image_key, NULL::jsonb FROM posts WHERE image_key IS NOT NULL
+		UNION ALL
+		SELECT 'post_media', id, storage_key, variants FROM post_media
+		WHERE storage_key IS NOT NULL OR variants IS NOT NULL
+		UNION ALL
+		SELECT 'users.avatar', id, avatar_key, avatar_variants FROM users
+		WHERE avatar_key IS NOT NULL OR avatar_variants IS NOT NULL
+		UNION ALL
+		SELECT 'users.header', id, header_key, header_variants FROM users
+		WHERE header_key IS NOT NULL OR header_variants IS NOT NULL
+		UNION ALL
+		SELECT 'uploads', id, storage_key, variants FROM uploads
+		WHERE storage_key IS NOT NULL OR variants IS NOT NULL
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var refs []Reference
+	for rows.Next() {
+		var source string
+		var id uuid.UUID
+		var key *string
+		var variants []byte
+		if err := rows.Scan(&source, &id, &key, &variants); err != nil {
+			return nil, nil, err
+		}
+
+		keys := imaging.DecodeVariants(variants).Keys()
+		if key != nil && !slices.Contains(keys, *key) {
+			keys = append(keys, *key)
+		}
+		for _, k := range keys {
+			refs = append(refs, Reference{Source: source, ID: id, Key: k})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// An upload's raw file exists from its first byte until it is
+	// processed, so it is neither an orphan nor dangling
+	rows, err = r.db.Query(ctx, `SELECT raw_key FROM uploads`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	optional := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, nil, err
+		}
+		optional[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return refs, optional, nil
+}
+
+// compare finds the files no reference or optional key names, and the
+// references whose files are not among files.
+func compare(files []storage.FileInfo, refs []Reference, optional map[string]bool) ([]storage.FileInfo, []Reference) {
+	stored := make(map[string]bool, len(files))
+	for _, f := range files {
+		stored[f.Key] = true
+	}
+	referenced := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		referenced[ref.Key] = true
+	}
+
+	var orphans []storage.FileInfo
+	for _, f := range files {
+		if !referenced[f.Key] && !optional[f.Key] {
+			orphans = append(orphans, f)
+		}
+	}
+	var dangling []Reference
+	for _, ref := range refs {
+		if !stored[ref.Key] && !optional[ref.Key] {
+			dangling = append(dangling, ref)
+		}
+	}
+	return orphans, dangling
+}
+
+// Check compares storage with the database without changing either.
+func (r *Reconciler) Check(ctx context.Context) (*Report, error) {
+	// Storage is listed first, so a file stored after the listing can only
+	// look dangling, which is checked again below, and never orphaned
+	var files []storage.FileInfo
+	for _, prefix := range Prefixes {
+		listed, err := r.store.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, listed...)
+	}
+
+	refs, optional, err := r.references(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Files: len(files), References: len(refs)}
+	var dangling []Reference
+	report.Orphans, dangling = compare(files, refs, optional)
+	for _, ref := range dangling {
+		exists, err := r.store.Exists(ctx, ref.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			report.Dangling = append(report.Dangling, ref)
+		}
+	}
+	return report, nil
+}
+
+// DeleteOrphans deletes the orphans in report last modified more than
+// grace ago, and returns how many it deleted.
+func (r *Reconciler) DeleteOrphans(ctx context.Context, report *Report, grace time.Duration) (int, error) {
+	cutoff := time.Now().Add(-grace)
+	deleted := 0
+	for _, f := range report.Orphans {
+		if f.LastModified.After(cutoff) {
+			continue
+		}
+		if err := r.store.Delete(ctx, f.Key); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Run checks storage every interval until ctx is cancelled, logging what
+// it finds. Orphans older than grace are deleted when remove is set;
+// dangling references are only reported, since their rows may still be
+// worth keeping.
+func (r *Reconciler) Run(ctx context.Context, interval, grace time.Duration, remove bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := r.Check(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("failed to check storage", "error", err)
+				}
+				continue
+			}
+
+			deleted := 0
+			if remove {
+				deleted, err = r.DeleteOrphans(ctx, report, grace)
+				if err != nil && ctx.Err() == nil {
+					slog.Error("failed to delete orphaned files", "error", err)
+				}
+			}
+
+			if len(report.Orphans) > 0 || len(report.Dangling) > 0 {
+				slog.Warn("storage is out of sync with the database",
+					"files", report.Files,
+					"orphans", len(report.Orphans),
+					"deleted", deleted,
+					"dangling", len(report.Dangling),
+				)
+			}
+		}
+	}
+}
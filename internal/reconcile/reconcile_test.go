@@ -0,0 +1,30 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+func TestCompare(t *testing.T) {
+	files := []storage.FileInfo{
+		{Key: "posts/a.jpg"},
+		{Key: "posts/orphan.jpg"},
+		{Key: "uploads/pending"},
+	}
+	refs := []Reference{
+		{Source: "post_media", ID: uuid.New(), Key: "posts/a.jpg"},
+		{Source: "users.avatar", ID: uuid.New(), Key: "avatars/missing.png"},
+		{Source: "uploads", ID: uuid.New(), Key: "uploads/sent"},
+	}
+	optional := map[string]bool{"uploads/pending": true, "uploads/sent": true}
+
+	orphans, dangling := compare(files, refs, optional)
+	if len(orphans) != 1 || orphans[0].Key != "posts/orphan.jpg" {
+		t.Errorf("orphans = %v, want [posts/orphan.jpg]", orphans)
+	}
+	if len(dangling) != 1 || dangling[0].Key != "avatars/missing.png" {
+		t.Errorf("dangling = %v, want [avatars/missing.png]", dangling)
+	}
+}
@@ -0,0 +1,315 @@
+// Package reports lets users flag posts and accounts for admins to review,
+// and keeps the queue admins triage them from.
+package reports
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/audit"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+type Category string
+
+const (
+	CategorySpam           Category = "spam"
+	CategoryHarassment     Category = "harassment"
+	CategoryHate           Category = "hate"
+	CategoryViolence       Category = "violence"
+	CategorySexual         Category = "sexual"
+	CategoryMisinformation Category = "misinformation"
+	CategoryImpersonation  Category = "impersonation"
+	CategoryIllegal        Category = "illegal"
+	CategoryOther          Category = "other"
+)
+
+var AllCategories = []Category{
+	CategorySpam, CategoryHarassment, CategoryHate, CategoryViolence, CategorySexual,
+	CategoryMisinformation, CategoryImpersonation, CategoryIllegal, CategoryOther,
+}
+
+func IsValidCategory(c Category) bool {
+	for _, valid := range AllCategories {
+		if c == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type Status string
+
+const (
+	StatusOpen      Status = "open"
+	StatusResolved  Status = "resolved"  // Acted on
+	StatusDismissed Status = "dismissed" // Nothing to do
+)
+
+func IsValidStatus(s Status) bool {
+	return s == StatusOpen || s == StatusResolved || s == StatusDismissed
+}
+
+// Target types
+const (
+	TargetPost = "post"
+	TargetUser = "user"
+)
+
+// MaxCommentLength caps a reporter's comment, in characters.
+const MaxCommentLength = 1000
+
+var (
+	ErrInvalidCategory = errors.New("invalid report category")
+	ErrInvalidStatus   = errors.New("invalid report status")
+	ErrCommentTooLong  = errors.New("report comment too long")
+	ErrReportSelf      = errors.New("cannot report yourself")
+	ErrAlreadyReported = errors.New("already reported")
+	ErrTargetNotFound  = errors.New("report target not found")
+	ErrReportNotFound  = errors.New("report not found")
+)
+
+type Report struct {
+	ID         uuid.UUID        `json:"id"`
+	Reporter   users.UserPublic `json:"reporter"`
+	User       users.UserPublic `json:"user"`              // The reported account, or the post's author
+	PostID     *uuid.UUID       `json:"post_id,omitempty"` // Nil for account reports, and once the post is deleted
+	TargetType string           `json:"target_type"`
+	Category   Category         `json:"category"`
+	Comment    *string          `json:"comment,omitempty"`
+	Status     Status           `json:"status"`
+	ResolvedBy *uuid.UUID       `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time       `json:"resolved_at,omitempty"`
+	Resolution *string          `json:"resolution,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Create files a report by reporterID against a post, when postID is set,
+// or otherwise against the account userID. Only published posts can be
+// reported, and each reporter can only have one open report per target.
+func (r *Repository) Create(ctx context.Context, reporterID uuid.UUID, postID, userID *uuid.UUID, category Category, comment *string) (*Report, error) {
+	if !IsValidCategory(category) {
+		return nil, ErrInvalidCategory
+	}
+	if comment != nil {
+		trimmed := strings.TrimSpace(*comment)
+		if utf8.RuneCountInString(trimmed) > MaxCommentLength {
+			return nil, ErrCommentTooLong
+		}
+		comment = &trimmed
+		if trimmed == "" {
+			comment = nil
+		}
+	}
+
+	targetType := TargetUser
+	if postID != nil {
+		targetType = TargetPost
+		var authorID uuid.UUID
+		err := r.db.QueryRow(ctx, `
+			SELECT user_id FROM posts WHERE id = $1 AND state = 'published'
+		`, postID).Scan(&authorID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrTargetNotFound
+			}
+			return nil, err
+		}
+		userID = &authorID
+	}
+	if userID == nil {
+		return nil, ErrTargetNotFound
+	}
+	if *userID == reporterID {
+		return nil, ErrReportSelf
+	}
+
+	var id uuid.UUID
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO reports (reporter_id, user_id, post_id, target_type, category, comment)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, reporterID, userID, postID, targetType, category, comment).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return nil, ErrAlreadyReported
+		}
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
+
+const reportColumns = `
+	rp.id, rp.post_id, rp.target_type, rp.category, rp.comment, rp.status,
+	rp.resolved_by, rp.resolved_at, rp.resolution, rp.created_at,
+	ru.id, ru.username, ru.display_name, ru.avatar_url, ru.is_agent, ru.verified_at, ru.created_at,
+	tu.id, tu.username, tu.display_name, tu.avatar_url, tu.is_agent, tu.verified_at, tu.created_at
+	FROM reports rp
+	JOIN users ru ON ru.id = rp.reporter_id
+	JOIN users tu ON tu.id = rp.user_id
+`
+
+func scanReport(row pgx.Row) (*Report, error) {
+	var rp Report
+	var reporterVerified, userVerified *time.Time
+	err := row.Scan(
+		&rp.ID, &rp.PostID, &rp.TargetType, &rp.Category, &rp.Comment, &rp.Status,
+		&rp.ResolvedBy, &rp.ResolvedAt, &rp.Resolution, &rp.CreatedAt,
+		&rp.Reporter.ID, &rp.Reporter.Username, &rp.Reporter.DisplayName, &rp.Reporter.AvatarURL,
+		&rp.Reporter.IsAgent, &reporterVerified, &rp.Reporter.CreatedAt,
+		&rp.User.ID, &rp.User.Username, &rp.User.DisplayName, &rp.User.AvatarURL,
+		&rp.User.IsAgent, &userVerified, &rp.User.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	rp.Reporter.IsVerified = reporterVerified != nil
+	rp.User.IsVerified = userVerified != nil
+	return &rp, nil
+}
+
+func (r *Repository) Get(ctx context.Context, id uuid.UUID) (*Report, error) {
+	rp, err := scanReport(r.db.QueryRow(ctx, `SELECT `+reportColumns+` WHERE rp.id = $1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+	return rp, nil
+}
+
+type ListOptions struct {
+	Limit    int
+	Cursor   *pagination.Cursor
+	Status   *Status
+	Category *Category
+	UserID   *uuid.UUID // Reports about one account
+}
+
+type Page struct {
+	Reports    []Report `json:"reports"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	HasMore    bool     `json:"has_more"`
+}
+
+// List returns reports oldest first, so the queue is worked in the order
+// it was filed.
+func (r *Repository) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+
+	var cursorTime *time.Time
+	var cursorID *uuid.UUID
+	if opts.Cursor != nil {
+		cursorTime = &opts.Cursor.CreatedAt
+		cursorID = &opts.Cursor.ID
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT `+reportColumns+`
+		WHERE ($1::timestamptz IS NULL OR (rp.created_at, rp.id) > ($1, $2))
+		  AND ($3::text IS NULL OR rp.status = $3)
+		  AND ($4::text IS NULL OR rp.category = $4)
+		  AND ($5::uuid IS NULL OR rp.user_id = $5)
+		ORDER BY rp.created_at ASC, rp.id ASC
+		LIMIT $6
+	`, cursorTime, cursorID, opts.Status, opts.Category, opts.UserID, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page{Reports: []Report{}}
+	for rows.Next() {
+		rp, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Reports = append(page.Reports, *rp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Reports) > opts.Limit {
+		page.Reports = page.Reports[:opts.Limit]
+		page.HasMore = true
+	}
+	if n := len(page.Reports); n > 0 {
+		last := page.Reports[n-1]
+		page.NextCursor = pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
+}
+
+// Triage sets a report's status on behalf of adminID and records it in the
+// audit log. Setting it back to open clears the resolution.
+func (r *Repository) Triage(ctx context.Context, adminID, id uuid.UUID, status Status, resolution *string) (*Report, error) {
+	if !IsValidStatus(status) {
+		return nil, ErrInvalidStatus
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE reports SET
+			status = $2::text,
+			resolved_by = CASE WHEN $2::text = 'open' THEN NULL ELSE $3::uuid END,
+			resolved_at = CASE WHEN $2::text = 'open' THEN NULL ELSE CURRENT_TIMESTAMP END,
+			resolution = CASE WHEN $2::text = 'open' THEN NULL ELSE $4::text END
+		WHERE id = $1
+	`, id, status, adminID, resolution)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrAlreadyReported // Reopening next to a newer open report
+		}
+		return nil, err
+	}
+	if result.RowsAffected() == 0 {
+		return nil, ErrReportNotFound
+	}
+
+	err = audit.Record(ctx, tx, audit.Entry{
+		ActorID:    adminID,
+		Action:     audit.ActionTriageReport,
+		TargetType: audit.TargetReport,
+		TargetID:   id,
+		Details:    map[string]any{"status": status, "resolution": resolution},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
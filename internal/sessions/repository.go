@@ -0,0 +1,130 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// DefaultTTL is how long a web login stays valid.
+const DefaultTTL = 30 * 24 * time.Hour
+
+type Session struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Create starts a session for userID. Only a hash of the token is stored;
+// the plaintext token is returned for the session cookie.
+func (r *Repository) Create(ctx context.Context, userID uuid.UUID, ttl time.Duration) (*Session, string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &Session{}
+	err = r.db.QueryRow(ctx, `
+		INSERT INTO sessions (user_id, token, expires_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second')
+		RETURNING id, user_id, expires_at, created_at
+	`, userID, hashToken(token), ttl.Seconds()).Scan(
+		&session.ID, &session.UserID, &session.ExpiresAt, &session.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// Get returns the unexpired session for token.
+func (r *Repository) Get(ctx context.Context, token string) (*Session, error) {
+	session := &Session{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, expires_at, created_at
+		FROM sessions
+		WHERE token = $1 AND expires_at > CURRENT_TIMESTAMP
+	`, hashToken(token)).Scan(
+		&session.ID, &session.UserID, &session.ExpiresAt, &session.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (r *Repository) Delete(ctx context.Context, token string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM sessions WHERE token = $1`, hashToken(token))
+	return err
+}
+
+func (r *Repository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Sweep removes expired sessions every interval until ctx is cancelled.
+func (r *Repository) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := r.DeleteExpired(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("failed to sweep expired sessions", "error", err)
+				}
+				continue
+			}
+			if deleted > 0 {
+				slog.Info("swept expired sessions", "count", deleted)
+			}
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
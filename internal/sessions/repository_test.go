@@ -0,0 +1,104 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+func TestSessions(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "human")
+
+	session, token, err := repo.Create(ctx, userID, time.Hour)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if len(token) != 64 || session.UserID != userID {
+		t.Fatalf("Create() = %+v, %q", session, token)
+	}
+
+	var stored string
+	if err := db.QueryRow(ctx, `SELECT token FROM sessions WHERE id = $1`, session.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != hashToken(token) {
+		t.Error("the stored token is not the token's hash")
+	}
+
+	got, err := repo.Get(ctx, token)
+	if err != nil || got.ID != session.ID {
+		t.Fatalf("Get() = %v, %v; want the session", got, err)
+	}
+	if _, err := repo.Get(ctx, stored); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() with the stored hash = %v, want ErrSessionNotFound", err)
+	}
+
+	if err := repo.Delete(ctx, token); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.Get(ctx, token); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() after Delete() = %v, want ErrSessionNotFound", err)
+	}
+
+	_, expired, err := repo.Create(ctx, userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbtest.Exec(t, db, `UPDATE sessions SET expires_at = NOW() - INTERVAL '1 second' WHERE token = $1`, hashToken(expired))
+	if _, err := repo.Get(ctx, expired); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() of an expired session = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "human")
+
+	_, live, err := repo.Create(ctx, userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, _, err := repo.Create(ctx, userID, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbtest.Exec(t, db, `UPDATE sessions SET expires_at = NOW() - INTERVAL '1 second' WHERE token <> $1`, hashToken(live))
+
+	sweepCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		repo.Sweep(sweepCtx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	var count int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM sessions`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count == 1 {
+			break
+		}
+	}
+	cancel()
+	<-done
+
+	if count != 1 {
+		t.Fatalf("%d sessions left after sweeping, want 1", count)
+	}
+	if _, err := repo.Get(ctx, live); err != nil {
+		t.Errorf("the live session was swept: %v", err)
+	}
+	if deleted, err := repo.DeleteExpired(ctx); err != nil || deleted != 0 {
+		t.Errorf("DeleteExpired() after sweeping = %d, %v; want 0", deleted, err)
+	}
+}
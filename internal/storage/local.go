@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -50,6 +52,38 @@ func (s *LocalStorage) Put(ctx context.Context, key string, reader io.Reader, co
 	return err
 }
 
+func (s *LocalStorage) Append(ctx context.Context, key string, offset int64, reader io.Reader) (int64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	fullPath := filepath.Join(s.basePath, key)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size() != offset {
+		return stat.Size(), ErrOffsetMismatch
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(file, reader)
+	return offset + written, err
+}
+
 func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	if err := validateKey(key); err != nil {
 		return nil, err
@@ -67,6 +101,23 @@ func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, erro
 	return file, nil
 }
 
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	fullPath := filepath.Join(s.basePath, key)
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
 func (s *LocalStorage) Delete(ctx context.Context, key string) error {
 	if err := validateKey(key); err != nil {
 		return err
@@ -128,9 +179,76 @@ func (s *LocalStorage) Info(ctx context.Context, key string) (*FileInfo, error)
 		Size:         stat.Size(),
 		ContentType:  detectContentType(key),
 		LastModified: stat.ModTime(),
+		ETag:         localETag(stat),
 	}, nil
 }
 
+// List walks only the directory holding prefix, so listing one upload's
+// files does not visit every other file in storage.
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	root := s.basePath
+	if dir := prefix[:strings.LastIndex(prefix, "/")+1]; dir != "" {
+		if err := validateKey(dir); err != nil {
+			return nil, err
+		}
+		root = filepath.Join(s.basePath, dir)
+	}
+
+	var files []FileInfo
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				// Nothing has been stored under prefix
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.basePath, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Deleted while we were listing
+				return nil
+			}
+			return err
+		}
+		files = append(files, FileInfo{
+			Key:          key,
+			Size:         stat.Size(),
+			ContentType:  detectContentType(key),
+			LastModified: stat.ModTime(),
+			ETag:         localETag(stat),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// localETag identifies a version of a file by its modification time and
+// size, which is cheaper than hashing it and changes on every write.
+func localETag(stat fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size())
+}
+
 func validateKey(key string) error {
 	if key == "" {
 		return ErrInvalidPath
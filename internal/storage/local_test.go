@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage_List(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir(), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"media/abc/original.png",
+		"media/abc/small.webp",
+		"media/abd/original.png",
+		"posts/abc/original.png",
+		"avatar.png",
+	} {
+		if err := s.Put(ctx, key, strings.NewReader(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"media/abc/", []string{"media/abc/original.png", "media/abc/small.webp"}},
+		{"media/ab", []string{"media/abc/original.png", "media/abc/small.webp", "media/abd/original.png"}},
+		{"media/abc/small", []string{"media/abc/small.webp"}},
+		{"av", []string{"avatar.png"}},
+		{"", []string{
+			"avatar.png", "media/abc/original.png", "media/abc/small.webp", "media/abd/original.png", "posts/abc/original.png",
+		}},
+		{"media/nothing/", nil},
+		{"nowhere/at/all", nil},
+	}
+	for _, tt := range tests {
+		files, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Errorf("List(%q) error = %v", tt.prefix, err)
+			continue
+		}
+		var keys []string
+		for _, f := range files {
+			keys = append(keys, f.Key)
+		}
+		if !slices.Equal(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if _, err := s.List(ctx, "../"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("List(\"../\") = %v, want ErrInvalidPath", err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -73,6 +74,32 @@ func (s *S3Storage) Put(ctx context.Context, key string, reader io.Reader, conte
 	return err
 }
 
+func (s *S3Storage) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, map[string]string, error) {
+	if err := validateKey(key); err != nil {
+		return "", nil, err
+	}
+
+	req, err := s3.NewPresignClient(s.client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Host is set by the client from the URL
+	headers := make(map[string]string, len(req.SignedHeader))
+	for name, values := range req.SignedHeader {
+		if !strings.EqualFold(name, "Host") {
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+
+	return req.URL, headers, nil
+}
+
 func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	if err := validateKey(key); err != nil {
 		return nil, err
@@ -92,6 +119,29 @@ func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error)
 	return result.Body, nil
 }
 
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	if offset < 0 || length <= 0 {
+		return nil, ErrInvalidRange
+	}
+
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	return result.Body, nil
+}
+
 func (s *S3Storage) Delete(ctx context.Context, key string) error {
 	if err := validateKey(key); err != nil {
 		return err
@@ -163,10 +213,54 @@ func (s *S3Storage) Info(ctx context.Context, key string) (*FileInfo, error) {
 		size = *result.ContentLength
 	}
 
+	var etag string
+	if result.ETag != nil {
+		etag = *result.ETag
+	}
+
 	return &FileInfo{
 		Key:          key,
 		Size:         size,
 		ContentType:  contentType,
 		LastModified: lastModified,
+		ETag:         etag,
 	}, nil
 }
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var files []FileInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+
+			// Listings do not include the content type
+			info := FileInfo{
+				Key:         *object.Key,
+				ContentType: detectContentType(*object.Key),
+			}
+			if object.Size != nil {
+				info.Size = *object.Size
+			}
+			if object.LastModified != nil {
+				info.LastModified = *object.LastModified
+			}
+			if object.ETag != nil {
+				info.ETag = *object.ETag
+			}
+			files = append(files, info)
+		}
+	}
+
+	return files, nil
+}
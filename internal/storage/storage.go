@@ -8,16 +8,19 @@ import (
 )
 
 var (
-	ErrFileNotFound = errors.New("file not found")
-	ErrInvalidPath  = errors.New("invalid file path")
+	ErrFileNotFound   = errors.New("file not found")
+	ErrInvalidPath    = errors.New("invalid file path")
+	ErrOffsetMismatch = errors.New("offset does not match file size")
+	ErrInvalidRange   = errors.New("invalid byte range")
 )
 
 // FileInfo contains metadata about a stored file
 type FileInfo struct {
-	Key          string
-	Size         int64
-	ContentType  string
-	LastModified time.Time
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"` // Quoted, and changes whenever the file does
 }
 
 // Storage defines the interface for file storage backends
@@ -42,4 +45,36 @@ type Storage interface {
 
 	// Info returns metadata about a file
 	Info(ctx context.Context, key string) (*FileInfo, error)
+
+	// List returns every file whose key starts with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+}
+
+// Presigner is implemented by backends that clients can upload to
+// directly, so large files never pass through the server.
+type Presigner interface {
+	// PresignPut returns a URL that accepts a PUT of exactly size bytes to
+	// key until expires has passed, and headers the request must include.
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, map[string]string, error)
+}
+
+// Appender is implemented by backends that can receive a file in chunks,
+// each small enough to upload within a request timeout.
+type Appender interface {
+	// Append writes reader to the end of key, creating it if needed, and
+	// returns the new size. offset must be the current size of key;
+	// otherwise Append returns ErrOffsetMismatch and the current size.
+	Append(ctx context.Context, key string, offset int64, reader io.Reader) (int64, error)
+}
+
+// Opener is implemented by backends that can open a file for random
+// access, so it can be served in ranges.
+type Opener interface {
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+}
+
+// RangeGetter is implemented by backends that can read part of a file.
+type RangeGetter interface {
+	// GetRange reads length bytes of key starting at offset.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
 }
@@ -0,0 +1,178 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	streamKey   = "stream:events"
+	liveChannel = "stream:live"
+)
+
+// Broker publishes events to Redis and fans those from every instance out
+// to the subscribers connected to this one.
+type Broker struct {
+	client *redis.Client
+
+	// MaxLen is roughly how many events are kept for resuming.
+	MaxLen int64
+	// Buffer is how many events a subscriber may fall behind by before it
+	// is disconnected.
+	Buffer int
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBroker(client *redis.Client) *Broker {
+	return &Broker{
+		client: client,
+		MaxLen: 10000,
+		Buffer: 256,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish appends e to the stream and announces it to all instances.
+func (b *Broker) Publish(ctx context.Context, e Event) error {
+	e.ID = ""
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	id, err := b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: b.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": payload},
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	e.ID = id
+	announced, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, liveChannel, announced).Err()
+}
+
+// Since returns up to limit events published after the event with ID
+// lastID, oldest first. Events trimmed from the stream are lost.
+func (b *Broker) Since(ctx context.Context, lastID string, limit int64) ([]Event, error) {
+	if !ValidEventID(lastID) {
+		return nil, ErrInvalidEventID
+	}
+
+	messages, err := b.client.XRangeN(ctx, streamKey, "("+lastID, "+", limit).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(messages))
+	for _, m := range messages {
+		raw, _ := m.Values["event"].(string)
+		var e Event
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			continue
+		}
+		e.ID = m.ID
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Subscription receives every event published while it is open. C is
+// closed if the subscriber falls too far behind; it should reconnect and
+// resume from the last ID it handled.
+type Subscription struct {
+	C <-chan *Event
+
+	c      chan *Event
+	broker *Broker
+	once   sync.Once
+}
+
+func (b *Broker) Subscribe() *Subscription {
+	c := make(chan *Event, b.Buffer)
+	sub := &Subscription{C: c, c: c, broker: b}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Close stops delivery to the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	delete(s.broker.subs, s)
+	s.broker.mu.Unlock()
+
+	s.once.Do(func() { close(s.c) })
+}
+
+func (b *Broker) dispatch(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub.c <- e:
+		default:
+			delete(b.subs, sub)
+			sub.once.Do(func() { close(sub.c) })
+		}
+	}
+}
+
+// Run relays announced events to local subscribers until ctx is cancelled.
+func (b *Broker) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		b.listen(ctx)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (b *Broker) listen(ctx context.Context) {
+	pubsub := b.client.Subscribe(ctx, liveChannel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() == nil {
+			slog.Error("failed to subscribe to stream", "error", err)
+		}
+		return
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				slog.Warn("dropping malformed stream event", "error", err)
+				continue
+			}
+			b.dispatch(&e)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package stream delivers new posts, likes and notifications to connected
+// clients as they happen. Events are appended to a capped Redis stream, so
+// a reconnecting client can resume from the last ID it saw, and announced
+// over Redis pub/sub so every server instance hears about them.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidChannel = errors.New("invalid stream channel")
+	ErrInvalidEventID = errors.New("invalid event id")
+)
+
+type EventType string
+
+const (
+	EventPost         EventType = "post"         // A new post or reply
+	EventReblog       EventType = "reblog"       // A new reblog
+	EventLike         EventType = "like"         // A post was liked
+	EventNotification EventType = "notification" // A notification for the subscriber
+)
+
+// Event is one message on the stream. The routing fields decide which
+// channels it is delivered on; only ID, Type and Data reach clients.
+type Event struct {
+	ID   string          `json:"id,omitempty"`
+	Type EventType       `json:"type"`
+	Data json.RawMessage `json:"data"`
+
+	// ActorID is the author of a post or the user who liked it. Events are
+	// shown on the home channel of the actor and their followers.
+	ActorID uuid.UUID `json:"actor_id"`
+	// Public marks events that belong on the public channel.
+	Public bool     `json:"public,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// RecipientID is set on notifications, which only their recipient sees.
+	RecipientID *uuid.UUID `json:"recipient_id,omitempty"`
+}
+
+// NewEvent builds an event carrying data as its JSON payload.
+func NewEvent(eventType EventType, actorID uuid.UUID, data any) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: eventType, ActorID: actorID, Data: raw}, nil
+}
+
+// Publisher sends events to every connected client. It is implemented by
+// Broker; repositories take the interface so they can run without Redis.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Channel names accepted by ParseChannels.
+const (
+	ChannelHome          = "home"
+	ChannelPublic        = "public"
+	ChannelNotifications = "notifications"
+	channelTagPrefix     = "tag:"
+)
+
+// MaxTagChannels caps how many tags one connection may follow.
+const MaxTagChannels = 20
+
+// Channels is the set of channels a client subscribed to.
+type Channels struct {
+	Home          bool
+	Public        bool
+	Notifications bool
+	Tags          []string
+}
+
+// ParseChannels parses a comma-separated list such as
+// "home,public,tag:go,notifications".
+func ParseChannels(value string) (Channels, error) {
+	var c Channels
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			continue
+		case name == ChannelHome:
+			c.Home = true
+		case name == ChannelPublic:
+			c.Public = true
+		case name == ChannelNotifications:
+			c.Notifications = true
+		case strings.HasPrefix(name, channelTagPrefix):
+			tag := strings.ToLower(strings.TrimPrefix(name, channelTagPrefix))
+			if tag == "" {
+				return Channels{}, ErrInvalidChannel
+			}
+			c.Tags = append(c.Tags, tag)
+		default:
+			return Channels{}, ErrInvalidChannel
+		}
+	}
+
+	if !c.Home && !c.Public && !c.Notifications && len(c.Tags) == 0 {
+		return Channels{}, ErrInvalidChannel
+	}
+	if len(c.Tags) > MaxTagChannels {
+		return Channels{}, ErrInvalidChannel
+	}
+	return c, nil
+}
+
+// RequiresAuth reports whether any of the channels are private to a user.
+func (c Channels) RequiresAuth() bool {
+	return c.Home || c.Notifications
+}
+
+// Match returns the subscribed channels e should be delivered on, or nil if
+// none. viewerID is the subscriber, nil when anonymous, and follows reports
+// whether they follow a user.
+func (c Channels) Match(e *Event, viewerID *uuid.UUID, follows func(uuid.UUID) bool) []string {
+	if e.Type == EventNotification {
+		if c.Notifications && viewerID != nil && e.RecipientID != nil && *e.RecipientID == *viewerID {
+			return []string{ChannelNotifications}
+		}
+		return nil
+	}
+
+	var matched []string
+	if c.Home && viewerID != nil && (e.ActorID == *viewerID || follows(e.ActorID)) {
+		matched = append(matched, ChannelHome)
+	}
+	if c.Public && e.Public {
+		matched = append(matched, ChannelPublic)
+	}
+	for _, tag := range c.Tags {
+		for _, t := range e.Tags {
+			if strings.EqualFold(tag, t) {
+				matched = append(matched, channelTagPrefix+tag)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// parseEventID splits a Redis stream ID ("<ms>-<seq>") into its parts.
+func parseEventID(id string) (ms, seq uint64, err error) {
+	msPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, ErrInvalidEventID
+	}
+	if ms, err = strconv.ParseUint(msPart, 10, 64); err != nil {
+		return 0, 0, ErrInvalidEventID
+	}
+	if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+		return 0, 0, ErrInvalidEventID
+	}
+	return ms, seq, nil
+}
+
+// ValidEventID reports whether id is a well-formed event ID.
+func ValidEventID(id string) bool {
+	_, _, err := parseEventID(id)
+	return err == nil
+}
+
+// After reports whether event ID a comes after b. Malformed IDs sort first.
+func After(a, b string) bool {
+	aMs, aSeq, errA := parseEventID(a)
+	bMs, bSeq, errB := parseEventID(b)
+	if errA != nil {
+		return false
+	}
+	if errB != nil {
+		return true
+	}
+	if aMs != bMs {
+		return aMs > bMs
+	}
+	return aSeq > bSeq
+}
+
+// PublishAll publishes events through p, skipping nil events. It is meant
+// to run after the transaction that produced them commits, when the change
+// is already saved, so failures are logged rather than returned.
+func PublishAll(ctx context.Context, p Publisher, events ...*Event) {
+	if p == nil {
+		return
+	}
+	for _, e := range events {
+		if e == nil {
+			continue
+		}
+		if err := p.Publish(ctx, *e); err != nil {
+			slog.Warn("failed to publish stream event", "type", e.Type, "error", err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseChannels(t *testing.T) {
+	c, err := ParseChannels("home, public,tag:Go,notifications")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Home || !c.Public || !c.Notifications {
+		t.Errorf("expected home, public and notifications, got %+v", c)
+	}
+	if !reflect.DeepEqual(c.Tags, []string{"go"}) {
+		t.Errorf("expected tags [go], got %v", c.Tags)
+	}
+	if !c.RequiresAuth() {
+		t.Error("expected home and notifications to require auth")
+	}
+
+	for _, bad := range []string{"", ",", "everything", "tag:", "public,bogus"} {
+		if _, err := ParseChannels(bad); err != ErrInvalidChannel {
+			t.Errorf("%q: expected ErrInvalidChannel, got %v", bad, err)
+		}
+	}
+}
+
+func TestChannelsMatch(t *testing.T) {
+	viewer := uuid.New()
+	followed := uuid.New()
+	stranger := uuid.New()
+	follows := func(id uuid.UUID) bool { return id == followed }
+
+	c := Channels{Home: true, Public: true, Notifications: true, Tags: []string{"go"}}
+
+	tests := []struct {
+		name   string
+		event  Event
+		viewer *uuid.UUID
+		want   []string
+	}{
+		{"followed public post", Event{Type: EventPost, ActorID: followed, Public: true}, &viewer, []string{"home", "public"}},
+		{"own reply", Event{Type: EventPost, ActorID: viewer}, &viewer, []string{"home"}},
+		{"stranger tagged reply", Event{Type: EventPost, ActorID: stranger, Tags: []string{"Go"}}, &viewer, []string{"tag:go"}},
+		{"stranger untagged reply", Event{Type: EventPost, ActorID: stranger}, &viewer, nil},
+		{"anonymous sees public only", Event{Type: EventPost, ActorID: followed, Public: true}, nil, []string{"public"}},
+		{"own notification", Event{Type: EventNotification, ActorID: stranger, Public: true, RecipientID: &viewer}, &viewer, []string{"notifications"}},
+		{"someone else's notification", Event{Type: EventNotification, ActorID: followed, RecipientID: &stranger}, &viewer, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Match(&tt.event, tt.viewer, follows)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1700000000001-0", "1700000000000-5", true},
+		{"1700000000000-5", "1700000000000-4", true},
+		{"1700000000000-4", "1700000000000-4", false},
+		{"999-0", "1000-0", false}, // Numeric, not lexical
+		{"bogus", "1000-0", false},
+		{"1000-0", "bogus", true},
+	}
+
+	for _, tt := range tests {
+		if got := After(tt.a, tt.b); got != tt.want {
+			t.Errorf("After(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBroker_DropsSlowSubscribers(t *testing.T) {
+	b := NewBroker(nil)
+	b.Buffer = 1
+
+	fast := b.Subscribe()
+	defer fast.Close()
+	slow := b.Subscribe()
+	defer slow.Close()
+
+	b.dispatch(&Event{ID: "1-0"})
+	<-fast.C
+	b.dispatch(&Event{ID: "2-0"})
+
+	if _, ok := <-fast.C; !ok {
+		t.Fatal("expected the fast subscriber to stay open")
+	}
+	<-slow.C // The first event
+	if _, ok := <-slow.C; ok {
+		t.Fatal("expected the slow subscriber to be closed")
+	}
+}
@@ -1,6 +1,7 @@
 package twitter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,9 @@ var (
 	ErrFetchFailed   = errors.New("failed to fetch tweet")
 )
 
+// DefaultBaseURL is the public syndication endpoint used by embedded tweets.
+const DefaultBaseURL = "https://cdn.syndication.twimg.com"
+
 type Tweet struct {
 	ID             string
 	Text           string
@@ -32,44 +37,127 @@ type syndicationTweet struct {
 	} `json:"user"`
 }
 
+// Client fetches public tweets from the syndication API. Transient
+// failures are retried with exponential backoff and successful lookups are
+// cached for CacheTTL. The zero value is not usable; use NewClient.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of extra attempts after a network error,
+	// 429 or 5xx response. Backoff is the delay before the first retry and
+	// doubles on each one after that.
+	MaxRetries int
+	Backoff    time.Duration
+
+	// CacheTTL is how long a fetched tweet is reused. Zero disables caching.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedTweet
+}
+
+type cachedTweet struct {
+	tweet     *Tweet
+	expiresAt time.Time
+}
+
+// maxCachedTweets bounds the cache; expired entries are dropped first.
+const maxCachedTweets = 1000
+
+func NewClient() *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		MaxRetries: 2,
+		Backoff:    500 * time.Millisecond,
+		CacheTTL:   5 * time.Minute,
+	}
+}
+
+// DefaultClient is used by FetchTweet.
+var DefaultClient = NewClient()
+
+// FetchTweet fetches a tweet with the package's default client.
 func FetchTweet(tweetURL string) (*Tweet, error) {
+	return DefaultClient.FetchTweet(context.Background(), tweetURL)
+}
+
+func (c *Client) FetchTweet(ctx context.Context, tweetURL string) (*Tweet, error) {
 	ID, err := parseTweetID(tweetURL)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	endpoint := fmt.Sprintf("https://cdn.syndication.twimg.com/tweet-result?id=%s&token=a", ID)
-	request, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if tweet := c.cached(ID); tweet != nil {
+		return tweet, nil
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		tweet, retry, err := c.fetch(ctx, ID)
+		if err == nil {
+			c.store(tweet)
+			return tweet, nil
+		}
+		if !retry || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		slog.Warn("retrying tweet fetch", "tweet_id", ID, "attempt", attempt+1, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrFetchFailed, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetch makes a single request and reports whether a failure is worth
+// retrying.
+func (c *Client) fetch(ctx context.Context, ID string) (*Tweet, bool, error) {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	endpoint := fmt.Sprintf("%s/tweet-result?id=%s&token=a", strings.TrimSuffix(baseURL, "/"), ID)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
 		slog.Error("failed to build tweet request", "error", err, "tweet_id", ID)
-		return nil, fmt.Errorf("%w", ErrFetchFailed)
+		return nil, false, fmt.Errorf("%w", ErrFetchFailed)
 	}
 
-	response, err := client.Do(request)
+	response, err := httpClient.Do(request)
 	if err != nil {
 		slog.Error("failed to fetch tweet", "error", err, "tweet_id", ID)
-		return nil, fmt.Errorf("%w", ErrFetchFailed)
+		return nil, ctx.Err() == nil, fmt.Errorf("%w", ErrFetchFailed)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode == http.StatusNotFound {
-		return nil, ErrTweetNotFound
+		return nil, false, ErrTweetNotFound
 	}
 
 	if response.StatusCode != http.StatusOK {
 		slog.Error("unexpected tweet response status", "status", response.StatusCode, "tweet_id", ID)
-		return nil, fmt.Errorf("%w", ErrFetchFailed)
+		retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+		return nil, retry, fmt.Errorf("%w", ErrFetchFailed)
 	}
 
 	var payload syndicationTweet
 	if err := json.NewDecoder(response.Body).Decode(&payload); err != nil {
 		slog.Error("failed to decode tweet response", "error", err, "tweet_id", ID)
-		return nil, fmt.Errorf("%w", ErrFetchFailed)
+		return nil, false, fmt.Errorf("%w", ErrFetchFailed)
 	}
 
 	if payload.IDStr == "" || payload.User.ScreenName == "" {
-		return nil, ErrTweetNotFound
+		return nil, false, ErrTweetNotFound
 	}
 
 	text := payload.Text
@@ -81,7 +169,56 @@ func FetchTweet(tweetURL string) (*Tweet, error) {
 		ID:             payload.IDStr,
 		Text:           text,
 		AuthorUsername: payload.User.ScreenName,
-	}, nil
+	}, false, nil
+}
+
+func (c *Client) cached(ID string) *Tweet {
+	if c.CacheTTL <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[ID]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.cache, ID)
+		return nil
+	}
+	return entry.tweet
+}
+
+func (c *Client) store(tweet *Tweet) {
+	if c.CacheTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cache == nil {
+		c.cache = make(map[string]cachedTweet)
+	}
+	if len(c.cache) >= maxCachedTweets {
+		now := time.Now()
+		for id, entry := range c.cache {
+			if now.After(entry.expiresAt) {
+				delete(c.cache, id)
+			}
+		}
+		// Still full of live entries; drop an arbitrary one
+		for id := range c.cache {
+			if len(c.cache) < maxCachedTweets {
+				break
+			}
+			delete(c.cache, id)
+		}
+	}
+
+	c.cache[tweet.ID] = cachedTweet{tweet: tweet, expiresAt: time.Now().Add(c.CacheTTL)}
 }
 
 func parseTweetID(tweetURL string) (string, error) {
@@ -0,0 +1,127 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTweetURL = "https://x.com/molty/status/1234567890"
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *int32) {
+	t.Helper()
+
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient()
+	client.BaseURL = server.URL
+	client.HTTPClient = server.Client()
+	client.Backoff = time.Millisecond
+	return client, &hits
+}
+
+func writeTweet(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, `{"id_str": %q, "text": "Verifying MP-abc", "user": {"screen_name": "molty"}}`, r.URL.Query().Get("id"))
+}
+
+func TestClient_FetchTweet(t *testing.T) {
+	client, _ := newTestClient(t, writeTweet)
+
+	tweet, err := client.FetchTweet(context.Background(), testTweetURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tweet.ID != "1234567890" || tweet.AuthorUsername != "molty" || tweet.Text != "Verifying MP-abc" {
+		t.Errorf("unexpected tweet: %+v", tweet)
+	}
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	var calls int32
+	client, hits := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeTweet(w, r)
+	})
+
+	if _, err := client.FetchTweet(context.Background(), testTweetURL); err != nil {
+		t.Fatalf("expected fetch to succeed after retries, got %v", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	client, hits := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	client.MaxRetries = 1
+
+	if _, err := client.FetchTweet(context.Background(), testTweetURL); !errors.Is(err, ErrFetchFailed) {
+		t.Fatalf("expected ErrFetchFailed, got %v", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestClient_DoesNotRetryNotFound(t *testing.T) {
+	client, hits := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	if _, err := client.FetchTweet(context.Background(), testTweetURL); !errors.Is(err, ErrTweetNotFound) {
+		t.Fatalf("expected ErrTweetNotFound, got %v", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
+
+func TestClient_CachesTweets(t *testing.T) {
+	client, hits := newTestClient(t, writeTweet)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.FetchTweet(context.Background(), testTweetURL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("expected cached tweet to be reused, got %d requests", n)
+	}
+
+	client.CacheTTL = 0
+	if _, err := client.FetchTweet(context.Background(), testTweetURL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 2 {
+		t.Errorf("expected disabled cache to refetch, got %d requests", n)
+	}
+}
+
+func TestParseTweetID(t *testing.T) {
+	for input, want := range map[string]error{
+		"https://twitter.com/molty/status/42":       nil,
+		"https://mobile.x.com/molty/status/42?s=20": nil,
+		"https://example.com/molty/status/42":       ErrInvalidURL,
+		"https://x.com/molty/status/abc":            ErrInvalidURL,
+		"https://x.com/molty":                       ErrInvalidURL,
+	} {
+		if _, err := parseTweetID(input); err != want {
+			t.Errorf("parseTweetID(%q): expected %v, got %v", input, want, err)
+		}
+	}
+}
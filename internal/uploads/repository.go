@@ -0,0 +1,330 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/imaging"
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+// Upload states. A pending upload is waiting for its file; a ready one
+// has been processed and can be attached to posts and profiles.
+const (
+	StatePending = "pending"
+	StateReady   = "ready"
+)
+
+var (
+	ErrUploadNotFound  = errors.New("upload not found")
+	ErrNotReady        = errors.New("upload is not complete")
+	ErrAlreadyComplete = errors.New("upload is already complete")
+	ErrInvalidSize     = errors.New("invalid upload size")
+	ErrTooManyUploads  = errors.New("too many unattached uploads")
+)
+
+const (
+	// MaxSize bounds the file of an upload.
+	MaxSize = 10 << 20
+	// MaxUnattached caps a user's uploads that nothing references yet.
+	MaxUnattached = 50
+	// PendingTTL is how long a client has to send an upload's file.
+	PendingTTL = time.Hour
+	// UnattachedTTL is how long a completed upload is kept, from when it
+	// completed, unless something references it.
+	UnattachedTTL = 24 * time.Hour
+)
+
+// unattached matches uploads u that no post or profile references.
+const unattached = `
+	NOT EXISTS (SELECT 1 FROM post_media m WHERE m.upload_id = u.id)
+	AND NOT EXISTS (SELECT 1 FROM users WHERE avatar_upload_id = u.id)
+	AND NOT EXISTS (SELECT 1 FROM users WHERE header_upload_id = u.id)
+`
+
+// Upload is an image uploaded on its own, to be attached by ID.
+type Upload struct {
+	ID          uuid.UUID        `json:"id"`
+	UserID      uuid.UUID        `json:"-"`
+	State       string           `json:"state"`
+	ContentType string           `json:"content_type,omitempty"`
+	Size        int64            `json:"size"`
+	URL         *string          `json:"url,omitempty"`
+	Key         *string          `json:"-"` // Storage key of the full variant
+	RawKey      string           `json:"-"` // Where the client sends the file
+	Width       *int             `json:"width,omitempty"`
+	Height      *int             `json:"height,omitempty"`
+	Variants    imaging.Variants `json:"variants,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	ExpiresAt   time.Time        `json:"expires_at"` // Deleted then unless attached
+
+	// Target tells the client where to send the file of a new upload
+	Target *Target `json:"upload,omitempty"`
+}
+
+// Target is where and how the file of a pending upload is sent.
+type Target struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// ChunkSize is set when the file must be sent in chunks of at most
+	// this many bytes, each with a Content-Range header
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+}
+
+// Image is a processed and stored upload.
+type Image struct {
+	URL         string
+	Key         string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	Variants    imaging.Variants
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+const uploadColumns = `
+	id, user_id, state, content_type, size_bytes, url, storage_key, raw_key,
+	width, height, variants, created_at, expires_at
+`
+
+func scanUpload(row pgx.Row) (*Upload, error) {
+	var u Upload
+	var variants []byte
+	err := row.Scan(
+		&u.ID, &u.UserID, &u.State, &u.ContentType, &u.Size, &u.URL, &u.Key, &u.RawKey,
+		&u.Width, &u.Height, &variants, &u.CreatedAt, &u.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	u.Variants = imaging.DecodeVariants(variants)
+	return &u, nil
+}
+
+// checkUnattached fails if userID has too many uploads nothing references.
+func (r *Repository) checkUnattached(ctx context.Context, userID uuid.UUID) error {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM uploads u
+		WHERE user_id = $1 AND expires_at > NOW() AND `+unattached,
+		userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= MaxUnattached {
+		return ErrTooManyUploads
+	}
+	return nil
+}
+
+// Create starts an upload of size bytes, whose file the client then sends
+// to RawKey.
+func (r *Repository) Create(ctx context.Context, userID uuid.UUID, size int64, contentType string) (*Upload, error) {
+	if size <= 0 || size > MaxSize {
+		return nil, ErrInvalidSize
+	}
+	if err := r.checkUnattached(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	return scanUpload(r.db.QueryRow(ctx, `
+		INSERT INTO uploads (id, user_id, state, raw_key, content_type, size_bytes, expires_at)
+		VALUES ($1, $2, 'pending', $3, $4, $5, $6)
+		RETURNING `+uploadColumns,
+		id, userID, fmt.Sprintf("uploads/%s", id), contentType, size, time.Now().Add(PendingTTL)))
+}
+
+// CreateReady records an upload whose file was sent and processed in one
+// request.
+func (r *Repository) CreateReady(ctx context.Context, userID uuid.UUID, img Image) (*Upload, error) {
+	if err := r.checkUnattached(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	return scanUpload(r.db.QueryRow(ctx, `
+		INSERT INTO uploads (
+			id, user_id, state, raw_key, content_type, size_bytes, url, storage_key,
+			width, height, variants, completed_at, expires_at
+		)
+		VALUES ($1, $2, 'ready', $3, $4, $5, $6, $7, $8, $9, $10, NOW(), $11)
+		RETURNING `+uploadColumns,
+		id, userID, fmt.Sprintf("uploads/%s", id), img.ContentType, img.Size, img.URL, img.Key,
+		img.Width, img.Height, imaging.EncodeVariants(img.Variants), time.Now().Add(UnattachedTTL)))
+}
+
+// Get returns one of userID's uploads.
+func (r *Repository) Get(ctx context.Context, id, userID uuid.UUID) (*Upload, error) {
+	return scanUpload(r.db.QueryRow(ctx, `
+		SELECT `+uploadColumns+` FROM uploads WHERE id = $1 AND user_id = $2
+	`, id, userID))
+}
+
+// GetReady returns userID's uploads with the given IDs, in the same order.
+// Each must exist and be complete. Expired uploads are gone unless
+// something still references them, since the sweep may delete them at any
+// moment.
+func (r *Repository) GetReady(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) ([]*Upload, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+uploadColumns+` FROM uploads u
+		WHERE id = ANY($1) AND user_id = $2 AND (expires_at > NOW() OR NOT (`+unattached+`))
+	`, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[uuid.UUID]*Upload, len(ids))
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[u.ID] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	uploads := make([]*Upload, 0, len(ids))
+	for _, id := range ids {
+		u, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+		}
+		if u.State != StateReady {
+			return nil, fmt.Errorf("%w: %s", ErrNotReady, id)
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
+
+// Complete records the processed file of a pending upload.
+func (r *Repository) Complete(ctx context.Context, id, userID uuid.UUID, img Image) (*Upload, error) {
+	u, err := scanUpload(r.db.QueryRow(ctx, `
+		UPDATE uploads SET
+			state = 'ready', content_type = $3, size_bytes = $4, url = $5, storage_key = $6,
+			width = $7, height = $8, variants = $9, completed_at = NOW(), expires_at = $10
+		WHERE id = $1 AND user_id = $2 AND state = 'pending'
+		RETURNING `+uploadColumns,
+		id, userID, img.ContentType, img.Size, img.URL, img.Key, img.Width, img.Height,
+		imaging.EncodeVariants(img.Variants), time.Now().Add(UnattachedTTL)))
+	if errors.Is(err, ErrUploadNotFound) {
+		// Completed by another request, or not ours
+		if _, err := r.Get(ctx, id, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrAlreadyComplete
+	}
+	return u, err
+}
+
+// Expire marks an upload for deletion by the next sweep, unless something
+// references it.
+func (r *Repository) Expire(ctx context.Context, id, userID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE uploads SET expires_at = NOW() WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	return err
+}
+
+// sweepBatch is how many uploads DeleteExpired removes per transaction.
+const sweepBatch = 100
+
+// DeleteExpired removes expired uploads that nothing references, and their
+// files.
+func (r *Repository) DeleteExpired(ctx context.Context, store storage.Storage) (int, error) {
+	deleted := 0
+	for {
+		rows, err := r.db.Query(ctx, `
+			DELETE FROM uploads WHERE id IN (
+				SELECT id FROM uploads u
+				WHERE expires_at <= NOW() AND `+unattached+`
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING raw_key, storage_key, variants
+		`, sweepBatch)
+		if err != nil {
+			return deleted, err
+		}
+
+		var keys []string
+		n := 0
+		for rows.Next() {
+			var rawKey string
+			var key *string
+			var variants []byte
+			if err := rows.Scan(&rawKey, &key, &variants); err != nil {
+				rows.Close()
+				return deleted, err
+			}
+			keys = append(keys, rawKey)
+			if key != nil {
+				keys = append(keys, *key)
+			}
+			keys = append(keys, imaging.DecodeVariants(variants).Keys()...)
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return deleted, err
+		}
+
+		// Files left behind by a failed delete are only wasted space
+		for _, key := range keys {
+			if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrFileNotFound) {
+				slog.Error("failed to delete upload file", "error", err, "key", key)
+			}
+		}
+
+		deleted += n
+		if n < sweepBatch {
+			return deleted, nil
+		}
+	}
+}
+
+// Sweep removes expired uploads every interval until ctx is cancelled.
+func (r *Repository) Sweep(ctx context.Context, store storage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := r.DeleteExpired(ctx, store)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("failed to sweep expired uploads", "error", err)
+				}
+				continue
+			}
+			if deleted > 0 {
+				slog.Info("swept expired uploads", "count", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+	"github.com/watzon/moltpress/internal/imaging"
+	"github.com/watzon/moltpress/internal/storage"
+)
+
+// testImage describes a processed upload stored under base.
+func testImage(base string) Image {
+	full := imaging.Variant{URL: "http://localhost/uploads/" + base + ".png", Key: base + ".png", Width: 10, Height: 10}
+	thumb := imaging.Variant{URL: "http://localhost/uploads/" + base + "_thumb.png", Key: base + "_thumb.png", Width: 5, Height: 5}
+	return Image{
+		URL:         full.URL,
+		Key:         full.Key,
+		ContentType: "image/png",
+		Size:        100,
+		Width:       10,
+		Height:      10,
+		Variants:    imaging.Variants{"full": full, "medium": full, "thumb": thumb},
+	}
+}
+
+// createReady makes a completed upload of userID's, stored under base.
+func createReady(t *testing.T, repo *Repository, userID uuid.UUID, base string) *Upload {
+	t.Helper()
+	u, err := repo.CreateReady(context.Background(), userID, testImage(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestCompleteRace(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "uploader")
+
+	upload, err := repo.Create(ctx, userID, 100, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 5
+	errs := make([]error, racers)
+	var wg sync.WaitGroup
+	for i := range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = repo.Complete(ctx, upload.ID, userID, testImage(uuid.NewString()))
+		}()
+	}
+	wg.Wait()
+
+	completed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			completed++
+		case !errors.Is(err, ErrAlreadyComplete):
+			t.Errorf("Complete() = %v, want nil or ErrAlreadyComplete", err)
+		}
+	}
+	if completed != 1 {
+		t.Errorf("%d requests completed the upload, want 1", completed)
+	}
+
+	got, err := repo.Get(ctx, upload.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateReady || got.Key == nil {
+		t.Errorf("upload = %s with key %v, want ready with a key", got.State, got.Key)
+	}
+
+	// Someone else's upload is not found rather than complete
+	otherID := dbtest.CreateUser(t, db, "other")
+	if _, err := repo.Complete(ctx, upload.ID, otherID, testImage("x")); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Complete() by another user = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestGetReady(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "uploader")
+
+	first := createReady(t, repo, userID, "media/first")
+	second := createReady(t, repo, userID, "media/second")
+	pending, err := repo.Create(ctx, userID, 100, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := createReady(t, repo, userID, "media/expired")
+	if err := repo.Expire(ctx, expired.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	avatar := createReady(t, repo, userID, "media/avatar")
+	if err := repo.Expire(ctx, avatar.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	dbtest.Exec(t, db, `UPDATE users SET avatar_upload_id = $1 WHERE id = $2`, avatar.ID, userID)
+
+	got, err := repo.GetReady(ctx, userID, []uuid.UUID{second.ID, first.ID, avatar.ID})
+	if err != nil {
+		t.Fatalf("GetReady() error = %v", err)
+	}
+	if len(got) != 3 || got[0].ID != second.ID || got[1].ID != first.ID || got[2].ID != avatar.ID {
+		t.Errorf("GetReady() did not return the uploads in the order asked for")
+	}
+
+	if _, err := repo.GetReady(ctx, userID, []uuid.UUID{first.ID, pending.ID}); !errors.Is(err, ErrNotReady) {
+		t.Errorf("pending upload: GetReady() = %v, want ErrNotReady", err)
+	}
+	if _, err := repo.GetReady(ctx, userID, []uuid.UUID{expired.ID}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("expired upload: GetReady() = %v, want ErrUploadNotFound", err)
+	}
+	otherID := dbtest.CreateUser(t, db, "other")
+	if _, err := repo.GetReady(ctx, otherID, []uuid.UUID{first.ID}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("another user's upload: GetReady() = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "uploader")
+
+	store, err := storage.NewLocalStorage(t.TempDir(), "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key string) {
+		t.Helper()
+		if err := store.Put(ctx, key, bytes.NewReader([]byte("data")), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(key string) bool {
+		_, err := store.Info(ctx, key)
+		return err == nil
+	}
+
+	// Abandoned before completing, with part of its file sent
+	abandoned, err := repo.Create(ctx, userID, 100, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(abandoned.RawKey)
+	if err := repo.Expire(ctx, abandoned.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Completed but never used
+	unused := createReady(t, repo, userID, "media/unused")
+	put("media/unused.png")
+	put("media/unused_thumb.png")
+	if err := repo.Expire(ctx, unused.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired, but still an avatar
+	avatar := createReady(t, repo, userID, "media/avatar")
+	put("media/avatar.png")
+	if err := repo.Expire(ctx, avatar.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	dbtest.Exec(t, db, `UPDATE users SET avatar_upload_id = $1 WHERE id = $2`, avatar.ID, userID)
+
+	// Not expired yet
+	fresh := createReady(t, repo, userID, "media/fresh")
+	put("media/fresh.png")
+
+	deleted, err := repo.DeleteExpired(ctx, store)
+	if err != nil {
+		t.Fatalf("DeleteExpired() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteExpired() = %d, want 2", deleted)
+	}
+
+	for _, id := range []uuid.UUID{abandoned.ID, unused.ID} {
+		if _, err := repo.Get(ctx, id, userID); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("upload %s should have been deleted, got %v", id, err)
+		}
+	}
+	for _, key := range []string{abandoned.RawKey, "media/unused.png", "media/unused_thumb.png"} {
+		if exists(key) {
+			t.Errorf("%s should have been deleted", key)
+		}
+	}
+
+	for _, id := range []uuid.UUID{avatar.ID, fresh.ID} {
+		if _, err := repo.Get(ctx, id, userID); err != nil {
+			t.Errorf("upload %s should have been kept, got %v", id, err)
+		}
+	}
+	for _, key := range []string{"media/avatar.png", "media/fresh.png"} {
+		if !exists(key) {
+			t.Errorf("%s should have been kept", key)
+		}
+	}
+
+	// Nothing is left to sweep
+	if deleted, err := repo.DeleteExpired(ctx, store); err != nil || deleted != 0 {
+		t.Errorf("second DeleteExpired() = %d, %v, want 0", deleted, err)
+	}
+}
@@ -0,0 +1,337 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidScope      = errors.New("invalid scope")
+	ErrInsufficientScope = errors.New("api key lacks required scope")
+)
+
+// Scope limits what an API key may be used for. Liking and following
+// share the interact scope rather than having one each, since a key
+// trusted with either is trusted with both, and it also covers blocking
+// and muting.
+type Scope string
+
+const (
+	ScopeRead     Scope = "read"     // Read feeds, notifications and account data
+	ScopePost     Scope = "post"     // Create, reblog and delete posts
+	ScopeInteract Scope = "interact" // Like and follow
+	ScopeProfile  Scope = "profile"  // Edit profile, theme and verification
+	ScopeAdmin    Scope = "admin"    // Manage keys and delete the account
+)
+
+// AllScopes is granted to the key issued at registration.
+var AllScopes = []Scope{ScopeRead, ScopePost, ScopeInteract, ScopeProfile, ScopeAdmin}
+
+func IsValidScope(scope Scope) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyPrefixLen is the number of leading characters of a key stored in
+// plaintext so a presented key can be looked up without scanning hashes.
+const apiKeyPrefixLen = len("mp_") + 8
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []Scope    `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	Key    APIKey `json:"key"`
+	APIKey string `json:"api_key"`
+}
+
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func scopesToStrings(scopes []Scope) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
+
+func scopesFromStrings(values []string) []Scope {
+	out := make([]Scope, len(values))
+	for i, v := range values {
+		out[i] = Scope(v)
+	}
+	return out
+}
+
+// insertAPIKey generates a new key for userID and stores only its hash.
+// The plaintext key is returned once and cannot be recovered afterwards.
+func insertAPIKey(ctx context.Context, q querier, userID uuid.UUID, name string, scopes []Scope, expiresAt *time.Time) (*APIKey, string, error) {
+	plain := generateAPIKey()
+
+	key := &APIKey{}
+	var scopeValues []string
+	err := q.QueryRow(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, user_id, name, prefix, scopes, last_used_at, expires_at, revoked_at, created_at
+	`, userID, name, plain[:apiKeyPrefixLen], hashAPIKey(plain), scopesToStrings(scopes), expiresAt).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopeValues,
+		&key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt, &key.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	key.Scopes = scopesFromStrings(scopeValues)
+
+	return key, plain, nil
+}
+
+func (r *Repository) CreateAPIKey(ctx context.Context, userID uuid.UUID, req CreateAPIKeyRequest) (*APIKey, string, error) {
+	if len(req.Scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, s := range req.Scopes {
+		if !IsValidScope(s) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	return insertAPIKey(ctx, r.db, userID, req.Name, req.Scopes, req.ExpiresAt)
+}
+
+func (r *Repository) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]APIKey, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, name, prefix, scopes, last_used_at, expires_at, revoked_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var scopeValues []string
+		if err := rows.Scan(
+			&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopeValues,
+			&key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt, &key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		key.Scopes = scopesFromStrings(scopeValues)
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, userID, keyID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, keyID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// RotateAPIKey replaces a key with a new one carrying the same name, scopes
+// and expiry. The old key is revoked immediately when grace is zero,
+// otherwise it expires once the grace period has passed. It returns the
+// new key, its plaintext and when the old key stops working.
+func (r *Repository) RotateAPIKey(ctx context.Context, userID, keyID uuid.UUID, grace time.Duration) (*APIKey, string, time.Time, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	var scopeValues []string
+	var expiresAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT name, scopes, expires_at FROM api_keys
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		FOR UPDATE
+	`, keyID, userID).Scan(&name, &scopeValues, &expiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, "", time.Time{}, ErrAPIKeyNotFound
+		}
+		return nil, "", time.Time{}, err
+	}
+
+	oldKeyExpiresAt, err := retireAPIKeys(ctx, tx, `id = $1`, keyID, grace)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	key, plain, err := insertAPIKey(ctx, tx, userID, name, scopesFromStrings(scopeValues), expiresAt)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return key, plain, oldKeyExpiresAt, nil
+}
+
+// retireAPIKeys revokes the active keys matching condition, or shortens
+// their expiry to the end of the grace period when one is given. It returns
+// when the last of them stops working, which is sooner than the grace
+// period for keys that were already due to expire.
+func retireAPIKeys(ctx context.Context, q querier, condition string, arg any, grace time.Duration) (time.Time, error) {
+	active := condition + ` AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+	var until time.Time
+	if grace <= 0 {
+		err := q.QueryRow(ctx, `
+			WITH retired AS (
+				UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+				WHERE `+active+`
+				RETURNING revoked_at
+			)
+			SELECT COALESCE(MAX(revoked_at), CURRENT_TIMESTAMP) FROM retired
+		`, arg).Scan(&until)
+		return until, err
+	}
+
+	err := q.QueryRow(ctx, `
+		WITH retired AS (
+			UPDATE api_keys
+			SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), CURRENT_TIMESTAMP + $2 * INTERVAL '1 second')
+			WHERE `+active+`
+			RETURNING expires_at
+		)
+		SELECT COALESCE(MAX(expires_at), CURRENT_TIMESTAMP) FROM retired
+	`, arg, grace.Seconds()).Scan(&until)
+	return until, err
+}
+
+// GetByAPIKey resolves a presented key to its owner. Keys are looked up by
+// their plaintext prefix and then compared by hash, so the database never
+// holds a usable credential.
+func (r *Repository) GetByAPIKey(ctx context.Context, apiKey string) (*User, *APIKey, error) {
+	if len(apiKey) <= apiKeyPrefixLen || !strings.HasPrefix(apiKey, "mp_") {
+		return nil, nil, ErrUserNotFound
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.last_used_at, k.expires_at, k.created_at,
+		       u.id, u.username, u.display_name, u.bio, u.avatar_url, u.header_url, u.is_agent,
+		       u.verification_code, u.verified_at, u.x_username, u.owner_id, u.suspended_at,
+		       u.role, u.moderation_state, u.moderation_until, u.created_at, u.updated_at
+		FROM api_keys k
+		JOIN users u ON k.user_id = u.id
+		WHERE k.prefix = $1
+		  AND k.revoked_at IS NULL
+		  AND (k.expires_at IS NULL OR k.expires_at > CURRENT_TIMESTAMP)
+	`, apiKey[:apiKeyPrefixLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	hash := hashAPIKey(apiKey)
+	for rows.Next() {
+		key := &APIKey{}
+		user := &User{}
+		var keyHash string
+		var scopeValues []string
+		if err := rows.Scan(
+			&key.ID, &key.UserID, &key.Name, &key.Prefix, &keyHash, &scopeValues,
+			&key.LastUsedAt, &key.ExpiresAt, &key.CreatedAt,
+			&user.ID, &user.Username, &user.DisplayName, &user.Bio,
+			&user.AvatarURL, &user.HeaderURL, &user.IsAgent,
+			&user.VerificationCode, &user.VerifiedAt, &user.XUsername,
+			&user.OwnerID, &user.SuspendedAt,
+			&user.Role, &user.ModerationState, &user.ModerationUntil, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			return nil, nil, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hash)) != 1 {
+			continue
+		}
+		rows.Close()
+
+		key.Scopes = scopesFromStrings(scopeValues)
+		r.touchAPIKey(ctx, key)
+		return user, key, nil
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return nil, nil, ErrUserNotFound
+}
+
+// touchAPIKey records usage, throttled so busy agents do not write a row
+// on every request.
+func (r *Repository) touchAPIKey(ctx context.Context, key *APIKey) {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < time.Minute {
+		return
+	}
+	_, _ = r.db.Exec(ctx, `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, key.ID)
+}
+
+func generateAPIKey() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return "mp_" + hex.EncodeToString(bytes)
+}
@@ -0,0 +1,270 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	key := generateAPIKey()
+	if !strings.HasPrefix(key, "mp_") || len(key) != len("mp_")+64 {
+		t.Fatalf("generateAPIKey() = %q", key)
+	}
+	if generateAPIKey() == key {
+		t.Error("generateAPIKey() returned the same key twice")
+	}
+
+	hash := hashAPIKey(key)
+	if hash != hashAPIKey(key) {
+		t.Error("hashAPIKey() is not deterministic")
+	}
+	if len(hash) != 64 || strings.Contains(hash, key[len("mp_"):]) {
+		t.Errorf("hashAPIKey() = %q", hash)
+	}
+	if hashAPIKey(key+"x") == hash {
+		t.Error("different keys hashed the same")
+	}
+}
+
+func TestAPIKey_HasScope(t *testing.T) {
+	key := &APIKey{Scopes: []Scope{ScopeRead, ScopeInteract}}
+	for _, scope := range AllScopes {
+		want := scope == ScopeRead || scope == ScopeInteract
+		if got := key.HasScope(scope); got != want {
+			t.Errorf("HasScope(%s) = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestGetByAPIKey(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	result, err := repo.Create(ctx, CreateUserRequest{Username: "keyholder", IsAgent: true})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	plain := result.APIKey
+
+	user, key, err := repo.GetByAPIKey(ctx, plain)
+	if err != nil {
+		t.Fatalf("GetByAPIKey() error = %v", err)
+	}
+	if user.ID != result.User.ID {
+		t.Errorf("GetByAPIKey() user = %s, want %s", user.ID, result.User.ID)
+	}
+	if !slices.Equal(key.Scopes, AllScopes) || key.Prefix != plain[:apiKeyPrefixLen] {
+		t.Errorf("registration key = %+v, want every scope and the key's prefix", key)
+	}
+
+	var stored string
+	if err := db.QueryRow(ctx, `SELECT key_hash FROM api_keys WHERE id = $1`, key.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != hashAPIKey(plain) {
+		t.Error("the stored key is not the key's hash")
+	}
+
+	// Another key sharing the prefix is told apart by its hash
+	dbtest.Exec(t, db, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1, 'twin', $2, $3, '{read}')
+	`, user.ID, key.Prefix, hashAPIKey(key.Prefix+"twin"))
+	if _, got, err := repo.GetByAPIKey(ctx, plain); err != nil || got.ID != key.ID {
+		t.Errorf("GetByAPIKey() with a shared prefix = %v, %v; want the original key", got, err)
+	}
+	if _, got, err := repo.GetByAPIKey(ctx, key.Prefix+"twin"); err != nil || got.Name != "twin" {
+		t.Errorf("GetByAPIKey() of the other key = %v, %v; want the twin", got, err)
+	}
+
+	for name, presented := range map[string]string{
+		"wrong key":   plain[:len(plain)-1] + "x",
+		"prefix only": plain[:apiKeyPrefixLen],
+		"missing mp_": "xx" + plain[2:],
+		"empty":       "",
+		"unknown key": generateAPIKey(),
+	} {
+		if _, _, err := repo.GetByAPIKey(ctx, presented); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("%s: GetByAPIKey() = %v, want ErrUserNotFound", name, err)
+		}
+	}
+}
+
+func TestAPIKeys_RevokeAndExpire(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "keyholder")
+
+	if _, _, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: "none"}); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("CreateAPIKey() without scopes = %v, want ErrInvalidScope", err)
+	}
+	if _, _, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: "bad", Scopes: []Scope{"like"}}); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("CreateAPIKey() with an unknown scope = %v, want ErrInvalidScope", err)
+	}
+
+	reader, readerPlain, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: "reader", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	expiring, expiringPlain, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: "expiring", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	if _, key, err := repo.GetByAPIKey(ctx, readerPlain); err != nil || !slices.Equal(key.Scopes, []Scope{ScopeRead}) {
+		t.Errorf("GetByAPIKey() = %v, %v; want the read-only key", key, err)
+	}
+
+	if err := repo.RevokeAPIKey(ctx, uuid.New(), reader.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RevokeAPIKey() by another user = %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := repo.RevokeAPIKey(ctx, userID, reader.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() error = %v", err)
+	}
+	if err := repo.RevokeAPIKey(ctx, userID, reader.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("second RevokeAPIKey() = %v, want ErrAPIKeyNotFound", err)
+	}
+	if _, _, err := repo.GetByAPIKey(ctx, readerPlain); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("revoked key: GetByAPIKey() = %v, want ErrUserNotFound", err)
+	}
+
+	dbtest.Exec(t, db, `UPDATE api_keys SET expires_at = $1 WHERE id = $2`, time.Now().Add(-time.Minute), expiring.ID)
+	if _, _, err := repo.GetByAPIKey(ctx, expiringPlain); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expired key: GetByAPIKey() = %v, want ErrUserNotFound", err)
+	}
+
+	keys, err := repo.ListAPIKeys(ctx, userID)
+	if err != nil {
+		t.Fatalf("ListAPIKeys() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("ListAPIKeys() returned %d keys, want 2", len(keys))
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "keyholder")
+
+	create := func(name string) (*APIKey, string) {
+		t.Helper()
+		key, plain, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: name, Scopes: []Scope{ScopeRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, plain
+	}
+
+	// Without a grace period the old key stops working straight away
+	old, oldPlain := create("immediate")
+	before := time.Now()
+	key, plain, until, err := repo.RotateAPIKey(ctx, userID, old.ID, 0)
+	if err != nil {
+		t.Fatalf("RotateAPIKey() error = %v", err)
+	}
+	if key.Name != old.Name || !slices.Equal(key.Scopes, old.Scopes) {
+		t.Errorf("RotateAPIKey() = %+v, want the old key's name and scopes", key)
+	}
+	if until.Before(before.Add(-time.Minute)) || until.After(time.Now().Add(time.Minute)) {
+		t.Errorf("RotateAPIKey() without grace: old key expires at %v, want now", until)
+	}
+	if _, _, err := repo.GetByAPIKey(ctx, oldPlain); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("old key after rotation: GetByAPIKey() = %v, want ErrUserNotFound", err)
+	}
+	if _, _, err := repo.GetByAPIKey(ctx, plain); err != nil {
+		t.Errorf("new key: GetByAPIKey() error = %v", err)
+	}
+	if _, _, _, err := repo.RotateAPIKey(ctx, userID, old.ID, 0); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("rotating a revoked key = %v, want ErrAPIKeyNotFound", err)
+	}
+
+	// The grace period is reported as the time the old key stops working
+	old, oldPlain = create("grace")
+	_, _, until, err = repo.RotateAPIKey(ctx, userID, old.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateAPIKey() error = %v", err)
+	}
+	var stored time.Time
+	if err := db.QueryRow(ctx, `SELECT expires_at FROM api_keys WHERE id = $1`, old.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !until.Equal(stored) || until.Before(before.Add(59*time.Minute)) {
+		t.Errorf("RotateAPIKey() with grace: old key expires at %v, stored %v; want an hour from now", until, stored)
+	}
+	if _, _, err := repo.GetByAPIKey(ctx, oldPlain); err != nil {
+		t.Errorf("old key during the grace period: GetByAPIKey() error = %v", err)
+	}
+
+	// A key due to expire sooner keeps its own expiry
+	old, _ = create("expiring")
+	expiresAt := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	dbtest.Exec(t, db, `UPDATE api_keys SET expires_at = $1 WHERE id = $2`, expiresAt, old.ID)
+	_, _, until, err = repo.RotateAPIKey(ctx, userID, old.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateAPIKey() error = %v", err)
+	}
+	if !until.Equal(expiresAt) {
+		t.Errorf("RotateAPIKey() of an expiring key: old key expires at %v, want %v", until, expiresAt)
+	}
+
+	if _, _, _, err := repo.RotateAPIKey(ctx, uuid.New(), old.ID, 0); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RotateAPIKey() by another user = %v, want ErrAPIKeyNotFound", err)
+	}
+}
+
+func TestRegenerateAPIKey(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+	userID := dbtest.CreateUser(t, db, "keyholder")
+
+	var plains []string
+	for _, name := range []string{"first", "second"} {
+		_, plain, err := repo.CreateAPIKey(ctx, userID, CreateAPIKeyRequest{Name: name, Scopes: []Scope{ScopeRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plains = append(plains, plain)
+	}
+
+	before := time.Now()
+	plain, until, err := repo.RegenerateAPIKey(ctx, userID, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("RegenerateAPIKey() error = %v", err)
+	}
+	if until.Before(before.Add(29*time.Minute)) || until.After(time.Now().Add(31*time.Minute)) {
+		t.Errorf("RegenerateAPIKey() old keys expire at %v, want in 30 minutes", until)
+	}
+	for _, old := range plains {
+		if _, _, err := repo.GetByAPIKey(ctx, old); err != nil {
+			t.Errorf("old key during the grace period: GetByAPIKey() error = %v", err)
+		}
+	}
+	if _, key, err := repo.GetByAPIKey(ctx, plain); err != nil || !slices.Equal(key.Scopes, AllScopes) {
+		t.Errorf("regenerated key = %v, %v; want a full-scope key", key, err)
+	}
+
+	// Regenerating without grace also cuts short keys still in their grace period
+	_, until, err = repo.RegenerateAPIKey(ctx, userID, 0)
+	if err != nil {
+		t.Fatalf("RegenerateAPIKey() error = %v", err)
+	}
+	if until.After(time.Now().Add(time.Minute)) {
+		t.Errorf("RegenerateAPIKey() without grace: old keys expire at %v, want now", until)
+	}
+	for _, old := range append(plains, plain) {
+		if _, _, err := repo.GetByAPIKey(ctx, old); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("replaced key: GetByAPIKey() = %v, want ErrUserNotFound", err)
+		}
+	}
+}
@@ -4,24 +4,46 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/imaging"
+)
+
+// Roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin" // May use the admin API
+)
+
+// Moderation states an admin can put an account in
+const (
+	ModerationSuspended = "suspended" // Every request is refused
+	ModerationSilenced  = "silenced"  // May read, but not post, interact or edit its profile
 )
 
 type User struct {
-	ID               uuid.UUID      `json:"id"`
-	Username         string         `json:"username"`
-	DisplayName      *string        `json:"display_name,omitempty"`
-	Bio              *string        `json:"bio,omitempty"`
-	AvatarURL        *string        `json:"avatar_url,omitempty"`
-	HeaderURL        *string        `json:"header_url,omitempty"`
-	APIKey           *string        `json:"-"` // Never expose in JSON
-	PasswordHash     *string        `json:"-"`
-	IsAgent          bool           `json:"is_agent"`
-	VerificationCode *string        `json:"-"` // Never expose
-	VerifiedAt       *time.Time     `json:"verified_at,omitempty"`
-	XUsername        *string        `json:"x_username,omitempty"`
-	ThemeSettings    *ThemeSettings `json:"theme_settings,omitempty"`
-	CreatedAt        time.Time      `json:"created_at"`
-	UpdatedAt        time.Time      `json:"updated_at"`
+	ID               uuid.UUID        `json:"id"`
+	Username         string           `json:"username"`
+	DisplayName      *string          `json:"display_name,omitempty"`
+	Bio              *string          `json:"bio,omitempty"`
+	AvatarURL        *string          `json:"avatar_url,omitempty"`
+	HeaderURL        *string          `json:"header_url,omitempty"`
+	AvatarVariants   imaging.Variants `json:"avatar_variants,omitempty"`
+	HeaderVariants   imaging.Variants `json:"header_variants,omitempty"`
+	APIKey           *string          `json:"-"` // Never expose in JSON
+	PasswordHash     *string          `json:"-"`
+	IsAgent          bool             `json:"is_agent"`
+	VerificationCode *string          `json:"-"` // Never expose
+	VerifiedAt       *time.Time       `json:"verified_at,omitempty"`
+	XUsername        *string          `json:"x_username,omitempty"`
+	VerifiedVia      *string          `json:"verified_via,omitempty"`      // Verification provider, e.g. "dns"
+	VerifiedIdentity *string          `json:"verified_identity,omitempty"` // e.g. "example.com"
+	ThemeSettings    *ThemeSettings   `json:"theme_settings,omitempty"`
+	OwnerID          *uuid.UUID       `json:"-"` // Human account that manages this agent
+	SuspendedAt      *time.Time       `json:"-"`
+	Role             string           `json:"role,omitempty"`
+	ModerationState  *string          `json:"-"` // Set by an admin; see Moderation
+	ModerationUntil  *time.Time       `json:"-"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
 
 	// Computed fields (not in DB)
 	FollowerCount  int  `json:"follower_count,omitempty"`
@@ -32,40 +54,67 @@ type User struct {
 }
 
 type UserPublic struct {
-	ID             uuid.UUID      `json:"id"`
-	Username       string         `json:"username"`
-	DisplayName    *string        `json:"display_name,omitempty"`
-	Bio            *string        `json:"bio,omitempty"`
-	AvatarURL      *string        `json:"avatar_url,omitempty"`
-	HeaderURL      *string        `json:"header_url,omitempty"`
-	IsAgent        bool           `json:"is_agent"`
-	IsVerified     bool           `json:"is_verified"`
-	XUsername      *string        `json:"x_username,omitempty"`
-	ThemeSettings  *ThemeSettings `json:"theme_settings,omitempty"`
-	CreatedAt      time.Time      `json:"created_at"`
-	FollowerCount  int            `json:"follower_count"`
-	FollowingCount int            `json:"following_count"`
-	PostCount      int            `json:"post_count"`
-	IsFollowing    bool           `json:"is_following,omitempty"`
+	ID               uuid.UUID        `json:"id"`
+	Username         string           `json:"username"`
+	DisplayName      *string          `json:"display_name,omitempty"`
+	Bio              *string          `json:"bio,omitempty"`
+	AvatarURL        *string          `json:"avatar_url,omitempty"`
+	HeaderURL        *string          `json:"header_url,omitempty"`
+	AvatarVariants   imaging.Variants `json:"avatar_variants,omitempty"`
+	HeaderVariants   imaging.Variants `json:"header_variants,omitempty"`
+	IsAgent          bool             `json:"is_agent"`
+	IsVerified       bool             `json:"is_verified"`
+	XUsername        *string          `json:"x_username,omitempty"`
+	VerifiedVia      *string          `json:"verified_via,omitempty"`
+	VerifiedIdentity *string          `json:"verified_identity,omitempty"`
+	ThemeSettings    *ThemeSettings   `json:"theme_settings,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	FollowerCount    int              `json:"follower_count"`
+	FollowingCount   int              `json:"following_count"`
+	PostCount        int              `json:"post_count"`
+	IsFollowing      bool             `json:"is_following,omitempty"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Moderation returns the moderation state in force on the account at now,
+// or "" if there is none or it has expired.
+func (u *User) Moderation(now time.Time) string {
+	if u.ModerationState == nil || (u.ModerationUntil != nil && !u.ModerationUntil.After(now)) {
+		return ""
+	}
+	return *u.ModerationState
+}
+
+// IsSuspended reports whether the account's owner or an admin has
+// suspended it.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil || u.Moderation(time.Now()) == ModerationSuspended
 }
 
 func (u *User) ToPublic() UserPublic {
 	return UserPublic{
-		ID:             u.ID,
-		Username:       u.Username,
-		DisplayName:    u.DisplayName,
-		Bio:            u.Bio,
-		AvatarURL:      u.AvatarURL,
-		HeaderURL:      u.HeaderURL,
-		IsAgent:        u.IsAgent,
-		IsVerified:     u.VerifiedAt != nil,
-		XUsername:      u.XUsername,
-		ThemeSettings:  u.ThemeSettings,
-		CreatedAt:      u.CreatedAt,
-		FollowerCount:  u.FollowerCount,
-		FollowingCount: u.FollowingCount,
-		PostCount:      u.PostCount,
-		IsFollowing:    u.IsFollowing,
+		ID:               u.ID,
+		Username:         u.Username,
+		DisplayName:      u.DisplayName,
+		Bio:              u.Bio,
+		AvatarURL:        u.AvatarURL,
+		HeaderURL:        u.HeaderURL,
+		AvatarVariants:   u.AvatarVariants,
+		HeaderVariants:   u.HeaderVariants,
+		IsAgent:          u.IsAgent,
+		IsVerified:       u.VerifiedAt != nil,
+		XUsername:        u.XUsername,
+		VerifiedVia:      u.VerifiedVia,
+		VerifiedIdentity: u.VerifiedIdentity,
+		ThemeSettings:    u.ThemeSettings,
+		CreatedAt:        u.CreatedAt,
+		FollowerCount:    u.FollowerCount,
+		FollowingCount:   u.FollowingCount,
+		PostCount:        u.PostCount,
+		IsFollowing:      u.IsFollowing,
 	}
 }
 
@@ -94,6 +143,19 @@ type RegisterResponse struct {
 }
 
 type VerifyRequest struct {
-	XUsername string  `json:"x_username"`
+	// Provider defaults to "twitter", which also accepts the original
+	// x_username and tweet_url fields
+	Provider string  `json:"provider,omitempty"`
+	Identity string  `json:"identity,omitempty"`
+	ProofURL *string `json:"proof_url,omitempty"`
+
+	XUsername string  `json:"x_username,omitempty"`
 	TweetURL  *string `json:"tweet_url,omitempty"`
 }
+
+// ManagedAgent is an agent as seen by the human account that owns it.
+type ManagedAgent struct {
+	UserPublic
+	SuspendedAt  *time.Time `json:"suspended_at,omitempty"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
@@ -0,0 +1,52 @@
+package users
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModeration(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	suspended := ModerationSuspended
+	silenced := ModerationSilenced
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name  string
+		state *string
+		until *time.Time
+		want  string
+	}{
+		{"none", nil, nil, ""},
+		{"indefinite", &suspended, nil, ModerationSuspended},
+		{"until later", &silenced, &later, ModerationSilenced},
+		{"expired", &suspended, &earlier, ""},
+		{"expires now", &suspended, &now, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &User{ModerationState: tt.state, ModerationUntil: tt.until}
+			if got := u.Moderation(now); got != tt.want {
+				t.Errorf("Moderation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSuspended(t *testing.T) {
+	silenced := ModerationSilenced
+	suspended := ModerationSuspended
+	now := time.Now()
+
+	if (&User{ModerationState: &silenced}).IsSuspended() {
+		t.Error("silenced user reported as suspended")
+	}
+	if !(&User{ModerationState: &suspended}).IsSuspended() {
+		t.Error("admin suspension not reported")
+	}
+	if !(&User{SuspendedAt: &now}).IsSuspended() {
+		t.Error("owner suspension not reported")
+	}
+}
@@ -0,0 +1,185 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/watzon/moltpress/internal/audit"
+)
+
+var (
+	ErrInvalidModeration = errors.New("invalid moderation state")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrModerateAdmin     = errors.New("admins cannot be moderated")
+	ErrOwnRole           = errors.New("cannot change your own role")
+)
+
+// Moderated returns an SQL condition that holds while the user given as
+// an SQL expression is suspended, by an admin or its owner, or silenced.
+// Such accounts and their posts are hidden from everyone else.
+func Moderated(userID string) string {
+	return `EXISTS(SELECT 1 FROM users WHERE id = ` + userID + ` AND (suspended_at IS NOT NULL
+		OR (moderation_state IS NOT NULL AND (moderation_until IS NULL OR moderation_until > NOW()))))`
+}
+
+// ModeratedIDs returns the users that Moderated currently holds for.
+func (r *Repository) ModeratedIDs(ctx context.Context) (map[uuid.UUID]bool, error) {
+	rows, err := r.db.Query(ctx, `SELECT id FROM users u WHERE `+Moderated("u.id"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// lockRole locks a user's row for an admin action and returns its role.
+func lockRole(ctx context.Context, tx pgx.Tx, id uuid.UUID) (string, error) {
+	var role string
+	err := tx.QueryRow(ctx, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// Moderate suspends or silences userID until the given time, or until
+// restored when until is nil, on behalf of adminID. Admins cannot be
+// moderated; demote them first.
+func (r *Repository) Moderate(ctx context.Context, adminID, userID uuid.UUID, state string, until *time.Time, reason string) error {
+	action := audit.ActionSuspendUser
+	switch state {
+	case ModerationSuspended:
+	case ModerationSilenced:
+		action = audit.ActionSilenceUser
+	default:
+		return ErrInvalidModeration
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := lockRole(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if role == RoleAdmin {
+		return ErrModerateAdmin
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET moderation_state = $2, moderation_until = $3, moderation_reason = NULLIF($4, ''),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID, state, until, reason)
+	if err != nil {
+		return err
+	}
+
+	err = audit.Record(ctx, tx, audit.Entry{
+		ActorID:    adminID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Details:    map[string]any{"until": until, "reason": reason},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Restore lifts any suspension or silence an admin put on userID.
+func (r *Repository) Restore(ctx context.Context, adminID, userID uuid.UUID, reason string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := lockRole(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET moderation_state = NULL, moderation_until = NULL, moderation_reason = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = audit.Record(ctx, tx, audit.Entry{
+		ActorID:    adminID,
+		Action:     audit.ActionRestoreUser,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Details:    map[string]any{"reason": reason},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// SetRole gives userID role on behalf of adminID, who cannot change their
+// own so there is always an admin left.
+func (r *Repository) SetRole(ctx context.Context, adminID, userID uuid.UUID, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return ErrInvalidRole
+	}
+	if adminID == userID {
+		return ErrOwnRole
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	previous, err := lockRole(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, userID, role)
+	if err != nil {
+		return err
+	}
+
+	err = audit.Record(ctx, tx, audit.Entry{
+		ActorID:    adminID,
+		Action:     audit.ActionSetRole,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Details:    map[string]any{"from": previous, "to": role},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
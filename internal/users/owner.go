@@ -0,0 +1,182 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrNotOwner          = errors.New("only human accounts can own agents")
+	ErrAgentAlreadyOwned = errors.New("agent already has an owner")
+	ErrAccountSuspended  = errors.New("account suspended")
+)
+
+// CreateOwnedAgent registers an agent managed by ownerID. When the owner was
+// verified against a proof the agent inherits that verification and skips
+// the X flow.
+func (r *Repository) CreateOwnedAgent(ctx context.Context, ownerID uuid.UUID, req CreateUserRequest) (*CreateResult, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var ownerIsAgent bool
+	var ownerVerified bool
+	var ownerXUsername, ownerVerifiedVia, ownerIdentity *string
+	err = tx.QueryRow(ctx, `
+		SELECT is_agent, verified_at IS NOT NULL AND verification_proven, x_username, verified_via, verified_identity
+		FROM users WHERE id = $1
+	`, ownerID).Scan(&ownerIsAgent, &ownerVerified, &ownerXUsername, &ownerVerifiedVia, &ownerIdentity)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if ownerIsAgent {
+		return nil, ErrNotOwner
+	}
+
+	var verificationCode *string
+	var verificationCodePlain string
+	if !ownerVerified {
+		code := generateVerificationCode()
+		verificationCode = &code
+		verificationCodePlain = code
+	}
+
+	user := &User{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (username, display_name, bio, avatar_url, is_agent, owner_id, verification_code,
+		                   verified_at, x_username, verified_via, verified_identity)
+		VALUES ($1, $2, $3, $4, true, $5, $6,
+		        CASE WHEN $7::boolean THEN CURRENT_TIMESTAMP END,
+		        CASE WHEN $7::boolean THEN $8 END,
+		        CASE WHEN $7::boolean THEN $9 END,
+		        CASE WHEN $7::boolean THEN $10 END)
+		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, verification_code, verified_at,
+		          x_username, verified_via, verified_identity, owner_id, created_at, updated_at
+	`, req.Username, req.DisplayName, req.Bio, req.AvatarURL, ownerID, verificationCode,
+		ownerVerified, ownerXUsername, ownerVerifiedVia, ownerIdentity).Scan(
+		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
+		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.VerificationCode,
+		&user.VerifiedAt, &user.XUsername, &user.VerifiedVia, &user.VerifiedIdentity,
+		&user.OwnerID, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		if isUniqueViolation(err, "users_username_key") {
+			return nil, ErrUsernameExists
+		}
+		return nil, err
+	}
+
+	_, apiKeyPlain, err := insertAPIKey(ctx, tx, user.ID, "default", AllScopes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &CreateResult{
+		User:             user,
+		APIKey:           apiKeyPlain,
+		VerificationCode: verificationCodePlain,
+	}, nil
+}
+
+// ClaimAgent attaches an existing, unowned agent to ownerID.
+func (r *Repository) ClaimAgent(ctx context.Context, ownerID, agentID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE users SET owner_id = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND is_agent = true AND owner_id IS NULL
+	`, ownerID, agentID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAgentAlreadyOwned
+	}
+	return nil
+}
+
+func (r *Repository) ListOwnedAgents(ctx context.Context, ownerID uuid.UUID) ([]ManagedAgent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			u.id, u.username, u.display_name, u.bio, u.avatar_url, u.header_url,
+			u.is_agent, u.verified_at, u.x_username, u.created_at, u.suspended_at,
+			(SELECT COUNT(*) FROM follows WHERE following_id = u.id) as follower_count,
+			(SELECT COUNT(*) FROM follows WHERE follower_id = u.id) as following_count,
+			(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND reblog_of_id IS NULL AND state = 'published') as post_count,
+			(SELECT MAX(last_used_at) FROM api_keys WHERE user_id = u.id) as last_active_at
+		FROM users u
+		WHERE u.owner_id = $1
+		ORDER BY u.created_at ASC
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []ManagedAgent{}
+	for rows.Next() {
+		var u User
+		var agent ManagedAgent
+		if err := rows.Scan(
+			&u.ID, &u.Username, &u.DisplayName, &u.Bio, &u.AvatarURL, &u.HeaderURL,
+			&u.IsAgent, &u.VerifiedAt, &u.XUsername, &u.CreatedAt, &agent.SuspendedAt,
+			&u.FollowerCount, &u.FollowingCount, &u.PostCount, &agent.LastActiveAt,
+		); err != nil {
+			return nil, err
+		}
+		agent.UserPublic = u.ToPublic()
+		agents = append(agents, agent)
+	}
+
+	return agents, rows.Err()
+}
+
+// GetOwnedAgent looks up an agent by username, returning ErrUserNotFound
+// unless it belongs to ownerID.
+func (r *Repository) GetOwnedAgent(ctx context.Context, ownerID uuid.UUID, username string) (*User, error) {
+	user := &User{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, username, display_name, bio, avatar_url, header_url, is_agent,
+		       verified_at, x_username, owner_id, suspended_at, created_at, updated_at
+		FROM users WHERE username = $1 AND owner_id = $2
+	`, username, ownerID).Scan(
+		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
+		&user.AvatarURL, &user.HeaderURL, &user.IsAgent,
+		&user.VerifiedAt, &user.XUsername, &user.OwnerID, &user.SuspendedAt,
+		&user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) SetSuspended(ctx context.Context, id uuid.UUID, suspended bool) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE users SET
+			suspended_at = CASE WHEN $2::boolean THEN COALESCE(suspended_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, suspended)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -6,10 +6,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/imaging"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,6 +23,10 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 )
 
+// MinPasswordLength applies to human accounts, which log in with a password
+// instead of holding an API key.
+const MinPasswordLength = 8
+
 type Repository struct {
 	db *pgxpool.Pool
 }
@@ -27,6 +35,13 @@ func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
+// isUniqueViolation reports whether err is the database refusing a
+// duplicate in the unique constraint named constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
 type CreateResult struct {
 	User             *User
 	APIKey           string
@@ -34,22 +49,11 @@ type CreateResult struct {
 }
 
 func (r *Repository) Create(ctx context.Context, req CreateUserRequest) (*CreateResult, error) {
-	// Generate API key for agents
-	var apiKey *string
-	var apiKeyPlain string
-	var verificationCode *string
-	var verificationCodePlain string
-
-	if req.IsAgent {
-		key := generateAPIKey()
-		apiKey = &key
-		apiKeyPlain = key
-
-		// Generate verification code for X validation
-		code := generateVerificationCode()
-		verificationCode = &code
-		verificationCodePlain = code
-	}
+	// Generate verification code for X validation. Humans verify too so
+	// the agents they own can inherit their verification.
+	code := generateVerificationCode()
+	verificationCode := &code
+	verificationCodePlain := code
 
 	// Hash password if provided
 	var passwordHash *string
@@ -62,23 +66,42 @@ func (r *Repository) Create(ctx context.Context, req CreateUserRequest) (*Create
 		passwordHash = &hashStr
 	}
 
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	user := &User{}
-	err := r.db.QueryRow(ctx, `
-		INSERT INTO users (username, display_name, bio, avatar_url, api_key, password_hash, is_agent, verification_code)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (username, display_name, bio, avatar_url, password_hash, is_agent, verification_code)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, verification_code, verified_at, x_username, created_at, updated_at
-	`, req.Username, req.DisplayName, req.Bio, req.AvatarURL, apiKey, passwordHash, req.IsAgent, verificationCode).Scan(
+	`, req.Username, req.DisplayName, req.Bio, req.AvatarURL, passwordHash, req.IsAgent, verificationCode).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.VerificationCode,
 		&user.VerifiedAt, &user.XUsername, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
-		if err.Error() == `ERROR: duplicate key value violates unique constraint "users_username_key" (SQLSTATE 23505)` {
+		if isUniqueViolation(err, "users_username_key") {
 			return nil, ErrUsernameExists
 		}
 		return nil, err
 	}
 
+	// Generate API key for agents
+	var apiKeyPlain string
+	if req.IsAgent {
+		_, apiKeyPlain, err = insertAPIKey(ctx, tx, user.ID, "default", AllScopes, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
 	return &CreateResult{
 		User:             user,
 		APIKey:           apiKeyPlain,
@@ -96,13 +119,15 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRow(ctx, `
 		SELECT id, username, display_name, bio, avatar_url, header_url, is_agent,
-		       verification_code, verified_at, x_username, created_at, updated_at
+		       verification_code, verified_at, x_username, verified_via, verified_identity,
+		       owner_id, suspended_at, role, moderation_state, moderation_until, created_at, updated_at
 		FROM users WHERE id = $1
 	`, id).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent,
 		&user.VerificationCode, &user.VerifiedAt, &user.XUsername,
-		&user.CreatedAt, &user.UpdatedAt,
+		&user.VerifiedVia, &user.VerifiedIdentity, &user.OwnerID, &user.SuspendedAt,
+		&user.Role, &user.ModerationState, &user.ModerationUntil, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -116,14 +141,15 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
 
 func (r *Repository) GetByUsername(ctx context.Context, username string) (*User, error) {
 	user := &User{}
-	var themeJSON []byte
+	var themeJSON, avatarVariants, headerVariants []byte
 	err := r.db.QueryRow(ctx, `
-		SELECT id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings
+		SELECT id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings,
+		       avatar_variants, header_variants
 		FROM users WHERE username = $1
 	`, username).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.CreatedAt, &user.UpdatedAt,
-		&themeJSON,
+		&themeJSON, &avatarVariants, &headerVariants,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -136,28 +162,8 @@ func (r *Repository) GetByUsername(ctx context.Context, username string) (*User,
 		user.ThemeSettings = &ThemeSettings{}
 		json.Unmarshal(themeJSON, user.ThemeSettings)
 	}
-
-	return user, nil
-}
-
-func (r *Repository) GetByAPIKey(ctx context.Context, apiKey string) (*User, error) {
-	user := &User{}
-	err := r.db.QueryRow(ctx, `
-		SELECT id, username, display_name, bio, avatar_url, header_url, is_agent,
-		       verification_code, verified_at, x_username, created_at, updated_at
-		FROM users WHERE api_key = $1
-	`, apiKey).Scan(
-		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
-		&user.AvatarURL, &user.HeaderURL, &user.IsAgent,
-		&user.VerificationCode, &user.VerifiedAt, &user.XUsername,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrUserNotFound
-		}
-		return nil, err
-	}
+	user.AvatarVariants = imaging.DecodeVariants(avatarVariants)
+	user.HeaderVariants = imaging.DecodeVariants(headerVariants)
 
 	return user, nil
 }
@@ -197,21 +203,27 @@ func (r *Repository) Update(ctx context.Context, id uuid.UUID, req UpdateUserReq
 	}
 
 	user := &User{}
-	var themeJSON []byte
+	var themeJSON, avatarVariants, headerVariants []byte
 	err := r.db.QueryRow(ctx, `
 		UPDATE users SET
 			display_name = COALESCE($2, display_name),
 			bio = COALESCE($3, bio),
 			avatar_url = COALESCE($4, avatar_url),
 			header_url = COALESCE($5, header_url),
+			-- Variants belong to uploaded images, not a URL set directly
+			avatar_variants = CASE WHEN $4::text IS NULL THEN avatar_variants END,
+			header_variants = CASE WHEN $5::text IS NULL THEN header_variants END,
+			avatar_upload_id = CASE WHEN $4::text IS NULL THEN avatar_upload_id END,
+			header_upload_id = CASE WHEN $5::text IS NULL THEN header_upload_id END,
 			theme_settings = COALESCE($6, theme_settings),
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
-		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings
+		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings,
+		       avatar_variants, header_variants
 	`, id, req.DisplayName, req.Bio, req.AvatarURL, req.HeaderURL, themeSettingsJSON).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.CreatedAt, &user.UpdatedAt,
-		&themeJSON,
+		&themeJSON, &avatarVariants, &headerVariants,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -224,42 +236,71 @@ func (r *Repository) Update(ctx context.Context, id uuid.UUID, req UpdateUserReq
 		user.ThemeSettings = &ThemeSettings{}
 		json.Unmarshal(themeJSON, user.ThemeSettings)
 	}
+	user.AvatarVariants = imaging.DecodeVariants(avatarVariants)
+	user.HeaderVariants = imaging.DecodeVariants(headerVariants)
 
 	return user, nil
 }
 
-func (r *Repository) GetProfileImageKeys(ctx context.Context, id uuid.UUID) (*string, *string, error) {
-	var avatarKey *string
-	var headerKey *string
+// ProfileImage is an avatar or header image we store. UploadID is set
+// when it was attached from /api/v1/media.
+type ProfileImage struct {
+	URL      string
+	Key      string
+	Variants imaging.Variants
+	UploadID *uuid.UUID
+}
+
+// GetProfileImageKeys returns the storage keys of the user's avatar and
+// header images, including their variants. Images attached from uploads
+// are left to the upload sweep and have none.
+func (r *Repository) GetProfileImageKeys(ctx context.Context, id uuid.UUID) ([]string, []string, error) {
+	var avatarKey, headerKey *string
+	var avatarVariants, headerVariants []byte
+	var avatarUploadID, headerUploadID *uuid.UUID
 	if err := r.db.QueryRow(ctx, `
-		SELECT avatar_key, header_key
+		SELECT avatar_key, header_key, avatar_variants, header_variants, avatar_upload_id, header_upload_id
 		FROM users
 		WHERE id = $1
-	`, id).Scan(&avatarKey, &headerKey); err != nil {
+	`, id).Scan(&avatarKey, &headerKey, &avatarVariants, &headerVariants, &avatarUploadID, &headerUploadID); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil, ErrUserNotFound
 		}
 		return nil, nil, err
 	}
 
-	return avatarKey, headerKey, nil
+	return imageKeys(avatarKey, avatarVariants, avatarUploadID), imageKeys(headerKey, headerVariants, headerUploadID), nil
 }
 
-func (r *Repository) UpdateAvatar(ctx context.Context, id uuid.UUID, avatarURL, avatarKey string) (*User, error) {
+func imageKeys(key *string, variants []byte, uploadID *uuid.UUID) []string {
+	if uploadID != nil {
+		return nil
+	}
+	keys := imaging.DecodeVariants(variants).Keys()
+	if key != nil && *key != "" && !slices.Contains(keys, *key) {
+		keys = append(keys, *key)
+	}
+	return keys
+}
+
+func (r *Repository) UpdateAvatar(ctx context.Context, id uuid.UUID, img ProfileImage) (*User, error) {
 	user := &User{}
-	var themeJSON []byte
+	var themeJSON, avatarVariants, headerVariants []byte
 
 	err := r.db.QueryRow(ctx, `
 		UPDATE users SET
 			avatar_url = $2,
 			avatar_key = $3,
+			avatar_variants = $4,
+			avatar_upload_id = $5,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
-		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings
-	`, id, avatarURL, avatarKey).Scan(
+		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings,
+		       avatar_variants, header_variants
+	`, id, img.URL, img.Key, imaging.EncodeVariants(img.Variants), img.UploadID).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.CreatedAt, &user.UpdatedAt,
-		&themeJSON,
+		&themeJSON, &avatarVariants, &headerVariants,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -272,25 +313,30 @@ func (r *Repository) UpdateAvatar(ctx context.Context, id uuid.UUID, avatarURL,
 		user.ThemeSettings = &ThemeSettings{}
 		json.Unmarshal(themeJSON, user.ThemeSettings)
 	}
+	user.AvatarVariants = imaging.DecodeVariants(avatarVariants)
+	user.HeaderVariants = imaging.DecodeVariants(headerVariants)
 
 	return user, nil
 }
 
-func (r *Repository) UpdateHeader(ctx context.Context, id uuid.UUID, headerURL, headerKey string) (*User, error) {
+func (r *Repository) UpdateHeader(ctx context.Context, id uuid.UUID, img ProfileImage) (*User, error) {
 	user := &User{}
-	var themeJSON []byte
+	var themeJSON, avatarVariants, headerVariants []byte
 
 	err := r.db.QueryRow(ctx, `
 		UPDATE users SET
 			header_url = $2,
 			header_key = $3,
+			header_variants = $4,
+			header_upload_id = $5,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
-		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings
-	`, id, headerURL, headerKey).Scan(
+		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent, created_at, updated_at, theme_settings,
+		       avatar_variants, header_variants
+	`, id, img.URL, img.Key, imaging.EncodeVariants(img.Variants), img.UploadID).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.CreatedAt, &user.UpdatedAt,
-		&themeJSON,
+		&themeJSON, &avatarVariants, &headerVariants,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -303,6 +349,8 @@ func (r *Repository) UpdateHeader(ctx context.Context, id uuid.UUID, headerURL,
 		user.ThemeSettings = &ThemeSettings{}
 		json.Unmarshal(themeJSON, user.ThemeSettings)
 	}
+	user.AvatarVariants = imaging.DecodeVariants(avatarVariants)
+	user.HeaderVariants = imaging.DecodeVariants(headerVariants)
 
 	return user, nil
 }
@@ -310,15 +358,15 @@ func (r *Repository) UpdateHeader(ctx context.Context, id uuid.UUID, headerURL,
 func (r *Repository) GetWithStats(ctx context.Context, id uuid.UUID, viewerID *uuid.UUID) (*User, error) {
 	user := &User{}
 	var isFollowing bool
-	var themeJSON []byte
+	var themeJSON, avatarVariants, headerVariants []byte
 
 	err := r.db.QueryRow(ctx, `
 		SELECT 
 			u.id, u.username, u.display_name, u.bio, u.avatar_url, u.header_url, 
-			u.is_agent, u.created_at, u.updated_at, u.theme_settings,
+			u.is_agent, u.created_at, u.updated_at, u.theme_settings, u.avatar_variants, u.header_variants,
 			(SELECT COUNT(*) FROM follows WHERE following_id = u.id) as follower_count,
 			(SELECT COUNT(*) FROM follows WHERE follower_id = u.id) as following_count,
-			(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND reblog_of_id IS NULL) as post_count,
+			(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND reblog_of_id IS NULL AND state = 'published') as post_count,
 			CASE WHEN $2::uuid IS NOT NULL THEN
 				EXISTS(SELECT 1 FROM follows WHERE follower_id = $2 AND following_id = u.id)
 			ELSE false END as is_following
@@ -327,7 +375,7 @@ func (r *Repository) GetWithStats(ctx context.Context, id uuid.UUID, viewerID *u
 	`, id, viewerID).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent, &user.CreatedAt, &user.UpdatedAt,
-		&themeJSON,
+		&themeJSON, &avatarVariants, &headerVariants,
 		&user.FollowerCount, &user.FollowingCount, &user.PostCount, &isFollowing,
 	)
 	if err != nil {
@@ -341,21 +389,38 @@ func (r *Repository) GetWithStats(ctx context.Context, id uuid.UUID, viewerID *u
 		user.ThemeSettings = &ThemeSettings{}
 		json.Unmarshal(themeJSON, user.ThemeSettings)
 	}
+	user.AvatarVariants = imaging.DecodeVariants(avatarVariants)
+	user.HeaderVariants = imaging.DecodeVariants(headerVariants)
 
 	user.IsFollowing = isFollowing
 	return user, nil
 }
 
-func (r *Repository) RegenerateAPIKey(ctx context.Context, id uuid.UUID) (string, error) {
-	newKey := generateAPIKey()
-	_, err := r.db.Exec(ctx, `
-		UPDATE users SET api_key = $2, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $1 AND is_agent = true
-	`, id, newKey)
+// RegenerateAPIKey retires every key on the account and issues a single
+// new key with full scopes. With a non-zero grace the old keys keep working
+// until the grace period ends instead of being revoked immediately. It
+// returns the new key and when the last of the old ones stops working.
+func (r *Repository) RegenerateAPIKey(ctx context.Context, id uuid.UUID, grace time.Duration) (string, time.Time, error) {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-	return newKey, nil
+	defer tx.Rollback(ctx)
+
+	oldKeysExpireAt, err := retireAPIKeys(ctx, tx, `user_id = $1`, id, grace)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	_, newKey, err := insertAPIKey(ctx, tx, id, "default", AllScopes, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+	return newKey, oldKeysExpireAt, nil
 }
 
 func (r *Repository) ValidatePassword(ctx context.Context, username, password string) (*User, error) {
@@ -389,12 +454,6 @@ func (r *Repository) ValidatePassword(ctx context.Context, username, password st
 	return user, nil
 }
 
-func generateAPIKey() string {
-	bytes := make([]byte, 32)
-	rand.Read(bytes)
-	return "mp_" + hex.EncodeToString(bytes)
-}
-
 func (r *Repository) GetByVerificationCode(ctx context.Context, code string) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRow(ctx, `
@@ -416,21 +475,28 @@ func (r *Repository) GetByVerificationCode(ctx context.Context, code string) (*U
 	return user, nil
 }
 
-func (r *Repository) VerifyUser(ctx context.Context, userID uuid.UUID, xUsername string) (*User, error) {
+// VerifyUser marks the account verified through provider. The X username is
+// only recorded for twitter verifications.
+func (r *Repository) VerifyUser(ctx context.Context, userID uuid.UUID, provider, identity string) (*User, error) {
 	user := &User{}
 	err := r.db.QueryRow(ctx, `
 		UPDATE users SET
 			verified_at = CURRENT_TIMESTAMP,
-			x_username = $2,
+			verification_proven = true,
+			verified_via = $2,
+			verified_identity = $3,
+			x_username = CASE WHEN $2 = 'twitter' THEN $3 ELSE x_username END,
 			verification_code = NULL,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
 		RETURNING id, username, display_name, bio, avatar_url, header_url, is_agent,
-				  verification_code, verified_at, x_username, created_at, updated_at
-	`, userID, xUsername).Scan(
+				  verification_code, verified_at, x_username, verified_via, verified_identity,
+				  created_at, updated_at
+	`, userID, provider, identity).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.Bio,
 		&user.AvatarURL, &user.HeaderURL, &user.IsAgent,
 		&user.VerificationCode, &user.VerifiedAt, &user.XUsername,
+		&user.VerifiedVia, &user.VerifiedIdentity,
 		&user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -0,0 +1,59 @@
+package users
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/watzon/moltpress/internal/database"
+)
+
+// Search finds accounts whose username, display name or bio match query.
+// Exact and prefix username matches rank above everything else so looking
+// someone up by handle works as expected. Suspended and silenced users,
+// and users the viewer has blocked or been blocked by, are left out.
+func (r *Repository) Search(ctx context.Context, query string, limit int, viewerID *uuid.UUID) ([]UserPublic, error) {
+	username := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query), "@"))
+
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			u.id, u.username, u.display_name, u.bio, u.avatar_url, u.header_url,
+			u.is_agent, u.verified_at, u.x_username, u.created_at,
+			(SELECT COUNT(*) FROM follows WHERE following_id = u.id) as follower_count,
+			(SELECT COUNT(*) FROM follows WHERE follower_id = u.id) as following_count,
+			(SELECT COUNT(*) FROM posts WHERE user_id = u.id AND reblog_of_id IS NULL AND state = 'published') as post_count,
+			CASE WHEN $4::uuid IS NOT NULL THEN
+				EXISTS(SELECT 1 FROM follows WHERE follower_id = $4 AND following_id = u.id)
+			ELSE false END as is_following
+		FROM users u
+		WHERE NOT `+Moderated("u.id")+`
+		  AND NOT EXISTS(SELECT 1 FROM blocks WHERE (blocker_id = $4 AND blocked_id = u.id)
+			OR (blocker_id = u.id AND blocked_id = $4))
+		  AND (u.search_vector @@ websearch_to_tsquery('simple', $1) OR LOWER(u.username) LIKE $2 || '%' ESCAPE '\')
+		ORDER BY
+			(LOWER(u.username) = $5) DESC,
+			(LOWER(u.username) LIKE $2 || '%' ESCAPE '\') DESC,
+			ts_rank(u.search_vector, websearch_to_tsquery('simple', $1)) DESC,
+			u.created_at ASC
+		LIMIT $3
+	`, query, database.EscapeLike(username), limit, viewerID, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []UserPublic{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(
+			&u.ID, &u.Username, &u.DisplayName, &u.Bio, &u.AvatarURL, &u.HeaderURL,
+			&u.IsAgent, &u.VerifiedAt, &u.XUsername, &u.CreatedAt,
+			&u.FollowerCount, &u.FollowingCount, &u.PostCount, &u.IsFollowing,
+		); err != nil {
+			return nil, err
+		}
+		results = append(results, u.ToPublic())
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,44 @@
+package users
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/database/dbtest"
+)
+
+func TestSearch_Ranking(t *testing.T) {
+	db := dbtest.New(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	// Created oldest first so a tie in ranking would put rob_erts ahead.
+	// robxert is there to catch _ being treated as a wildcard.
+	for _, username := range []string{"rob_erts", "robxert", "rob_ert"} {
+		dbtest.CreateUser(t, db, username)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"rob_ert", []string{"rob_ert", "rob_erts"}},
+		{"@ROB_ERT", []string{"rob_ert", "rob_erts"}},
+		{"rob_erts", []string{"rob_erts"}},
+		{"robxert", []string{"robxert"}},
+	}
+	for _, tt := range tests {
+		results, err := repo.Search(ctx, tt.query, 10, nil)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", tt.query, err)
+		}
+		var got []string
+		for _, u := range results {
+			got = append(got, u.Username)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
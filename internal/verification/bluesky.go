@@ -0,0 +1,104 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BlueskyVerifier checks a public post by the claimed handle using the
+// unauthenticated AppView API.
+type BlueskyVerifier struct {
+	// APIBase defaults to https://public.api.bsky.app.
+	APIBase    string
+	HTTPClient *http.Client
+}
+
+func (v *BlueskyVerifier) Name() string { return "bluesky" }
+
+type blueskyPosts struct {
+	Posts []struct {
+		Author struct {
+			DID    string `json:"did"`
+			Handle string `json:"handle"`
+		} `json:"author"`
+		Record struct {
+			Text string `json:"text"`
+		} `json:"record"`
+	} `json:"posts"`
+}
+
+func (v *BlueskyVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	handle := strings.ToLower(normalizeHandle(claim.Identity))
+	if _, err := normalizeDomain(handle); err != nil {
+		return "", ErrInvalidClaim
+	}
+
+	profile, rkey, err := parseBlueskyPostURL(claim.ProofURL)
+	if err != nil {
+		return "", err
+	}
+
+	base := v.APIBase
+	if base == "" {
+		base = "https://public.api.bsky.app"
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	body, err := fetch(ctx, v.HTTPClient, base+"/xrpc/com.atproto.identity.resolveHandle?"+url.Values{"handle": {handle}}.Encode(), "application/json")
+	if err != nil {
+		return "", err
+	}
+	var resolved struct {
+		DID string `json:"did"`
+	}
+	if err := json.Unmarshal(body, &resolved); err != nil || resolved.DID == "" {
+		return "", ErrProofNotFound
+	}
+
+	// The post URL may name the author by handle or by DID
+	if !strings.EqualFold(profile, handle) && profile != resolved.DID {
+		return "", ErrIdentityMismatch
+	}
+
+	uri := "at://" + resolved.DID + "/app.bsky.feed.post/" + rkey
+	body, err = fetch(ctx, v.HTTPClient, base+"/xrpc/app.bsky.feed.getPosts?"+url.Values{"uris": {uri}}.Encode(), "application/json")
+	if err != nil {
+		return "", err
+	}
+
+	var payload blueskyPosts
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", ErrFetchFailed
+	}
+	if len(payload.Posts) == 0 {
+		return "", ErrProofNotFound
+	}
+
+	post := payload.Posts[0]
+	if post.Author.DID != resolved.DID {
+		return "", ErrIdentityMismatch
+	}
+	if !containsCode(post.Record.Text, claim.Code) {
+		return "", ErrCodeNotFound
+	}
+
+	return handle, nil
+}
+
+// parseBlueskyPostURL extracts the author and record key from
+// https://bsky.app/profile/<author>/post/<rkey>.
+func parseBlueskyPostURL(proof string) (string, string, error) {
+	parsed, err := url.Parse(proof)
+	if err != nil || !strings.EqualFold(parsed.Hostname(), "bsky.app") {
+		return "", "", ErrInvalidClaim
+	}
+
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) != 4 || segments[0] != "profile" || segments[2] != "post" || segments[1] == "" || segments[3] == "" {
+		return "", "", ErrInvalidClaim
+	}
+	return segments[1], segments[3], nil
+}
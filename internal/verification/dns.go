@@ -0,0 +1,88 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DNSTXTPrefix is the record value that carries the code, published on
+// _moltpress.<domain>.
+const DNSTXTPrefix = "moltpress-verify="
+
+// DNSVerifier looks up a TXT record through a DNS-over-HTTPS resolver that
+// speaks the JSON API offered by Cloudflare and Google.
+type DNSVerifier struct {
+	// ResolverURL defaults to https://cloudflare-dns.com/dns-query.
+	ResolverURL string
+	HTTPClient  *http.Client
+}
+
+func (v *DNSVerifier) Name() string { return "dns" }
+
+type dohResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+const (
+	dnsTypeTXT     = 16
+	dnsRcodeNXName = 3
+)
+
+func (v *DNSVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	domain, err := normalizeDomain(claim.Identity)
+	if err != nil {
+		return "", err
+	}
+
+	resolver := v.ResolverURL
+	if resolver == "" {
+		resolver = "https://cloudflare-dns.com/dns-query"
+	}
+	query := url.Values{"name": {"_moltpress." + domain}, "type": {"TXT"}}
+
+	body, err := fetch(ctx, v.HTTPClient, resolver+"?"+query.Encode(), "application/dns-json")
+	if err != nil {
+		return "", err
+	}
+
+	var payload dohResponse
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", ErrFetchFailed
+	}
+	if payload.Status == dnsRcodeNXName {
+		return "", ErrProofNotFound
+	}
+
+	found := false
+	for _, answer := range payload.Answer {
+		if answer.Type != dnsTypeTXT {
+			continue
+		}
+		found = true
+		value := txtValue(answer.Data)
+		if strings.HasPrefix(value, DNSTXTPrefix) && strings.EqualFold(strings.TrimPrefix(value, DNSTXTPrefix), claim.Code) {
+			return domain, nil
+		}
+	}
+	if !found {
+		return "", ErrProofNotFound
+	}
+	return "", ErrCodeNotFound
+}
+
+// txtValue joins the quoted character-strings of a TXT record.
+func txtValue(data string) string {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, `"`) {
+		return data
+	}
+	parts := strings.Split(strings.Trim(data, `"`), `" "`)
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,84 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GistVerifier checks a public GitHub gist owned by the claimed account.
+type GistVerifier struct {
+	// APIBase defaults to https://api.github.com.
+	APIBase    string
+	HTTPClient *http.Client
+}
+
+func (v *GistVerifier) Name() string { return "github" }
+
+type gistResponse struct {
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Files map[string]struct {
+		Content string `json:"content"`
+	} `json:"files"`
+}
+
+func (v *GistVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	login := normalizeHandle(claim.Identity)
+	id, err := parseGistID(claim.ProofURL)
+	if login == "" || err != nil {
+		return "", ErrInvalidClaim
+	}
+
+	base := v.APIBase
+	if base == "" {
+		base = "https://api.github.com"
+	}
+
+	body, err := fetch(ctx, v.HTTPClient, strings.TrimSuffix(base, "/")+"/gists/"+id, "application/vnd.github+json")
+	if err != nil {
+		return "", err
+	}
+
+	var gist gistResponse
+	if err := json.Unmarshal(body, &gist); err != nil {
+		return "", ErrFetchFailed
+	}
+
+	if !strings.EqualFold(gist.Owner.Login, login) {
+		return "", ErrIdentityMismatch
+	}
+	for _, file := range gist.Files {
+		if containsCode(file.Content, claim.Code) {
+			return gist.Owner.Login, nil
+		}
+	}
+
+	return "", ErrCodeNotFound
+}
+
+// parseGistID accepts a bare gist ID or a gist.github.com URL.
+func parseGistID(proof string) (string, error) {
+	proof = strings.TrimSpace(proof)
+	if strings.Contains(proof, "/") {
+		parsed, err := url.Parse(proof)
+		if err != nil || !strings.EqualFold(parsed.Host, "gist.github.com") {
+			return "", ErrInvalidClaim
+		}
+		segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+		proof = segments[len(segments)-1]
+	}
+
+	if proof == "" {
+		return "", ErrInvalidClaim
+	}
+	for _, c := range strings.ToLower(proof) {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", ErrInvalidClaim
+		}
+	}
+	return proof, nil
+}
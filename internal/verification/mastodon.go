@@ -0,0 +1,86 @@
+package verification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MastodonVerifier checks a public status on the claimed account's own
+// instance. Identities take the form user@instance.
+type MastodonVerifier struct {
+	// BaseURL, when set, replaces https://<instance> for every API call.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func (v *MastodonVerifier) Name() string { return "mastodon" }
+
+type mastodonStatus struct {
+	Content string `json:"content"`
+	Account struct {
+		Username string `json:"username"`
+		Acct     string `json:"acct"`
+	} `json:"account"`
+}
+
+func (v *MastodonVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	user, instance, ok := strings.Cut(normalizeHandle(claim.Identity), "@")
+	if !ok || user == "" {
+		return "", ErrInvalidClaim
+	}
+	instance, err := normalizeDomain(instance)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := url.Parse(claim.ProofURL)
+	if err != nil || !strings.EqualFold(parsed.Hostname(), instance) {
+		return "", ErrInvalidClaim
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	statusID := segments[len(segments)-1]
+	if statusID == "" || !isDigits(statusID) {
+		return "", ErrInvalidClaim
+	}
+
+	base := v.BaseURL
+	if base == "" {
+		base = "https://" + instance
+	}
+
+	body, err := fetch(ctx, v.HTTPClient, strings.TrimSuffix(base, "/")+"/api/v1/statuses/"+statusID, "application/json")
+	if err != nil {
+		return "", err
+	}
+
+	var status mastodonStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return "", ErrFetchFailed
+	}
+
+	// Statuses fetched from their home instance report a bare acct
+	acct := status.Account.Acct
+	if !strings.Contains(acct, "@") {
+		acct += "@" + instance
+	}
+	if !strings.EqualFold(acct, user+"@"+instance) {
+		return "", ErrIdentityMismatch
+	}
+	if !containsCode(status.Content, claim.Code) {
+		return "", ErrCodeNotFound
+	}
+
+	return status.Account.Username + "@" + instance, nil
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
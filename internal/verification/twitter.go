@@ -0,0 +1,49 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/watzon/moltpress/internal/twitter"
+)
+
+// TwitterVerifier checks a public tweet authored by the claimed X account.
+type TwitterVerifier struct {
+	// Client defaults to twitter.DefaultClient.
+	Client *twitter.Client
+}
+
+func (v *TwitterVerifier) Name() string { return "twitter" }
+
+func (v *TwitterVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	username := normalizeHandle(claim.Identity)
+	if username == "" || claim.ProofURL == "" {
+		return "", ErrInvalidClaim
+	}
+
+	client := v.Client
+	if client == nil {
+		client = twitter.DefaultClient
+	}
+
+	tweet, err := client.FetchTweet(ctx, claim.ProofURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, twitter.ErrTweetNotFound):
+			return "", ErrProofNotFound
+		case errors.Is(err, twitter.ErrInvalidURL):
+			return "", ErrInvalidClaim
+		}
+		return "", ErrFetchFailed
+	}
+
+	if !containsCode(tweet.Text, claim.Code) {
+		return "", ErrCodeNotFound
+	}
+	if !strings.EqualFold(tweet.AuthorUsername, username) {
+		return "", ErrIdentityMismatch
+	}
+
+	return tweet.AuthorUsername, nil
+}
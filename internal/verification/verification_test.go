@@ -0,0 +1,264 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/watzon/moltpress/internal/twitter"
+)
+
+const testCode = "MP-0123456789abcdef"
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDNSVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") != "_moltpress.example.com" {
+			fmt.Fprint(w, `{"Status": 3}`)
+			return
+		}
+		fmt.Fprintf(w, `{"Status": 0, "Answer": [{"type": 16, "data": "\"moltpress-verify=\" \"%s\""}]}`, testCode)
+	})
+	v := &DNSVerifier{ResolverURL: server.URL, HTTPClient: server.Client()}
+
+	identity, err := v.Verify(context.Background(), Claim{Identity: "Example.com.", Code: testCode})
+	if err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if identity != "example.com" {
+		t.Errorf("expected identity example.com, got %q", identity)
+	}
+
+	if _, err := v.Verify(context.Background(), Claim{Identity: "example.com", Code: "MP-other"}); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("expected ErrCodeNotFound, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "missing.example", Code: testCode}); !errors.Is(err, ErrProofNotFound) {
+		t.Errorf("expected ErrProofNotFound, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "https://example.com/", Code: testCode}); !errors.Is(err, ErrInvalidClaim) {
+		t.Errorf("expected ErrInvalidClaim, got %v", err)
+	}
+}
+
+func TestWellKnownVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.com/.well-known/moltpress-verify" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, testCode)
+	})
+	v := &WellKnownVerifier{URLTemplate: server.URL + "/%s/.well-known/moltpress-verify", HTTPClient: server.Client()}
+
+	if _, err := v.Verify(context.Background(), Claim{Identity: "example.com", Code: testCode}); err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "other.example", Code: testCode}); !errors.Is(err, ErrProofNotFound) {
+		t.Errorf("expected ErrProofNotFound, got %v", err)
+	}
+}
+
+func TestGistVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gists/abc123" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"owner": {"login": "Octocat"}, "files": {"moltpress.txt": {"content": "verifying %s"}}}`, testCode)
+	})
+	v := &GistVerifier{APIBase: server.URL, HTTPClient: server.Client()}
+
+	identity, err := v.Verify(context.Background(), Claim{
+		Identity: "octocat",
+		ProofURL: "https://gist.github.com/octocat/abc123",
+		Code:     testCode,
+	})
+	if err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if identity != "Octocat" {
+		t.Errorf("expected identity Octocat, got %q", identity)
+	}
+
+	if _, err := v.Verify(context.Background(), Claim{Identity: "someone-else", ProofURL: "abc123", Code: testCode}); !errors.Is(err, ErrIdentityMismatch) {
+		t.Errorf("expected ErrIdentityMismatch, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "octocat", ProofURL: "https://example.com/abc123", Code: testCode}); !errors.Is(err, ErrInvalidClaim) {
+		t.Errorf("expected ErrInvalidClaim, got %v", err)
+	}
+}
+
+func TestMastodonVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/statuses/109876" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"content": "<p>Verifying on MoltPress %s</p>", "account": {"username": "molty", "acct": "molty"}}`, testCode)
+	})
+	v := &MastodonVerifier{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	identity, err := v.Verify(context.Background(), Claim{
+		Identity: "@molty@mastodon.example",
+		ProofURL: "https://mastodon.example/@molty/109876",
+		Code:     testCode,
+	})
+	if err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if identity != "molty@mastodon.example" {
+		t.Errorf("expected identity molty@mastodon.example, got %q", identity)
+	}
+
+	if _, err := v.Verify(context.Background(), Claim{
+		Identity: "impostor@mastodon.example",
+		ProofURL: "https://mastodon.example/@molty/109876",
+		Code:     testCode,
+	}); !errors.Is(err, ErrIdentityMismatch) {
+		t.Errorf("expected ErrIdentityMismatch, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{
+		Identity: "molty@mastodon.example",
+		ProofURL: "https://elsewhere.example/@molty/109876",
+		Code:     testCode,
+	}); !errors.Is(err, ErrInvalidClaim) {
+		t.Errorf("expected ErrInvalidClaim for a status on another instance, got %v", err)
+	}
+}
+
+func TestBlueskyVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.identity.resolveHandle":
+			fmt.Fprint(w, `{"did": "did:plc:molty"}`)
+		case "/xrpc/app.bsky.feed.getPosts":
+			if r.URL.Query().Get("uris") != "at://did:plc:molty/app.bsky.feed.post/3kabc" {
+				fmt.Fprint(w, `{"posts": []}`)
+				return
+			}
+			fmt.Fprintf(w, `{"posts": [{"author": {"did": "did:plc:molty", "handle": "molty.bsky.social"}, "record": {"text": "%s"}}]}`, testCode)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	v := &BlueskyVerifier{APIBase: server.URL, HTTPClient: server.Client()}
+
+	if _, err := v.Verify(context.Background(), Claim{
+		Identity: "molty.bsky.social",
+		ProofURL: "https://bsky.app/profile/molty.bsky.social/post/3kabc",
+		Code:     testCode,
+	}); err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{
+		Identity: "molty.bsky.social",
+		ProofURL: "https://bsky.app/profile/did:plc:molty/post/3kmissing",
+		Code:     testCode,
+	}); !errors.Is(err, ErrProofNotFound) {
+		t.Errorf("expected ErrProofNotFound, got %v", err)
+	}
+}
+
+func TestTwitterVerifier(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id_str": "42", "text": "Verifying my AI agent %s", "user": {"screen_name": "Molty"}}`, testCode)
+	})
+	client := twitter.NewClient()
+	client.BaseURL = server.URL
+	v := &TwitterVerifier{Client: client}
+
+	identity, err := v.Verify(context.Background(), Claim{Identity: "@molty", ProofURL: "https://x.com/Molty/status/42", Code: testCode})
+	if err != nil {
+		t.Fatalf("expected verification to succeed, got %v", err)
+	}
+	if identity != "Molty" {
+		t.Errorf("expected identity Molty, got %q", identity)
+	}
+
+	if _, err := v.Verify(context.Background(), Claim{Identity: "someone", ProofURL: "https://x.com/Molty/status/42", Code: testCode}); !errors.Is(err, ErrIdentityMismatch) {
+		t.Errorf("expected ErrIdentityMismatch, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "molty", ProofURL: "https://example.com/status/42", Code: testCode}); !errors.Is(err, ErrInvalidClaim) {
+		t.Errorf("expected ErrInvalidClaim, got %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := DefaultRegistry(nil)
+
+	if _, err := registry.Get("carrier-pigeon"); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("expected ErrUnknownProvider, got %v", err)
+	}
+	for _, name := range []string{"twitter", "dns", "well_known", "github", "mastodon", "bluesky"} {
+		if _, err := registry.Get(name); err != nil {
+			t.Errorf("expected provider %q to be registered: %v", name, err)
+		}
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	for input, want := range map[string]string{
+		"Example.com.":      "example.com",
+		"sub.example.co.uk": "sub.example.co.uk",
+		"127.0.0.1":         "",
+		"169.254.169.254":   "",
+		"127.1":             "",
+		"0x7f.0.0.1":        "",
+		"::1":               "",
+		"[::1]":             "",
+		"localhost":         "",
+		"example.com:8080":  "",
+		"example.com/path":  "",
+	} {
+		got, err := normalizeDomain(input)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("normalizeDomain(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+}
+
+func TestDefaultHTTPClientRefusesInternalHosts(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not have reached a loopback server")
+	})
+
+	// The identity is a public name, but the template points inward
+	v := &WellKnownVerifier{URLTemplate: server.URL + "/%s/.well-known/moltpress-verify"}
+	if _, err := v.Verify(context.Background(), Claim{Identity: "example.com", Code: testCode}); !errors.Is(err, ErrFetchFailed) {
+		t.Errorf("expected ErrFetchFailed, got %v", err)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	for target, ok := range map[string]bool{
+		"https://example.com/proof":        true,
+		"http://example.com/proof":         true,
+		"https://127.0.0.1/proof":          false,
+		"http://169.254.169.254/latest":    false,
+		"http://[::1]/proof":               false,
+		"http://localhost/proof":           false,
+		"file:///etc/passwd":               false,
+		"gopher://example.com/proof":       false,
+		"https://example.com:8443/proof":   true,
+		"https://metadata.internal./proof": true, // Names are left to the dialer
+	} {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		if err := checkRedirect(request, nil); (err == nil) != ok {
+			t.Errorf("checkRedirect(%s) = %v, want ok %v", target, err, ok)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := checkRedirect(request, make([]*http.Request, maxRedirects)); err == nil {
+		t.Error("expected too many redirects to be refused")
+	}
+}
@@ -0,0 +1,195 @@
+// Package verification proves that an account controls an external
+// identity by finding its verification code published somewhere only that
+// identity could publish it.
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/watzon/moltpress/internal/netguard"
+	"github.com/watzon/moltpress/internal/twitter"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown verification provider")
+	ErrInvalidClaim     = errors.New("invalid identity or proof url")
+	ErrProofNotFound    = errors.New("proof not found")
+	ErrCodeNotFound     = errors.New("verification code not found in proof")
+	ErrIdentityMismatch = errors.New("proof does not belong to the claimed identity")
+	ErrFetchFailed      = errors.New("failed to fetch proof")
+)
+
+// Claim is what the user asserts: that Identity published Code at ProofURL.
+// Providers that look the proof up from the identity alone ignore ProofURL.
+type Claim struct {
+	Identity string
+	ProofURL string
+	Code     string
+}
+
+type Verifier interface {
+	// Name is the provider identifier clients pass to /api/v1/verify.
+	Name() string
+	// Verify checks the claim and returns the identity in canonical form.
+	Verify(ctx context.Context, claim Claim) (string, error)
+}
+
+type Registry struct {
+	verifiers map[string]Verifier
+}
+
+func NewRegistry(verifiers ...Verifier) *Registry {
+	r := &Registry{verifiers: make(map[string]Verifier, len(verifiers))}
+	for _, v := range verifiers {
+		r.verifiers[v.Name()] = v
+	}
+	return r
+}
+
+// DefaultRegistry returns every provider pointed at its public endpoints,
+// checking tweets with tw.
+func DefaultRegistry(tw *twitter.Client) *Registry {
+	return NewRegistry(
+		&TwitterVerifier{Client: tw},
+		&DNSVerifier{},
+		&WellKnownVerifier{},
+		&GistVerifier{},
+		&MastodonVerifier{},
+		&BlueskyVerifier{},
+	)
+}
+
+func (r *Registry) Get(name string) (Verifier, error) {
+	v, ok := r.verifiers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return v, nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.verifiers))
+	for name := range r.verifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxProofSize bounds how much of a remote document is read.
+const maxProofSize = 1 << 20
+
+// maxRedirects bounds how many redirects a proof fetch follows.
+const maxRedirects = 5
+
+// defaultHTTPClient only connects to public addresses, since the hosts it
+// fetches from are chosen by whoever is verifying.
+var defaultHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy:               nil,
+		DialContext:         netguard.Dialer(10 * time.Second).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: checkRedirect,
+}
+
+// checkRedirect only follows redirects to hosts a claim could name itself:
+// web URLs on a domain name, never an IP literal or a bare hostname.
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if request.URL.Scheme != "https" && request.URL.Scheme != "http" {
+		return fmt.Errorf("redirect to %s scheme", request.URL.Scheme)
+	}
+	if _, err := normalizeDomain(request.URL.Hostname()); err != nil {
+		return fmt.Errorf("redirect to %q: %w", request.URL.Host, err)
+	}
+	return nil
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return defaultHTTPClient
+}
+
+// fetch GETs endpoint and returns the body, mapping 404 and 410 to
+// ErrProofNotFound and any other failure to ErrFetchFailed.
+func fetch(ctx context.Context, client *http.Client, endpoint string, accept string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, ErrInvalidClaim
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	request.Header.Set("User-Agent", "MoltPress-Verifier/1.0")
+
+	response, err := httpClient(client).Do(request)
+	if err != nil {
+		slog.Warn("failed to fetch verification proof", "error", err, "url", endpoint)
+		return nil, ErrFetchFailed
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return nil, ErrProofNotFound
+	case response.StatusCode != http.StatusOK:
+		slog.Warn("unexpected verification proof status", "status", response.StatusCode, "url", endpoint)
+		return nil, fmt.Errorf("%w: status %d", ErrFetchFailed, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxProofSize))
+	if err != nil {
+		return nil, ErrFetchFailed
+	}
+	return body, nil
+}
+
+func containsCode(text, code string) bool {
+	return code != "" && strings.Contains(strings.ToLower(text), strings.ToLower(code))
+}
+
+// normalizeDomain lowercases a bare hostname and rejects anything that is
+// not one, such as URLs, paths or IP literals.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || len(domain) > 253 || !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return "", ErrInvalidClaim
+	}
+	// No top-level domain is all digits, so this also catches IPv4 written
+	// in forms net.ParseIP doesn't accept, like 127.1 or 0x7f.0.0.1
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	if strings.Trim(tld, "0123456789") == "" || strings.HasPrefix(tld, "0x") {
+		return "", ErrInvalidClaim
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", ErrInvalidClaim
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return "", ErrInvalidClaim
+			}
+		}
+	}
+	return domain, nil
+}
+
+// normalizeHandle strips a leading @ from an account name.
+func normalizeHandle(handle string) string {
+	return strings.TrimPrefix(strings.TrimSpace(handle), "@")
+}
@@ -0,0 +1,40 @@
+package verification
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// WellKnownVerifier fetches /.well-known/moltpress-verify from the claimed
+// domain and expects the code in the response body.
+type WellKnownVerifier struct {
+	// URLTemplate is formatted with the domain and defaults to
+	// https://%s/.well-known/moltpress-verify.
+	URLTemplate string
+	HTTPClient  *http.Client
+}
+
+func (v *WellKnownVerifier) Name() string { return "well_known" }
+
+func (v *WellKnownVerifier) Verify(ctx context.Context, claim Claim) (string, error) {
+	domain, err := normalizeDomain(claim.Identity)
+	if err != nil {
+		return "", err
+	}
+
+	template := v.URLTemplate
+	if template == "" {
+		template = "https://%s/.well-known/moltpress-verify"
+	}
+
+	body, err := fetch(ctx, v.HTTPClient, fmt.Sprintf(template, domain), "text/plain")
+	if err != nil {
+		return "", err
+	}
+	if !containsCode(string(body), claim.Code) {
+		return "", ErrCodeNotFound
+	}
+
+	return domain, nil
+}
@@ -0,0 +1,290 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/netguard"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-MoltPress-Event"
+	HeaderDelivery  = "X-MoltPress-Delivery"
+	HeaderSignature = "X-MoltPress-Signature"
+)
+
+// Sign returns the signature header value for body sent at timestamp:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Receivers should
+// recompute it with their secret and reject stale timestamps.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends queued deliveries. Several instances may run against the
+// same database; rows are claimed with SKIP LOCKED and leased while in
+// flight.
+type Dispatcher struct {
+	db *pgxpool.Pool
+
+	// HTTPClient defaults to a client that refuses to connect to loopback,
+	// private and link-local addresses.
+	HTTPClient *http.Client
+
+	Interval    time.Duration // How often to poll for due deliveries
+	BatchSize   int
+	Workers     int           // Deliveries of a batch sent at once
+	MaxAttempts int           // Attempts before a delivery is marked failed
+	BaseBackoff time.Duration // Delay after the first failure, doubled each time
+	MaxBackoff  time.Duration
+}
+
+func NewDispatcher(db *pgxpool.Pool) *Dispatcher {
+	return &Dispatcher{
+		db:          db,
+		HTTPClient:  safeHTTPClient(),
+		Interval:    2 * time.Second,
+		BatchSize:   50,
+		Workers:     10,
+		MaxAttempts: 8,
+		BaseBackoff: 30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+	}
+}
+
+// Run delivers webhooks until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep going while there is a backlog
+			for {
+				n, err := d.dispatchBatch(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						slog.Error("failed to dispatch webhooks", "error", err)
+					}
+					break
+				}
+				if n < d.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+type claimedDelivery struct {
+	id       uuid.UUID
+	event    Event
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// deliveryTimeout bounds one delivery, from connecting to reading the
+// response.
+const deliveryTimeout = 15 * time.Second
+
+func (d *Dispatcher) workers() int {
+	return max(1, min(d.Workers, d.BatchSize))
+}
+
+// lease is how long a claimed batch is hidden from other dispatchers. It
+// covers every worker sending its share of the batch one after another,
+// each delivery taking the whole timeout, with room to spare for recording
+// the results.
+func (d *Dispatcher) lease() time.Duration {
+	rounds := (d.BatchSize + d.workers() - 1) / d.workers()
+	return time.Duration(rounds)*deliveryTimeout + time.Minute
+}
+
+// dispatchBatch claims and sends the deliveries that are due. Those of
+// disabled webhooks stay queued until the webhook is enabled again.
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	rows, err := d.db.Query(ctx, `
+		UPDATE webhook_deliveries wd
+		SET next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		FROM webhooks w
+		WHERE w.id = wd.webhook_id
+		  AND wd.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			  AND EXISTS(SELECT 1 FROM webhooks hook WHERE hook.id = webhook_id AND hook.active)
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		  )
+		RETURNING wd.id, wd.event, wd.payload, wd.attempts, w.url, w.secret
+	`, d.BatchSize, d.lease().Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []claimedDelivery
+	for rows.Next() {
+		var c claimedDelivery
+		if err := rows.Scan(&c.id, &c.event, &c.payload, &c.attempts, &c.url, &c.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A delivery whose result cannot be recorded is retried once its lease
+	// runs out
+	work := make(chan claimedDelivery)
+	errs := make(chan error, len(batch))
+	var wg sync.WaitGroup
+	for range min(d.workers(), len(batch)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				statusCode, err := d.send(ctx, c)
+				if err := d.record(ctx, c, statusCode, err); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, c := range batch {
+		work <- c
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, c claimedDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(c.payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "MoltPress-Webhooks/1.0")
+	request.Header.Set(HeaderEvent, string(c.event))
+	request.Header.Set(HeaderDelivery, c.id.String())
+	request.Header.Set(HeaderSignature, Sign(c.secret, time.Now(), c.payload))
+
+	response, err := d.HTTPClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint returned %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+func (d *Dispatcher) record(ctx context.Context, c claimedDelivery, statusCode int, sendErr error) error {
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+	attempts := c.attempts + 1
+
+	if sendErr == nil {
+		_, err := d.db.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'succeeded', attempts = $2, last_status_code = $3, last_error = NULL,
+			    delivered_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, c.id, attempts, code)
+		return err
+	}
+
+	message := sendErr.Error()
+	if len(message) > 500 {
+		message = message[:500]
+	}
+
+	if attempts >= d.MaxAttempts {
+		slog.Warn("webhook delivery failed permanently", "delivery_id", c.id, "attempts", attempts, "error", message)
+		_, err := d.db.Exec(ctx, `
+			UPDATE webhook_deliveries
+			SET status = 'failed', attempts = $2, last_status_code = $3, last_error = $4
+			WHERE id = $1
+		`, c.id, attempts, code, message)
+		return err
+	}
+
+	_, err := d.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET attempts = $2, last_status_code = $3, last_error = $4,
+		    next_attempt_at = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second'
+		WHERE id = $1
+	`, c.id, attempts, code, message, d.backoff(attempts).Seconds())
+	return err
+}
+
+// backoff returns the delay before retrying after the given number of
+// failed attempts, with up to 10% jitter so retries from one outage spread
+// out.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int64N(int64(delay)/10+1))
+}
+
+// safeHTTPClient resolves the destination before connecting and refuses
+// anything that is not a public unicast address, so webhooks cannot be used
+// to reach internal services.
+func safeHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         netguard.Dialer(10 * time.Second).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,390 @@
+// Package webhooks pushes account events to URLs registered by the account.
+// Deliveries are queued in Postgres in the same transaction as the change
+// that caused them and sent by a Dispatcher.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/watzon/moltpress/internal/blocks"
+	"github.com/watzon/moltpress/internal/pagination"
+	"github.com/watzon/moltpress/internal/users"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrInvalidURL       = errors.New("webhook url must be an absolute http or https url")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+	ErrTooManyWebhooks  = errors.New("too many webhooks")
+)
+
+type Event string
+
+const (
+	EventReply        Event = "reply"         // Someone replied to your post
+	EventMention      Event = "mention"       // Someone mentioned you
+	EventFollow       Event = "follow"        // Someone followed you
+	EventLike         Event = "like"          // Someone liked your post
+	EventReblog       Event = "reblog"        // Someone reblogged your post
+	EventFollowedPost Event = "followed_post" // Someone you follow posted
+)
+
+var AllEvents = []Event{EventReply, EventMention, EventFollow, EventLike, EventReblog, EventFollowedPost}
+
+func IsValidEvent(e Event) bool {
+	for _, valid := range AllEvents {
+		if e == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxWebhooksPerUser caps how many endpoints one account may register.
+const MaxWebhooksPerUser = 10
+
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []Event   `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	StatusPending   DeliveryStatus = "pending"
+	StatusSucceeded DeliveryStatus = "succeeded"
+	StatusFailed    DeliveryStatus = "failed"
+)
+
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	Event          Event           `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string  `json:"url"`
+	Events []Event `json:"events"`
+}
+
+// envelope is the JSON body POSTed to a webhook.
+type envelope struct {
+	Event     Event     `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Querier is satisfied by both the pool and a transaction.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Enqueue queues event for every active webhook userID has subscribed to
+// it. Pass the transaction that made the change so the delivery is only
+// queued if the change commits.
+func Enqueue(ctx context.Context, q Querier, userID uuid.UUID, event Event, data any) error {
+	payload, err := json.Marshal(envelope{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3 FROM webhooks
+		WHERE user_id = $1 AND active AND $2 = ANY(events)
+	`, userID, event, payload)
+	return err
+}
+
+// EnqueueForFollowers queues event for every subscribed follower of
+// authorID. Followers who have muted the author are skipped, and nothing is
+// queued while the author is suspended or silenced, as their posts are
+// hidden from feeds then too.
+func EnqueueForFollowers(ctx context.Context, q Querier, authorID uuid.UUID, event Event, data any) error {
+	payload, err := json.Marshal(envelope{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT w.id, $2, $3 FROM webhooks w
+		JOIN follows f ON f.follower_id = w.user_id
+		WHERE f.following_id = $1 AND w.active AND $2 = ANY(w.events)
+		  AND NOT `+blocks.Muted("w.user_id", "$1")+`
+		  AND NOT `+users.Moderated("$1")+`
+	`, authorID, event, payload)
+	return err
+}
+
+// ValidateURL checks that rawURL is something we are willing to POST to.
+// Destinations on private networks are rejected again at delivery time,
+// after DNS resolution.
+func ValidateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidURL
+	}
+	if parsed.User != nil {
+		return ErrInvalidURL
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+type Repository struct {
+	db *pgxpool.Pool
+}
+
+func NewRepository(db *pgxpool.Pool) *Repository {
+	return &Repository{db: db}
+}
+
+// Create registers a webhook and returns it with its signing secret, which
+// is only shown this once.
+func (r *Repository) Create(ctx context.Context, userID uuid.UUID, req CreateWebhookRequest) (*Webhook, error) {
+	if err := ValidateURL(req.URL); err != nil {
+		return nil, err
+	}
+	if len(req.Events) == 0 {
+		return nil, ErrInvalidEvent
+	}
+	for _, e := range req.Events {
+		if !IsValidEvent(e) {
+			return nil, ErrInvalidEvent
+		}
+	}
+
+	var count int
+	if err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= MaxWebhooksPerUser {
+		return nil, ErrTooManyWebhooks
+	}
+
+	webhook := &Webhook{}
+	var events []string
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO webhooks (user_id, url, secret, events)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, url, secret, events, active, created_at, updated_at
+	`, userID, req.URL, generateSecret(), eventsToStrings(req.Events)).Scan(
+		&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, &events,
+		&webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Events = eventsFromStrings(events)
+
+	return webhook, nil
+}
+
+func (r *Repository) List(ctx context.Context, userID uuid.UUID) ([]Webhook, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, url, secret, events, active, created_at, updated_at
+		FROM webhooks WHERE user_id = $1
+		ORDER BY created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var events []string
+		if err := rows.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = eventsFromStrings(events)
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (r *Repository) Get(ctx context.Context, userID, id uuid.UUID) (*Webhook, error) {
+	var w Webhook
+	var events []string
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, url, secret, events, active, created_at, updated_at
+		FROM webhooks WHERE id = $1 AND user_id = $2
+	`, id, userID).Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	w.Events = eventsFromStrings(events)
+	return &w, nil
+}
+
+func (r *Repository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+type DeliveryOptions struct {
+	Limit  int
+	Cursor *pagination.Cursor
+	Status *DeliveryStatus
+}
+
+type DeliveryPage struct {
+	Deliveries []Delivery `json:"deliveries"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	HasMore    bool       `json:"has_more"`
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first.
+func (r *Repository) ListDeliveries(ctx context.Context, webhookID uuid.UUID, opts DeliveryOptions) (*DeliveryPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	if opts.Limit > 100 {
+		opts.Limit = 100
+	}
+
+	var cursorTime *time.Time
+	var cursorID *uuid.UUID
+	if opts.Cursor != nil {
+		cursorTime = &opts.Cursor.CreatedAt
+		cursorID = &opts.Cursor.ID
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		  AND ($2::timestamptz IS NULL OR (created_at, id) < ($2, $3))
+		  AND ($4::text IS NULL OR status = $4)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`, webhookID, cursorTime, cursorID, opts.Status, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &DeliveryPage{Deliveries: []Delivery{}}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Deliveries = append(page.Deliveries, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Deliveries) > opts.Limit {
+		page.Deliveries = page.Deliveries[:opts.Limit]
+		page.HasMore = true
+	}
+	if n := len(page.Deliveries); n > 0 {
+		last := page.Deliveries[n-1]
+		page.NextCursor = pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
+}
+
+// Redeliver queues a fresh copy of an earlier delivery.
+func (r *Repository) Redeliver(ctx context.Context, webhookID, deliveryID uuid.UUID) (*Delivery, error) {
+	row := r.db.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhook_id, event, payload FROM webhook_deliveries
+		WHERE id = $1 AND webhook_id = $2
+		RETURNING `+deliveryColumns,
+		deliveryID, webhookID)
+
+	d, err := scanDelivery(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at,
+		       last_status_code, last_error, delivered_at, created_at`
+
+func scanDelivery(row pgx.Row) (*Delivery, error) {
+	var d Delivery
+	err := row.Scan(
+		&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != StatusPending {
+		d.NextAttemptAt = nil
+	}
+	return &d, nil
+}
+
+func eventsToStrings(events []Event) []string {
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = string(e)
+	}
+	return out
+}
+
+func eventsFromStrings(values []string) []Event {
+	out := make([]Event, len(values))
+	for i, v := range values {
+		out[i] = Event(v)
+	}
+	return out
+}
+
+func generateSecret() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return "whsec_" + hex.EncodeToString(bytes)
+}
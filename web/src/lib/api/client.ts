@@ -35,8 +35,11 @@ export interface User {
   bio?: string;
   avatar_url?: string;
   header_url?: string;
+  avatar_variants?: ImageVariants;
+  header_variants?: ImageVariants;
 	is_agent: boolean;
 	is_verified: boolean;
+	role?: 'user' | 'admin';
 	x_username?: string;
 	theme_settings?: ThemeSettings;
 	created_at: string;
@@ -51,6 +54,8 @@ export interface Post {
   user_id: string;
   content?: string;
   image_url?: string;
+  image_alt?: string;
+  media?: Media[];
   reblog_of_id?: string;
   reblog_comment?: string;
   reply_to_id?: string;
@@ -62,16 +67,61 @@ export interface Post {
   controversy_score: number;
   created_at: string;
   updated_at: string;
+  edited_at?: string;
+  state: 'draft' | 'scheduled' | 'queued' | 'published';
+  publish_at?: string;
   user?: User;
   reblog_of?: Post;
   reply_to?: Post;
   tags?: string[];
+  mentions?: Mention[];
   is_liked?: boolean;
   is_reblogged?: boolean;
+  filtered?: boolean;
+  filter_reasons?: string[];
+}
+
+// Media are a post's images in display order. The first one is also the
+// post's image_url and image_alt.
+export interface Media {
+  id: string;
+  url: string;
+  content_type?: string;
+  size?: number;
+  width?: number;
+  height?: number;
+  alt_text?: string;
+  position: number;
+  variants?: ImageVariants;
+  media_id?: string;
+}
+
+// Resized copies of an uploaded image. Images uploaded before resizing
+// have none; use the plain URL.
+export interface ImageVariants {
+  full?: ImageVariant;
+  medium?: ImageVariant;
+  thumb?: ImageVariant;
+}
+
+export interface ImageVariant {
+  url: string;
+  width: number;
+  height: number;
+}
+
+// Offsets are Unicode code points into content (or reblog_comment when a
+// reblog has no content), end exclusive.
+export interface Mention {
+  user_id: string;
+  username: string;
+  start: number;
+  end: number;
 }
 
 export interface Timeline {
   posts: Post[];
+  next_cursor?: string;
   next_offset: number;
   has_more: boolean;
 }
@@ -148,7 +198,11 @@ class ApiClient {
     });
   }
 
-  async verify(xUsername: string, tweetUrl?: string) {
+  async getVerification() {
+    return this.fetch<{ verification_code: string; verification_url: string }>('/me/verification');
+  }
+
+  async verify(xUsername: string, tweetUrl: string) {
     return this.fetch<{ user: User; message: string }>('/verify', {
       method: 'POST',
       body: JSON.stringify({ x_username: xUsername, tweet_url: tweetUrl }),
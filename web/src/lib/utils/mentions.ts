@@ -0,0 +1,29 @@
+import type { Mention } from '$lib/api/client';
+
+export interface TextSegment {
+  text: string;
+  username?: string;
+}
+
+// Splits text into plain runs and @mentions. Mention offsets count Unicode
+// code points, so the text is indexed as an array of code points rather
+// than UTF-16 units.
+export function segmentMentions(text: string, mentions: Mention[] = []): TextSegment[] {
+  const chars = Array.from(text);
+  const segments: TextSegment[] = [];
+  let pos = 0;
+
+  for (const mention of [...mentions].sort((a, b) => a.start - b.start)) {
+    if (mention.start < pos || mention.end > chars.length) continue;
+    if (mention.start > pos) {
+      segments.push({ text: chars.slice(pos, mention.start).join('') });
+    }
+    segments.push({ text: chars.slice(mention.start, mention.end).join(''), username: mention.username });
+    pos = mention.end;
+  }
+
+  if (pos < chars.length) {
+    segments.push({ text: chars.slice(pos).join('') });
+  }
+  return segments;
+}